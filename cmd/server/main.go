@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,22 +11,34 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/boddle/reservoir/internal/admin"
+	"github.com/boddle/reservoir/internal/audit"
 	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/background"
+	"github.com/boddle/reservoir/internal/buildinfo"
 	"github.com/boddle/reservoir/internal/config"
 	"github.com/boddle/reservoir/internal/database"
+	"github.com/boddle/reservoir/internal/debug"
 	"github.com/boddle/reservoir/internal/middleware"
 	"github.com/boddle/reservoir/internal/oauth"
 	"github.com/boddle/reservoir/internal/ratelimit"
 	"github.com/boddle/reservoir/internal/token"
 	"github.com/boddle/reservoir/internal/user"
+	"github.com/boddle/reservoir/pkg/boddlectx"
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/integrations/nrgin"
 	"github.com/newrelic/go-agent/v3/newrelic"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 func main() {
+	showVersion := flag.Bool("version", false, "print build info and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(buildinfo.Get())
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -41,7 +54,15 @@ func main() {
 	}
 	defer logger.Sync()
 
-	logger.Info("Starting Boddle Auth Gateway", zap.String("env", cfg.Env))
+	logger.Info("Starting Boddle Auth Gateway",
+		zap.String("version", buildinfo.Version),
+		zap.String("commit", buildinfo.Commit),
+		zap.String("env", cfg.Env),
+		zap.String("addr", fmt.Sprintf(":%s", cfg.Port)),
+		zap.Strings("providers", cfg.EnabledProviders()),
+	)
+	// Secrets are redacted by Config.Redacted; never log cfg directly.
+	logger.Info("Effective configuration", zap.Any("config", cfg.Redacted()))
 
 	// Initialize New Relic. Disabled when NEW_RELIC_LICENSE_KEY is empty —
 	// nrgin middleware and nrpostgres driver remain installed but become
@@ -101,7 +122,7 @@ func main() {
 	logger.Info("Database write probe passed")
 
 	// Connect to Redis
-	redisClient, err := database.NewRedisClient(cfg.RedisURL)
+	redisClient, err := database.NewRedisClient(cfg.RedisURL, cfg.RedisOpTimeout)
 	if err != nil {
 		logger.Fatal("Failed to connect to Redis", zap.Error(err))
 	}
@@ -110,13 +131,62 @@ func main() {
 
 	// Initialize services
 	userRepo := user.NewRepository(db.DB, readerDB.DB)
+	userRepo.SetQueryTimeout(cfg.Database.QueryTimeout)
+	subjectFormat, err := token.ParseSubjectFormat(cfg.JWT.SubjectFormat)
+	if err != nil {
+		logger.Fatal("Invalid JWT_SUBJECT_FORMAT", zap.Error(err))
+	}
+	tokenOpts := []token.Option{
+		token.WithIssueSkew(cfg.JWT.IssueSkew),
+		token.WithLeeway(cfg.JWT.Leeway),
+		token.WithMaxTTL(cfg.JWT.MaxAccessTTL, cfg.JWT.MaxRefreshTTL),
+		token.WithStudentEmailOmitted(cfg.JWT.OmitStudentEmail),
+		token.WithMinimalClaims(cfg.JWT.MinimalClaims),
+		token.WithSubjectFormat(subjectFormat),
+		token.WithPreviousRefreshSecrets(cfg.JWT.PreviousRefreshSecretKeys...),
+	}
+	var signer token.Signer
+	if cfg.JWT.SigningKeyFile != "" {
+		local, err := token.LoadLocalSigner(cfg.JWT.SigningKeyFile)
+		if err != nil {
+			logger.Fatal("Failed to load JWT signing key", zap.Error(err))
+		}
+		signer = local
+	}
+	if err := token.CheckSigningAlgorithm(cfg.JWT.SigningAlgorithm, signer); err != nil {
+		logger.Fatal("Invalid JWT_SIGNING_ALGORITHM", zap.Error(err))
+	}
+	if signer != nil {
+		tokenOpts = append(tokenOpts, token.WithSigner(signer))
+	}
+	for _, path := range cfg.JWT.PreviousSigningPublicKeyFiles {
+		key, err := token.LoadPublicKey(path)
+		if err != nil {
+			logger.Fatal("Failed to load previous JWT signing key", zap.String("path", path), zap.Error(err))
+		}
+		tokenOpts = append(tokenOpts, token.WithPreviousSigningKeys(key))
+	}
 	tokenService := token.NewService(
 		cfg.JWT.SecretKey,
 		cfg.JWT.RefreshSecretKey,
 		cfg.JWT.AccessTokenTTL,
 		cfg.JWT.RefreshTokenTTL,
+		tokenOpts...,
 	)
-	tokenBlacklist := token.NewBlacklist(redisClient.Client)
+	if ttl := tokenService.AccessTTL(); ttl < cfg.JWT.AccessTokenTTL {
+		logger.Warn("JWT_ACCESS_TOKEN_TTL exceeds JWT_MAX_ACCESS_TTL; capping",
+			zap.Duration("configured", cfg.JWT.AccessTokenTTL), zap.Duration("effective", ttl))
+	}
+	if ttl := tokenService.RefreshTTL(); ttl < cfg.JWT.RefreshTokenTTL {
+		logger.Warn("JWT_REFRESH_TOKEN_TTL exceeds JWT_MAX_REFRESH_TTL; capping",
+			zap.Duration("configured", cfg.JWT.RefreshTokenTTL), zap.Duration("effective", ttl))
+	}
+	var blacklistOpts []token.BlacklistOption
+	if cfg.JWT.BlacklistBloom {
+		blacklistOpts = append(blacklistOpts, token.WithBloomFilter(cfg.JWT.BlacklistBloomCapacity, 0.01))
+	}
+	tokenBlacklist := token.NewBlacklist(redisClient.Client, blacklistOpts...)
+	issuedAtCutoffs := token.NewIssuedAtCutoffs(redisClient.Client, cfg.JWT.IssueSkew)
 	rateLimiter := ratelimit.NewLimiter(
 		redisClient.Client,
 		cfg.RateLimit.Window,
@@ -124,88 +194,201 @@ func main() {
 		cfg.RateLimit.LockoutDuration,
 		logger,
 	)
+	rateLimiter.SetChallengeThreshold(cfg.RateLimit.CaptchaThreshold)
+	rateLimiter.SetIPMaxAttempts(cfg.RateLimit.OAuthMaxFailuresPerIP)
+	rateLimiter.SetLockoutEscalation(cfg.RateLimit.LockoutMultiplier, cfg.RateLimit.MaxLockoutDuration, cfg.RateLimit.LockoutResetAfter)
+	rateLimitAlgorithm, err := ratelimit.ParseAlgorithm(cfg.RateLimit.Algorithm)
+	if err != nil {
+		logger.Fatal("Invalid RATE_LIMIT_ALGORITHM", zap.Error(err))
+	}
+	rateLimiter.SetAlgorithm(rateLimitAlgorithm)
+
+	// Background workers share one context, cancelled during shutdown once
+	// the HTTP server has stopped handing them work.
+	workers := background.NewGroup(logger)
 
-	// Background batcher for last_logged_on writes. Started here so the
-	// goroutine runs for the lifetime of the process and shuts down with
-	// the HTTP server.
+	// Background batcher for last_logged_on writes. Runs for the lifetime of
+	// the process and drains its queue when the worker group is stopped.
 	lastLoginWriter := user.NewLastLoginWriter(db.DB, logger)
+	lastLoginWriter.SetQueryTimeout(cfg.Database.QueryTimeout)
+	workers.Go("last_login_writer", lastLoginWriter.Run)
+	workers.Go("blacklist_bloom_refresh", func(ctx context.Context) {
+		tokenBlacklist.RunBloomRefresh(ctx, cfg.JWT.BlacklistBloomRefresh)
+	})
+
+	// Optional /auth/me profile cache, kept coherent with Rails via pub/sub.
+	var userCache *user.Cache
+	if cfg.UserCacheTTL > 0 {
+		userCache = user.NewCache(cfg.UserCacheTTL, redisClient.Client)
+		userCache.SetMaxEntries(cfg.UserCacheMaxEntries)
+		workers.Go("user_cache_invalidation", func(ctx context.Context) {
+			userCache.RunInvalidationSubscriber(ctx, logger)
+		})
+	}
 
 	authService := auth.NewService(userRepo, tokenService, tokenBlacklist, rateLimiter, lastLoginWriter, logger)
+	authService.SetUserCache(userCache)
+	authService.SetIssuedAtCutoffs(issuedAtCutoffs)
+	authService.SetLoginAttemptReasons(cfg.RateLimit.RecordAttemptReasons)
+	authService.SetPasswordLoginUnavailableError(cfg.PasswordLoginUnavailableError)
+	authService.SetExpiredTokenGrace(cfg.JWT.ExpiredGrace)
+	// Entries must outlive every access token they revoke, grace and leeway included.
+	authService.SetUserTokenVersions(token.NewUserTokenVersions(redisClient.Client, tokenService.AccessTTL()+cfg.JWT.ExpiredGrace+cfg.JWT.Leeway))
+	if cfg.LoginTokenReplayWindow > 0 {
+		authService.SetLoginTokenDedup(auth.NewLoginTokenDedup(redisClient.Client, cfg.LoginTokenReplayWindow))
+	}
+	apps := auth.NewApps(cfg.JWT.Apps)
+	authService.SetApps(apps)
+	var refreshFamilies *token.RefreshFamilies
+	if cfg.JWT.RefreshReuseDetection {
+		refreshFamilies = token.NewRefreshFamilies(redisClient.Client, tokenService.RefreshTTL())
+		authService.SetRefreshFamilies(refreshFamilies)
+	}
+	if cfg.PasswordChangeCacheTTL > 0 {
+		authService.SetPasswordChanges(auth.NewPasswordChanges(userRepo, redisClient.Client, cfg.PasswordChangeCacheTTL))
+	}
+	authService.SetBcryptPool(auth.NewBcryptPool(cfg.BcryptMaxConcurrency, cfg.BcryptQueueTimeout))
+	var deviceSessions *auth.DeviceSessions
+	if cfg.SessionPerDevice {
+		deviceSessions = auth.NewDeviceSessions(redisClient.Client, tokenService.RefreshTTL())
+		authService.SetDeviceSessions(deviceSessions)
+	}
 
 	// Initialize OAuth services
-	oauthStateManager := oauth.NewStateManager(redisClient.Client)
+	stateBinding, err := oauth.ParseClientBinding(cfg.OAuthState.ClientBinding)
+	if err != nil {
+		logger.Fatal("Invalid OAUTH_STATE_CLIENT_BINDING", zap.Error(err))
+	}
+	var oauthStateManager oauth.StateManager
+	switch cfg.OAuthState.Mode {
+	case "redis":
+		redisStates := oauth.NewRedisStateManager(redisClient.Client, cfg.OAuthState.MaxAge)
+		redisStates.SetMaxPerIP(cfg.OAuthState.MaxPerIP)
+		redisStates.SetClientBinding(stateBinding)
+		oauthStateManager = redisStates
+	case "signed":
+		var nonces *redis.Client
+		if cfg.OAuthState.SingleUse {
+			nonces = redisClient.Client
+		}
+		signed, err := oauth.NewSignedStateManager([]byte(cfg.OAuthState.Secret), cfg.OAuthState.MaxAge, nonces)
+		if err != nil {
+			logger.Fatal("Failed to configure signed OAuth state", zap.Error(err))
+		}
+		signed.SetClientBinding(stateBinding)
+		oauthStateManager = signed
+	default:
+		logger.Fatal("Unknown OAUTH_STATE_MODE", zap.String("mode", cfg.OAuthState.Mode))
+	}
+	providerHTTPClient := oauth.NewProviderHTTPClient(cfg.OAuthHTTPTimeout)
 	googleService := oauth.NewGoogleService(cfg.Google, oauthStateManager)
+	userInfoRetry := oauth.RetryPolicy{MaxRetries: cfg.OAuthUserInfoMaxRetries, BaseDelay: cfg.OAuthUserInfoRetryBaseDelay}
+	googleService.SetHTTPClient(providerHTTPClient)
+	googleService.SetRetryPolicy(userInfoRetry)
 	cleverService := oauth.NewCleverService(cfg.Clever, oauthStateManager)
+	cleverService.SetHTTPClient(providerHTTPClient)
+	cleverService.SetRetryPolicy(userInfoRetry)
 	icloudService := oauth.NewICloudService(cfg.ICloud, redisClient.Client)
+	icloudService.SetHTTPClient(providerHTTPClient)
 	if !icloudService.Configured() {
 		// Fail closed: /auth/icloud rejects every request until APPLE_CLIENT_IDS
 		// is set, since without an audience allowlist a token cannot be verified.
 		logger.Warn("iCloud sign-in disabled: APPLE_CLIENT_IDS not set")
 	}
 
+	// Provider links that fail mid-sign-in are retried in the background
+	// rather than failing the login.
+	linkRetries := oauth.NewLinkRetryQueue(redisClient.Client, logger)
+	linkRetries.SetUserCache(userCache)
+
 	oauthAuthService := oauth.NewAuthService(userRepo, tokenService, googleService, cleverService, icloudService, lastLoginWriter)
+	oauthAuthService.SetLogger(logger)
+	oauthAuthService.SetMaxLinkedProviders(cfg.MaxLinkedProviders)
+	oauthAuthService.SetLinkRetries(linkRetries)
+	oauthAuthService.SetRateLimiter(rateLimiter)
+	oauthAuthService.SetRevealNoLinkedAccountEmail(cfg.OAuthNoAccountRevealEmail)
+	oauthAuthService.SetRefreshFamilies(refreshFamilies)
+	oauthAuthService.SetDeviceSessions(deviceSessions)
+	oauthAuthService.SetUserCache(userCache)
+	workers.Go("oauth_link_retry", func(ctx context.Context) {
+		linkRetries.Run(ctx, oauthAuthService.ApplyPendingLink)
+	})
+	oidcProviders := oauth.NewOIDCProviders(cfg.OIDC, oauthStateManager)
+	oidcProviders.SetHTTPClient(providerHTTPClient)
+	oauthAuthService.SetOIDCProviders(oidcProviders)
+	if cfg.JWT.RefreshTokenIndex {
+		refreshIndex := token.NewRefreshTokenIndex(redisClient.Client, tokenService.RefreshTTL())
+		authService.SetRefreshTokenIndex(refreshIndex)
+		oauthAuthService.SetRefreshTokenIndex(refreshIndex)
+	}
+
+	auditRepo := audit.NewRepository(db.DB)
+	auditRepo.SetQueryTimeout(cfg.Database.QueryTimeout)
+	if cfg.AuditTokenIssuance {
+		authService.SetTokenAuditor(auditRepo)
+		oauthAuthService.SetTokenAuditor(auditRepo)
+	}
 
 	// Initialize handlers
 	var readerPinger auth.DBPinger
 	if cfg.Database.HasReader() {
 		readerPinger = readerDB
 	}
-	authHandler := auth.NewHandler(authService, db, readerPinger)
+	authHandler := auth.NewHandler(authService, db, readerPinger, redisClient)
 	oauthHandler := oauth.NewHandler(oauthAuthService, googleService, cleverService, icloudService)
+	oauthHandler.SetApps(apps)
+	tokenCookie := auth.AccessTokenCookie{Domain: cfg.AuthCookieDomain}
+	authHandler.SetAccessTokenCookie(tokenCookie)
+	oauthHandler.SetAccessTokenCookie(tokenCookie)
+	oauthHandler.SetOIDCProviders(oidcProviders)
+	redirectAllowlist := oauth.NewRedirectAllowlist(cfg.OAuthRedirectAllowlist)
+	if cfg.OAuthRedirectAllowlist == "" {
+		redirectAllowlist = oauth.NewRedirectAllowlist(cfg.CORS.AllowedOrigins).WithoutWildcard()
+	}
+	oauthHandler.SetRedirectAllowlist(redirectAllowlist)
+	adminHandler := admin.NewHandler(userRepo, userCache, issuedAtCutoffs, auditRepo, oauthStateManager, logger)
+	adminHandler.SetSessionRevoker(authService)
+	adminHandler.SetOAuthSelfTester(oauth.NewSelfTester(googleService, cleverService, icloudService, oidcProviders))
 
 	// Set up Gin router
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.New()
-
-	// Global middleware. nrgin runs first so every request becomes a
-	// New Relic transaction; downstream middleware and handlers that use
-	// c.Request.Context() (including DB calls via the nrpostgres driver)
-	// attach their work as segments to that transaction.
-	router.Use(nrgin.Middleware(nrApp))
-	allowedOrigins := middleware.ParseAllowedOrigins(cfg.CORS.AllowedOrigins)
-	router.Use(middleware.CORS(allowedOrigins))
-	router.Use(middleware.SecurityHeaders())
-	router.Use(middleware.Recovery(logger))
-	router.Use(middleware.Logger(logger))
-	router.Use(middleware.Metrics())
-
-	// Public routes
-	router.GET("/health", authHandler.Health)
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
-
-	// Auth routes
-	authGroup := router.Group("/auth")
-	{
-		authGroup.POST("/login", authHandler.Login)
-		authGroup.POST("/refresh", authHandler.Refresh)
-		authGroup.POST("/token", authHandler.LoginWithToken)
-		authGroup.POST("/logout", authHandler.Logout)
-
-		// OAuth token routes: LMS passes pre-obtained OmniAuth tokens for JWT issuance
-		authGroup.POST("/google", oauthHandler.GoogleTokenAuth)
-		authGroup.POST("/clever", oauthHandler.CleverTokenAuth)
-
-		// OAuth redirect-based routes (Reservoir-led flow)
-		authGroup.GET("/google", oauthHandler.GoogleLogin)
-		authGroup.GET("/google/callback", oauthHandler.GoogleCallback)
-		authGroup.GET("/clever", oauthHandler.CleverLogin)
-		authGroup.GET("/clever/callback", oauthHandler.CleverCallback)
-
-		// iCloud routes — client completes Sign in with Apple and sends the
-		// resulting ID token; the server issues a nonce and verifies the token.
-		authGroup.POST("/icloud/nonce", oauthHandler.ICloudNonce)
-		authGroup.POST("/icloud", oauthHandler.ICloudAuth)
-
-		// Protected routes (require authentication)
-		authGroup.Use(middleware.Auth(authService))
-		{
-			authGroup.GET("/me", authHandler.Me)
+	// Per-IP cap on concurrent credential logins, shared across instances.
+	var loginConcurrency *ratelimit.ConcurrencyGate
+	if cfg.RateLimit.MaxConcurrentLogins > 0 {
+		loginConcurrency = ratelimit.NewConcurrencyGate(redisClient.Client, cfg.RateLimit.MaxConcurrentLogins)
+	}
+
+	// Per-IP cap on requests of any kind, shared across instances.
+	var requestLimiter *ratelimit.RequestLimiter
+	if cfg.RateLimit.RequestsPerIP > 0 {
+		requestLimiter = ratelimit.NewRequestLimiter(redisClient.Client, cfg.RateLimit.RequestsPerIP, cfg.RateLimit.RequestWindow)
+	}
+
+	// Context Rails vouches for, when a shared secret is configured.
+	var boddleContext *boddlectx.Verifier
+	if cfg.BoddleContextSecret != "" {
+		boddleContext, err = boddlectx.NewVerifier([]byte(cfg.BoddleContextSecret))
+		if err != nil {
+			logger.Fatal("Invalid BODDLE_CONTEXT_SECRET", zap.Error(err))
 		}
 	}
 
+	drainer := middleware.NewDrainer()
+	router := newRouter(cfg, logger, nrApp, routes{
+		authService:      authService,
+		authHandler:      authHandler,
+		oauthHandler:     oauthHandler,
+		adminHandler:     adminHandler,
+		debugHandler:     debug.NewHandler(tokenService, tokenBlacklist),
+		loginConcurrency: loginConcurrency,
+		requestLimiter:   requestLimiter,
+		boddleContext:    boddleContext,
+		drainer:          drainer,
+	})
+
 	// Create HTTP server
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Port),
@@ -230,6 +413,14 @@ func main() {
 
 	logger.Info("Shutting down server...")
 
+	// Turn new requests away while in-flight ones finish, and give the load
+	// balancer ShutdownDrainDelay to notice before the listener closes.
+	drainer.Start()
+	if cfg.ShutdownDrainDelay > 0 {
+		logger.Info("Draining before shutdown", zap.Duration("delay", cfg.ShutdownDrainDelay))
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+
 	// Graceful shutdown with 5 second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -238,12 +429,15 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
-	// Flush any queued last_logged_on writes before exit. Use a fresh
-	// 3s deadline rather than reusing the server-shutdown ctx, which
-	// has already had part of its budget consumed by srv.Shutdown above.
-	flushCtx, flushCancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer flushCancel()
-	lastLoginWriter.Shutdown(flushCtx)
+	// Stop background workers only now that no request can enqueue more
+	// work, and wait for them to drain (e.g. queued last_logged_on writes).
+	// Use a fresh deadline rather than reusing the server-shutdown ctx, which
+	// has already had part of its budget consumed by server.Shutdown above.
+	workersCtx, workersCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer workersCancel()
+	if err := workers.Shutdown(workersCtx); err != nil {
+		logger.Warn("Background workers did not finish draining", zap.Error(err))
+	}
 
 	// Flush pending New Relic data before exit. No-op when the agent is
 	// disabled. Bounded so a network blip can't stall shutdown.
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"time"
+
+	"github.com/boddle/reservoir/internal/admin"
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/buildinfo"
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/boddle/reservoir/internal/debug"
+	"github.com/boddle/reservoir/internal/middleware"
+	"github.com/boddle/reservoir/internal/oauth"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	"github.com/boddle/reservoir/pkg/boddlectx"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// routes holds what the HTTP routes are wired to.
+type routes struct {
+	authService      *auth.Service
+	authHandler      *auth.Handler
+	oauthHandler     *oauth.Handler
+	adminHandler     *admin.Handler
+	debugHandler     *debug.Handler
+	loginConcurrency *ratelimit.ConcurrencyGate // nil: no concurrent-login cap
+	requestLimiter   *ratelimit.RequestLimiter  // nil: no per-IP request cap
+	boddleContext    *boddlectx.Verifier        // nil: X-Boddle-Context is ignored
+	drainer          *middleware.Drainer
+}
+
+// newRouter builds the gin engine: global middleware, then every route.
+// Kept out of main so the end-to-end tests exercise the same wiring.
+func newRouter(cfg *config.Config, logger *zap.Logger, nrApp *newrelic.Application, r routes) *gin.Engine {
+	router := gin.New()
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES", zap.Error(err))
+	}
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES", zap.Error(err))
+	}
+
+	// Sanitize the request before anything reads its headers.
+	router.Use(middleware.StripInboundHeaders(cfg.StripInboundHeaders, trustedProxies))
+
+	// Global middleware. nrgin runs next so every request becomes a
+	// New Relic transaction; downstream middleware and handlers that use
+	// c.Request.Context() (including DB calls via the nrpostgres driver)
+	// attach their work as segments to that transaction.
+	router.Use(nrgin.Middleware(nrApp))
+	router.Use(middleware.RequestID())
+	allowedOrigins := middleware.ParseAllowedOrigins(cfg.CORS.AllowedOrigins)
+	router.Use(middleware.CORS(allowedOrigins))
+	router.Use(middleware.SecurityHeaders())
+	router.Use(middleware.Recovery(logger))
+	router.Use(middleware.Logger(logger, cfg.LogRedactQueryParams, middleware.WithHandlerName(cfg.LogHandlerName)))
+	router.Use(middleware.Metrics())
+	requestAllowlist, err := middleware.ParseIPAllowlist(cfg.RateLimit.RequestAllowlist)
+	if err != nil {
+		logger.Fatal("Invalid RATE_LIMIT_REQUEST_ALLOWLIST", zap.Error(err))
+	}
+	router.Use(middleware.RateLimitPerIP(r.requestLimiter, requestAllowlist, logger))
+	router.Use(middleware.Drain(r.drainer))
+	router.Use(middleware.LoadShed(cfg.MaxInFlightRequests, time.Second))
+	naming, err := response.ParseNaming(cfg.ResponseNaming)
+	if err != nil {
+		logger.Fatal("Invalid RESPONSE_NAMING", zap.Error(err))
+	}
+	router.Use(middleware.NamingConvention(naming))
+	router.Use(middleware.APIVersion("1"))
+	if r.boddleContext != nil {
+		router.Use(middleware.BoddleContext(r.boddleContext))
+	}
+
+	authHandler, oauthHandler, adminHandler := r.authHandler, r.oauthHandler, r.adminHandler
+
+	// Public routes
+	router.GET("/health", authHandler.Health)
+	router.GET("/ready", authHandler.Ready)
+	router.GET("/version", buildinfo.Handler)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	loginGate := middleware.LoginConcurrency(r.loginConcurrency, logger)
+
+	// Auth routes
+	authGroup := router.Group("/auth")
+	{
+		authGroup.POST("/login", loginGate, authHandler.Login)
+		authGroup.POST("/refresh", authHandler.Refresh)
+		authGroup.POST("/token", loginGate, authHandler.LoginWithToken)
+		if cfg.RegistrationEnabled {
+			authGroup.POST("/register", loginGate, authHandler.Register)
+		}
+		authGroup.POST("/logout", authHandler.Logout)
+		if len(cfg.IntrospectionAPIKeys) > 0 {
+			serviceKey := middleware.ServiceKey(cfg.IntrospectionAPIKeys)
+			authGroup.POST("/introspect", serviceKey, authHandler.Introspect)
+			authGroup.POST("/introspect/batch", serviceKey, authHandler.IntrospectBatch)
+		}
+
+		// OAuth token routes: LMS passes pre-obtained OmniAuth tokens for JWT issuance
+		authGroup.POST("/google", oauthHandler.GoogleTokenAuth)
+		authGroup.POST("/clever", oauthHandler.CleverTokenAuth)
+
+		// OAuth redirect-based routes (Reservoir-led flow)
+		authGroup.GET("/google", oauthHandler.GoogleLogin)
+		authGroup.GET("/google/callback", oauthHandler.GoogleCallback)
+		authGroup.GET("/clever", oauthHandler.CleverLogin)
+		authGroup.GET("/clever/callback", oauthHandler.CleverCallback)
+		authGroup.GET("/oidc/:name", oauthHandler.OIDCLogin)
+		authGroup.GET("/oidc/:name/callback", oauthHandler.OIDCCallback)
+
+		// iCloud routes — client completes Sign in with Apple and sends the
+		// resulting ID token; the server issues a nonce and verifies the token.
+		authGroup.POST("/icloud/nonce", oauthHandler.ICloudNonce)
+		authGroup.POST("/icloud", oauthHandler.ICloudAuth)
+
+		// Protected routes (require authentication)
+		authGroup.Use(middleware.Auth(r.authService))
+		{
+			authGroup.GET("/me", authHandler.Me)
+			authGroup.PATCH("/me/locale", authHandler.UpdateLocale)
+			authGroup.GET("/security/activity", authHandler.SecurityActivity)
+			authGroup.POST("/refresh/revoke", authHandler.RevokeRefresh)
+			if cfg.JWT.RefreshTokenIndex {
+				authGroup.GET("/refresh-tokens", authHandler.ListRefreshTokens)
+				authGroup.DELETE("/refresh-tokens/:jti", authHandler.RevokeRefreshJTI)
+			}
+			authGroup.POST("/logout-all", authHandler.LogoutAll)
+
+			// Security review of one account's login attempts (Admin only)
+			authGroup.GET("/audit/attempts", middleware.RequireRole("Admin"), adminHandler.ListLoginAttempts)
+		}
+	}
+
+	// Admin routes (require an authenticated Admin)
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(middleware.Auth(r.authService), middleware.RequireRole("Admin"))
+	{
+		adminGroup.POST("/teachers/:id/verify", adminHandler.VerifyTeacher)
+		adminGroup.POST("/users/:id/invalidate-cache", adminHandler.InvalidateUserCache)
+		adminGroup.PUT("/users/:id/status", adminHandler.SetUserStatus)
+		adminGroup.PUT("/token-cutoffs/:meta_type", adminHandler.SetTokenCutoff)
+		adminGroup.GET("/audit-events", adminHandler.ListAuditEvents)
+		adminGroup.POST("/oauth-states/purge", adminHandler.PurgeOAuthStates)
+		adminGroup.GET("/oauth/selftest", adminHandler.OAuthSelfTest)
+	}
+
+	// Debug routes: only registered when ENV=development.
+	debug.RegisterRoutes(router, cfg, r.debugHandler)
+
+	return router
+}
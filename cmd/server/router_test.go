@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/boddle/reservoir/internal/middleware"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var userColumns = []string{
+	"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+	"last_logged_on", "token_version", "locale", "created_at", "updated_at",
+}
+
+var studentColumns = []string{
+	"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at",
+}
+
+// newTestServer serves the real router over HTTP, backed by miniredis and a
+// sqlmock database in place of Redis and Postgres. The OAuth and admin
+// handlers are left nil: these tests don't call their routes.
+func newTestServer(t *testing.T) (*httptest.Server, sqlmock.Sqlmock) {
+	t.Helper()
+	return newTestServerWithConfig(t, func(*config.Config) {})
+}
+
+// newTestServerWithConfig is newTestServer with cfg adjusted by configure
+// before the routes are built.
+func newTestServerWithConfig(t *testing.T, configure func(*config.Config)) (*httptest.Server, sqlmock.Sqlmock) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	logger := zap.NewNop()
+	cfg := &config.Config{Env: "test", CORS: config.CORSConfig{AllowedOrigins: "*"}}
+	configure(cfg)
+
+	tokenService := token.NewService("test-secret-key-at-least-32-bytes!", "test-refresh-key-at-least-32-bytes", 15*time.Minute, time.Hour)
+	blacklist := token.NewBlacklist(client)
+	limiter := ratelimit.NewLimiter(client, 15*time.Minute, 5, 15*time.Minute, logger)
+	authService := auth.NewService(user.NewRepository(sqlxDB, sqlxDB), tokenService, blacklist, limiter,
+		user.NewLastLoginWriter(sqlxDB, logger), logger)
+	authService.SetIssuedAtCutoffs(token.NewIssuedAtCutoffs(client, 0))
+
+	router := newRouter(cfg, logger, nil, routes{
+		authService: authService,
+		authHandler: auth.NewHandler(authService, nil, nil, nil),
+		drainer:     middleware.NewDrainer(),
+	})
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, mock
+}
+
+// call sends a request to srv and decodes the response envelope.
+func call(t *testing.T, srv *httptest.Server, method, path, accessToken, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("%s %s: decode body: %v", method, path, err)
+	}
+	return resp.StatusCode, envelope
+}
+
+func TestRouter_LoginMeLogoutLifecycle(t *testing.T) {
+	srv, mock := newTestServer(t)
+
+	digest, err := auth.HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	now := time.Now()
+	userRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now)
+	}
+	studentRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(studentColumns).AddRow(9, nil, nil, nil, nil, nil, now, now)
+	}
+
+	// Login
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("kid1@student.student").WillReturnRows(userRow())
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(userRow())
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).WillReturnRows(studentRow())
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	status, body := call(t, srv, http.MethodPost, "/auth/login", "",
+		`{"email":"kid1@student.student","password":"correct-horse"}`)
+	if status != http.StatusOK {
+		t.Fatalf("login status = %d, body %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	pair, _ := data["token"].(map[string]interface{})
+	accessToken, _ := pair["access_token"].(string)
+	if accessToken == "" {
+		t.Fatalf("login returned no access token: %v", body)
+	}
+
+	// Me with the new token
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(userRow())
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).WillReturnRows(studentRow())
+
+	status, body = call(t, srv, http.MethodGet, "/auth/me", accessToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("me status = %d, body %v", status, body)
+	}
+	data, _ = body["data"].(map[string]interface{})
+	me, _ := data["user"].(map[string]interface{})
+	if me["id"] != float64(42) {
+		t.Errorf("me user = %v, want id 42", me)
+	}
+
+	// Logout
+	mock.ExpectQuery(`UPDATE users SET token_version = token_version \+ 1`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(1))
+
+	if status, body = call(t, srv, http.MethodPost, "/auth/logout", accessToken, ""); status != http.StatusOK {
+		t.Fatalf("logout status = %d, body %v", status, body)
+	}
+
+	// The same token is now refused, without touching the database.
+	status, body = call(t, srv, http.MethodGet, "/auth/me", accessToken, "")
+	errBody, _ := body["error"].(map[string]interface{})
+	if status != http.StatusUnauthorized || errBody["code"] != apperrors.ErrCodeTokenRevoked {
+		t.Errorf("me after logout = %d %v, want 401 %s", status, errBody["code"], apperrors.ErrCodeTokenRevoked)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRouter_IntrospectBatchRequiresServiceKey(t *testing.T) {
+	post := func(srv *httptest.Server, key string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/auth/introspect/batch", strings.NewReader(`{"tokens":["x"]}`))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middleware.ServiceKeyHeader, key)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Without introspection keys the route isn't mounted at all.
+	srv, _ := newTestServer(t)
+	if status := post(srv, ""); status != http.StatusNotFound {
+		t.Errorf("no keys configured: status = %d, want 404", status)
+	}
+
+	srv, _ = newTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.IntrospectionAPIKeys = []string{"sidecar-key"}
+	})
+	if status := post(srv, ""); status != http.StatusUnauthorized {
+		t.Errorf("no key: status = %d, want 401", status)
+	}
+	if status := post(srv, "wrong-key"); status != http.StatusUnauthorized {
+		t.Errorf("wrong key: status = %d, want 401", status)
+	}
+	if status := post(srv, "sidecar-key"); status != http.StatusOK {
+		t.Errorf("valid key: status = %d, want 200", status)
+	}
+}
@@ -0,0 +1,379 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boddle/reservoir/internal/audit"
+	"github.com/boddle/reservoir/internal/oauth"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/boddle/reservoir/pkg/utctime"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Handler serves the /admin endpoints. Every route is expected to sit behind
+// middleware.Auth and middleware.RequireRole("Admin"); the handlers read the
+// acting admin from the claims to attribute audit events.
+type Handler struct {
+	userRepo  *user.Repository
+	userCache *user.Cache // nil when the user cache is disabled
+	cutoffs   *token.IssuedAtCutoffs
+	auditRepo *audit.Repository
+	states    StatePurger
+	selftest  OAuthSelfTester
+	sessions  SessionRevoker
+	logger    *zap.Logger
+}
+
+// SessionRevoker rejects a user's tokens minted below a token_version.
+// auth.Service satisfies it.
+type SessionRevoker interface {
+	PublishTokenVersion(ctx context.Context, userID, version int) error
+}
+
+// StatePurger deletes expired OAuth states. oauth.StateManager satisfies it.
+type StatePurger interface {
+	PurgeExpired(ctx context.Context) (int, error)
+}
+
+// OAuthSelfTester checks an OAuth provider's configuration.
+// oauth.SelfTester satisfies it.
+type OAuthSelfTester interface {
+	SelfTest(ctx context.Context, provider string) (oauth.SelfTestReport, error)
+}
+
+// NewHandler creates a new admin handler
+func NewHandler(userRepo *user.Repository, userCache *user.Cache, cutoffs *token.IssuedAtCutoffs, auditRepo *audit.Repository, states StatePurger, logger *zap.Logger) *Handler {
+	return &Handler{userRepo: userRepo, userCache: userCache, cutoffs: cutoffs, auditRepo: auditRepo, states: states, logger: logger}
+}
+
+// VerifyTeacher force-sets a teacher's verified flag for support cases that
+// can't wait on a Rails round-trip during the migration.
+// POST /admin/teachers/:id/verify
+func (h *Handler) VerifyTeacher(c *gin.Context) {
+	teacherID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || teacherID <= 0 {
+		response.ValidationError(c, "teacher id must be a positive integer")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.userRepo.SetTeacherVerified(ctx, teacherID, true); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.Error(c, apperrors.ErrNotFound)
+			return
+		}
+		response.Error(c, err)
+		return
+	}
+
+	// The cached /auth/me profile carries the teacher's verified flag.
+	usr, err := h.userRepo.FindUserByMeta(ctx, "Teacher", teacherID)
+	if err != nil {
+		h.logger.Warn("failed to find teacher's user to invalidate its cache",
+			zap.Int("teacher_id", teacherID),
+			zap.Error(err),
+		)
+	} else if usr != nil {
+		h.invalidateUserCache(ctx, usr.ID)
+	}
+
+	h.recordAudit(c, audit.Event{
+		Action:     audit.ActionTeacherVerified,
+		TargetType: "Teacher",
+		TargetID:   teacherID,
+	})
+
+	response.Success(c, http.StatusOK, gin.H{
+		"teacher_id":  teacherID,
+		"is_verified": true,
+	})
+}
+
+// InvalidateUserCache evicts a user from every instance's user cache, for
+// when a Rails-side change didn't publish its own invalidation.
+// POST /admin/users/:id/invalidate-cache
+func (h *Handler) InvalidateUserCache(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userID <= 0 {
+		response.ValidationError(c, "user id must be a positive integer")
+		return
+	}
+
+	if err := h.userCache.Invalidate(c.Request.Context(), userID); err != nil {
+		// This instance is already evicted; the others still hold the entry
+		// until it expires.
+		response.Error(c, err)
+		return
+	}
+
+	h.recordAudit(c, audit.Event{
+		Action:     audit.ActionUserCacheInvalidated,
+		TargetType: "User",
+		TargetID:   userID,
+	})
+
+	response.Success(c, http.StatusOK, gin.H{
+		"user_id":     userID,
+		"invalidated": true,
+	})
+}
+
+// SetUserStatusRequest is the body of PUT /admin/users/:id/status
+type SetUserStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// SetUserStatus suspends, disables ("deleted") or reactivates an account.
+// Any change also bumps the user's token_version, revoking their refresh
+// tokens, and publishes it so their access tokens stop working at once.
+// PUT /admin/users/:id/status { "status": "suspended" }
+func (h *Handler) SetUserStatus(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userID <= 0 {
+		response.ValidationError(c, "user id must be a positive integer")
+		return
+	}
+
+	var req SetUserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !user.ValidStatus(req.Status) {
+		response.ValidationError(c, "status must be one of active, suspended, deleted")
+		return
+	}
+
+	ctx := c.Request.Context()
+	version, err := h.userRepo.SetStatus(ctx, userID, req.Status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.Error(c, apperrors.ErrNotFound)
+			return
+		}
+		response.Error(c, err)
+		return
+	}
+
+	if h.sessions != nil {
+		if err := h.sessions.PublishTokenVersion(ctx, userID, version); err != nil {
+			// The status is saved and refresh tokens are dead; access
+			// tokens live out their TTL. Fail so the admin can retry.
+			response.Error(c, err)
+			return
+		}
+	}
+
+	// A cached /auth/me profile would keep showing the old status.
+	h.invalidateUserCache(ctx, userID)
+
+	h.recordAudit(c, audit.Event{
+		Action:     audit.ActionUserStatusChanged,
+		TargetType: "User",
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"status": req.Status},
+	})
+
+	response.Success(c, http.StatusOK, gin.H{
+		"user_id": userID,
+		"status":  req.Status,
+	})
+}
+
+// invalidateUserCache evicts userID's cached profile after an admin write to
+// it. A failure is logged: the write has been made, and the entry expires
+// with its TTL regardless.
+func (h *Handler) invalidateUserCache(ctx context.Context, userID int) {
+	if err := h.userCache.Invalidate(ctx, userID); err != nil {
+		h.logger.Warn("failed to invalidate user cache after admin change",
+			zap.Int("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
+
+// cutoffMetaTypes are the meta types a token cutoff can be set for.
+var cutoffMetaTypes = map[string]bool{"Student": true, "Teacher": true, "Parent": true, "Admin": true}
+
+// SetTokenCutoffRequest is the body of PUT /admin/token-cutoffs/:meta_type.
+// IssuedBefore defaults to now.
+type SetTokenCutoffRequest struct {
+	IssuedBefore *time.Time `json:"issued_before"`
+}
+
+// SetTokenCutoff revokes every token of a meta type issued before a cutoff,
+// forcing all those accounts to sign in again, without enumerating users.
+// Applies to access and refresh tokens; other instances pick it up within a
+// few seconds.
+// PUT /admin/token-cutoffs/:meta_type { "issued_before": "2026-10-15T00:00:00Z" }
+func (h *Handler) SetTokenCutoff(c *gin.Context) {
+	metaType := c.Param("meta_type")
+	if !cutoffMetaTypes[metaType] {
+		response.ValidationError(c, "meta_type must be one of Student, Teacher, Parent, Admin")
+		return
+	}
+
+	var req SetTokenCutoffRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ValidationError(c, "issued_before must be an RFC 3339 timestamp")
+			return
+		}
+	}
+	cutoff := time.Now()
+	if req.IssuedBefore != nil {
+		if req.IssuedBefore.After(cutoff) {
+			response.ValidationError(c, "issued_before must not be in the future")
+			return
+		}
+		cutoff = *req.IssuedBefore
+	}
+
+	if err := h.cutoffs.Set(c.Request.Context(), metaType, cutoff); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	h.recordAudit(c, audit.Event{
+		Action:     audit.ActionTokenCutoffSet,
+		TargetType: metaType,
+		Metadata:   map[string]interface{}{"issued_before": utctime.New(cutoff)},
+	})
+
+	response.Success(c, http.StatusOK, gin.H{
+		"meta_type":     metaType,
+		"issued_before": utctime.New(cutoff),
+	})
+}
+
+// PurgeOAuthStates deletes OAuth states left behind by abandoned sign-ins,
+// for when they pile up in Redis faster than their TTLs clear them.
+// POST /admin/oauth-states/purge
+func (h *Handler) PurgeOAuthStates(c *gin.Context) {
+	purged, err := h.states.PurgeExpired(c.Request.Context())
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	h.recordAudit(c, audit.Event{
+		Action:     audit.ActionOAuthStatesPurged,
+		TargetType: "OAuthState",
+		Metadata:   map[string]interface{}{"purged": purged},
+	})
+
+	response.Success(c, http.StatusOK, gin.H{"purged": purged})
+}
+
+// SetSessionRevoker makes SetUserStatus revoke the user's access tokens as
+// well as their refresh tokens.
+func (h *Handler) SetSessionRevoker(r SessionRevoker) {
+	h.sessions = r
+}
+
+// SetOAuthSelfTester enables GET /admin/oauth/selftest.
+func (h *Handler) SetOAuthSelfTester(t OAuthSelfTester) {
+	h.selftest = t
+}
+
+// OAuthSelfTest reports whether a provider's OAuth configuration looks
+// usable, check by check, without performing a login. The report is a 200
+// whether or not the checks pass. It is a 404 until SetOAuthSelfTester is
+// called.
+// GET /admin/oauth/selftest?provider=google|clever|icloud|oidc:<name>
+func (h *Handler) OAuthSelfTest(c *gin.Context) {
+	if h.selftest == nil {
+		response.Error(c, apperrors.ErrNotFound)
+		return
+	}
+
+	provider := c.Query("provider")
+	if provider == "" {
+		response.ValidationError(c, "provider is required")
+		return
+	}
+
+	report, err := h.selftest.SelfTest(c.Request.Context(), provider)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, report)
+}
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// ListAuditEvents lists audit events newest first, optionally filtered by
+// actor or target. Pages are capped at maxAuditPageSize and streamed; pass the
+// last event's id as before_id for the next page.
+// GET /admin/audit-events?actor_user_id=&target_type=&target_id=&before_id=&limit=
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	filter := audit.ListFilter{TargetType: c.Query("target_type"), Limit: defaultAuditPageSize}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"actor_user_id", &filter.ActorUserID},
+		{"target_id", &filter.TargetID},
+		{"limit", &filter.Limit},
+	} {
+		if raw := c.Query(p.name); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 {
+				response.ValidationError(c, p.name+" must be a positive integer")
+				return
+			}
+			*p.dst = v
+		}
+	}
+	if filter.Limit > maxAuditPageSize {
+		response.ValidationError(c, "limit must be at most "+strconv.Itoa(maxAuditPageSize))
+		return
+	}
+	if raw := c.Query("before_id"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v <= 0 {
+			response.ValidationError(c, "before_id must be a positive integer")
+			return
+		}
+		filter.BeforeID = v
+	}
+
+	err := response.StreamItems(c, func(emit func(interface{}) error) error {
+		return h.auditRepo.Each(c.Request.Context(), filter, func(e audit.Event) error {
+			return emit(e)
+		})
+	})
+	if err != nil {
+		h.logger.Error("failed to list audit events", zap.Error(err))
+	}
+}
+
+// recordAudit fills in the actor and IP from the request and writes the
+// event. The admin action has already been applied by the time this runs, so
+// a failed audit write is logged loudly rather than failing the response.
+func (h *Handler) recordAudit(c *gin.Context, e audit.Event) {
+	if claims, ok := c.Get("claims"); ok {
+		if tc, ok := claims.(*token.Claims); ok {
+			e.ActorUserID = tc.UserID
+		}
+	}
+	e.IPAddress = c.ClientIP()
+
+	if err := h.auditRepo.Record(c.Request.Context(), e); err != nil {
+		h.logger.Error("failed to record audit event",
+			zap.String("action", e.Action),
+			zap.Int("target_id", e.TargetID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,454 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/audit"
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/oauth"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newTestHandler wires a Handler whose user and audit repositories share one
+// sqlmock connection, so a test can assert the full sequence of writes.
+func newTestHandler(t *testing.T) (*Handler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	return NewHandler(user.NewRepository(sqlxDB, sqlxDB), nil, nil, audit.NewRepository(sqlxDB), nil, zap.NewNop()), mock
+}
+
+// serve runs a single request through a router with the admin claims
+// preloaded, standing in for the Auth + RequireRole middleware.
+func serve(h gin.HandlerFunc, method, route, target string, claims *token.Claims) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		c.Set("claims", claims)
+		h(c)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestVerifyTeacher_UpdatesAndAudits(t *testing.T) {
+	h, mock := newTestHandler(t)
+	h.userCache = user.NewCache(time.Hour, nil)
+	h.userCache.Set(&user.UserWithMeta{User: user.User{ID: 55}})
+
+	now := time.Now()
+	mock.ExpectExec(`UPDATE teachers SET is_verified`).
+		WithArgs(true, sqlmock.AnyArg(), 77).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+		WithArgs("Teacher", 77).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "meta_type", "meta_id", "created_at", "updated_at"}).
+			AddRow(55, "Ada Lovelace", "ada@school.edu", "Teacher", 77, now, now))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(9, audit.ActionTeacherVerified, "Teacher", 77, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := serve(h.VerifyTeacher, http.MethodPost, "/admin/teachers/:id/verify", "/admin/teachers/77/verify",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if _, ok := h.userCache.Get(55); ok {
+		t.Error("teacher's cached profile survived verification")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyTeacher_NotFound(t *testing.T) {
+	h, mock := newTestHandler(t)
+
+	mock.ExpectExec(`UPDATE teachers SET is_verified`).
+		WithArgs(true, sqlmock.AnyArg(), 404).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := serve(h.VerifyTeacher, http.MethodPost, "/admin/teachers/:id/verify", "/admin/teachers/404/verify",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	// No audit event is written for a no-op.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyTeacher_InvalidID(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	w := serve(h.VerifyTeacher, http.MethodPost, "/admin/teachers/:id/verify", "/admin/teachers/abc/verify",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestInvalidateUserCache_EvictsAndAudits(t *testing.T) {
+	h, mock := newTestHandler(t)
+	h.userCache = user.NewCache(time.Hour, nil)
+	h.userCache.Set(&user.UserWithMeta{User: user.User{ID: 55}})
+
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(9, audit.ActionUserCacheInvalidated, "User", 55, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := serve(h.InvalidateUserCache, http.MethodPost, "/admin/users/:id/invalidate-cache", "/admin/users/55/invalidate-cache",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if _, ok := h.userCache.Get(55); ok {
+		t.Error("user 55 still cached")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListAuditEvents_StreamsFilteredPage(t *testing.T) {
+	h, mock := newTestHandler(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM audit_events WHERE target_type = \$1 AND target_id = \$2 AND id < \$3 ORDER BY id DESC LIMIT \$4`).
+		WithArgs("Teacher", 77, int64(500), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor_user_id", "action", "target_type", "target_id", "ip_address", "metadata", "created_at"}).
+			AddRow(int64(42), 9, audit.ActionTeacherVerified, "Teacher", 77, "203.0.113.7", []byte(`{"reason":"support"}`), now).
+			AddRow(int64(17), nil, audit.ActionTeacherVerified, "Teacher", 77, nil, nil, now))
+
+	w := serve(h.ListAuditEvents, http.MethodGet, "/admin/audit-events",
+		"/admin/audit-events?target_type=Teacher&target_id=77&before_id=500&limit=2",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Items []audit.Event `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	items := resp.Data.Items
+	if len(items) != 2 || items[0].ID != 42 || items[0].Metadata["reason"] != "support" || items[1].ActorUserID != 0 {
+		t.Errorf("items = %+v", items)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListAuditEvents_EnforcesPageCap(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	for _, target := range []string{
+		"/admin/audit-events?limit=1001",
+		"/admin/audit-events?limit=0",
+		"/admin/audit-events?before_id=abc",
+	} {
+		w := serve(h.ListAuditEvents, http.MethodGet, "/admin/audit-events", target,
+			&token.Claims{UserID: 9, MetaType: "Admin"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSetUserStatus_RevokesAndAudits(t *testing.T) {
+	h, mock := newTestHandler(t)
+
+	mock.ExpectQuery(`UPDATE users SET status = \$1, token_version = token_version \+ 1`).
+		WithArgs("suspended", sqlmock.AnyArg(), 42).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(9, audit.ActionUserStatusChanged, "User", 42, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/admin/users/:id/status", func(c *gin.Context) {
+		c.Set("claims", &token.Claims{UserID: 9, MetaType: "Admin"})
+		h.SetUserStatus(c)
+	})
+	put := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := put("/admin/users/42/status", `{"status":"suspended"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := put("/admin/users/42/status", `{"status":"banished"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetTokenCutoff_StoresCutoffAndAudits(t *testing.T) {
+	h, mock := newTestHandler(t)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	h.cutoffs = token.NewIssuedAtCutoffs(client, 0)
+
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(9, audit.ActionTokenCutoffSet, "Student", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/admin/token-cutoffs/:meta_type", func(c *gin.Context) {
+		c.Set("claims", &token.Claims{UserID: 9, MetaType: "Admin"})
+		h.SetTokenCutoff(c)
+	})
+	put := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := put("/admin/token-cutoffs/Student", `{"issued_before":"2026-10-01T00:00:00Z"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if got, _ := mr.Get("token:min_iat:Student"); got != "1790812800" {
+		t.Errorf("stored cutoff = %q, want 1790812800", got)
+	}
+	if w := put("/admin/token-cutoffs/Robot", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown meta type: code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+type stubPurger struct{ purged int }
+
+func (p stubPurger) PurgeExpired(ctx context.Context) (int, error) { return p.purged, nil }
+
+func TestPurgeOAuthStates_ReportsAndAudits(t *testing.T) {
+	h, mock := newTestHandler(t)
+	h.states = stubPurger{purged: 12}
+
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(9, audit.ActionOAuthStatesPurged, "OAuthState", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := serve(h.PurgeOAuthStates, http.MethodPost, "/admin/oauth-states/purge", "/admin/oauth-states/purge",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Purged int `json:"purged"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Data.Purged != 12 {
+		t.Errorf("purged = %d, want 12", resp.Data.Purged)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+type stubSelfTester struct{}
+
+func (stubSelfTester) SelfTest(ctx context.Context, provider string) (oauth.SelfTestReport, error) {
+	if provider != "google" {
+		return oauth.SelfTestReport{}, apperrors.ErrNotFound
+	}
+	return oauth.SelfTestReport{Provider: provider, Checks: []oauth.SelfTestCheck{
+		{Name: "constructed", OK: true},
+		{Name: "redirect_url", Detail: "URL is not set"},
+	}}, nil
+}
+
+func TestOAuthSelfTest_ReportsChecks(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.SetOAuthSelfTester(stubSelfTester{})
+	claims := &token.Claims{UserID: 9, MetaType: "Admin"}
+
+	w := serve(h.OAuthSelfTest, http.MethodGet, "/admin/oauth/selftest", "/admin/oauth/selftest?provider=google", claims)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data oauth.SelfTestReport `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Data.OK || len(resp.Data.Checks) != 2 || resp.Data.Checks[1].Detail != "URL is not set" {
+		t.Errorf("report = %+v", resp.Data)
+	}
+
+	for target, want := range map[string]int{
+		"/admin/oauth/selftest":                  http.StatusBadRequest,
+		"/admin/oauth/selftest?provider=myspace": http.StatusNotFound,
+	} {
+		if w := serve(h.OAuthSelfTest, http.MethodGet, "/admin/oauth/selftest", target, claims); w.Code != want {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, want)
+		}
+	}
+}
+
+func TestOAuthSelfTest_NotConfigured(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	w := serve(h.OAuthSelfTest, http.MethodGet, "/admin/oauth/selftest", "/admin/oauth/selftest?provider=google",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+}
+
+func TestListLoginAttempts_PagesOneAccount(t *testing.T) {
+	h, mock := newTestHandler(t)
+	now := time.Now()
+	from, _ := time.Parse(time.RFC3339, "2026-01-01T00:00:00Z")
+	to, _ := time.Parse(time.RFC3339, "2026-02-01T00:00:00Z")
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_attempts WHERE email = \$1`).
+		WithArgs("kid1@student.student", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`ORDER BY attempted_at DESC, id DESC\s+LIMIT \$4 OFFSET \$5`).
+		WithArgs("kid1@student.student", from, to, 2, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "ip_address", "success", "attempted_at"}).
+			AddRow(3, "kid1@student.student", "203.0.113.7", false, now).
+			AddRow(2, "kid1@student.student", "198.51.100.4", true, now))
+
+	w := serve(h.ListLoginAttempts, http.MethodGet, "/auth/audit/attempts",
+		"/auth/audit/attempts?email=Kid1@student.student&from=2026-01-01T00:00:00Z&to=2026-02-01T00:00:00Z&limit=2",
+		&token.Claims{UserID: 9, MetaType: "Admin"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Attempts []user.LoginAttempt `json:"attempts"`
+			Total    int                 `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	// Admins see the full IP, unlike the account's own security activity.
+	if resp.Data.Total != 3 || len(resp.Data.Attempts) != 2 || resp.Data.Attempts[1].IPAddress != "198.51.100.4" {
+		t.Errorf("data = %+v", resp.Data)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListLoginAttempts_RejectsBadParams(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	for _, target := range []string{
+		"/auth/audit/attempts",
+		"/auth/audit/attempts?email=a@b.co&limit=1001",
+		"/auth/audit/attempts?email=a@b.co&offset=-1",
+		"/auth/audit/attempts?email=a@b.co&from=yesterday",
+		"/auth/audit/attempts?email=a@b.co&from=2026-02-01T00:00:00Z&to=2026-01-01T00:00:00Z",
+	} {
+		w := serve(h.ListLoginAttempts, http.MethodGet, "/auth/audit/attempts", target,
+			&token.Claims{UserID: 9, MetaType: "Admin"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSetUserStatus_SuspensionRejectsExistingAccessTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	userRepo := user.NewRepository(sqlxDB, sqlxDB)
+	ts := token.NewService("test-secret-key-minimum-32-chars", "test-refresh-secret-key-32-chars", 6*time.Hour, 24*time.Hour)
+	authService := auth.NewService(userRepo, ts, token.NewBlacklist(client), nil, nil, zap.NewNop())
+	authService.SetUserTokenVersions(token.NewUserTokenVersions(client, 6*time.Hour))
+	h := NewHandler(userRepo, nil, nil, audit.NewRepository(sqlxDB), nil, zap.NewNop())
+	h.SetSessionRevoker(authService)
+
+	// Signed in before the suspension, at token_version 0.
+	pair, err := ts.Generate(42, "uid-42", "kid@student.student", "Kid", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := authService.ValidateToken(ctx, pair.AccessToken); err != nil {
+		t.Fatalf("token rejected before the suspension: %v", err)
+	}
+
+	mock.ExpectQuery(`UPDATE users SET status = \$1, token_version = token_version \+ 1`).
+		WithArgs("suspended", sqlmock.AnyArg(), 42).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/admin/users/:id/status", func(c *gin.Context) {
+		c.Set("claims", &token.Claims{UserID: 9, MetaType: "Admin"})
+		h.SetUserStatus(c)
+	})
+	req := httptest.NewRequest(http.MethodPut, "/admin/users/42/status", strings.NewReader(`{"status":"suspended"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	if _, err := authService.ValidateToken(ctx, pair.AccessToken); !errors.Is(err, apperrors.ErrTokenRevoked) {
+		t.Errorf("access token after suspension: err = %v, want ErrTokenRevoked", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
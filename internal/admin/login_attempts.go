@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAttemptsWindow is how far back ListLoginAttempts looks when no from
+// is given.
+const defaultAttemptsWindow = 30 * 24 * time.Hour
+
+// ListLoginAttempts pages through one account's login attempts from any IP,
+// newest first, for security review. email is the login as recorded: an
+// email, or a student's username. from and to (RFC 3339) bound the range,
+// defaulting to the last 30 days. Pages are limit/offset, with the total
+// count; limit is capped at maxAuditPageSize.
+// GET /auth/audit/attempts?email=&from=&to=&limit=&offset=
+func (h *Handler) ListLoginAttempts(c *gin.Context) {
+	email := strings.ToLower(strings.TrimSpace(c.Query("email")))
+	if email == "" {
+		response.ValidationError(c, "email is required")
+		return
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			response.ValidationError(c, "to must be an RFC 3339 timestamp")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultAttemptsWindow)
+	if raw := c.Query("from"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			response.ValidationError(c, "from must be an RFC 3339 timestamp")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		response.ValidationError(c, "from must be before to")
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditPageSize)))
+	if err != nil || limit < 1 || limit > maxAuditPageSize {
+		response.ValidationError(c, "limit must be between 1 and "+strconv.Itoa(maxAuditPageSize))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		response.ValidationError(c, "offset must be a non-negative integer")
+		return
+	}
+
+	attempts, total, err := h.userRepo.FindLoginAttemptsByEmail(c.Request.Context(), email, from, to, limit, offset)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"attempts": attempts,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
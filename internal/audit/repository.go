@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boddle/reservoir/internal/database"
+	"github.com/boddle/reservoir/pkg/utctime"
+	"github.com/jmoiron/sqlx"
+)
+
+// Audit actions
+const (
+	ActionTeacherVerified      = "teacher.verified"
+	ActionUserCacheInvalidated = "user.cache_invalidated"
+	ActionUserStatusChanged    = "user.status_changed"
+	ActionTokenCutoffSet       = "token.cutoff_set"
+	ActionTokenIssued          = "token.issued"
+	ActionOAuthStatesPurged    = "oauth.states_purged"
+)
+
+// Event represents a row in the audit_events table
+type Event struct {
+	ID          int64                  `db:"id" json:"id"`
+	ActorUserID int                    `db:"actor_user_id" json:"actor_user_id,omitempty"`
+	Action      string                 `db:"action" json:"action"`
+	TargetType  string                 `db:"target_type" json:"target_type,omitempty"`
+	TargetID    int                    `db:"target_id" json:"target_id,omitempty"`
+	IPAddress   string                 `db:"ip_address" json:"ip_address,omitempty"`
+	Metadata    map[string]interface{} `db:"-" json:"metadata,omitempty"`
+	CreatedAt   utctime.Time           `db:"created_at" json:"created_at"`
+}
+
+// Repository persists audit events. Events are append-only and always
+// written to the primary.
+type Repository struct {
+	db           *sqlx.DB
+	queryTimeout time.Duration
+}
+
+// NewRepository creates a new audit repository
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// SetQueryTimeout bounds each Record by timeout (DB_QUERY_TIMEOUT) when its
+// context has no deadline. Each streams to its caller for as long as the
+// caller keeps reading, so it is left to the caller's context. 0 disables.
+func (r *Repository) SetQueryTimeout(timeout time.Duration) {
+	r.queryTimeout = timeout
+}
+
+// Record inserts an audit event. CreatedAt is set by the database.
+func (r *Repository) Record(ctx context.Context, e Event) error {
+	var metadata interface{}
+	if len(e.Metadata) > 0 {
+		b, err := json.Marshal(e.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit metadata: %w", err)
+		}
+		metadata = b
+	}
+
+	query := `INSERT INTO audit_events (actor_user_id, action, target_type, target_id, ip_address, metadata)
+			  VALUES (NULLIF($1, 0), $2, NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, ''), $6)`
+
+	ctx, cancel := database.WithQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, query, e.ActorUserID, e.Action, e.TargetType, e.TargetID, e.IPAddress, metadata)
+	if err = database.QueryError(ctx, err); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// RecordTokenIssued records that a token pair was minted for userID. Only the
+// access token's jti is stored, never the token. It satisfies
+// auth.TokenAuditor.
+func (r *Repository) RecordTokenIssued(ctx context.Context, userID int, method, ipAddress, jti string) error {
+	return r.Record(ctx, Event{
+		ActorUserID: userID,
+		Action:      ActionTokenIssued,
+		TargetType:  "User",
+		TargetID:    userID,
+		IPAddress:   ipAddress,
+		Metadata:    map[string]interface{}{"method": method, "jti": jti},
+	})
+}
+
+// ListFilter selects the events Each visits. Zero fields don't filter.
+type ListFilter struct {
+	ActorUserID int
+	TargetType  string
+	TargetID    int
+	BeforeID    int64 // keyset cursor: only events older than this id
+	Limit       int
+}
+
+// Each calls fn for every event matching f, newest first, as rows are read
+// from the database rather than after collecting them, so memory stays flat
+// however large the page. An error from fn stops the iteration and is
+// returned as is.
+func (r *Repository) Each(ctx context.Context, f ListFilter, fn func(Event) error) error {
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorUserID != 0 {
+		add("actor_user_id = $%d", f.ActorUserID)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.BeforeID != 0 {
+		add("id < $%d", f.BeforeID)
+	}
+
+	query := `SELECT id, actor_user_id, action, target_type, target_id, ip_address, metadata, created_at
+			  FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e                     Event
+			actorID, targetID     sql.NullInt64
+			targetType, ipAddress sql.NullString
+			metadata              []byte
+		)
+		if err := rows.Scan(&e.ID, &actorID, &e.Action, &targetType, &targetID, &ipAddress, &metadata, &e.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		e.ActorUserID = int(actorID.Int64)
+		e.TargetType = targetType.String
+		e.TargetID = int(targetID.Int64)
+		e.IPAddress = ipAddress.String
+		if len(metadata) > 0 {
+			if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+				return fmt.Errorf("failed to decode audit metadata: %w", err)
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return nil
+}
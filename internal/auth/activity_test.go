@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+var userColumns = []string{
+	"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+	"last_logged_on", "token_version", "locale", "created_at", "updated_at",
+}
+
+var studentColumns = []string{
+	"id", "username", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at",
+}
+
+func TestSecurityActivity_ScopedToCallerAndMasked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// The email comes from the caller's own row, so only their attempts are
+	// queried no matter what the token or request says.
+	mock.ExpectQuery(`FROM users\s+WHERE id`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(studentColumns).AddRow(9, nil, nil, nil, nil, nil, nil, now, now))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_attempts WHERE email = ANY\(\$1\) AND attempted_at`).
+		WithArgs(pq.Array([]string{"kid1@student.student"}), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`FROM login_attempts\s+WHERE email = ANY\(\$1\) AND attempted_at`).
+		WithArgs(pq.Array([]string{"kid1@student.student"}), sqlmock.AnyArg(), 2, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "ip_address", "success", "attempted_at"}).
+			AddRow(2, "kid1@student.student", "203.0.113.77", false, now).
+			AddRow(1, "kid1@student.student", "2001:db8:85a3:1:2:3:4:5", true, now.Add(-time.Hour)))
+
+	handler := &Handler{service: &Service{userRepo: repo}}
+	c, w := newTestContext(http.MethodGet, "/auth/security/activity?per_page=2", "", nil)
+	c.Set("claims", &token.Claims{UserID: 42, MetaType: "Student", Email: "someone-else@school.org"})
+
+	handler.SecurityActivity(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Attempts []LoginActivity `json:"attempts"`
+			HasMore  bool            `json:"has_more"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Data.Attempts) != 2 || resp.Data.HasMore {
+		t.Fatalf("got %d attempts (has_more=%v), want 2 and no more", len(resp.Data.Attempts), resp.Data.HasMore)
+	}
+	if got := resp.Data.Attempts[0].IPAddress; got != "203.0.113.*" {
+		t.Errorf("IPv4 masked as %q, want 203.0.113.*", got)
+	}
+	if got := resp.Data.Attempts[1].IPAddress; got != "2001:db8:85a3:1::*" {
+		t.Errorf("IPv6 masked as %q, want 2001:db8:85a3:1::*", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSecurityActivity_IncludesStudentUsernameSignIns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// Username sign-ins are recorded under the normalized username, not the
+	// student's synthetic email.
+	mock.ExpectQuery(`FROM users\s+WHERE id`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(studentColumns).AddRow(9, "Kid.One", nil, nil, nil, nil, nil, now, now))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_attempts WHERE email = ANY\(\$1\) AND attempted_at`).
+		WithArgs(pq.Array([]string{"kid1@student.student", "kid.one"}), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`FROM login_attempts\s+WHERE email = ANY\(\$1\) AND attempted_at`).
+		WithArgs(pq.Array([]string{"kid1@student.student", "kid.one"}), sqlmock.AnyArg(), 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "ip_address", "success", "attempted_at"}).
+			AddRow(1, "kid.one", "203.0.113.77", true, now))
+
+	activity, hasMore, err := (&Service{userRepo: repo}).RecentLoginActivity(context.Background(), 42, 1, 20)
+	if err != nil {
+		t.Fatalf("RecentLoginActivity: %v", err)
+	}
+	if len(activity) != 1 || hasMore {
+		t.Fatalf("got %d attempts (has_more=%v), want 1 and no more", len(activity), hasMore)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSecurityActivity_RejectsBadPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, query := range []string{"page=0", "per_page=0", "per_page=101", "page=abc"} {
+		handler := &Handler{service: &Service{}}
+		c, w := newTestContext(http.MethodGet, "/auth/security/activity?"+query, "", nil)
+		c.Set("claims", &token.Claims{UserID: 42})
+
+		handler.SecurityActivity(c)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMaskIP(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.20": "192.168.1.*",
+		"::1":          "::*",
+		"not-an-ip":    "*",
+	}
+	for in, want := range tests {
+		if got := maskIP(in); got != want {
+			t.Errorf("maskIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// Apps is the allowlist of Boddle app identifiers (JWT_APPS) a client may
+// scope its tokens to. A scoped token carries the app as its aud, so the
+// student game and the teacher dashboard each reject the other's tokens.
+type Apps map[string]bool
+
+// NewApps builds the allowlist from identifiers, skipping empty ones.
+func NewApps(ids []string) Apps {
+	apps := make(Apps, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			apps[id] = true
+		}
+	}
+	return apps
+}
+
+// Check accepts "" (an unscoped token) and any listed app, and rejects
+// anything else with ErrUnknownApp.
+func (a Apps) Check(app string) error {
+	if app == "" || a[app] {
+		return nil
+	}
+	return apperrors.ErrUnknownApp
+}
+
+// AppHeader names the app a request acts for on paths that validate an
+// access token (middleware.Auth, introspection). When set, only tokens
+// scoped to that app are accepted.
+const AppHeader = "X-Boddle-App"
+
+type appKey struct{}
+
+// WithApp returns a copy of ctx asking for tokens scoped to app. Handlers
+// set it once app has passed Apps.Check; every path that mints a pair reads
+// it back with AppFromContext, and ValidateToken and IntrospectBatch reject
+// tokens scoped to anything else.
+func WithApp(ctx context.Context, app string) context.Context {
+	return context.WithValue(ctx, appKey{}, app)
+}
+
+// AppFromContext returns the app ctx asks tokens to be scoped to, or "".
+func AppFromContext(ctx context.Context) string {
+	app, _ := ctx.Value(appKey{}).(string)
+	return app
+}
+
+// SetApps sets the apps a login may be scoped to; see CheckApp.
+func (s *Service) SetApps(apps Apps) {
+	s.apps = apps
+}
+
+// CheckApp validates a client-supplied app against the allowlist. With no
+// allowlist configured only "" passes.
+func (s *Service) CheckApp(app string) error {
+	return s.apps.Check(app)
+}
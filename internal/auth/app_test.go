@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestLogin_ScopesTokenToApp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, mock := newMockRepository(t)
+	expectStudentLogin(t, mock, "correct-horse")
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	tokens := newTestTokenService()
+	service := NewService(repo, tokens, nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	service.SetApps(NewApps([]string{"student-game", "teacher-dashboard"}))
+	handler := &Handler{service: service}
+
+	c, w := newTestContext(http.MethodPost, "/auth/login", `{"email":"kid1@student.student","password":"correct-horse","app":"student-game"}`, nil)
+	handler.Login(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Token struct {
+				AccessToken string `json:"access_token"`
+			} `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if _, err := tokens.ValidateAudience(resp.Data.Token.AccessToken, "student-game"); err != nil {
+		t.Errorf("token rejected by its own app: %v", err)
+	}
+	if _, err := tokens.ValidateAudience(resp.Data.Token.AccessToken, "teacher-dashboard"); err == nil {
+		t.Error("student-game token accepted by teacher-dashboard")
+	}
+}
+
+func TestLogin_UnknownApp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, _ := newMockRepository(t)
+	service := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	service.SetApps(NewApps([]string{"student-game"}))
+	handler := &Handler{service: service}
+
+	c, w := newTestContext(http.MethodPost, "/auth/login", `{"email":"kid1@student.student","password":"correct-horse","app":"someone-else"}`, nil)
+	handler.Login(c)
+	if w.Code != http.StatusBadRequest || errorCode(t, w.Body.Bytes()) != "UNKNOWN_APP" {
+		t.Errorf("got %d %s, want 400 UNKNOWN_APP", w.Code, w.Body.String())
+	}
+}
+
+func TestIntrospect_ChecksAudience(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	ts := newTestTokenService()
+	pair, err := ts.Generate(7, "", "", "", "Teacher", 3, 0, token.WithAudience("teacher-dashboard"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	service := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client)}
+	service.SetApps(NewApps([]string{"student-game", "teacher-dashboard"}))
+	handler := &Handler{service: service}
+
+	introspect := func(t *testing.T, app string) (int, map[string]interface{}) {
+		t.Helper()
+		c, w := newTestContext(http.MethodPost, "/auth/introspect", `{"token":"`+pair.AccessToken+`","app":"`+app+`"}`, nil)
+		handler.Introspect(c)
+		var got map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		return w.Code, got
+	}
+
+	if code, got := introspect(t, ""); code != http.StatusOK || got["active"] != true || got["aud"] != "teacher-dashboard" {
+		t.Errorf("no app = %d %v, want active with aud teacher-dashboard", code, got)
+	}
+	if code, got := introspect(t, "teacher-dashboard"); code != http.StatusOK || got["active"] != true {
+		t.Errorf("own app = %d %v, want active", code, got)
+	}
+	if code, got := introspect(t, "student-game"); code != http.StatusOK || got["active"] != false {
+		t.Errorf("other app = %d %v, want inactive", code, got)
+	}
+	if code, _ := introspect(t, "someone-else"); code != http.StatusBadRequest {
+		t.Errorf("unknown app = %d, want %d", code, http.StatusBadRequest)
+	}
+}
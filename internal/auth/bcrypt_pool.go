@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// BcryptPool caps how many bcrypt operations run at once. bcrypt is meant
+// to be slow, so a login storm can otherwise take every core and stall
+// unrelated requests; with the pool, excess logins queue for up to wait and
+// then fail fast with ErrServerBusy. A nil pool runs everything
+// immediately.
+type BcryptPool struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewBcryptPool allows max concurrent bcrypt operations, each queueing for a
+// slot at most wait. max <= 0 returns nil: no limit.
+func NewBcryptPool(max int, wait time.Duration) *BcryptPool {
+	if max <= 0 {
+		return nil
+	}
+	return &BcryptPool{slots: make(chan struct{}, max), wait: wait}
+}
+
+// Verify is VerifyPassword run in the pool.
+func (p *BcryptPool) Verify(ctx context.Context, password, hash string) error {
+	return p.run(ctx, func() error { return VerifyPassword(password, hash) })
+}
+
+// Hash is HashPassword run in the pool.
+func (p *BcryptPool) Hash(ctx context.Context, password string) (string, error) {
+	var hash string
+	err := p.run(ctx, func() error {
+		var err error
+		hash, err = HashPassword(password)
+		return err
+	})
+	return hash, err
+}
+
+// run calls fn once a slot is free. It returns ErrServerBusy without
+// calling fn if none frees up within the wait or ctx ends first, so callers
+// never mistake a skipped comparison for a wrong password.
+func (p *BcryptPool) run(ctx context.Context, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		timer := time.NewTimer(p.wait)
+		defer timer.Stop()
+		select {
+		case p.slots <- struct{}{}:
+		case <-timer.C:
+			return apperrors.ErrServerBusy
+		case <-ctx.Done():
+			return apperrors.ErrServerBusy
+		}
+	}
+	defer func() { <-p.slots }()
+	return fn()
+}
+
+// SetBcryptPool bounds the bcrypt work of password logins with p. A login
+// that can't get a slot fails with ErrServerBusy.
+func (s *Service) SetBcryptPool(p *BcryptPool) {
+	s.bcrypt = p
+}
+
+// comparePassword runs the login password comparison in s's pool.
+func (s *Service) comparePassword(ctx context.Context, password, hash string) error {
+	return s.bcrypt.run(ctx, func() error { return comparePassword(password, hash) })
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// fillPool occupies every slot of p until the returned func is called.
+func fillPool(t *testing.T, p *BcryptPool, slots int) (release func()) {
+	t.Helper()
+	hold := make(chan struct{})
+	var started, done sync.WaitGroup
+	for i := 0; i < slots; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			_ = p.run(context.Background(), func() error {
+				started.Done()
+				<-hold
+				return nil
+			})
+		}()
+	}
+	started.Wait()
+	return func() {
+		close(hold)
+		done.Wait()
+	}
+}
+
+func TestBcryptPool_ExcessWorkTimesOut(t *testing.T) {
+	p := NewBcryptPool(2, 50*time.Millisecond)
+	release := fillPool(t, p, 2)
+
+	// Every excess caller gives up after the wait, without running.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	ran := make([]bool, 8)
+	start := time.Now()
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.run(context.Background(), func() error { ran[i] = true; return nil })
+		}(i)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("excess callers took %v to give up, want about the 50ms wait", elapsed)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, apperrors.ErrServerBusy) || ran[i] {
+			t.Errorf("caller %d: err = %v, ran = %v; want ErrServerBusy without running", i, err, ran[i])
+		}
+	}
+
+	// Once the pool drains, work runs again.
+	release()
+	if err := p.run(context.Background(), func() error { return nil }); err != nil {
+		t.Errorf("run after release: %v", err)
+	}
+}
+
+func TestBcryptPool_QueuedWorkRunsWhenASlotFrees(t *testing.T) {
+	p := NewBcryptPool(1, time.Second)
+	release := fillPool(t, p, 1)
+
+	result := make(chan error, 1)
+	go func() { result <- p.run(context.Background(), func() error { return nil }) }()
+	time.Sleep(20 * time.Millisecond)
+	release()
+
+	if err := <-result; err != nil {
+		t.Errorf("queued run: %v", err)
+	}
+}
+
+func TestBcryptPool_CanceledWhileQueued(t *testing.T) {
+	p := NewBcryptPool(1, time.Minute)
+	release := fillPool(t, p, 1)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.run(ctx, func() error { return nil }); !errors.Is(err, apperrors.ErrServerBusy) {
+		t.Errorf("err = %v, want ErrServerBusy", err)
+	}
+}
+
+func TestBcryptPool_NilIsUnbounded(t *testing.T) {
+	p := NewBcryptPool(0, time.Second)
+	if p != nil {
+		t.Fatal("NewBcryptPool(0) should disable the pool")
+	}
+	if err := p.Verify(context.Background(), "pw", mustHashPassword("pw")); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestLogin_BcryptPoolFullReturnsServerBusy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, mock := newMockRepository(t)
+	// No login_attempts row: no password was compared, so nothing failed.
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	pool := NewBcryptPool(1, 10*time.Millisecond)
+	s.SetBcryptPool(pool)
+	defer fillPool(t, pool, 1)()
+
+	c, w := newTestContext(http.MethodPost, "/auth/login", `{"email":"kid1@student.student","password":"pw"}`, nil)
+	(&Handler{service: s}).Login(c)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error.Code != apperrors.ErrCodeServerBusy {
+		t.Errorf("code = %q, want %s", resp.Error.Code, apperrors.ErrCodeServerBusy)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogin_ServerBusyRefundsRateLimitCount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	limiter := ratelimit.NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 3, 15*time.Minute, zap.NewNop())
+	repo, mock := newMockRepository(t)
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+	pool := NewBcryptPool(1, 10*time.Millisecond)
+	s.SetBcryptPool(pool)
+	defer fillPool(t, pool, 1)()
+
+	// More busy logins than maxAttempts: none of them may count towards a
+	// lockout, since no password was ever compared.
+	for i := 0; i < 5; i++ {
+		mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+		_, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "pw", "203.0.113.7", "")
+		if !errors.Is(err, apperrors.ErrServerBusy) {
+			t.Fatalf("login %d: err = %v, want ErrServerBusy", i, err)
+		}
+	}
+	if count, err := limiter.GetAttemptCount(context.Background(), "kid1@student.student", "203.0.113.7"); err != nil || count != 0 {
+		t.Errorf("attempt count = %d, %v; want 0", count, err)
+	}
+}
+
+func TestLogin_DatabaseErrorRefundsRateLimitCount(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(errors.New("connection refused"))
+	limiter := &fakeLimiter{}
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+
+	if _, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "pw", "203.0.113.7", ""); err == nil {
+		t.Fatal("expected the database error")
+	}
+	if want := []string{"check and record", "refund"}; !reflect.DeepEqual(limiter.calls, want) {
+		t.Errorf("limiter calls = %v, want %v", limiter.calls, want)
+	}
+}
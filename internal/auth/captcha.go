@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// CaptchaVerifier checks a CAPTCHA response token submitted with a login once
+// the rate limiter's soft limit has been reached (e.g. against reCAPTCHA or
+// hCaptcha's siteverify endpoint).
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// NopCaptchaVerifier accepts any non-empty token. It is the default, so the
+// challenge flow can be exercised before a real provider is wired in; it
+// does not stop bots.
+type NopCaptchaVerifier struct{}
+
+// Verify reports whether token is non-empty.
+func (NopCaptchaVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return token != "", nil
+}
+
+// SetCaptchaVerifier replaces the default NopCaptchaVerifier.
+func (s *Service) SetCaptchaVerifier(v CaptchaVerifier) {
+	s.captcha = v
+}
+
+// passChallenge verifies captchaToken for a login the rate limiter has
+// challenged and, when it is solved, resets the soft limit. It fails closed:
+// a verifier error asks for the challenge again.
+func (s *Service) passChallenge(ctx context.Context, email, ipAddress, captchaToken string) error {
+	if captchaToken == "" {
+		return apperrors.ErrCaptchaRequired
+	}
+	var v CaptchaVerifier = NopCaptchaVerifier{}
+	if s.captcha != nil {
+		v = s.captcha
+	}
+	ok, err := v.Verify(ctx, captchaToken, ipAddress)
+	if err != nil {
+		s.log(ctx).Warn("captcha verification failed", zap.Error(err))
+		return apperrors.ErrCaptchaRequired
+	}
+	if !ok {
+		return apperrors.ErrCaptchaRequired
+	}
+	if err := s.rateLimiter.ClearChallenge(ctx, email, ipAddress); err != nil {
+		s.log(ctx).Warn("failed to clear captcha challenge", zap.Error(err))
+	}
+	return nil
+}
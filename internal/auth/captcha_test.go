@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type stubCaptcha struct{ valid string }
+
+func (s stubCaptcha) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return token == s.valid, nil
+}
+
+func TestLogin_CaptchaChallengeAfterSoftLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	limiter := ratelimit.NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 5, 15*time.Minute, zap.NewNop())
+	limiter.SetChallengeThreshold(2)
+	const email, ip = "kid1@student.student", "192.0.2.1"
+	for i := 0; i < 2; i++ {
+		if err := limiter.RecordFailedAttempt(context.Background(), email, ip); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+	}
+
+	repo, mock := newMockRepository(t)
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+	s.SetCaptchaVerifier(stubCaptcha{valid: "solved"})
+	handler := &Handler{service: s}
+
+	login := func(body string) (int, string) {
+		c, w := newTestContext(http.MethodPost, "/auth/login", body, nil)
+		handler.Login(c)
+		if w.Code == http.StatusOK {
+			return w.Code, ""
+		}
+		return w.Code, errorCode(t, w.Body.Bytes())
+	}
+
+	// Past the soft limit, without or with a wrong answer: challenged before
+	// the password is even looked at.
+	for _, body := range []string{
+		`{"email":"kid1@student.student","password":"correct-horse"}`,
+		`{"email":"kid1@student.student","password":"correct-horse","captcha_token":"guess"}`,
+	} {
+		if code, errCode := login(body); code != http.StatusForbidden || errCode != apperrors.ErrCodeCaptchaRequired {
+			t.Fatalf("status = %d, code = %q; want 403 %s", code, errCode, apperrors.ErrCodeCaptchaRequired)
+		}
+	}
+
+	// Solving it lets the login through; the soft counter is reset.
+	expectStudentLogin(t, mock, "correct-horse")
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if code, errCode := login(`{"email":"kid1@student.student","password":"correct-horse","captcha_token":"solved"}`); code != http.StatusOK {
+		t.Fatalf("status = %d, code = %q; want 200", code, errCode)
+	}
+	if mr.Exists(limiter.LoginChallengeKey(email, ip)) {
+		t.Error("challenge counter still set after a solved challenge")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
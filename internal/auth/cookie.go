@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/gin-gonic/gin"
+)
+
+// AccessTokenCookieName is the cookie a browser OAuth flow's access token is
+// set in (see AccessTokenCookie). middleware.Auth accepts it in place of an
+// Authorization header.
+const AccessTokenCookieName = "reservoir_access_token"
+
+// RefreshTokenCookieName is the cookie the matching refresh token is set in.
+// It is scoped to RefreshTokenCookiePath, so it only ever travels to
+// POST /auth/refresh (and /auth/refresh/revoke).
+const (
+	RefreshTokenCookieName = "reservoir_refresh_token"
+	RefreshTokenCookiePath = "/auth/refresh"
+)
+
+// AccessTokenCookie sets and clears the token cookies of web OAuth flows,
+// which redirect back to the app instead of returning the tokens in JSON.
+// Both cookies are HttpOnly, Secure and SameSite=Lax (Strict would keep them
+// off the redirect that follows the provider's callback). The access token
+// cookie lives as long as the access token; the refresh token cookie as
+// long as the refresh token, but only on the refresh path. Domain "" scopes
+// them to this host.
+type AccessTokenCookie struct {
+	Domain string
+}
+
+// Set stores pair's access and refresh tokens in their cookies.
+func (k AccessTokenCookie) Set(c *gin.Context, pair *token.TokenPair) {
+	k.write(c, AccessTokenCookieName, "/", pair.AccessToken, pair.ExpiresAt.Time)
+	if pair.RefreshToken != "" {
+		k.write(c, RefreshTokenCookieName, RefreshTokenCookiePath, pair.RefreshToken, pair.RefreshExpiresAt)
+	}
+}
+
+// Clear deletes both cookies.
+func (k AccessTokenCookie) Clear(c *gin.Context) {
+	k.write(c, AccessTokenCookieName, "/", "", time.Unix(0, 0))
+	k.write(c, RefreshTokenCookieName, RefreshTokenCookiePath, "", time.Unix(0, 0))
+}
+
+// Token returns the access token in the request's cookie, or "".
+func (k AccessTokenCookie) Token(c *gin.Context) string {
+	v, _ := c.Cookie(AccessTokenCookieName)
+	return v
+}
+
+// RefreshToken returns the refresh token in the request's cookie, or "".
+func (k AccessTokenCookie) RefreshToken(c *gin.Context) string {
+	v, _ := c.Cookie(RefreshTokenCookieName)
+	return v
+}
+
+// write sets cookie name to value until expires; an expiry already past
+// deletes it.
+func (k AccessTokenCookie) write(c *gin.Context, name, path, value string, expires time.Time) {
+	maxAge := int(time.Until(expires).Seconds())
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   k.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/pkg/utctime"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestAccessTokenCookie_Set(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	AccessTokenCookie{Domain: ".boddle.com"}.Set(c, &token.TokenPair{
+		AccessToken: "jwt",
+		ExpiresAt:   utctime.New(time.Now().Add(15 * time.Minute)),
+	})
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %v, want one", cookies)
+	}
+	k := cookies[0]
+	if k.Name != AccessTokenCookieName || k.Value != "jwt" || k.Domain != "boddle.com" || k.Path != "/" {
+		t.Errorf("cookie = %+v", k)
+	}
+	if !k.HttpOnly || !k.Secure || k.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie flags: HttpOnly=%v Secure=%v SameSite=%v", k.HttpOnly, k.Secure, k.SameSite)
+	}
+	// Lives as long as the access token.
+	if k.MaxAge < 14*60 || k.MaxAge > 15*60 {
+		t.Errorf("MaxAge = %d, want ~900", k.MaxAge)
+	}
+}
+
+func TestAccessTokenCookie_SetsRefreshCookieOnRefreshPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	AccessTokenCookie{}.Set(c, &token.TokenPair{
+		AccessToken:      "jwt",
+		RefreshToken:     "refresh-jwt",
+		ExpiresAt:        utctime.New(time.Now().Add(15 * time.Minute)),
+		RefreshExpiresAt: time.Now().Add(30 * 24 * time.Hour),
+	})
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("cookies = %v, want access and refresh", cookies)
+	}
+	k := cookies[1]
+	if k.Name != RefreshTokenCookieName || k.Value != "refresh-jwt" || k.Path != RefreshTokenCookiePath {
+		t.Errorf("refresh cookie = %+v", k)
+	}
+	if !k.HttpOnly || !k.Secure || k.SameSite != http.SameSiteLaxMode {
+		t.Errorf("refresh cookie flags: HttpOnly=%v Secure=%v SameSite=%v", k.HttpOnly, k.Secure, k.SameSite)
+	}
+	if k.MaxAge < 29*24*60*60 {
+		t.Errorf("MaxAge = %d, want about the refresh token's 30 days", k.MaxAge)
+	}
+}
+
+func TestLogout_RevokesAndClearsCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	ts := newTestTokenService()
+	handler := &Handler{service: &Service{userRepo: repo, tokenService: ts, tokenBlacklist: token.NewBlacklist(client)}}
+
+	pair, _ := ts.Generate(42, "uid-42", "kid@student.student", "Kid", "Student", 9, 0)
+	mock.ExpectQuery(`UPDATE users SET token_version`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(1))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	c.Request.AddCookie(&http.Cookie{Name: AccessTokenCookieName, Value: pair.AccessToken})
+	handler.Logout(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("cookies = %+v, want both token cookies cleared", cookies)
+	}
+	for i, name := range []string{AccessTokenCookieName, RefreshTokenCookieName} {
+		if cookies[i].Name != name || cookies[i].MaxAge >= 0 {
+			t.Errorf("cookie %d = %+v, want %s cleared", i, cookies[i], name)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefresh_FromCookieRotatesCookies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	ts := newTestTokenService()
+	handler := &Handler{service: &Service{userRepo: repo, tokenService: ts, tokenBlacklist: token.NewBlacklist(client), logger: zap.NewNop()}}
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, now, now))
+	pair, _ := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	c.Request.AddCookie(&http.Cookie{Name: AccessTokenCookieName, Value: pair.AccessToken})
+	c.Request.AddCookie(&http.Cookie{Name: RefreshTokenCookieName, Value: pair.RefreshToken})
+	handler.Refresh(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "refresh_token") || strings.Contains(w.Body.String(), "access_token") {
+		t.Errorf("body = %s, want the tokens kept out of it", w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != AccessTokenCookieName || cookies[1].Name != RefreshTokenCookieName {
+		t.Fatalf("cookies = %+v, want both token cookies set", cookies)
+	}
+	if cookies[1].Value == "" || cookies[1].Value == pair.RefreshToken {
+		t.Error("refresh cookie was not rotated")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
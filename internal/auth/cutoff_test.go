@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestValidateToken_RejectsTokensPredatingMetaTypeCutoff(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ts := newTestTokenService()
+	cutoffs := token.NewIssuedAtCutoffs(client, 0)
+	s := NewService(nil, ts, token.NewBlacklist(client), nil, nopEnqueuer{}, zap.NewNop())
+	s.SetIssuedAtCutoffs(cutoffs)
+	ctx := context.Background()
+
+	student, err := ts.Generate(1, "uid-1", "kid@student.student", "Kid", "Student", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	teacher, err := ts.Generate(2, "uid-2", "t@school.org", "Ms. T", "Teacher", 20, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if err := cutoffs.Set(ctx, "Student", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if _, err := s.ValidateToken(ctx, student.AccessToken); err == nil {
+		t.Error("student token issued before the Student cutoff was accepted")
+	}
+	if _, err := s.ValidateToken(ctx, teacher.AccessToken); err != nil {
+		t.Errorf("teacher token rejected by the Student cutoff: %v", err)
+	}
+
+	results, err := s.IntrospectBatch(ctx, []string{student.AccessToken, teacher.AccessToken})
+	if err != nil {
+		t.Fatalf("IntrospectBatch: %v", err)
+	}
+	if results[0].Active || !results[1].Active {
+		t.Errorf("introspection active = [%v %v], want [false true]", results[0].Active, results[1].Active)
+	}
+}
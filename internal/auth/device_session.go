@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	deviceSessionKeyPrefix = "session:device:"
+
+	// DeviceFingerprintHeader carries the client's device fingerprint on
+	// sign-in requests.
+	DeviceFingerprintHeader = "X-Device-Fingerprint"
+)
+
+// DeviceSessions keeps at most one live session per user per device: a new
+// sign-in from a device records its refresh family as that device's session,
+// and tokens of any earlier sign-in from the same device stop validating.
+// Sign-ins from other devices, or without a fingerprint, are unaffected.
+// Only a hash of the fingerprint is stored, and the hash is what tokens carry.
+type DeviceSessions struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewDeviceSessions creates a session store. An entry must outlive the
+// session's refresh token, so refreshTTL is the token service's.
+func NewDeviceSessions(client *redis.Client, refreshTTL time.Duration) *DeviceSessions {
+	return &DeviceSessions{client: client, ttl: refreshTTL}
+}
+
+// Claim makes family userID's current session on device, superseding any
+// earlier one. Refreshing a session claims it again to extend it.
+func (d *DeviceSessions) Claim(ctx context.Context, userID int, device, family string) error {
+	if err := d.client.Set(ctx, deviceSessionKey(userID, device), family, d.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to record device session: %w", err)
+	}
+	return nil
+}
+
+// Current returns userID's current session on device, if one is recorded.
+func (d *DeviceSessions) Current(ctx context.Context, userID int, device string) (family string, ok bool, err error) {
+	family, err = d.client.Get(ctx, deviceSessionKey(userID, device)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check device session: %w", err)
+	}
+	return family, true, nil
+}
+
+// Superseded reports whether claims' token belongs to a sign-in that a later
+// one from the same device replaced. Tokens not tied to a device never are,
+// and a nil *DeviceSessions supersedes nothing.
+func (d *DeviceSessions) Superseded(ctx context.Context, claims *token.Claims) (bool, error) {
+	if d == nil || claims.Device == "" {
+		return false, nil
+	}
+	current, ok, err := d.Current(ctx, claims.UserID, claims.Device)
+	if err != nil {
+		return false, err
+	}
+	return ok && current != claims.Session, nil
+}
+
+type deviceKey struct{}
+
+// WithDeviceFingerprint returns a copy of ctx whose sign-in comes from the
+// device with the given client-supplied fingerprint. "" means unknown.
+func WithDeviceFingerprint(ctx context.Context, fingerprint string) context.Context {
+	if fingerprint == "" {
+		return ctx
+	}
+	sum := sha256.Sum256([]byte(fingerprint))
+	return context.WithValue(ctx, deviceKey{}, hex.EncodeToString(sum[:16]))
+}
+
+// deviceFromContext returns the hashed fingerprint ctx signs in from, or "".
+func deviceFromContext(ctx context.Context) string {
+	device, _ := ctx.Value(deviceKey{}).(string)
+	return device
+}
+
+// SetDeviceSessions limits each user to one session per device fingerprint.
+// Off (nil) by default.
+func (s *Service) SetDeviceSessions(d *DeviceSessions) {
+	s.deviceSessions = d
+}
+
+// Option ties a new sign-in's pair to ctx's device. A nil *DeviceSessions
+// ties it to none.
+func (d *DeviceSessions) Option(ctx context.Context) token.ClaimOption {
+	if d == nil {
+		return token.WithDevice("")
+	}
+	return token.WithDevice(deviceFromContext(ctx))
+}
+
+// ClaimSignIn makes pair the current session of its device, if it is tied to
+// one, revoking the device's previous sign-in. A nil *DeviceSessions claims
+// nothing.
+func (d *DeviceSessions) ClaimSignIn(ctx context.Context, userID int, pair *token.TokenPair) error {
+	device := deviceFromContext(ctx)
+	if d == nil || device == "" {
+		return nil
+	}
+	return d.Claim(ctx, userID, device, pair.RefreshFamily)
+}
+
+// deviceOption ties a new sign-in's pair to ctx's device when sessions are
+// limited per device.
+func (s *Service) deviceOption(ctx context.Context) token.ClaimOption {
+	return s.deviceSessions.Option(ctx)
+}
+
+// claimDeviceSession makes pair the current session of its device, if it is
+// tied to one, revoking the device's previous sign-in.
+func (s *Service) claimDeviceSession(ctx context.Context, userID int, pair *token.TokenPair) error {
+	return s.deviceSessions.ClaimSignIn(ctx, userID, pair)
+}
+
+func deviceSessionKey(userID int, device string) string {
+	return deviceSessionKeyPrefix + strconv.Itoa(userID) + ":" + device
+}
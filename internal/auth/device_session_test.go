@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestDeviceSessions_SameDeviceKeepsOnlyNewestSession(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	for i := 0; i < 3; i++ {
+		expectStudentLogin(t, mock, "correct-horse")
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+		mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	s := NewService(repo, newTestTokenService(), token.NewBlacklist(client), &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	s.SetDeviceSessions(NewDeviceSessions(client, time.Hour))
+	login := func(fingerprint string) *LoginResponse {
+		t.Helper()
+		ctx := WithDeviceFingerprint(context.Background(), fingerprint)
+		resp, err := s.AuthenticateEmailPassword(ctx, "kid1@student.student", "correct-horse", "203.0.113.7", "")
+		if err != nil {
+			t.Fatalf("login from %q: %v", fingerprint, err)
+		}
+		return resp
+	}
+
+	first := login("ipad-1")
+	second := login("ipad-1")
+	laptop := login("laptop-7")
+
+	ctx := context.Background()
+	if _, err := s.ValidateToken(ctx, first.Token.AccessToken); !errors.Is(err, apperrors.ErrTokenRevoked) {
+		t.Errorf("superseded session: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.ValidateToken(ctx, second.Token.AccessToken); err != nil {
+		t.Errorf("newest session on the device rejected: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, laptop.Token.AccessToken); err != nil {
+		t.Errorf("session on another device rejected: %v", err)
+	}
+
+	results, err := s.IntrospectBatch(ctx, []string{first.Token.AccessToken, second.Token.AccessToken})
+	if err != nil {
+		t.Fatalf("IntrospectBatch: %v", err)
+	}
+	if results[0].Active || !results[1].Active {
+		t.Errorf("introspection active = [%v %v], want [false true]", results[0].Active, results[1].Active)
+	}
+	// The superseded sign-in can't refresh its way back either.
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", time.Now(), time.Now()))
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	if _, err := s.RefreshToken(ctx, first.Token.RefreshToken, ""); err == nil {
+		t.Error("superseded session refreshed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestLogin_RecordsFailureReasonWithoutLeakingIt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	digest, err := HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	userRow := func(digest string) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now)
+	}
+
+	tests := []struct {
+		name       string
+		expectUser func(mock sqlmock.Sqlmock)
+		wantReason string
+	}{
+		{
+			name: "unknown email",
+			expectUser: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+			},
+			wantReason: user.LoginFailureUnknownUser,
+		},
+		{
+			name: "wrong password",
+			expectUser: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(userRow(digest))
+			},
+			wantReason: user.LoginFailureWrongPassword,
+		},
+		{
+			name: "account without a password",
+			expectUser: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(userRow(""))
+			},
+			wantReason: user.LoginFailureWrongPassword,
+		},
+	}
+
+	var firstBody string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			tt.expectUser(mock)
+			mock.ExpectExec(`INSERT INTO login_attempts \(email, ip_address, success, attempted_at, reason\)`).
+				WithArgs("kid1@student.student", sqlmock.AnyArg(), false, sqlmock.AnyArg(), tt.wantReason).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+			s.SetLoginAttemptReasons(true)
+			handler := &Handler{service: s}
+
+			c, w := newTestContext(http.MethodPost, "/auth/login",
+				`{"email":"kid1@student.student","password":"wrong-horse"}`, nil)
+			handler.Login(c)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			// Every failure must read the same to the client.
+			if firstBody == "" {
+				firstBody = w.Body.String()
+			} else if w.Body.String() != firstBody {
+				t.Errorf("body = %s, want the same as the other failures: %s", w.Body.String(), firstBody)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLogin_FailureReasonNotStoredWhenDisabled(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+	// Four arguments: the reason column is left out for older schemas.
+	mock.ExpectExec(`INSERT INTO login_attempts \(email, ip_address, success, attempted_at\)`).
+		WithArgs("kid1@student.student", "203.0.113.7", false, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	if _, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "wrong-horse", "203.0.113.7", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
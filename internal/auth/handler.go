@@ -2,11 +2,15 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
 	"github.com/boddle/reservoir/pkg/response"
 	"github.com/gin-gonic/gin"
 )
@@ -17,21 +21,55 @@ type DBPinger interface {
 	Health(ctx context.Context) error
 }
 
+// readyRetryAfter is the Retry-After hint sent when /ready fails. Short
+// enough that the ALB re-probes promptly once the dependency recovers.
+const readyRetryAfter = 5 * time.Second
+
 // Handler handles authentication HTTP requests
 type Handler struct {
 	service  *Service
 	dbWriter DBPinger
 	dbReader DBPinger // nil when no dedicated read replica is configured
+	redis    DBPinger
+	cookie   AccessTokenCookie
 }
 
 // NewHandler creates a new authentication handler. Pass nil for dbReader when
 // no read replica is configured — it will be omitted from the health response.
-func NewHandler(service *Service, dbWriter DBPinger, dbReader DBPinger) *Handler {
-	return &Handler{service: service, dbWriter: dbWriter, dbReader: dbReader}
+// redis is probed by the readiness endpoint alongside the writer.
+func NewHandler(service *Service, dbWriter DBPinger, dbReader DBPinger, redis DBPinger) *Handler {
+	return &Handler{service: service, dbWriter: dbWriter, dbReader: dbReader, redis: redis}
+}
+
+// SetAccessTokenCookie sets the cookie web OAuth flows keep the access token
+// in, so Logout accepts and clears the same one.
+func (h *Handler) SetAccessTokenCookie(k AccessTokenCookie) {
+	h.cookie = k
+}
+
+// tokenOnlyParam lets privacy-sensitive clients (kiosks, embedded players)
+// ask the login endpoints for the token pair alone, keeping the user record
+// and meta (email, names, school details) out of the response. They call
+// /auth/me if they ever need the profile.
+const tokenOnlyParam = "token_only"
+
+// TokenOnlyRequested reports whether the request carries ?token_only=true.
+func TokenOnlyRequested(c *gin.Context) bool {
+	tokenOnly, _ := strconv.ParseBool(c.Query(tokenOnlyParam))
+	return tokenOnly
+}
+
+// ForClient returns r as the client asked to receive it: unchanged, or reduced
+// to the token pair when TokenOnlyRequested.
+func (r *LoginResponse) ForClient(c *gin.Context) *LoginResponse {
+	if TokenOnlyRequested(c) {
+		return &LoginResponse{Token: r.Token}
+	}
+	return r
 }
 
-// Login handles email/password login
-// POST /auth/login
+// Login handles email/password and student username/password login
+// POST /auth/login[?token_only=true]
 func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,40 +77,84 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	byUsername := strings.TrimSpace(req.Username) != ""
+	if byUsername == (req.Email != "") {
+		response.ValidationError(c, "exactly one of email or username is required")
+		return
+	}
+
+	if err := h.service.CheckApp(req.App); err != nil {
+		response.Error(c, err)
+		return
+	}
+	ctx := WithApp(c.Request.Context(), req.App)
+	ctx = WithDeviceFingerprint(ctx, c.GetHeader(DeviceFingerprintHeader))
+
 	// Get client IP address
 	ipAddress := c.ClientIP()
 
 	// Authenticate
-	result, err := h.service.AuthenticateEmailPassword(c.Request.Context(), req.Email, req.Password, ipAddress)
+	var result *LoginResponse
+	var err error
+	if byUsername {
+		result, err = h.service.AuthenticateUsernamePassword(ctx, req.Username, req.Password, ipAddress, req.CaptchaToken)
+	} else {
+		result, err = h.service.AuthenticateEmailPassword(ctx, req.Email, req.Password, ipAddress, req.CaptchaToken)
+	}
+	if errors.Is(err, apperrors.ErrServerBusy) {
+		c.Header("Retry-After", "1")
+	}
+	var lockedOut *LockedOutError
+	if errors.As(err, &lockedOut) {
+		c.Header("Retry-After", strconv.Itoa(lockedOut.RetryAfterSeconds()))
+	}
+	if hasAppError(err) {
+		response.Error(c, err)
+		return
+	}
 	if err != nil {
 		// Return 401 for invalid credentials
+		message := "Invalid email or password"
+		if byUsername {
+			message = "Invalid username or password"
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"error": gin.H{
 				"code":    "INVALID_CREDENTIALS",
-				"message": "Invalid email or password",
+				"message": message,
 			},
 		})
 		return
 	}
 
-	response.Success(c, http.StatusOK, result)
+	response.Success(c, http.StatusOK, result.ForClient(c))
 }
 
 // LoginWithToken handles login token authentication (magic links).
-// POST /auth/token — the secret is read from the Authorization header
+// POST /auth/token[?token_only=true] — the secret is read from the Authorization header
 // ("Bearer <secret>") or a JSON body {"token":"<secret>"}, never the query
 // string, which would leak the credential into access logs, browser history,
-// and Referer headers (security review Finding 3 / LMS-6514).
+// and Referer headers (security review Finding 3 / LMS-6514). AppHeader
+// scopes the issued tokens to one app, as "app" does for password login.
 func (h *Handler) LoginWithToken(c *gin.Context) {
 	secret := extractLoginTokenSecret(c)
 	if secret == "" {
 		response.ValidationError(c, "login token is required (send it as 'Authorization: Bearer <token>' or a JSON body {\"token\":\"...\"})")
 		return
 	}
+	ctx, ok := h.requestApp(c, "")
+	if !ok {
+		return
+	}
 
 	// Authenticate
-	result, err := h.service.AuthenticateLoginToken(c.Request.Context(), secret)
+	ctx = WithDeviceFingerprint(ctx, c.GetHeader(DeviceFingerprintHeader))
+	result, err := h.service.AuthenticateLoginToken(ctx, secret)
+	if hasAppError(err) {
+		response.Error(c, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
@@ -84,7 +166,16 @@ func (h *Handler) LoginWithToken(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, http.StatusOK, result)
+	response.Success(c, http.StatusOK, result.ForClient(c))
+}
+
+// hasAppError reports whether err carries an AppError (e.g. a suspended
+// account, CAPTCHA_REQUIRED). The service returns those for the client, so
+// they are passed through rather than folded into the generic sign-in
+// failure; anything else stays opaque.
+func hasAppError(err error) bool {
+	var appErr *apperrors.AppError
+	return errors.As(err, &appErr)
 }
 
 // extractLoginTokenSecret reads the magic-link secret from the Authorization
@@ -107,21 +198,26 @@ func extractLoginTokenSecret(c *gin.Context) string {
 
 // Logout handles logout (token revocation)
 // POST /auth/logout
+// The token comes from the Authorization header or, for web OAuth flows,
+// the access token cookie, which is cleared either way.
 func (h *Handler) Logout(c *gin.Context) {
 	// Get token from Authorization header
 	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
+	cookieToken := h.cookie.Token(c)
+	if authHeader == "" && cookieToken == "" {
 		response.ValidationError(c, "Authorization header is required")
 		return
 	}
 
 	// Extract token (format: "Bearer TOKEN")
-	tokenString := ""
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		tokenString = authHeader[7:]
-	} else {
-		response.ValidationError(c, "Invalid Authorization header format")
-		return
+	tokenString := cookieToken
+	if authHeader != "" {
+		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+			tokenString = authHeader[7:]
+		} else {
+			response.ValidationError(c, "Invalid Authorization header format")
+			return
+		}
 	}
 
 	// Revoke token
@@ -129,16 +225,130 @@ func (h *Handler) Logout(c *gin.Context) {
 		response.Error(c, err)
 		return
 	}
+	if cookieToken != "" {
+		h.cookie.Clear(c)
+	}
 
 	response.Success(c, http.StatusOK, gin.H{
 		"message": "Logged out successfully",
 	})
 }
 
+// LogoutAll signs the caller out on every device: all their access and
+// refresh tokens, including the one on this request, stop working.
+// POST /auth/logout-all
+func (h *Handler) LogoutAll(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeAllForUser(c.Request.Context(), claims.UserID); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"message": "Logged out everywhere",
+	})
+}
+
 // Me returns the authenticated user's information
 // GET /auth/me
 func (h *Handler) Me(c *gin.Context) {
-	// Get claims from context (set by auth middleware)
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	// Get full user data
+	userWithMeta, err := h.service.GetCurrentUser(c.Request.Context(), claims)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"user":             userWithMeta.User,
+		"meta":             userWithMeta.Meta,
+		"linked_providers": userWithMeta.LinkedProviders(),
+	})
+}
+
+// UpdateLocaleRequest is the body of PATCH /auth/me/locale
+type UpdateLocaleRequest struct {
+	Locale string `json:"locale"`
+}
+
+// UpdateLocale sets the authenticated user's preferred locale. The new value
+// appears in the locale claim of the next token issued (login or refresh). An
+// empty locale clears the preference.
+// PATCH /auth/me/locale { "locale": "es-MX" }
+func (h *Handler) UpdateLocale(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateLocaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ValidationError(c, "body must be a JSON object with a locale string")
+		return
+	}
+
+	if err := h.service.UpdateLocale(c.Request.Context(), claims.UserID, req.Locale); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{"locale": req.Locale})
+}
+
+// Pagination bounds for GET /auth/security/activity.
+const (
+	defaultActivityPerPage = 20
+	maxActivityPerPage     = 100
+)
+
+// SecurityActivity lists the caller's own recent login attempts so they can
+// spot sign-ins they don't recognise. Paginated with ?page= (1-based) and
+// ?per_page= (default 20, max 100).
+// GET /auth/security/activity
+func (h *Handler) SecurityActivity(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		response.ValidationError(c, "page must be a positive integer")
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultActivityPerPage)))
+	if err != nil || perPage < 1 || perPage > maxActivityPerPage {
+		response.ValidationError(c, fmt.Sprintf("per_page must be between 1 and %d", maxActivityPerPage))
+		return
+	}
+
+	activity, hasMore, err := h.service.RecentLoginActivity(c.Request.Context(), claims.UserID, page, perPage)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"attempts": activity,
+		"page":     page,
+		"per_page": perPage,
+		"has_more": hasMore,
+	})
+}
+
+// currentClaims returns the token claims set by the auth middleware. When
+// they are missing or malformed it writes the error response and returns
+// false, so callers can simply return.
+func currentClaims(c *gin.Context) (*token.Claims, bool) {
 	claimsInterface, exists := c.Get("claims")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -148,7 +358,7 @@ func (h *Handler) Me(c *gin.Context) {
 				"message": "Not authenticated",
 			},
 		})
-		return
+		return nil, false
 	}
 
 	claims, ok := claimsInterface.(*token.Claims)
@@ -160,32 +370,44 @@ func (h *Handler) Me(c *gin.Context) {
 				"message": "Invalid claims type",
 			},
 		})
-		return
-	}
-
-	// Get full user data
-	userWithMeta, err := h.service.GetCurrentUser(c.Request.Context(), claims)
-	if err != nil {
-		response.Error(c, err)
-		return
+		return nil, false
 	}
 
-	response.Success(c, http.StatusOK, gin.H{
-		"user": userWithMeta.User,
-		"meta": userWithMeta.Meta,
-	})
+	return claims, true
 }
 
-// Refresh exchanges a valid refresh token for a new token pair
-// POST /auth/refresh
+// Refresh exchanges a valid refresh token for a new token pair. If the
+// client sends its (possibly expired) access token as a Bearer header, the
+// two tokens must belong to the same user. Without a refresh_token in the
+// body the refresh token cookie is used, and the new pair is set in the
+// cookies rather than returned (see AccessTokenCookie).
+// POST /auth/refresh[?token_only=true]
 func (h *Handler) Refresh(c *gin.Context) {
+	// A browser signed in through a cookie flow sends its refresh token in
+	// the refresh cookie instead, and gets the new pair back the same way.
 	var req RefreshRequest
+	fromCookie := false
 	if err := c.ShouldBindJSON(&req); err != nil {
-		response.ValidationError(c, "refresh_token is required")
-		return
+		req.RefreshToken = h.cookie.RefreshToken(c)
+		if req.RefreshToken == "" {
+			response.ValidationError(c, "refresh_token is required")
+			return
+		}
+		fromCookie = true
+	}
+
+	accessToken := ""
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		accessToken = strings.TrimSpace(authHeader[7:])
+	} else if fromCookie {
+		accessToken = h.cookie.Token(c)
 	}
 
-	result, err := h.service.RefreshToken(c.Request.Context(), req.RefreshToken)
+	result, err := h.service.RefreshToken(c.Request.Context(), req.RefreshToken, accessToken)
+	if hasAppError(err) {
+		response.Error(c, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
@@ -197,7 +419,179 @@ func (h *Handler) Refresh(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, http.StatusOK, result)
+	if fromCookie {
+		// The tokens stay out of the body, where scripts could read them.
+		h.cookie.Set(c, result.Token)
+		response.Success(c, http.StatusOK, gin.H{"expires_at": result.Token.ExpiresAt})
+		return
+	}
+	response.Success(c, http.StatusOK, result.ForClient(c))
+}
+
+// RevokeRefresh revokes one of the caller's refresh tokens. Unlike Logout it
+// leaves the user's other sessions alone.
+// POST /auth/refresh/revoke
+func (h *Handler) RevokeRefresh(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ValidationError(c, "refresh_token is required")
+		return
+	}
+
+	if err := h.service.RevokeRefreshToken(c.Request.Context(), claims.UserID, req.RefreshToken); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"message": "Refresh token revoked",
+	})
+}
+
+// ListRefreshTokens lists the caller's outstanding refresh tokens by jti.
+// Mounted only when JWT_REFRESH_TOKEN_INDEX is on.
+// GET /auth/refresh-tokens
+func (h *Handler) ListRefreshTokens(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.service.ListRefreshTokens(c.Request.Context(), claims.UserID)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"refresh_tokens": tokens,
+	})
+}
+
+// RevokeRefreshJTI revokes one of the caller's refresh tokens by jti, as
+// listed by ListRefreshTokens.
+// DELETE /auth/refresh-tokens/:jti
+func (h *Handler) RevokeRefreshJTI(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeRefreshJTI(c.Request.Context(), claims.UserID, c.Param("jti")); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{
+		"message": "Refresh token revoked",
+	})
+}
+
+// IntrospectRequest is the body of POST /auth/introspect: RFC 7662's
+// form-encoded token=..., or the same as JSON.
+type IntrospectRequest struct {
+	Token string `form:"token" json:"token"`
+	// App, if set, reports tokens not scoped to that app inactive; see
+	// Apps. AppHeader works too.
+	App string `form:"app" json:"app"`
+}
+
+// Introspect tells a trusted service (the API gateway) whether an access
+// token is currently usable, so it needn't parse JWTs itself. The response
+// is RFC 7662's bare JSON rather than our envelope: an expired, malformed or
+// revoked token is {"active":false}, not an error. Only a failure to check
+// (Redis down) is an error. Mounted behind middleware.ServiceKey.
+// POST /auth/introspect token=...
+func (h *Handler) Introspect(c *gin.Context) {
+	var req IntrospectRequest
+	if err := c.ShouldBind(&req); err != nil || req.Token == "" {
+		response.ValidationError(c, "token is required")
+		return
+	}
+
+	ctx, ok := h.requestApp(c, req.App)
+	if !ok {
+		return
+	}
+	result, err := h.service.Introspect(ctx, req.Token)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
+// maxIntrospectBatch caps the tokens accepted by one introspection call.
+const maxIntrospectBatch = 100
+
+// IntrospectBatchRequest is the body of POST /auth/introspect/batch
+type IntrospectBatchRequest struct {
+	Tokens []string `json:"tokens" binding:"required"`
+	App    string   `json:"app"` // as IntrospectRequest.App
+}
+
+// IntrospectBatch reports whether each of a batch of access tokens is
+// currently usable, for sidecars that would otherwise validate them one
+// request at a time. Results are returned in request order.
+// POST /auth/introspect/batch { "tokens": ["...", ...] }
+func (h *Handler) IntrospectBatch(c *gin.Context) {
+	var req IntrospectBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Tokens) == 0 {
+		response.ValidationError(c, "tokens must be a non-empty array")
+		return
+	}
+	if len(req.Tokens) > maxIntrospectBatch {
+		response.ValidationError(c, fmt.Sprintf("at most %d tokens may be introspected per request", maxIntrospectBatch))
+		return
+	}
+
+	ctx, ok := h.requestApp(c, req.App)
+	if !ok {
+		return
+	}
+	results, err := h.service.IntrospectBatch(ctx, req.Tokens)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusOK, gin.H{"results": results})
+}
+
+// requestApp returns the request context scoped to the app a request names,
+// in its body or AppHeader. An unknown app is answered with an error and ok
+// false.
+func (h *Handler) requestApp(c *gin.Context, app string) (ctx context.Context, ok bool) {
+	if app == "" {
+		app = c.GetHeader(AppHeader)
+	}
+	if err := h.service.CheckApp(app); err != nil {
+		response.Error(c, err)
+		return nil, false
+	}
+	return WithApp(c.Request.Context(), app), true
+}
+
+// JWKS publishes the public keys that verify access tokens, for services
+// that validate them without sharing the HMAC secret: the current signing
+// key plus any rotated-out ones still in use. Served as a bare JWK set (no
+// response envelope) since that is what JWT libraries fetch. 404 when tokens
+// are HMAC-signed.
+// GET /.well-known/jwks.json
+func (h *Handler) JWKS(c *gin.Context) {
+	jwks, ok := h.service.tokenService.JWKSJSON()
+	if !ok {
+		response.Error(c, apperrors.NewAppError(apperrors.ErrCodeNotFound, "No public signing key configured", http.StatusNotFound))
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", jwks)
 }
 
 // Health returns service health with DB connectivity status.
@@ -225,3 +619,26 @@ func (h *Handler) Health(c *gin.Context) {
 
 	c.JSON(http.StatusOK, body)
 }
+
+// Ready reports whether the hard dependencies needed to serve auth traffic
+// (Postgres writer and Redis) are reachable. Unlike Health it fails with a
+// 503 and a Retry-After hint, so it is suitable for readiness gating but must
+// not be wired to the ALB liveness check.
+// GET /ready
+func (h *Handler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := h.dbWriter.Health(ctx); err != nil {
+		response.ServiceUnavailable(c, readyRetryAfter, "database unavailable")
+		return
+	}
+	if h.redis != nil {
+		if err := h.redis.Health(ctx); err != nil {
+			response.ServiceUnavailable(c, readyRetryAfter, "redis unavailable")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
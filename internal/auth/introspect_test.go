@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// roundTripCounter counts commands sent to Redis individually and in
+// pipelines.
+type roundTripCounter struct{ single, pipelines int }
+
+func (h *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.single++
+		return next(ctx, cmd)
+	}
+}
+
+func (h *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.pipelines++
+		return next(ctx, cmds)
+	}
+}
+
+func TestIntrospectBatch_MixedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	blacklist := token.NewBlacklist(client)
+
+	ts := newTestTokenService()
+	// Same signing keys, but tokens are already expired when issued.
+	expiredTS := token.NewService("test-secret-key-minimum-32-chars", "test-refresh-secret-key-32-chars", -time.Minute, time.Hour)
+
+	mint := func(s *token.Service, userID int) string {
+		pair, err := s.Generate(userID, "", "", "", "Teacher", userID, 0)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	valid := mint(ts, 1)
+	expired := mint(expiredTS, 2)
+	revoked := mint(ts, 3)
+	valid2 := mint(ts, 4)
+
+	revokedClaims, err := ts.Validate(revoked)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := blacklist.Add(context.Background(), revokedClaims.ID, revokedClaims.ExpiresAt.Time); err != nil {
+		t.Fatalf("blacklist.Add: %v", err)
+	}
+
+	counter := &roundTripCounter{}
+	client.AddHook(counter)
+
+	handler := &Handler{service: &Service{tokenService: ts, tokenBlacklist: blacklist}}
+	body, _ := json.Marshal(IntrospectBatchRequest{Tokens: []string{valid, expired, revoked, "not-a-jwt", valid2}})
+	c, w := newTestContext(http.MethodPost, "/auth/introspect/batch", string(body), nil)
+	handler.IntrospectBatch(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Results []map[string]interface{} `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	results := resp.Data.Results
+	wantActive := []bool{true, false, false, false, true}
+	if len(results) != len(wantActive) {
+		t.Fatalf("got %d results, want %d", len(results), len(wantActive))
+	}
+	for i, want := range wantActive {
+		if results[i]["active"] != want {
+			t.Errorf("result %d active = %v, want %v", i, results[i]["active"], want)
+		}
+		if !want && len(results[i]) != 1 {
+			t.Errorf("inactive result %d leaks fields: %v", i, results[i])
+		}
+	}
+	if results[0]["sub"] != "1" || results[4]["sub"] != "4" || results[0]["jti"] == "" || results[0]["exp"] == nil {
+		t.Errorf("active results missing claims: %v, %v", results[0], results[4])
+	}
+
+	// Three tokens needed a blacklist lookup; they share one pipeline.
+	if counter.pipelines != 1 || counter.single != 0 {
+		t.Errorf("redis round-trips: %d pipelines, %d single commands; want 1 pipeline", counter.pipelines, counter.single)
+	}
+}
+
+func TestIntrospectBatch_RejectsEmptyAndOversized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &Handler{service: &Service{tokenService: newTestTokenService()}}
+
+	tooMany := make([]string, maxIntrospectBatch+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", "t")
+	}
+	for name, body := range map[string]string{
+		"empty":     `{"tokens":[]}`,
+		"missing":   `{}`,
+		"oversized": `{"tokens":[` + strings.Join(tooMany, ",") + `]}`,
+	} {
+		c, w := newTestContext(http.MethodPost, "/auth/introspect/batch", body, nil)
+		handler.IntrospectBatch(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestIntrospectBatch_FailsClosedWhenRedisDown(t *testing.T) {
+	// Nothing listens here, so the blacklist lookup errors.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	ts := newTestTokenService()
+	pair, err := ts.Generate(1, "", "", "", "Teacher", 1, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	s := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client)}
+	if _, err := s.IntrospectBatch(context.Background(), []string{pair.AccessToken}); err == nil {
+		t.Error("expected an error when the blacklist can't be checked")
+	}
+}
+
+func TestIntrospect_SingleToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	blacklist := token.NewBlacklist(client)
+
+	ts := newTestTokenService()
+	expiredTS := token.NewService("test-secret-key-minimum-32-chars", "test-refresh-secret-key-32-chars", -time.Minute, time.Hour)
+	mint := func(s *token.Service) string {
+		pair, err := s.Generate(7, "", "", "", "Teacher", 3, 0)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	active, expired, revoked := mint(ts), mint(expiredTS), mint(ts)
+	revokedClaims, err := ts.Validate(revoked)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := blacklist.Add(context.Background(), revokedClaims.ID, revokedClaims.ExpiresAt.Time); err != nil {
+		t.Fatalf("blacklist.Add: %v", err)
+	}
+
+	handler := &Handler{service: &Service{tokenService: ts, tokenBlacklist: blacklist}}
+	introspect := func(t *testing.T, tok string) map[string]interface{} {
+		t.Helper()
+		c, w := newTestContext(http.MethodPost, "/auth/introspect", "token="+tok, map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+		handler.Introspect(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		return got
+	}
+
+	got := introspect(t, active)
+	if got["active"] != true || got["sub"] != "7" || got["user_id"] != float64(7) || got["meta_type"] != "Teacher" || got["exp"] == nil || got["iat"] == nil {
+		t.Errorf("active token = %v, want active with sub, user_id, meta_type, exp and iat", got)
+	}
+	for name, tok := range map[string]string{"expired": expired, "revoked": revoked, "garbage": "not-a-jwt"} {
+		if got := introspect(t, tok); got["active"] != false || len(got) != 1 {
+			t.Errorf("%s token = %v, want only {active:false}", name, got)
+		}
+	}
+
+	// A missing token is the caller's mistake, not an inactive token.
+	c, w := newTestContext(http.MethodPost, "/auth/introspect", `{}`, nil)
+	handler.Introspect(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing token: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
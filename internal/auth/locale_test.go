@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// newMockRepository returns a user.Repository backed by sqlmock, using the
+// same handle for writer and reader.
+func newMockRepository(t *testing.T) (*user.Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	return user.NewRepository(sqlxDB, sqlxDB), mock
+}
+
+func TestUpdateLocale_SetsLocale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo, mock := newMockRepository(t)
+	mock.ExpectExec(`UPDATE users SET locale`).
+		WithArgs("es-MX", sqlmock.AnyArg(), 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := &Handler{service: &Service{userRepo: repo}}
+	c, w := newTestContext(http.MethodPatch, "/auth/me/locale", `{"locale":"es-MX"}`, nil)
+	c.Set("claims", &token.Claims{UserID: 42})
+
+	handler.UpdateLocale(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Locale string `json:"locale"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.Data.Locale != "es-MX" {
+		t.Errorf("locale = %q, want es-MX", resp.Data.Locale)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateLocale_RejectsInvalidTag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// No expectations: an invalid tag must be rejected before any write.
+	repo, mock := newMockRepository(t)
+
+	handler := &Handler{service: &Service{userRepo: repo}}
+	c, w := newTestContext(http.MethodPatch, "/auth/me/locale", `{"locale":"<script>"}`, nil)
+	c.Set("claims", &token.Claims{UserID: 42})
+
+	handler.UpdateLocale(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateLocale_RejectsMalformedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// No expectations: a body that doesn't bind must be rejected before any write.
+	repo, mock := newMockRepository(t)
+
+	handler := &Handler{service: &Service{userRepo: repo}}
+	c, w := newTestContext(http.MethodPatch, "/auth/me/locale", `{"locale":42}`, nil)
+	c.Set("claims", &token.Claims{UserID: 42})
+
+	handler.UpdateLocale(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateLocale_RequiresClaims(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := &Handler{service: nil}
+	c, w := newTestContext(http.MethodPatch, "/auth/me/locale", `{"locale":"en"}`, nil)
+
+	handler.UpdateLocale(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// LockedOutError reports that the rate limiter refused a password login.
+// Remaining is how long the lockout has left. It unwraps to
+// ErrRateLimitExceeded, so the handler answers 429 RATE_LIMIT_EXCEEDED
+// rather than folding it into the generic INVALID_CREDENTIALS.
+type LockedOutError struct {
+	Remaining time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, locked out for %v", e.Remaining.Round(time.Second))
+}
+
+func (e *LockedOutError) Unwrap() error {
+	return apperrors.ErrRateLimitExceeded
+}
+
+// RetryAfterSeconds is Remaining as a Retry-After value: whole seconds,
+// rounded up, minimum 1.
+func (e *LockedOutError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.Remaining.Seconds())))
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// lockedLimiter refuses every login with lockout left on the clock.
+type lockedLimiter struct {
+	fakeLimiter
+	lockout time.Duration
+}
+
+func (f *lockedLimiter) CheckAndRecord(ctx context.Context, email, ipAddress string) (bool, int, time.Duration, bool, error) {
+	f.calls = append(f.calls, "check and record")
+	return false, 0, f.lockout, false, nil
+}
+
+func TestLogin_LockoutReturns429WithRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		lockout time.Duration
+		want    string
+	}{
+		{15 * time.Minute, "900"},
+		{90*time.Second + 200*time.Millisecond, "91"},
+		{300 * time.Millisecond, "1"},
+	} {
+		// No expectations: a locked-out login never reaches the database.
+		repo, mock := newMockRepository(t)
+		s := NewService(repo, newTestTokenService(), nil, &lockedLimiter{lockout: tc.lockout}, nopEnqueuer{}, zap.NewNop())
+
+		c, w := newTestContext(http.MethodPost, "/auth/login", `{"email":"kid1@student.student","password":"pw"}`, nil)
+		(&Handler{service: s}).Login(c)
+
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("lockout %v: status = %d, want 429", tc.lockout, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != tc.want {
+			t.Errorf("lockout %v: Retry-After = %q, want %q", tc.lockout, got, tc.want)
+		}
+		var resp struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Error.Code != apperrors.ErrCodeRateLimitExceeded {
+			t.Errorf("lockout %v: code = %q, want %s", tc.lockout, resp.Error.Code, apperrors.ErrCodeRateLimitExceeded)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAuthenticateEmailPassword_LockoutIsTyped(t *testing.T) {
+	repo, _ := newMockRepository(t)
+	s := NewService(repo, newTestTokenService(), nil, &lockedLimiter{lockout: time.Minute}, nopEnqueuer{}, zap.NewNop())
+
+	_, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "pw", "203.0.113.7", "")
+	var lockedOut *LockedOutError
+	if !errors.As(err, &lockedOut) || lockedOut.Remaining != time.Minute {
+		t.Fatalf("err = %v, want a *LockedOutError with 1m remaining", err)
+	}
+	if !errors.Is(err, apperrors.ErrRateLimitExceeded) {
+		t.Error("a lockout should unwrap to ErrRateLimitExceeded")
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+// fakeLimiter records the limiter calls a login makes, in order. onSuccess,
+// if set, runs inside RecordSuccessfulAttempt so a test can inspect state at
+// the moment the counter is reset.
+type fakeLimiter struct {
+	calls     []string
+	onSuccess func()
+}
+
+func (f *fakeLimiter) CheckAndRecord(ctx context.Context, email, ipAddress string) (bool, int, time.Duration, bool, error) {
+	f.calls = append(f.calls, "check and record")
+	return true, 4, 0, false, nil
+}
+
+func (f *fakeLimiter) RecordSuccessfulAttempt(ctx context.Context, email, ipAddress string) error {
+	f.calls = append(f.calls, "success")
+	if f.onSuccess != nil {
+		f.onSuccess()
+	}
+	return nil
+}
+
+func (f *fakeLimiter) RefundAttempt(ctx context.Context, email, ipAddress string) error {
+	f.calls = append(f.calls, "refund")
+	return nil
+}
+
+func (f *fakeLimiter) ClearChallenge(ctx context.Context, email, ipAddress string) error {
+	f.calls = append(f.calls, "clear challenge")
+	return nil
+}
+
+func (f *fakeLimiter) CheckByIP(ctx context.Context, ipAddress string) (bool, time.Duration, error) {
+	f.calls = append(f.calls, "check ip")
+	return true, 0, nil
+}
+
+func (f *fakeLimiter) RecordByIP(ctx context.Context, ipAddress string) error {
+	f.calls = append(f.calls, "failed ip")
+	return nil
+}
+
+type nopEnqueuer struct{}
+
+func (nopEnqueuer) Enqueue(int) {}
+
+func expectStudentLogin(t *testing.T, mock sqlmock.Sqlmock, password string) {
+	t.Helper()
+	digest, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	now := time.Now()
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now)
+	}
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("kid1@student.student").WillReturnRows(row())
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(row())
+}
+
+func TestAuthenticateEmailPassword_SuccessClearsAttemptsInOrder(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	expectStudentLogin(t, mock, "correct-horse")
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO login_attempts`).
+		WithArgs("kid1@student.student", "203.0.113.7", true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	limiter := &fakeLimiter{}
+	limiter.onSuccess = func() {
+		// The durable success record must already be written when the
+		// Redis counter is cleared.
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("counter reset before the success was recorded: %v", err)
+		}
+	}
+
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+	resp, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "correct-horse", "203.0.113.7", "")
+	if err != nil {
+		t.Fatalf("AuthenticateEmailPassword: %v", err)
+	}
+	if resp.Token == nil {
+		t.Fatal("expected a token pair")
+	}
+
+	want := []string{"check and record", "success"}
+	if len(limiter.calls) != len(want) || limiter.calls[0] != want[0] || limiter.calls[1] != want[1] {
+		t.Errorf("limiter calls = %v, want %v", limiter.calls, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthenticateEmailPassword_NoResetWhenLoginFailsLate(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	expectStudentLogin(t, mock, "correct-horse")
+	// Password is right but loading the profile fails: the login never
+	// completes, so the failed-attempt counter must survive.
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).WillReturnError(errors.New("connection reset"))
+
+	limiter := &fakeLimiter{}
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+
+	if _, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "correct-horse", "203.0.113.7", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, call := range limiter.calls {
+		if call == "success" {
+			t.Fatalf("limiter calls = %v; counter was reset for a failed login", limiter.calls)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
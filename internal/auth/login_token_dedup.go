@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginTokenDedupKeyPrefix = "login_token:consume:"
+
+	// loginTokenPending marks a secret whose first consumption is still in
+	// flight; it is replaced by the user ID once the token is consumed.
+	loginTokenPending = "pending"
+
+	loginTokenPollInterval = 25 * time.Millisecond
+)
+
+// LoginTokenDedup lets a one-time login token (magic link) be consumed more
+// than once within a short window, so a client that double-submits the same
+// link (flaky mobile networks retry) signs in twice instead of once plus an
+// "invalid token". The first consumption claims the secret in Redis; others
+// arriving meanwhile wait for it and then sign in as the same user. Only a
+// hash of the secret is stored.
+type LoginTokenDedup struct {
+	client *redis.Client
+	window time.Duration
+}
+
+// NewLoginTokenDedup creates a dedup with the given replay window. It bounds
+// both how long a concurrent request waits and how long after consumption a
+// retry still succeeds.
+func NewLoginTokenDedup(client *redis.Client, window time.Duration) *LoginTokenDedup {
+	return &LoginTokenDedup{client: client, window: window}
+}
+
+// Claim tries to become the first consumer of secret. If another request
+// already consumed it, Claim waits for that to finish and returns the user
+// it signed in, with ok set. Otherwise the caller goes on to look the token
+// up itself; if claimed it must finish with Consumed or Release.
+func (d *LoginTokenDedup) Claim(ctx context.Context, secret string) (userID int, ok, claimed bool, err error) {
+	key := loginTokenDedupKey(secret)
+	claimed, err = d.client.SetNX(ctx, key, loginTokenPending, d.window).Result()
+	if err != nil || claimed {
+		return 0, false, claimed, err
+	}
+
+	deadline := time.Now().Add(d.window)
+	for {
+		val, err := d.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			// The first request released its claim without consuming a
+			// one-time token (permanent, invalid, ...); go it alone.
+			return 0, false, false, nil
+		}
+		if err != nil {
+			return 0, false, false, err
+		}
+		if val != loginTokenPending {
+			id, err := strconv.Atoi(val)
+			if err != nil {
+				return 0, false, false, fmt.Errorf("malformed login token claim %q", val)
+			}
+			return id, true, false, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, false, false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, false, false, ctx.Err()
+		case <-time.After(loginTokenPollInterval):
+		}
+	}
+}
+
+// Consumed records that the claimed secret signed in userID. Call it before
+// deleting the token, so a request that no longer finds the token finds this.
+func (d *LoginTokenDedup) Consumed(ctx context.Context, secret string, userID int) error {
+	return d.client.Set(ctx, loginTokenDedupKey(secret), strconv.Itoa(userID), d.window).Err()
+}
+
+// Release drops a claim that didn't consume a one-time token.
+func (d *LoginTokenDedup) Release(ctx context.Context, secret string) error {
+	return d.client.Del(ctx, loginTokenDedupKey(secret)).Err()
+}
+
+func loginTokenDedupKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return loginTokenDedupKeyPrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestAuthenticateLoginToken_ConcurrentConsumptionBothSucceed(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.MatchExpectationsInOrder(false)
+	now := time.Now()
+	// The token is looked up and deleted once; every consumption loads the user.
+	mock.ExpectQuery(`FROM login_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret", "permanent", "created_at"}).
+			AddRow(1, 42, "magic", false, now))
+	mock.ExpectExec(`DELETE FROM login_tokens`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, now, now))
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	s.SetLoginTokenDedup(NewLoginTokenDedup(client, 5*time.Second))
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	results := make([]*LoginResponse, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = s.AuthenticateLoginToken(context.Background(), "magic")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("consumption %d failed: %v", i, errs[i])
+		}
+		if results[i].User.ID != 42 || results[i].Token == nil {
+			t.Errorf("consumption %d got user %d, token %v; want user 42 with a token", i, results[i].User.ID, results[i].Token)
+		}
+	}
+
+	// A retry just after still signs in, without touching login_tokens.
+	if _, err := s.AuthenticateLoginToken(context.Background(), "magic"); err != nil {
+		t.Errorf("retry within the window failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Past the window the link is spent.
+	mr.FastForward(6 * time.Second)
+	mock.ExpectQuery(`FROM login_tokens`).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret", "permanent", "created_at"}))
+	if _, err := s.AuthenticateLoginToken(context.Background(), "magic"); err == nil {
+		t.Error("spent login token accepted after the replay window")
+	}
+}
+
+func TestAuthenticateLoginToken_PermanentTokenReleasesClaim(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM login_tokens`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret", "permanent", "created_at"}).
+				AddRow(1, 42, "magic", true, now))
+		mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, now, now))
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	s.SetLoginTokenDedup(NewLoginTokenDedup(client, 5*time.Second))
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.AuthenticateLoginToken(context.Background(), "magic"); err != nil {
+			t.Fatalf("consumption %d: %v", i, err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("permanent token left claims behind: %v", keys)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -100,3 +100,17 @@ func TestLoginWithToken_MissingCredential(t *testing.T) {
 		t.Error("expected success=false for missing credential")
 	}
 }
+
+// errorCode extracts error.code from a JSON error response body.
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp.Error.Code
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestRevokeAllForUser_RejectsEarlierTokens(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	ts := newTestTokenService()
+	s := NewService(repo, ts, token.NewBlacklist(client), nil, nopEnqueuer{}, zap.NewNop())
+	s.SetUserTokenVersions(token.NewUserTokenVersions(client, time.Hour))
+	ctx := context.Background()
+
+	// Two devices signed in at token_version 0, plus another user.
+	phone, _ := ts.Generate(42, "uid-42", "kid@student.student", "Kid", "Student", 9, 0)
+	laptop, _ := ts.Generate(42, "uid-42", "kid@student.student", "Kid", "Student", 9, 0)
+	other, _ := ts.Generate(43, "uid-43", "pal@student.student", "Pal", "Student", 10, 0)
+
+	mock.ExpectQuery(`UPDATE users SET token_version`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(1))
+	if err := s.RevokeAllForUser(ctx, 42); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+
+	for name, pair := range map[string]*token.TokenPair{"phone": phone, "laptop": laptop} {
+		if _, err := s.ValidateToken(ctx, pair.AccessToken); !errors.Is(err, apperrors.ErrTokenRevoked) {
+			t.Errorf("%s token after logout-all: err = %v, want ErrTokenRevoked", name, err)
+		}
+	}
+	if _, err := s.ValidateToken(ctx, other.AccessToken); err != nil {
+		t.Errorf("another user's token rejected: %v", err)
+	}
+
+	// A sign-in after the bump carries the new version and works.
+	fresh, _ := ts.Generate(42, "uid-42", "kid@student.student", "Kid", "Student", 9, 1)
+	if _, err := s.ValidateToken(ctx, fresh.AccessToken); err != nil {
+		t.Errorf("token minted after logout-all rejected: %v", err)
+	}
+
+	results, err := s.IntrospectBatch(ctx, []string{phone.AccessToken, fresh.AccessToken, other.AccessToken})
+	if err != nil {
+		t.Fatalf("IntrospectBatch: %v", err)
+	}
+	if results[0].Active || !results[1].Active || !results[2].Active {
+		t.Errorf("introspection active = [%v %v %v], want [false true true]", results[0].Active, results[1].Active, results[2].Active)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
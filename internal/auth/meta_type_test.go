@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestValidateToken_RejectsUnknownMetaType(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ts := newTestTokenService()
+	s := NewService(nil, ts, token.NewBlacklist(client), nil, nopEnqueuer{}, zap.NewNop())
+	ctx := context.Background()
+
+	// Validly signed by our own key; only the meta type is wrong.
+	robot, err := ts.Generate(1, "uid-1", "r2d2@boddle.com", "R2-D2", "Robot", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, robot.AccessToken); !errors.Is(err, apperrors.ErrInvalidMetaType) {
+		t.Errorf("Robot token: err = %v, want ErrInvalidMetaType", err)
+	}
+	results, err := s.IntrospectBatch(ctx, []string{robot.AccessToken})
+	if err != nil {
+		t.Fatalf("IntrospectBatch: %v", err)
+	}
+	if results[0].Active {
+		t.Error("Robot token introspected as active")
+	}
+
+	for _, metaType := range []string{"Teacher", "Student", "Parent"} {
+		pair, err := ts.Generate(2, "uid-2", "someone@school.org", "Someone", metaType, 20, 0)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if _, err := s.ValidateToken(ctx, pair.AccessToken); err != nil {
+			t.Errorf("%s token rejected: %v", metaType, err)
+		}
+	}
+}
@@ -6,6 +6,23 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyPasswordHash is a cost-12 bcrypt hash of a throwaway string. Login
+// compares against it when the email is unknown, so that path costs the same
+// bcrypt work as a wrong password and response time doesn't reveal whether
+// an account exists. The cost must stay equal to real hashes' cost.
+const dummyPasswordHash = "$2a$12$RAHb4HPYeHXxenleSguYnuQK56wpiNe2KqLwR2I3pFfK/5uQIm7zq"
+
+// comparePassword is the password check used by login. It is a variable so
+// tests can observe which hashes a login compares against.
+var comparePassword = VerifyPassword
+
+// isBcryptHash reports whether hash is a bcrypt hash that can be compared
+// against. Accounts created through SSO have no password digest.
+func isBcryptHash(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
 // VerifyPassword verifies a password against a bcrypt hash
 // Cost is read from the stored hash itself; Rails uses cost 12 (bcrypt gem default).
 func VerifyPassword(password, hash string) error {
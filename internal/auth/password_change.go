@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const passwordChangeKeyPrefix = "user:password_changed_at:"
+
+// PasswordChanges revokes tokens minted before their user's last password
+// change. users.password_changed_at is read through Redis, cached for ttl, so
+// validating a token doesn't cost a DB query; Rails can DEL
+// user:password_changed_at:<id> after a change to apply it at once rather
+// than within ttl. A nil *PasswordChanges revokes nothing.
+type PasswordChanges struct {
+	repo   *user.Repository
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewPasswordChanges creates a password-change check caching each user's
+// password_changed_at for ttl.
+func NewPasswordChanges(repo *user.Repository, client *redis.Client, ttl time.Duration) *PasswordChanges {
+	return &PasswordChanges{repo: repo, client: client, ttl: ttl}
+}
+
+// ChangedAt returns when userID's password last changed, or the zero time.
+func (p *PasswordChanges) ChangedAt(ctx context.Context, userID int) (time.Time, error) {
+	key := passwordChangeKey(userID)
+	val, err := p.client.Get(ctx, key).Result()
+	if err == nil {
+		unix, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("malformed password change time %q for user %d", val, userID)
+		}
+		if unix == 0 {
+			return time.Time{}, nil
+		}
+		return time.Unix(unix, 0), nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return time.Time{}, fmt.Errorf("failed to check password change time: %w", err)
+	}
+
+	changedAt, err := p.repo.PasswordChangedAt(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// "0" caches a user who never changed their password too.
+	var unix int64
+	if !changedAt.IsZero() {
+		unix = changedAt.Unix()
+	}
+	if err := p.client.Set(ctx, key, unix, p.ttl).Err(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to cache password change time: %w", err)
+	}
+	return changedAt, nil
+}
+
+// Predates reports whether a token for userID issued at iat, carrying the
+// pwc claim stamped, was minted before the user's last password change.
+func (p *PasswordChanges) Predates(ctx context.Context, userID int, stamped int64, iat *jwt.NumericDate) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+	changedAt, err := p.ChangedAt(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return predatesPasswordChange(changedAt, stamped, iat), nil
+}
+
+// predatesPasswordChange compares a token to the password change at
+// changedAt. A pair stamped with that change (see
+// token.WithPasswordChangedAt) was minted after it, whatever its iat: iat is
+// backdated by the issue skew and truncated to seconds. Unstamped tokens fall
+// back to iat.
+func predatesPasswordChange(changedAt time.Time, stamped int64, iat *jwt.NumericDate) bool {
+	if changedAt.IsZero() || stamped >= changedAt.Unix() {
+		return false
+	}
+	return iat == nil || iat.Time.Before(changedAt)
+}
+
+// SetPasswordChanges makes a password change revoke the user's earlier
+// tokens, access and refresh alike.
+func (s *Service) SetPasswordChanges(p *PasswordChanges) {
+	s.passwordChanges = p
+}
+
+func passwordChangeKey(userID int) string {
+	return passwordChangeKeyPrefix + strconv.Itoa(userID)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestValidateToken_RejectsTokensPredatingPasswordChange(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	ts := newTestTokenService()
+	s := NewService(repo, ts, token.NewBlacklist(client), nil, nopEnqueuer{}, zap.NewNop())
+	s.SetPasswordChanges(NewPasswordChanges(repo, client, time.Minute))
+	ctx := context.Background()
+
+	before, _ := ts.Generate(42, "uid-42", "t@school.org", "Ms. T", "Teacher", 7, 0)
+	changedAt := time.Now().Add(time.Second).Truncate(time.Second)
+	after, _ := ts.Generate(42, "uid-42", "t@school.org", "Ms. T", "Teacher", 7, 0, token.WithPasswordChangedAt(changedAt))
+	other, _ := ts.Generate(43, "uid-43", "u@school.org", "Mr. U", "Teacher", 8, 0)
+
+	// Each user's change time is read once, then served from Redis.
+	mock.ExpectQuery(`SELECT password_changed_at FROM users`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"password_changed_at"}).AddRow(changedAt))
+	mock.ExpectQuery(`SELECT password_changed_at FROM users`).WithArgs(43).
+		WillReturnRows(sqlmock.NewRows([]string{"password_changed_at"}).AddRow(nil))
+
+	if _, err := s.ValidateToken(ctx, before.AccessToken); !errors.Is(err, apperrors.ErrTokenRevoked) {
+		t.Errorf("token minted before the password change: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.ValidateToken(ctx, after.AccessToken); err != nil {
+		t.Errorf("token minted after the password change rejected: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.ValidateToken(ctx, other.AccessToken); err != nil {
+			t.Errorf("token of a user who never changed their password rejected: %v", err)
+		}
+	}
+
+	results, err := s.IntrospectBatch(ctx, []string{before.AccessToken, after.AccessToken})
+	if err != nil {
+		t.Fatalf("IntrospectBatch: %v", err)
+	}
+	if results[0].Active || !results[1].Active {
+		t.Errorf("introspection active = [%v %v], want [false true]", results[0].Active, results[1].Active)
+	}
+
+	// The old refresh token can't mint a fresh pair either.
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(append(userColumns, "password_changed_at")).
+			AddRow(42, "Ms. T", "t@school.org", "", "uid-42", "Teacher", 7, nil, 0, "", now, now, changedAt))
+	mock.ExpectQuery(`FROM teachers\s+WHERE id`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "google_uid", "clever_uid", "is_verified", "created_at", "updated_at"}).
+			AddRow(7, "Ms.", "T", nil, nil, true, now, now))
+	if _, err := s.RefreshToken(ctx, before.RefreshToken, ""); err == nil {
+		t.Error("refresh token minted before the password change was accepted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
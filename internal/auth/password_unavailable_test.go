@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestLogin_PasswordLoginUnavailableForSSOOnlyAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, digest := range map[string]string{"empty digest": "", "malformed digest": "not-a-bcrypt-hash"} {
+		t.Run(name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			now := time.Now()
+			mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(42, "Ms. Frizzle", "teacher@example.com", digest, "uid-42", "Teacher", 7, nil, 0, "", now, now))
+			mock.ExpectExec(`INSERT INTO login_attempts`).
+				WithArgs("teacher@example.com", sqlmock.AnyArg(), false, sqlmock.AnyArg(), user.LoginFailureNoPassword).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			limiter := &fakeLimiter{}
+			s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+			s.SetLoginAttemptReasons(true)
+			s.SetPasswordLoginUnavailableError(true)
+			handler := &Handler{service: s}
+
+			c, w := newTestContext(http.MethodPost, "/auth/login",
+				`{"email":"teacher@example.com","password":"x"}`, nil)
+			handler.Login(c)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if code := errorCode(t, w.Body.Bytes()); code != apperrors.ErrCodePasswordLoginUnavailable {
+				t.Errorf("code = %q, want %q", code, apperrors.ErrCodePasswordLoginUnavailable)
+			}
+			// Still a failed attempt as far as the limiter is concerned:
+			// counted on the way in and never cleared.
+			if len(limiter.calls) != 1 || limiter.calls[0] != "check and record" {
+				t.Errorf("limiter calls = %v, want [check and record]", limiter.calls)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
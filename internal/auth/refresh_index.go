@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
+)
+
+// SetRefreshTokenIndex records every refresh token s issues in x, so users
+// can list and revoke them one by one. Off (nil) by default: it costs a Redis
+// write per issuance.
+func (s *Service) SetRefreshTokenIndex(x *token.RefreshTokenIndex) {
+	s.refreshIndex = x
+}
+
+// IndexRefreshToken records pair's refresh token in x, if x is non-nil. Like
+// AuditTokenIssued it is best-effort: a failed write is logged and the
+// sign-in goes ahead, the token simply missing from the user's list.
+func IndexRefreshToken(ctx context.Context, x *token.RefreshTokenIndex, logger *zap.Logger, userID int, pair *token.TokenPair) {
+	if x == nil {
+		return
+	}
+	if err := x.Add(ctx, userID, pair.RefreshJTI, pair.RefreshExpiresAt); err != nil {
+		requestid.Logger(ctx, logger).Warn("failed to index refresh token",
+			zap.Int("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
+
+// ListRefreshTokens returns userID's outstanding refresh tokens. Empty when
+// the index is off.
+func (s *Service) ListRefreshTokens(ctx context.Context, userID int) ([]token.IssuedRefreshToken, error) {
+	return s.refreshIndex.List(ctx, userID)
+}
+
+// RevokeRefreshJTI revokes userID's refresh token jti, as listed by
+// ListRefreshTokens, without the client holding the token itself. A jti that
+// isn't one of userID's outstanding tokens is ErrNotFound.
+func (s *Service) RevokeRefreshJTI(ctx context.Context, userID int, jti string) error {
+	expiresAt, ok, err := s.refreshIndex.Expiry(ctx, userID, jti)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apperrors.ErrNotFound
+	}
+	if err := s.tokenBlacklist.Add(ctx, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to blacklist refresh token: %w", err)
+	}
+	return s.refreshIndex.Remove(ctx, userID, jti)
+}
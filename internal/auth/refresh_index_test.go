@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestRefreshTokenIndex_ListAndRevokeByJTI(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	for i := 0; i < 2; i++ {
+		expectStudentLogin(t, mock, "correct-horse")
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+		mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	blacklist := token.NewBlacklist(client)
+	s := NewService(repo, newTestTokenService(), blacklist, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	s.SetRefreshTokenIndex(token.NewRefreshTokenIndex(client, time.Hour))
+	ctx := context.Background()
+
+	var jtis []string
+	for i := 0; i < 2; i++ {
+		resp, err := s.AuthenticateEmailPassword(ctx, "kid1@student.student", "correct-horse", "203.0.113.7", "")
+		if err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+		jtis = append(jtis, resp.Token.RefreshJTI)
+	}
+	if !mr.Exists("refresh:user:42") {
+		t.Fatalf("keys = %v, want refresh:user:42", mr.Keys())
+	}
+
+	listed, err := s.ListRefreshTokens(ctx, 42)
+	if err != nil {
+		t.Fatalf("ListRefreshTokens: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("listed %d refresh tokens, want 2", len(listed))
+	}
+	for _, rt := range listed {
+		if rt.JTI != jtis[0] && rt.JTI != jtis[1] {
+			t.Errorf("listed unknown jti %q", rt.JTI)
+		}
+		if rt.ExpiresAt.Before(time.Now()) {
+			t.Errorf("jti %q listed as already expired: %v", rt.JTI, rt.ExpiresAt)
+		}
+	}
+
+	if err := s.RevokeRefreshJTI(ctx, 42, jtis[0]); err != nil {
+		t.Fatalf("RevokeRefreshJTI: %v", err)
+	}
+	if revoked, _ := blacklist.IsBlacklisted(ctx, jtis[0]); !revoked {
+		t.Error("revoked refresh token isn't blacklisted")
+	}
+	if kept, _ := blacklist.IsBlacklisted(ctx, jtis[1]); kept {
+		t.Error("the other refresh token was blacklisted too")
+	}
+	listed, _ = s.ListRefreshTokens(ctx, 42)
+	if len(listed) != 1 || listed[0].JTI != jtis[1] {
+		t.Errorf("after revoking, listed = %+v, want only %q", listed, jtis[1])
+	}
+
+	// Another user can't revoke the token, nor can it be revoked twice.
+	if err := s.RevokeRefreshJTI(ctx, 43, jtis[1]); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("revoking another user's jti: err = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeRefreshJTI(ctx, 42, jtis[0]); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("revoking an already-revoked jti: err = %v, want ErrNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshTokenIndex_OffListsNothing(t *testing.T) {
+	repo, _ := newMockRepository(t)
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+
+	listed, err := s.ListRefreshTokens(context.Background(), 42)
+	if err != nil || len(listed) != 0 {
+		t.Errorf("ListRefreshTokens = %v, %v; want nothing", listed, err)
+	}
+	if err := s.RevokeRefreshJTI(context.Background(), 42, "jti"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("RevokeRefreshJTI: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestTokenService() *token.Service {
+	return token.NewService(
+		"test-secret-key-minimum-32-chars",
+		"test-refresh-secret-key-32-chars",
+		6*time.Hour,
+		720*time.Hour,
+	)
+}
+
+// TestRefreshToken_RejectsMismatchedAccessToken pairs user 2's refresh token
+// with user 1's access token; the mix-and-match must be rejected before any
+// lookup (no repository or blacklist is wired, so reaching them would panic).
+func TestRefreshToken_RejectsMismatchedAccessToken(t *testing.T) {
+	ts := newTestTokenService()
+	svc := &Service{tokenService: ts}
+
+	victim, err := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	attacker, err := ts.Generate(2, "uid-2", "c@d.com", "C", "Student", 20, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	_, err = svc.RefreshToken(context.Background(), attacker.RefreshToken, victim.AccessToken)
+	if !errors.Is(err, apperrors.ErrTokenMismatch) {
+		t.Fatalf("err = %v, want ErrTokenMismatch", err)
+	}
+}
+
+func TestRefreshToken_RejectsForgedAccessToken(t *testing.T) {
+	ts := newTestTokenService()
+	svc := &Service{tokenService: ts}
+
+	pair, err := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	_, err = svc.RefreshToken(context.Background(), pair.RefreshToken, "not.a.jwt")
+	if !errors.Is(err, apperrors.ErrTokenMismatch) {
+		t.Fatalf("err = %v, want ErrTokenMismatch", err)
+	}
+}
+
+func TestRefreshHandler_MismatchReturnsTokenMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ts := newTestTokenService()
+	handler := &Handler{service: &Service{tokenService: ts}}
+
+	victim, _ := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	attacker, _ := ts.Generate(2, "uid-2", "c@d.com", "C", "Student", 20, 0)
+
+	c, w := newTestContext(http.MethodPost, "/auth/refresh",
+		`{"refresh_token":"`+attacker.RefreshToken+`"}`,
+		map[string]string{"Authorization": "Bearer " + victim.AccessToken})
+	handler.Refresh(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != apperrors.ErrCodeTokenMismatch {
+		t.Errorf("error code = %q, want %q", code, apperrors.ErrCodeTokenMismatch)
+	}
+}
+
+// With boddle_uid subjects the access token's sub differs from the refresh
+// token's; the pair still belongs together and must get past the mismatch
+// check (to the user lookup, which finds nobody here).
+func TestRefreshToken_BoddleUIDSubjectMatchesOwnRefreshToken(t *testing.T) {
+	ts := token.NewService("test-secret-key-minimum-32-chars", "test-refresh-secret-key-32-chars", time.Hour, time.Hour,
+		token.WithSubjectFormat(token.SubjectBoddleUID))
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(1).WillReturnError(sql.ErrNoRows)
+	svc := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client), userRepo: repo}
+
+	pair, err := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	_, err = svc.RefreshToken(context.Background(), pair.RefreshToken, pair.AccessToken)
+	if errors.Is(err, apperrors.ErrTokenMismatch) {
+		t.Fatal("own access token rejected as a mismatch")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRevokeRefresh_RevokedTokenCannotBeExchanged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	svc := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client)}
+	handler := &Handler{service: svc}
+
+	pair, err := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	caller, err := ts.Validate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	c, w := newTestContext(http.MethodPost, "/auth/refresh/revoke",
+		`{"refresh_token":"`+pair.RefreshToken+`"}`, nil)
+	c.Set("claims", caller)
+	handler.RevokeRefresh(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// The blacklist check comes before the user lookup, so no repository is
+	// needed to see the exchange refused.
+	if _, err := svc.RefreshToken(context.Background(), pair.RefreshToken, ""); err == nil {
+		t.Fatal("revoked refresh token was exchanged for a new pair")
+	}
+}
+
+func TestRevokeRefresh_RejectsAnotherUsersToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	blacklist := token.NewBlacklist(client)
+	handler := &Handler{service: &Service{tokenService: ts, tokenBlacklist: blacklist}}
+
+	victim, _ := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	attacker, _ := ts.Generate(2, "uid-2", "c@d.com", "C", "Student", 20, 0)
+	caller, err := ts.Validate(attacker.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	c, w := newTestContext(http.MethodPost, "/auth/refresh/revoke",
+		`{"refresh_token":"`+victim.RefreshToken+`"}`, nil)
+	c.Set("claims", caller)
+	handler.RevokeRefresh(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != apperrors.ErrCodeTokenMismatch {
+		t.Errorf("error code = %q, want %q", code, apperrors.ErrCodeTokenMismatch)
+	}
+
+	victimClaims, _ := ts.ValidateRefreshToken(victim.RefreshToken)
+	if revoked, _ := blacklist.IsBlacklisted(context.Background(), victimClaims.ID); revoked {
+		t.Error("another user's refresh token was revoked")
+	}
+}
+
+// An expired or malformed refresh token is INVALID_REFRESH_TOKEN, never the
+// TOKEN_EXPIRED or INVALID_TOKEN that tell a client to refresh and retry.
+func TestRefreshHandler_ExpiredOrMalformedIsInvalidRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, token.RefreshClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+	}).SignedString([]byte("test-refresh-secret-key-32-chars"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	for name, refreshToken := range map[string]string{"expired": expired, "malformed": "not.a.jwt"} {
+		t.Run(name, func(t *testing.T) {
+			handler := &Handler{service: &Service{tokenService: newTestTokenService()}}
+
+			c, w := newTestContext(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+refreshToken+`"}`, nil)
+			handler.Refresh(c)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body.String())
+			}
+			if code := errorCode(t, w.Body.Bytes()); code != "INVALID_REFRESH_TOKEN" {
+				t.Errorf("error code = %q, want INVALID_REFRESH_TOKEN", code)
+			}
+		})
+	}
+}
+
+// A refresh token outliving its user is an invalid refresh token, not a
+// 404 or a 500.
+func TestRefreshHandler_DeletedUserIsUnauthorized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(1).WillReturnError(sql.ErrNoRows)
+	handler := &Handler{service: &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client), userRepo: repo}}
+
+	pair, err := ts.Generate(1, "uid-1", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	c, w := newTestContext(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+pair.RefreshToken+`"}`, nil)
+	handler.Refresh(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body.String())
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != "INVALID_REFRESH_TOKEN" {
+		t.Errorf("error code = %q, want INVALID_REFRESH_TOKEN", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshToken_ReplayRevokesFamily(t *testing.T) {
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	svc := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client), userRepo: repo, logger: zap.NewNop()}
+	svc.SetRefreshFamilies(token.NewRefreshFamilies(client, time.Hour))
+
+	now := time.Now()
+	expectUser := func() {
+		mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, now, now))
+	}
+
+	login, err := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	expectUser()
+	rotated, err := svc.RefreshToken(context.Background(), login.RefreshToken, "")
+	if err != nil {
+		t.Fatalf("first refresh: %v", err)
+	}
+
+	// The login's refresh token was rotated away; replaying it is reuse.
+	if _, err := svc.RefreshToken(context.Background(), login.RefreshToken, ""); !errors.Is(err, token.ErrRefreshReused) {
+		t.Fatalf("replay err = %v, want ErrRefreshReused", err)
+	}
+
+	// And the chain is gone: the legitimate rotated token no longer works.
+	expectUser()
+	if _, err := svc.RefreshToken(context.Background(), rotated.Token.RefreshToken, ""); !errors.Is(err, token.ErrRefreshReused) {
+		t.Errorf("refresh after revocation err = %v, want ErrRefreshReused", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshToken_FailedRotationKeepsOldToken(t *testing.T) {
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	svc := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client), userRepo: repo, logger: zap.NewNop()}
+
+	// The family store is unreachable, so the refresh fails at the rotation.
+	down := miniredis.RunT(t)
+	downClient := redis.NewClient(&redis.Options{Addr: down.Addr()})
+	t.Cleanup(func() { downClient.Close() })
+	down.Close()
+	svc.SetRefreshFamilies(token.NewRefreshFamilies(downClient, time.Hour))
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, now, now))
+
+	login, err := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := svc.RefreshToken(context.Background(), login.RefreshToken, ""); err == nil {
+		t.Fatal("refresh succeeded with the family store down")
+	}
+
+	// The failed refresh must not have spent the token.
+	blacklisted, err := svc.tokenBlacklist.IsBlacklisted(context.Background(), login.RefreshJTI)
+	if err != nil {
+		t.Fatalf("IsBlacklisted: %v", err)
+	}
+	if blacklisted {
+		t.Error("old refresh token blacklisted by a refresh that failed")
+	}
+}
+
+func TestSeedRefreshFamily_TracksSignInToken(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	families := token.NewRefreshFamilies(client, time.Hour)
+
+	pair, err := newTestTokenService().Generate(42, "uid-42", "a@b.com", "A", "Teacher", 10, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	SeedRefreshFamily(context.Background(), families, zap.NewNop(), 42, pair)
+
+	// Seeded, the family knows its token from the start: any other JTI
+	// presented for it is a reuse rather than being adopted.
+	if err := families.CheckAndRotate(context.Background(), pair.RefreshFamily, "forged-jti", "next"); !errors.Is(err, token.ErrRefreshReused) {
+		t.Errorf("CheckAndRotate err = %v, want ErrRefreshReused", err)
+	}
+}
+
+func TestRevokeRefresh_OlderTokenRevokesRotatedSession(t *testing.T) {
+	ts := newTestTokenService()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, mock := newMockRepository(t)
+	svc := &Service{tokenService: ts, tokenBlacklist: token.NewBlacklist(client), userRepo: repo, logger: zap.NewNop()}
+	svc.SetRefreshFamilies(token.NewRefreshFamilies(client, time.Hour))
+
+	now := time.Now()
+	expectUser := func() {
+		mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", "", "uid-42", "Student", 9, nil, 0, "", now, now))
+		mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+				AddRow(9, nil, nil, nil, nil, nil, now, now))
+	}
+
+	login, err := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	expectUser()
+	rotated, err := svc.RefreshToken(context.Background(), login.RefreshToken, "")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	// Signing out with the sign-in's original token ends the session, even
+	// though it has since been rotated.
+	if err := svc.RevokeRefreshToken(context.Background(), 42, login.RefreshToken); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	expectUser()
+	if _, err := svc.RefreshToken(context.Background(), rotated.Token.RefreshToken, ""); !errors.Is(err, token.ErrRefreshReused) {
+		t.Errorf("refresh after revoke err = %v, want ErrRefreshReused", err)
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+)
+
+// RegisterRequest is a self-service signup. MetaType is "Teacher", "Student"
+// or "Parent"; admins are never self-registered.
+type RegisterRequest struct {
+	Email     string `json:"email" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	MetaType  string `json:"meta_type" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+
+	// App scopes the tokens to one Boddle app (their aud); see Apps.
+	App string `json:"app"`
+}
+
+// Register creates an account and signs it in, returning its first token
+// pair. The email must not already be in use: that is user.ErrEmailTaken,
+// both when it is found up front and when a concurrent signup wins the race
+// to the unique index. Hashing the password shares the login bcrypt pool,
+// so a signup storm fails with ErrServerBusy rather than stalling logins.
+func (s *Service) Register(ctx context.Context, req *RegisterRequest, ipAddress string) (*LoginResponse, error) {
+	email := SanitizeEmail(req.Email)
+
+	existing, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if existing != nil {
+		return nil, user.ErrEmailTaken
+	}
+
+	digest, err := s.bcrypt.Hash(ctx, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	userWithMeta, err := s.userRepo.CreateUser(ctx, user.NewUser{
+		MetaType:       req.MetaType,
+		Email:          email,
+		PasswordDigest: digest,
+		FirstName:      strings.TrimSpace(req.FirstName),
+		LastName:       strings.TrimSpace(req.LastName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	usr := &userWithMeta.User
+
+	tokenPair, err := s.tokenService.Generate(
+		usr.ID,
+		usr.BoddleUID.String,
+		usr.Email,
+		userWithMeta.GetFullName(),
+		usr.MetaType,
+		usr.MetaID,
+		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(userWithMeta.Meta)),
+		token.WithAudience(AppFromContext(ctx)),
+		s.deviceOption(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	if err := s.claimDeviceSession(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, IssueMethodRegister, ipAddress, tokenPair)
+	IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
+
+	return &LoginResponse{
+		Token: tokenPair,
+		User:  usr,
+		Meta:  userWithMeta.Meta,
+	}, nil
+}
+
+// Register handles self-service signup
+// POST /auth/register — 201 with a token pair, 409 EMAIL_TAKEN, or 503
+// SERVER_BUSY with Retry-After
+func (h *Handler) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ValidationError(c, err.Error())
+		return
+	}
+	if err := ValidateRegisterRequest(&req); err != nil {
+		response.ValidationError(c, err.Error())
+		return
+	}
+
+	if err := h.service.CheckApp(req.App); err != nil {
+		response.Error(c, err)
+		return
+	}
+	ctx := WithApp(c.Request.Context(), req.App)
+	ctx = WithDeviceFingerprint(ctx, c.GetHeader(DeviceFingerprintHeader))
+
+	result, err := h.service.Register(ctx, &req, c.ClientIP())
+	if errors.Is(err, apperrors.ErrServerBusy) {
+		c.Header("Retry-After", "1")
+	}
+	if errors.Is(err, user.ErrEmailTaken) {
+		err = apperrors.ErrEmailTaken
+	}
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c, http.StatusCreated, result.ForClient(c))
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+type registerResponse struct {
+	Data struct {
+		Token struct {
+			AccessToken string `json:"access_token"`
+		} `json:"token"`
+		User struct {
+			ID       int    `json:"id"`
+			MetaType string `json:"meta_type"`
+		} `json:"user"`
+	} `json:"data"`
+	Error struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+func postRegister(t *testing.T, s *Service, body string) (int, registerResponse) {
+	t.Helper()
+	c, w := newTestContext(http.MethodPost, "/auth/register", body, nil)
+	(&Handler{service: s}).Register(c)
+	var resp registerResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+const teacherSignup = `{"email":"Ada@School.edu","password":"analytical","meta_type":"Teacher","first_name":"Ada","last_name":"Lovelace"}`
+
+func TestRegister_CreatesAccountAndSignsIn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("ada@school.edu").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO teachers`).WithArgs("Ada", "Lovelace", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("Ada Lovelace", "ada@school.edu", sqlmock.AnyArg(), sqlmock.AnyArg(), "Teacher", 7, "active", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	status, resp := postRegister(t, s, teacherSignup)
+
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", status)
+	}
+	if resp.Data.Token.AccessToken == "" {
+		t.Error("expected an access token")
+	}
+	if resp.Data.User.ID != 42 || resp.Data.User.MetaType != "Teacher" {
+		t.Errorf("user = %d/%s, want 42/Teacher", resp.Data.User.ID, resp.Data.User.MetaType)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegister_EmailTakenIs409(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("found up front", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		now := time.Now()
+		mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("ada@school.edu").
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(3, "Ada", "ada@school.edu", "digest", "uid-3", "Teacher", 1, nil, 0, "", now, now))
+
+		s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+		status, resp := postRegister(t, s, teacherSignup)
+		if status != http.StatusConflict || resp.Error.Code != apperrors.ErrCodeEmailTaken {
+			t.Errorf("got %d %q, want 409 %s", status, resp.Error.Code, apperrors.ErrCodeEmailTaken)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("lost the race", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO teachers`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(`INSERT INTO users`).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+		status, resp := postRegister(t, s, teacherSignup)
+		if status != http.StatusConflict || resp.Error.Code != apperrors.ErrCodeEmailTaken {
+			t.Errorf("got %d %q, want 409 %s", status, resp.Error.Code, apperrors.ErrCodeEmailTaken)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRegister_BcryptPoolFullReturnsServerBusy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, mock := newMockRepository(t)
+	// The account is never created: the password couldn't be hashed.
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnError(sql.ErrNoRows)
+
+	s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+	pool := NewBcryptPool(1, 10*time.Millisecond)
+	s.SetBcryptPool(pool)
+	defer fillPool(t, pool, 1)()
+
+	c, w := newTestContext(http.MethodPost, "/auth/register", teacherSignup, nil)
+	(&Handler{service: s}).Register(c)
+	var resp registerResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusServiceUnavailable || resp.Error.Code != apperrors.ErrCodeServerBusy {
+		t.Errorf("got %d %q, want 503 %s", w.Code, resp.Error.Code, apperrors.ErrCodeServerBusy)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegister_RejectsInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for name, body := range map[string]string{
+		"admin":        `{"email":"a@boddle.com","password":"secret","meta_type":"Admin","first_name":"A"}`,
+		"no name":      `{"email":"a@school.edu","password":"secret","meta_type":"Teacher"}`,
+		"bad email":    `{"email":"not-an-email","password":"secret","meta_type":"Parent","first_name":"P"}`,
+		"short secret": `{"email":"a@school.edu","password":"ab","meta_type":"Teacher","first_name":"A"}`,
+	} {
+		// No expectations: nothing reaches the database.
+		repo, mock := newMockRepository(t)
+		s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+		if status, _ := postRegister(t, s, body); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -2,14 +2,20 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/boddle/reservoir/internal/token"
 	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/boddle/reservoir/pkg/utctime"
 )
 
 // Service handles authentication business logic
@@ -19,14 +25,139 @@ type Service struct {
 	tokenBlacklist *token.Blacklist
 	rateLimiter    RateLimiter
 	lastLogin      user.LastLoginEnqueuer
+	userCache      *user.Cache // nil disables caching for /auth/me
 	logger         *zap.Logger
+
+	// cutoffs, if set, rejects tokens issued before their meta type's
+	// issued-at cutoff.
+	cutoffs *token.IssuedAtCutoffs
+
+	// attemptReasons writes the failure reason to login_attempts.reason.
+	// Off until the column exists (migration 006); the metric is always kept.
+	attemptReasons bool
+
+	// tokenAuditor, if set, records every token pair minted.
+	tokenAuditor TokenAuditor
+
+	// passwordUnavailableErr rejects password login for an account without a
+	// usable digest with ErrPasswordLoginUnavailable rather than the generic
+	// invalid-credentials failure.
+	passwordUnavailableErr bool
+
+	// captcha verifies challenges past the rate limiter's soft limit; nil
+	// means NopCaptchaVerifier.
+	captcha CaptchaVerifier
+
+	// expiredGrace is how long past exp an access token is still accepted
+	// by ValidateTokenWithGrace; 0 disables grace.
+	expiredGrace time.Duration
+
+	// refreshFamilies, if set, detects replayed refresh tokens and revokes
+	// their family.
+	refreshFamilies *token.RefreshFamilies
+
+	// bcrypt bounds concurrent password comparisons; nil is unbounded.
+	bcrypt *BcryptPool
+
+	// apps are the apps a login may scope its tokens to; nil allows none.
+	apps Apps
+
+	// loginTokenDedup, if set, lets a double-submitted magic link sign in
+	// both requests.
+	loginTokenDedup *LoginTokenDedup
+
+	// userVersions, if set, makes RevokeAllForUser reject the user's
+	// outstanding access tokens too, not just their refresh tokens.
+	userVersions *token.UserTokenVersions
+
+	// deviceSessions, if set, keeps one session per user per device.
+	deviceSessions *DeviceSessions
+
+	// passwordChanges, if set, revokes tokens minted before the user's last
+	// password change.
+	passwordChanges *PasswordChanges
+
+	// refreshIndex, if set, lists each user's outstanding refresh tokens.
+	refreshIndex *token.RefreshTokenIndex
+}
+
+// SetPasswordLoginUnavailableError makes password login for an account with
+// no usable password_digest fail with ErrPasswordLoginUnavailable, pointing
+// the user at SSO. Off by default: the error reveals that the email exists.
+func (s *Service) SetPasswordLoginUnavailableError(enabled bool) {
+	s.passwordUnavailableErr = enabled
+}
+
+// SetIssuedAtCutoffs has ValidateToken, IntrospectBatch and refresh reject
+// tokens issued before their meta type's cutoff in c. Off (nil) by default.
+func (s *Service) SetIssuedAtCutoffs(c *token.IssuedAtCutoffs) {
+	s.cutoffs = c
+}
+
+// SetLoginAttemptReasons writes each failed login's reason to
+// login_attempts.reason. Leave it off until migration 006 has added the
+// column; the login failure metric is recorded either way.
+func (s *Service) SetLoginAttemptReasons(enabled bool) {
+	s.attemptReasons = enabled
+}
+
+// SetExpiredTokenGrace lets ValidateTokenWithGrace accept an access token up
+// to grace past its expiry. 0 (the default) disables grace.
+func (s *Service) SetExpiredTokenGrace(grace time.Duration) {
+	s.expiredGrace = grace
+}
+
+// SetRefreshFamilies turns on refresh-token reuse detection: presenting a
+// refresh token that has already been rotated revokes every token of its
+// sign-in. Off (nil) by default.
+func (s *Service) SetRefreshFamilies(f *token.RefreshFamilies) {
+	s.refreshFamilies = f
+}
+
+// SeedRefreshFamily records a new sign-in's refresh token as the current one
+// of its family in f, if f is non-nil, so replaying it after its first
+// rotation is caught. Best-effort like IndexRefreshToken: CheckAndRotate
+// adopts a family it has never seen, so a failed write only delays tracking
+// to the first refresh.
+func SeedRefreshFamily(ctx context.Context, f *token.RefreshFamilies, logger *zap.Logger, userID int, pair *token.TokenPair) {
+	if f == nil || pair.RefreshFamily == "" {
+		return
+	}
+	if err := f.StoreRefreshJTI(ctx, pair.RefreshFamily, pair.RefreshJTI); err != nil {
+		requestid.Logger(ctx, logger).Warn("failed to seed refresh token family",
+			zap.Int("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
+
+// SetUserTokenVersions has ValidateToken reject access tokens minted before
+// the user's last RevokeAllForUser.
+func (s *Service) SetUserTokenVersions(v *token.UserTokenVersions) {
+	s.userVersions = v
+}
+
+// SetLoginTokenDedup makes concurrent or retried consumptions of one one-time
+// login token all succeed within d's window. Off (nil) by default.
+func (s *Service) SetLoginTokenDedup(d *LoginTokenDedup) {
+	s.loginTokenDedup = d
 }
 
 // RateLimiter interface for rate limiting
 type RateLimiter interface {
-	CheckLoginAttempt(ctx context.Context, email, ipAddress string) (allowed bool, remaining int, lockoutRemaining time.Duration, err error)
-	RecordFailedAttempt(ctx context.Context, email, ipAddress string) error
+	// CheckAndRecord admits a password login and, atomically, counts it as
+	// failed until RecordSuccessfulAttempt clears it. A challenged attempt
+	// isn't counted.
+	CheckAndRecord(ctx context.Context, email, ipAddress string) (allowed bool, remaining int, lockoutRemaining time.Duration, challengeRequired bool, err error)
 	RecordSuccessfulAttempt(ctx context.Context, email, ipAddress string) error
+	// RefundAttempt takes back a CheckAndRecord count for a login that
+	// failed for some reason other than its credentials.
+	RefundAttempt(ctx context.Context, email, ipAddress string) error
+	ClearChallenge(ctx context.Context, email, ipAddress string) error
+	// CheckByIP and RecordByIP limit failed sign-ins per IP alone, for flows
+	// with no email up front (OAuth).
+	CheckByIP(ctx context.Context, ipAddress string) (allowed bool, lockoutRemaining time.Duration, err error)
+	RecordByIP(ctx context.Context, ipAddress string) error
 }
 
 // NewService creates a new authentication service
@@ -48,73 +179,139 @@ func NewService(
 	}
 }
 
-// LoginRequest represents a login request
+// SetUserCache serves /auth/me from c and evicts from it on the writes s
+// makes. Off (nil) by default.
+func (s *Service) SetUserCache(c *user.Cache) {
+	s.userCache = c
+}
+
+// LoginRequest represents a login request. It names the account by Email or,
+// for a student, by Username; exactly one of the two is required.
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
+	Email    string `json:"email" binding:"omitempty,email"`
+	Username string `json:"username"`
 	Password string `json:"password" binding:"required"`
+
+	// CaptchaToken answers a CAPTCHA_REQUIRED challenge.
+	CaptchaToken string `json:"captcha_token"`
+
+	// App scopes the tokens to one Boddle app (their aud); see Apps.
+	App string `json:"app"`
 }
 
-// LoginResponse represents a login response
+// LoginResponse represents a login response. User and Meta are omitted when
+// the client asked for a token-only response (see ForClient).
 type LoginResponse struct {
 	Token     *token.TokenPair  `json:"token"`
-	User      *user.User        `json:"user"`
+	User      *user.User        `json:"user,omitempty"`
 	Meta      interface{}       `json:"meta,omitempty"`
 }
 
+// CheckAccountStatus returns ErrAccountSuspended or ErrAccountDisabled when
+// usr may not sign in. Every path that issues tokens calls it once the user is
+// identified. An empty status (column absent or NULL) counts as active.
+func CheckAccountStatus(usr *user.User) error {
+	switch usr.Status {
+	case user.StatusSuspended:
+		return apperrors.ErrAccountSuspended
+	case user.StatusDeleted:
+		return apperrors.ErrAccountDisabled
+	}
+	return nil
+}
+
 // AuthenticateEmailPassword authenticates with email and password
-func (s *Service) AuthenticateEmailPassword(ctx context.Context, email, password, ipAddress string) (*LoginResponse, error) {
+func (s *Service) AuthenticateEmailPassword(ctx context.Context, email, password, ipAddress, captchaToken string) (*LoginResponse, error) {
 	// Sanitize email
 	email = SanitizeEmail(email)
+	return s.authenticatePassword(ctx, email, password, ipAddress, captchaToken, s.userRepo.FindByEmail)
+}
 
+// AuthenticateUsernamePassword authenticates a student with their username
+// and password. The username is rate limited and recorded in place of an
+// email, and fails the same way as an unknown email does.
+func (s *Service) AuthenticateUsernamePassword(ctx context.Context, username, password, ipAddress, captchaToken string) (*LoginResponse, error) {
+	username = SanitizeUsername(username)
+	return s.authenticatePassword(ctx, username, password, ipAddress, captchaToken, s.userRepo.FindStudentByUsername)
+}
+
+// errInvalidCredentials is an unknown login or a wrong password; the only
+// failures that keep the rate limiter's count.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticatePassword is a password login for the user find resolves login
+// (an already-normalized email or username) to. login is also the rate
+// limiter's key and what login_attempts records.
+func (s *Service) authenticatePassword(ctx context.Context, login, password, ipAddress, captchaToken string, find func(context.Context, string) (*user.User, error)) (_ *LoginResponse, err error) {
 	// Check rate limit
 	if s.rateLimiter != nil {
-		allowed, _, lockoutRemaining, err := s.rateLimiter.CheckLoginAttempt(ctx, email, ipAddress)
-		if err != nil {
-			s.logger.Warn("rate limiter error", zap.Error(err))
-		} else if !allowed {
-			return nil, fmt.Errorf("too many failed attempts, locked out for %v", lockoutRemaining.Round(time.Second))
+		if err := s.admitLogin(ctx, login, ipAddress, captchaToken); err != nil {
+			return nil, err
 		}
+		// admitLogin counted this attempt as failed. Only wrong credentials
+		// may keep it that way; a busy server or a database error must not
+		// lock the user out.
+		defer func() {
+			if err != nil && !errors.Is(err, errInvalidCredentials) && !errors.Is(err, apperrors.ErrPasswordLoginUnavailable) {
+				s.refundLogin(ctx, login, ipAddress)
+			}
+		}()
 	}
 
-	// Find user by email
-	usr, err := s.userRepo.FindByEmail(ctx, email)
+	// Find user by email or username
+	usr, err := find(ctx, login)
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 
 	if usr == nil {
-		// Record failed attempt
-		_ = s.userRepo.RecordLoginAttempt(ctx, email, ipAddress, false)
-		if s.rateLimiter != nil {
-			_ = s.rateLimiter.RecordFailedAttempt(ctx, email, ipAddress)
+		// Burn the same bcrypt time as a real comparison so an unknown login
+		// can't be told apart from a wrong password by timing.
+		if err := s.comparePassword(ctx, password, dummyPasswordHash); errors.Is(err, apperrors.ErrServerBusy) {
+			return nil, err
 		}
-		return nil, fmt.Errorf("invalid credentials")
-	}
 
-	// Verify password
-	if err := VerifyPassword(password, usr.PasswordDigest); err != nil {
 		// Record failed attempt
-		_ = s.userRepo.RecordLoginAttempt(ctx, email, ipAddress, false)
-		if s.rateLimiter != nil {
-			_ = s.rateLimiter.RecordFailedAttempt(ctx, email, ipAddress)
-		}
-		return nil, fmt.Errorf("invalid credentials")
+		s.recordFailedLogin(ctx, 0, login, ipAddress, user.LoginFailureUnknownUser)
+		return nil, errInvalidCredentials
 	}
 
-	// Record successful attempt
-	_ = s.userRepo.RecordLoginAttempt(ctx, email, ipAddress, true)
-	if s.rateLimiter != nil {
-		_ = s.rateLimiter.RecordSuccessfulAttempt(ctx, email, ipAddress)
+	// Verify password. An account without a usable digest (SSO-only) is
+	// compared against the dummy hash too: failing fast would mark it as an
+	// existing account. The dummy comparison never authenticates. Deployments
+	// that accept that disclosure can point the user at SSO instead.
+	digest := usr.PasswordDigest
+	if !isBcryptHash(digest) {
+		if s.passwordUnavailableErr {
+			s.recordFailedLogin(ctx, usr.ID, login, ipAddress, user.LoginFailureNoPassword)
+			return nil, apperrors.ErrPasswordLoginUnavailable
+		}
+		digest = dummyPasswordHash
+	}
+	err = s.comparePassword(ctx, password, digest)
+	if errors.Is(err, apperrors.ErrServerBusy) {
+		return nil, err
+	}
+	if err != nil || digest == dummyPasswordHash {
+		// Record failed attempt
+		s.recordFailedLogin(ctx, usr.ID, login, ipAddress, user.LoginFailureWrongPassword)
+		return nil, errInvalidCredentials
 	}
 
-	// Defer last_logged_on update off the auth hot path.
-	s.lastLogin.Enqueue(usr.ID)
+	// Only reveal the account's status to someone who knows its password.
+	if err := CheckAccountStatus(usr); err != nil {
+		s.recordLoginAttempt(ctx, usr.ID, login, ipAddress, false, user.LoginFailureAccountStatus)
+		return nil, err
+	}
 
 	// Load meta data
 	userWithMeta, err := s.userRepo.FindWithMeta(ctx, usr.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load user meta: %w", err)
 	}
+	if userWithMeta == nil {
+		return nil, fmt.Errorf("user not found")
+	}
 
 	// Generate JWT token
 	boddleUID := ""
@@ -130,10 +327,35 @@ func (s *Service) AuthenticateEmailPassword(ctx context.Context, email, password
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(userWithMeta.Meta)),
+		token.WithLocale(usr.Locale),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(AppFromContext(ctx)),
+		s.deviceOption(ctx),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.claimDeviceSession(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, IssueMethodPassword, ipAddress, tokenPair)
+	IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
+
+	// Only now has the login truly succeeded. Record it durably first, then
+	// reset the Redis counter, so a crash in between leaves the limiter
+	// stricter rather than forgetting failures for a login that never
+	// completed.
+	s.recordLoginAttempt(ctx, usr.ID, login, ipAddress, true, "")
+	if s.rateLimiter != nil {
+		if err := s.rateLimiter.RecordSuccessfulAttempt(ctx, login, ipAddress); err != nil {
+			s.log(ctx).Warn("failed to reset rate limiter", zap.Int("user_id", usr.ID), zap.Error(err))
+		}
+	}
+
+	// Defer last_logged_on update off the auth hot path.
+	s.lastLogin.Enqueue(usr.ID)
 
 	return &LoginResponse{
 		Token: tokenPair,
@@ -142,35 +364,85 @@ func (s *Service) AuthenticateEmailPassword(ctx context.Context, email, password
 	}, nil
 }
 
-// AuthenticateLoginToken authenticates with a login token (magic link)
-func (s *Service) AuthenticateLoginToken(ctx context.Context, secret string) (*LoginResponse, error) {
-	// Find login token
-	loginToken, err := s.userRepo.FindLoginToken(ctx, secret)
-	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+// admitLogin asks the rate limiter to let a password login through. The
+// limiter counts the attempt as failed in the same step (see CheckAndRecord),
+// so concurrent guesses can't all slip past the check before any is
+// recorded; a successful login clears the count again, and a login that
+// fails for anything but its credentials refunds it (see refundLogin). A challenged login is
+// counted only once its CAPTCHA is solved. A refusal is a *LockedOutError.
+// Limiter errors fail open.
+func (s *Service) admitLogin(ctx context.Context, email, ipAddress, captchaToken string) error {
+	allowed, _, lockoutRemaining, challenge, err := s.rateLimiter.CheckAndRecord(ctx, email, ipAddress)
+	if err == nil && allowed && challenge {
+		if err := s.passChallenge(ctx, email, ipAddress, captchaToken); err != nil {
+			return err
+		}
+		allowed, _, lockoutRemaining, challenge, err = s.rateLimiter.CheckAndRecord(ctx, email, ipAddress)
+	}
+	switch {
+	case err != nil:
+		s.log(ctx).Warn("rate limiter error", zap.Error(err))
+	case !allowed:
+		return &LockedOutError{Remaining: lockoutRemaining}
+	case challenge:
+		// Challenged again straight after a solve: racing logins.
+		return apperrors.ErrCaptchaRequired
 	}
+	return nil
+}
 
-	if loginToken == nil {
-		return nil, fmt.Errorf("invalid token")
+// refundLogin gives back the attempt admitLogin counted. Limiter errors are
+// logged: the worst case is the count admitLogin already made.
+func (s *Service) refundLogin(ctx context.Context, login, ipAddress string) {
+	if err := s.rateLimiter.RefundAttempt(ctx, login, ipAddress); err != nil {
+		s.log(ctx).Warn("failed to refund login attempt", zap.Error(err))
 	}
+}
 
-	// Check if token is expired (5 minutes for non-permanent tokens)
-	if !loginToken.Permanent {
-		expiryTime := loginToken.CreatedAt.Add(5 * time.Minute)
-		if time.Now().After(expiryTime) {
-			return nil, fmt.Errorf("token expired")
-		}
+// recordFailedLogin stores a failed password login and counts it under
+// reason; the rate limiter counted it when admitLogin let it in. userID is 0
+// when the email is unknown. The reason is for dashboards only and must never
+// reach the client: an unknown email and a wrong password have to look the
+// same from outside.
+func (s *Service) recordFailedLogin(ctx context.Context, userID int, email, ipAddress, reason string) {
+	s.recordLoginAttempt(ctx, userID, email, ipAddress, false, reason)
+}
 
-		// Delete non-permanent token after use
-		if err := s.userRepo.DeleteLoginToken(ctx, loginToken.ID); err != nil {
-			// Log error but don't fail login
-			user.RecordAuthDBWriteError("login_token_delete")
-			s.logger.Warn("failed to delete login token", zap.Error(err))
-		}
+// recordLoginAttempt writes a login_attempts row and, for a failure, counts it
+// under reason. The write is best-effort: an error is logged, not returned.
+func (s *Service) recordLoginAttempt(ctx context.Context, userID int, email, ipAddress string, success bool, reason string) {
+	if !success {
+		user.RecordLoginFailure(reason)
+	}
+
+	stored := ""
+	if s.attemptReasons {
+		stored = reason
+	}
+	if err := s.userRepo.RecordLoginAttempt(ctx, email, ipAddress, success, stored); err != nil {
+		user.RecordAuthDBWriteError("login_attempt")
+		s.log(ctx).Warn("failed to record login attempt",
+			zap.Int("user_id", userID),
+			zap.Bool("success", success),
+			zap.Error(err),
+		)
+	}
+}
+
+// log returns the service logger annotated with ctx's request ID.
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	return requestid.Logger(ctx, s.logger)
+}
+
+// AuthenticateLoginToken authenticates with a login token (magic link)
+func (s *Service) AuthenticateLoginToken(ctx context.Context, secret string) (*LoginResponse, error) {
+	userID, err := s.consumeLoginToken(ctx, secret)
+	if err != nil {
+		return nil, err
 	}
 
 	// Load user with meta
-	userWithMeta, err := s.userRepo.FindWithMeta(ctx, loginToken.UserID)
+	userWithMeta, err := s.userRepo.FindWithMeta(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load user: %w", err)
 	}
@@ -180,6 +452,9 @@ func (s *Service) AuthenticateLoginToken(ctx context.Context, secret string) (*L
 	}
 
 	usr := &userWithMeta.User
+	if err := CheckAccountStatus(usr); err != nil {
+		return nil, err
+	}
 
 	// Defer last_logged_on update off the auth hot path.
 	s.lastLogin.Enqueue(usr.ID)
@@ -198,10 +473,21 @@ func (s *Service) AuthenticateLoginToken(ctx context.Context, secret string) (*L
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(userWithMeta.Meta)),
+		token.WithLocale(usr.Locale),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(AppFromContext(ctx)),
+		s.deviceOption(ctx),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.claimDeviceSession(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, IssueMethodMagicLink, "", tokenPair)
+	IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &LoginResponse{
 		Token: tokenPair,
@@ -210,25 +496,305 @@ func (s *Service) AuthenticateLoginToken(ctx context.Context, secret string) (*L
 	}, nil
 }
 
-// ValidateToken validates a JWT token
+// consumeLoginToken looks secret up, deleting it if it is one-time, and
+// returns its user. With a LoginTokenDedup set, every consumption of a
+// one-time secret within the replay window gets its user, not just the first.
+func (s *Service) consumeLoginToken(ctx context.Context, secret string) (int, error) {
+	claimed := false
+	if s.loginTokenDedup != nil {
+		userID, ok, c, err := s.loginTokenDedup.Claim(ctx, secret)
+		if err != nil {
+			s.log(ctx).Warn("login token dedup unavailable", zap.Error(err))
+		}
+		if ok {
+			return userID, nil
+		}
+		claimed = c
+	}
+	consumed := false
+	if claimed {
+		defer func() {
+			if consumed {
+				return
+			}
+			if err := s.loginTokenDedup.Release(ctx, secret); err != nil {
+				s.log(ctx).Warn("failed to release login token claim", zap.Error(err))
+			}
+		}()
+	}
+
+	// Find login token
+	loginToken, err := s.userRepo.FindLoginToken(ctx, secret)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+
+	if loginToken == nil {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	// Check if token is expired (5 minutes for non-permanent tokens)
+	if !loginToken.Permanent {
+		expiryTime := loginToken.CreatedAt.Add(5 * time.Minute)
+		if time.Now().After(expiryTime) {
+			return 0, fmt.Errorf("token expired")
+		}
+
+		if claimed {
+			if err := s.loginTokenDedup.Consumed(ctx, secret, loginToken.UserID); err != nil {
+				s.log(ctx).Warn("failed to record login token consumption", zap.Int("user_id", loginToken.UserID), zap.Error(err))
+			} else {
+				consumed = true
+			}
+		}
+
+		// Delete non-permanent token after use
+		if err := s.userRepo.DeleteLoginToken(ctx, loginToken.ID); err != nil {
+			// Log error but don't fail login
+			user.RecordAuthDBWriteError("login_token_delete")
+			s.log(ctx).Warn("failed to delete login token", zap.Int("user_id", loginToken.UserID), zap.Error(err))
+		}
+	}
+	return loginToken.UserID, nil
+}
+
+// ValidateToken validates a JWT token. An expired token's error wraps
+// token.ErrExpired; a blacklisted or cut-off one is apperrors.ErrTokenRevoked.
 func (s *Service) ValidateToken(ctx context.Context, tokenString string) (*token.Claims, error) {
-	// Validate token signature and expiry
-	claims, err := s.tokenService.Validate(tokenString)
+	// Validate token signature and expiry, and its audience when the
+	// caller has named its app (see WithApp).
+	var claims *token.Claims
+	var err error
+	if app := AppFromContext(ctx); app != "" {
+		claims, err = s.tokenService.ValidateAudience(tokenString, app)
+	} else {
+		claims, err = s.tokenService.Validate(tokenString)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
+	// A meta type renamed since the token was minted, or one that never
+	// existed, would otherwise sign in with no meta at all.
+	if !user.KnownMetaType(claims.MetaType) {
+		return nil, apperrors.ErrInvalidMetaType
+	}
+
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ValidateTokenWithGrace is ValidateToken, except that a token which expired
+// no more than the configured grace ago is still accepted, with expired set.
+// Meant for reads only: a client on a flaky network keeps working through a
+// slow refresh, and is told to refresh right away. Revocation is checked
+// either way.
+func (s *Service) ValidateTokenWithGrace(ctx context.Context, tokenString string) (claims *token.Claims, expired bool, err error) {
+	claims, err = s.ValidateToken(ctx, tokenString)
+	if err == nil || s.expiredGrace <= 0 || !errors.Is(err, token.ErrExpired) {
+		return claims, false, err
+	}
+
+	expiredClaims, parseErr := s.tokenService.ValidateAllowExpired(tokenString)
+	if parseErr != nil || expiredClaims.ExpiresAt == nil || time.Since(expiredClaims.ExpiresAt.Time) > s.expiredGrace {
+		return nil, false, err
+	}
+	if app := AppFromContext(ctx); app != "" {
+		if err := token.CheckAudience(expiredClaims, app); err != nil {
+			return nil, false, fmt.Errorf("invalid token: %w", err)
+		}
+	}
+	if !user.KnownMetaType(expiredClaims.MetaType) {
+		return nil, false, apperrors.ErrInvalidMetaType
+	}
+	if err := s.checkRevoked(ctx, expiredClaims); err != nil {
+		return nil, false, err
+	}
+	return expiredClaims, true, nil
+}
 
+// checkRevoked returns ErrTokenRevoked when claims' token is blacklisted,
+// was minted before the user's last version bump, or fails one of the checks
+// in checkSessionRevoked.
+func (s *Service) checkRevoked(ctx context.Context, claims *token.Claims) error {
 	// Check if token is blacklisted
 	blacklisted, err := s.tokenBlacklist.IsBlacklisted(ctx, claims.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check blacklist: %w", err)
+		return fmt.Errorf("failed to check blacklist: %w", err)
 	}
 
 	if blacklisted {
-		return nil, fmt.Errorf("token revoked")
+		return apperrors.ErrTokenRevoked
 	}
 
-	return claims, nil
+	// Logout everywhere: tokens minted before the user's last version bump.
+	stale, err := s.userVersions.Stale(ctx, claims)
+	if err != nil {
+		return err
+	}
+	if stale {
+		return apperrors.ErrTokenRevoked
+	}
+
+	return s.checkSessionRevoked(ctx, claims)
+}
+
+// checkSessionRevoked is the rest of checkRevoked, after the blacklist and
+// token-version lookups that IntrospectBatch batches: it returns
+// ErrTokenRevoked when claims' token predates its meta type's issued-at
+// cutoff or the user's last password change, or a later sign-in from the
+// same device replaced it.
+func (s *Service) checkSessionRevoked(ctx context.Context, claims *token.Claims) error {
+	// Bulk revocation: every token of this meta type issued before its cutoff.
+	predates, err := s.cutoffs.IssuedBefore(ctx, claims.MetaType, claims.IssuedAt)
+	if err != nil {
+		return fmt.Errorf("failed to check issued-at cutoff: %w", err)
+	}
+	if predates {
+		return apperrors.ErrTokenRevoked
+	}
+
+	// A later sign-in from the same device replaced this one.
+	superseded, err := s.deviceSessions.Superseded(ctx, claims)
+	if err != nil {
+		return err
+	}
+	if superseded {
+		return apperrors.ErrTokenRevoked
+	}
+
+	// Changing the password signs out every earlier session.
+	predates, err = s.passwordChanges.Predates(ctx, claims.UserID, claims.PasswordChangedAt, claims.IssuedAt)
+	if err != nil {
+		return err
+	}
+	if predates {
+		return apperrors.ErrTokenRevoked
+	}
+	return nil
+}
+
+// Introspection is the state of one access token in an introspection
+// response, modelled on RFC 7662: an inactive token (bad signature, expired,
+// revoked) is reported as active=false with no other fields.
+type Introspection struct {
+	Active   bool   `json:"active"`
+	JTI      string `json:"jti,omitempty"`
+	Exp      int64  `json:"exp,omitempty"` // Unix seconds
+	Iat      int64  `json:"iat,omitempty"` // Unix seconds
+	Sub      string `json:"sub,omitempty"`
+	Aud      string `json:"aud,omitempty"` // the app the token is scoped to; see WithApp
+	UserID   int    `json:"user_id,omitempty"`
+	MetaType string `json:"meta_type,omitempty"`
+}
+
+// Introspect is IntrospectBatch for a single token.
+func (s *Service) Introspect(ctx context.Context, tokenString string) (Introspection, error) {
+	results, err := s.IntrospectBatch(ctx, []string{tokenString})
+	if err != nil {
+		return Introspection{}, err
+	}
+	return results[0], nil
+}
+
+// IntrospectBatch applies ValidateToken's checks to every token and returns
+// one result per token, in order. As with ValidateToken, a token not scoped
+// to the app named by ctx (see WithApp) is reported inactive. The blacklist
+// and token-version lookups for the tokens that pass signature and expiry are
+// batched, one round-trip each; the rest of checkRevoked, checkSessionRevoked,
+// still runs per surviving token. A Redis failure fails the whole batch
+// rather than reporting revoked tokens active.
+func (s *Service) IntrospectBatch(ctx context.Context, tokens []string) ([]Introspection, error) {
+	results := make([]Introspection, len(tokens))
+
+	var valid []int
+	var jtis []string
+	claimsByIndex := make(map[int]*token.Claims, len(tokens))
+	app := AppFromContext(ctx)
+	for i, t := range tokens {
+		claims, err := s.tokenService.Validate(t)
+		if err == nil && app != "" {
+			err = token.CheckAudience(claims, app)
+		}
+		if err != nil || !user.KnownMetaType(claims.MetaType) {
+			continue
+		}
+		valid = append(valid, i)
+		jtis = append(jtis, claims.ID)
+		claimsByIndex[i] = claims
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	revoked, err := s.tokenBlacklist.AreBlacklisted(ctx, jtis)
+	if err != nil {
+		return nil, err
+	}
+	validClaims := make([]*token.Claims, len(valid))
+	for n, i := range valid {
+		validClaims[n] = claimsByIndex[i]
+	}
+	stale, err := s.userVersions.AreStale(ctx, validClaims)
+	if err != nil {
+		return nil, err
+	}
+	for n, i := range valid {
+		if revoked[n] || stale[n] {
+			continue
+		}
+		claims := claimsByIndex[i]
+		if err := s.checkSessionRevoked(ctx, claims); err != nil {
+			if errors.Is(err, apperrors.ErrTokenRevoked) {
+				continue
+			}
+			return nil, err
+		}
+		results[i] = Introspection{
+			Active:   true,
+			JTI:      claims.ID,
+			Exp:      claims.ExpiresAt.Unix(),
+			Sub:      claims.Subject,
+			Aud:      token.AppOf(claims.Audience),
+			UserID:   claims.UserID,
+			MetaType: claims.MetaType,
+		}
+		if claims.IssuedAt != nil {
+			results[i].Iat = claims.IssuedAt.Unix()
+		}
+	}
+
+	return results, nil
+}
+
+// RevokeAllForUser signs userID out everywhere. Bumping token_version kills
+// every refresh token; publishing the new version to the UserTokenVersions
+// kills every access token, on every device, on its next request.
+func (s *Service) RevokeAllForUser(ctx context.Context, userID int) error {
+	version, err := s.userRepo.IncrementTokenVersion(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return s.PublishTokenVersion(ctx, userID, version)
+}
+
+// PublishTokenVersion finishes revoking userID's sessions once their
+// token_version has been bumped to version, as RevokeAllForUser and an
+// account status change do: it drops their indexed refresh tokens and
+// rejects access tokens minted below version on their next request.
+func (s *Service) PublishTokenVersion(ctx context.Context, userID, version int) error {
+	if err := s.refreshIndex.Clear(ctx, userID); err != nil {
+		return err
+	}
+	if s.userVersions == nil {
+		return nil
+	}
+	if err := s.userVersions.Set(ctx, userID, version); err != nil {
+		return err
+	}
+	s.log(ctx).Info("revoked all sessions", zap.Int("user_id", userID), zap.Int("token_version", version))
+	return nil
 }
 
 // Logout revokes the caller's sessions. It bumps the user's token_version,
@@ -251,6 +817,9 @@ func (s *Service) Logout(ctx context.Context, tokenString string) error {
 	if _, err := s.userRepo.IncrementTokenVersion(ctx, claims.UserID); err != nil {
 		return fmt.Errorf("failed to revoke sessions: %w", err)
 	}
+	if err := s.refreshIndex.Clear(ctx, claims.UserID); err != nil {
+		return err
+	}
 
 	// Also blacklist the presented access token so it can't be used until it
 	// would have expired. Add() is a no-op when the token is already expired.
@@ -261,17 +830,72 @@ func (s *Service) Logout(ctx context.Context, tokenString string) error {
 	return nil
 }
 
+// RevokeRefreshToken blacklists a single refresh token belonging to userID,
+// e.g. when a client signs out of one device without ending the user's other
+// sessions (which Logout does). A token that belongs to someone else is
+// rejected with ErrTokenMismatch and left untouched. With reuse detection on,
+// the token's whole family is revoked too, so a token the presented one was
+// already rotated into can't keep the session alive.
+func (s *Service) RevokeRefreshToken(ctx context.Context, userID int, refreshTokenString string) error {
+	claims, err := s.tokenService.ValidateRefreshToken(refreshTokenString)
+	if err != nil {
+		return apperrors.ErrInvalidToken
+	}
+
+	if claims.Subject != strconv.Itoa(userID) {
+		return apperrors.ErrTokenMismatch
+	}
+
+	if err := s.tokenBlacklist.Add(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("failed to blacklist refresh token: %w", err)
+	}
+	if err := s.refreshIndex.Remove(ctx, userID, claims.ID); err != nil {
+		return err
+	}
+	if s.refreshFamilies != nil && claims.Family != "" {
+		if err := s.refreshFamilies.Revoke(ctx, claims.Family); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // RefreshRequest represents a token refresh request
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
-// RefreshToken validates a refresh token and issues a new token pair
-func (s *Service) RefreshToken(ctx context.Context, refreshTokenString string) (*LoginResponse, error) {
+// RefreshToken validates a refresh token and issues a new token pair.
+//
+// accessTokenString is optional. When the client presents its current access
+// token alongside the refresh token, both must reference the same subject;
+// otherwise a stolen refresh token is being mixed with someone else's session
+// and the refresh is rejected with ErrTokenMismatch. The access token may be
+// expired (that is the normal reason to refresh) but its signature must verify.
+func (s *Service) RefreshToken(ctx context.Context, refreshTokenString, accessTokenString string) (*LoginResponse, error) {
 	// Validate the refresh token
 	claims, err := s.tokenService.ValidateRefreshToken(refreshTokenString)
 	if err != nil {
-		return nil, fmt.Errorf("invalid refresh token: %w", err)
+		// Not %w: the token errors unwrap to TOKEN_EXPIRED and INVALID_TOKEN,
+		// which tell a client to refresh. A bad refresh token is
+		// INVALID_REFRESH_TOKEN, or the client would retry forever.
+		return nil, fmt.Errorf("invalid refresh token: %v", err)
+	}
+
+	// Refresh tokens always carry the numeric user ID as their subject.
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject in refresh token: %w", err)
+	}
+
+	// Compare user IDs rather than subjects: an access token's sub may be the
+	// boddle_uid (see token.WithSubjectFormat).
+	if accessTokenString != "" {
+		accessClaims, err := s.tokenService.ValidateAllowExpired(accessTokenString)
+		if err != nil || accessClaims.UserID != userID {
+			return nil, apperrors.ErrTokenMismatch
+		}
 	}
 
 	// Check if refresh token is blacklisted
@@ -280,15 +904,19 @@ func (s *Service) RefreshToken(ctx context.Context, refreshTokenString string) (
 		return nil, fmt.Errorf("failed to check blacklist: %w", err)
 	}
 	if blacklisted {
+		// Rotated (or explicitly revoked) already: a replay. Take the rest of
+		// its chain down with it.
+		if s.refreshFamilies != nil && claims.Family != "" {
+			s.log(ctx).Warn("refresh token reused; revoking its family",
+				zap.Int("user_id", userID), zap.String("family", claims.Family))
+			if err := s.refreshFamilies.Revoke(ctx, claims.Family); err != nil {
+				return nil, err
+			}
+			return nil, token.ErrRefreshReused
+		}
 		return nil, fmt.Errorf("refresh token revoked")
 	}
 
-	// Parse user ID from the subject claim
-	userID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		return nil, fmt.Errorf("invalid subject in refresh token: %w", err)
-	}
-
 	// Load user with meta
 	userWithMeta, err := s.userRepo.FindWithMeta(ctx, userID)
 	if err != nil {
@@ -306,10 +934,33 @@ func (s *Service) RefreshToken(ctx context.Context, refreshTokenString string) (
 	if claims.TokenVersion != usr.TokenVersion {
 		return nil, fmt.Errorf("refresh token revoked")
 	}
-
-	// Blacklist the old refresh token so it can't be reused
-	if err := s.tokenBlacklist.Add(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
-		return nil, fmt.Errorf("failed to blacklist old refresh token: %w", err)
+	// A cutoff for the user's meta type forces a fresh sign-in, so it covers
+	// refresh tokens too.
+	predates, err := s.cutoffs.IssuedBefore(ctx, usr.MetaType, claims.IssuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check issued-at cutoff: %w", err)
+	}
+	if predates {
+		return nil, fmt.Errorf("refresh token revoked")
+	}
+	// The user row is already loaded, so no need for the cached lookup.
+	if s.passwordChanges != nil && predatesPasswordChange(usr.PasswordChangedAt.Time, claims.PasswordChangedAt, claims.IssuedAt) {
+		return nil, fmt.Errorf("refresh token revoked")
+	}
+	if err := CheckAccountStatus(usr); err != nil {
+		return nil, err
+	}
+	// Only the device's current sign-in may refresh.
+	device := ""
+	if s.deviceSessions != nil && claims.Device != "" {
+		current, ok, err := s.deviceSessions.Current(ctx, userID, claims.Device)
+		if err != nil {
+			return nil, err
+		}
+		if ok && current != claims.Family {
+			return nil, fmt.Errorf("refresh token revoked")
+		}
+		device = claims.Device
 	}
 
 	// Generate new token pair
@@ -326,11 +977,46 @@ func (s *Service) RefreshToken(ctx context.Context, refreshTokenString string) (
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(userWithMeta.Meta)),
+		token.WithLocale(usr.Locale),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithRefreshFamily(claims.Family),
+		// A refreshed pair stays scoped to the app the sign-in was for.
+		token.WithAudience(token.AppOf(claims.Audience)),
+		token.WithDevice(device),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
+	// The blacklist check above can't see two refreshes racing with the same
+	// token; the family's atomic swap can.
+	if s.refreshFamilies != nil && claims.Family != "" {
+		if err := s.refreshFamilies.CheckAndRotate(ctx, claims.Family, claims.ID, tokenPair.RefreshJTI); err != nil {
+			if errors.Is(err, token.ErrRefreshReused) {
+				s.log(ctx).Warn("refresh token reused; revoking its family",
+					zap.Int("user_id", userID), zap.String("family", claims.Family))
+			}
+			return nil, err
+		}
+	}
+	if device != "" {
+		if err := s.deviceSessions.Claim(ctx, usr.ID, device, tokenPair.RefreshFamily); err != nil {
+			return nil, err
+		}
+	}
+	// Only now has the new pair replaced the old one: retire the old refresh
+	// token so it can't be reused. Doing this earlier would spend the token on
+	// a refresh that then failed, logging the client out.
+	if err := s.tokenBlacklist.Add(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return nil, fmt.Errorf("failed to blacklist old refresh token: %w", err)
+	}
+	if err := s.refreshIndex.Remove(ctx, userID, claims.ID); err != nil {
+		return nil, err
+	}
+	AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, IssueMethodRefresh, "", tokenPair)
+	IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
+
 	return &LoginResponse{
 		Token: tokenPair,
 		User:  usr,
@@ -338,8 +1024,13 @@ func (s *Service) RefreshToken(ctx context.Context, refreshTokenString string) (
 	}, nil
 }
 
-// GetCurrentUser gets the current user from token claims
+// GetCurrentUser gets the current user from token claims, from the user
+// cache when one is configured
 func (s *Service) GetCurrentUser(ctx context.Context, claims *token.Claims) (*user.UserWithMeta, error) {
+	if cached, ok := s.userCache.Get(claims.UserID); ok {
+		return cached, nil
+	}
+
 	userWithMeta, err := s.userRepo.FindWithMeta(ctx, claims.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load user: %w", err)
@@ -349,5 +1040,85 @@ func (s *Service) GetCurrentUser(ctx context.Context, claims *token.Claims) (*us
 		return nil, fmt.Errorf("user not found")
 	}
 
+	s.userCache.Set(userWithMeta)
 	return userWithMeta, nil
 }
+
+// UpdateLocale validates and stores the user's preferred locale. It takes
+// effect in the locale claim of the next token minted for the user.
+func (s *Service) UpdateLocale(ctx context.Context, userID int, locale string) error {
+	locale = strings.TrimSpace(locale)
+	if locale != "" && !IsValidLocale(locale) {
+		return apperrors.NewAppError(apperrors.ErrCodeValidationFailed, "locale must be a BCP 47 language tag (e.g. en-US)", 400)
+	}
+	if err := s.userRepo.UpdateLocale(ctx, userID, locale); err != nil {
+		return err
+	}
+	if err := s.userCache.Invalidate(ctx, userID); err != nil {
+		s.log(ctx).Warn("failed to invalidate user cache", zap.Int("user_id", userID), zap.Error(err))
+	}
+	return nil
+}
+
+// activityWindow is how far back GET /auth/security/activity looks.
+const activityWindow = 30 * 24 * time.Hour
+
+// LoginActivity is one login attempt as shown to the account owner.
+type LoginActivity struct {
+	Success     bool         `json:"success"`
+	IPAddress   string       `json:"ip_address"` // masked, see maskIP
+	AttemptedAt utctime.Time `json:"attempted_at"`
+}
+
+// RecentLoginActivity returns one page of the user's own login attempts
+// (successes and failures) from the last 30 days, newest first, with IPs
+// masked. Attempts are matched on the user's current email as stored, and
+// for a student also on their username, which username sign-ins are recorded
+// under; never on a caller-supplied one. hasMore reports whether a further
+// page exists.
+func (s *Service) RecentLoginActivity(ctx context.Context, userID, page, perPage int) ([]LoginActivity, bool, error) {
+	userWithMeta, err := s.userRepo.FindWithMeta(ctx, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load user: %w", err)
+	}
+	if userWithMeta == nil {
+		return nil, false, fmt.Errorf("user not found")
+	}
+
+	logins := []string{userWithMeta.User.Email}
+	if username := SanitizeUsername(user.StudentUsername(userWithMeta.Meta)); username != "" {
+		logins = append(logins, username)
+	}
+
+	offset := (page - 1) * perPage
+	attempts, total, err := s.userRepo.ListLoginAttempts(ctx, logins, time.Now().Add(-activityWindow), perPage, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	hasMore := offset+len(attempts) < total
+
+	activity := make([]LoginActivity, 0, len(attempts))
+	for _, a := range attempts {
+		activity = append(activity, LoginActivity{
+			Success:     a.Success,
+			IPAddress:   maskIP(a.IPAddress),
+			AttemptedAt: a.AttemptedAt,
+		})
+	}
+	return activity, hasMore, nil
+}
+
+// maskIP hides the host part of an address so it is recognisable to its
+// owner without exposing it in full: the last IPv4 octet, or everything past
+// the /64 prefix of an IPv6 address.
+func maskIP(raw string) string {
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		return "*"
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.*", v4[0], v4[1], v4[2])
+	}
+	// The masked address always ends in "::" (the zeroed host half).
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "*"
+}
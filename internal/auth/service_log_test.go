@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticateEmailPassword_LogsFailedAttemptWrite(t *testing.T) {
+	digest, err := HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnError(errors.New("connection reset"))
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.New(core))
+
+	ctx := requestid.WithID(context.Background(), "req-123")
+	if _, err := s.AuthenticateEmailPassword(ctx, "kid1@student.student", "wrong-horse", "10.0.0.1", ""); err == nil {
+		t.Fatal("expected invalid credentials")
+	}
+
+	entries := logs.FilterMessage("failed to record login attempt").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries, want 1: %v", len(entries), logs.All())
+	}
+	fields := entries[0].ContextMap()
+	if fields["user_id"] != int64(42) {
+		t.Errorf("user_id = %v, want 42", fields["user_id"])
+	}
+	if fields["request_id"] != "req-123" {
+		t.Errorf("request_id = %v, want req-123", fields["request_id"])
+	}
+	if fields["error"] != "failed to record login attempt: connection reset" {
+		t.Errorf("error = %v, want connection reset", fields["error"])
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var blockedStatuses = []struct {
+	status string
+	want   *apperrors.AppError
+}{
+	{user.StatusSuspended, apperrors.ErrAccountSuspended},
+	{user.StatusDeleted, apperrors.ErrAccountDisabled},
+}
+
+func statusUserRow(status, digest string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(append(userColumns, "status")).
+		AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now, status)
+}
+
+func TestAuthenticateEmailPassword_BlockedStatus(t *testing.T) {
+	digest, err := HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+
+	for _, tt := range blockedStatuses {
+		t.Run(tt.status, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(statusUserRow(tt.status, digest))
+			mock.ExpectExec(`INSERT INTO login_attempts`).
+				WithArgs("kid1@student.student", "203.0.113.7", false, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+			resp, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "correct-horse", "203.0.113.7", "")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if resp != nil {
+				t.Error("tokens issued for a blocked account")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateLoginToken_BlockedStatus(t *testing.T) {
+	for _, tt := range blockedStatuses {
+		t.Run(tt.status, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectQuery(`FROM login_tokens`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret", "permanent", "created_at"}).
+					AddRow(1, 42, "magic", true, time.Now()))
+			mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(statusUserRow(tt.status, ""))
+			mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+					AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+
+			s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+			if _, err := s.AuthenticateLoginToken(context.Background(), "magic"); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLoginHandler_ReportsAccountStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	digest, err := HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(statusUserRow(user.StatusSuspended, digest))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+	handler := &Handler{service: NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())}
+
+	c, w := newTestContext(http.MethodPost, "/auth/login",
+		`{"email":"kid1@student.student","password":"correct-horse"}`, nil)
+	handler.Login(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != apperrors.ErrCodeAccountSuspended {
+		t.Errorf("error code = %q, want %q", code, apperrors.ErrCodeAccountSuspended)
+	}
+}
+
+func TestCheckAccountStatus_MissingStatusIsActive(t *testing.T) {
+	for _, status := range []string{"", user.StatusActive} {
+		if err := CheckAccountStatus(&user.User{Status: status}); err != nil {
+			t.Errorf("status %q: %v, want nil", status, err)
+		}
+	}
+}
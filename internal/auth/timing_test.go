@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// spyComparePassword replaces comparePassword for the test and returns the
+// hashes each login compared against.
+func spyComparePassword(t *testing.T) *[]string {
+	t.Helper()
+	var hashes []string
+	orig := comparePassword
+	comparePassword = func(password, hash string) error {
+		hashes = append(hashes, hash)
+		return orig(password, hash)
+	}
+	t.Cleanup(func() { comparePassword = orig })
+	return &hashes
+}
+
+func TestAuthenticateEmailPassword_UnknownEmailStillComparesHash(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("nobody@school.org").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	hashes := spyComparePassword(t)
+	s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+
+	if _, err := s.AuthenticateEmailPassword(context.Background(), "nobody@school.org", "guess", "203.0.113.7", ""); err == nil {
+		t.Fatal("expected invalid credentials")
+	}
+	if len(*hashes) != 1 || (*hashes)[0] != dummyPasswordHash {
+		t.Errorf("compared against %v, want one comparison with the dummy hash", *hashes)
+	}
+}
+
+func TestAuthenticateEmailPassword_SSOOnlyAccountComparesDummyAndFails(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("sso@school.org").
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(7, "SSO Teacher", "sso@school.org", "", nil, "Teacher", 3, nil, 0, "", now, now))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	hashes := spyComparePassword(t)
+	s := NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())
+
+	// Even the dummy hash's own plaintext must not sign in.
+	if _, err := s.AuthenticateEmailPassword(context.Background(), "sso@school.org", "reservoir-timing-equalizer", "203.0.113.7", ""); err == nil {
+		t.Fatal("SSO-only account authenticated with a password")
+	}
+	if len(*hashes) != 1 || (*hashes)[0] != dummyPasswordHash {
+		t.Errorf("compared against %v, want one comparison with the dummy hash", *hashes)
+	}
+}
+
+func TestDummyPasswordHash_MatchesRealCost(t *testing.T) {
+	dummyCost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatalf("dummy hash is not bcrypt: %v", err)
+	}
+	real, err := HashPassword("anything")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	realCost, _ := bcrypt.Cost([]byte(real))
+	if dummyCost != realCost {
+		t.Errorf("dummy hash cost = %d, real hash cost = %d; timing would differ", dummyCost, realCost)
+	}
+}
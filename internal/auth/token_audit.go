@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/pkg/requestid"
+)
+
+// Token issuance methods reported to a TokenAuditor. OAuth sign-ins report
+// the provider name ("google", "clever", "icloud").
+const (
+	IssueMethodPassword  = "password"
+	IssueMethodMagicLink = "magic_link"
+	IssueMethodRefresh   = "refresh"
+	IssueMethodRegister  = "register"
+)
+
+// TokenAuditor records every token pair minted, for compliance. It is given
+// the access token's jti, never the token itself. Satisfied by
+// *audit.Repository.
+type TokenAuditor interface {
+	RecordTokenIssued(ctx context.Context, userID int, method, ipAddress, jti string) error
+}
+
+// SetTokenAuditor makes s report every token it issues to a. Auditing is off
+// until this is called.
+func (s *Service) SetTokenAuditor(a TokenAuditor) {
+	s.tokenAuditor = a
+}
+
+// AuditTokenIssued reports pair to a, if a is non-nil. ipAddress falls back
+// to the client IP in ctx. The record is best-effort: a failed write is
+// logged and the sign-in goes ahead.
+func AuditTokenIssued(ctx context.Context, a TokenAuditor, logger *zap.Logger, userID int, method, ipAddress string, pair *token.TokenPair) {
+	if a == nil {
+		return
+	}
+	if ipAddress == "" {
+		ipAddress = requestid.ClientIP(ctx)
+	}
+	if err := a.RecordTokenIssued(ctx, userID, method, ipAddress, pair.JTI); err != nil {
+		requestid.Logger(ctx, logger).Warn("failed to audit token issuance",
+			zap.Int("user_id", userID),
+			zap.String("method", method),
+			zap.Error(err),
+		)
+	}
+}
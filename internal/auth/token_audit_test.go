@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+type issuedToken struct {
+	userID              int
+	method, ipAddr, jti string
+}
+
+type recordingAuditor struct {
+	issued []issuedToken
+}
+
+func (a *recordingAuditor) RecordTokenIssued(ctx context.Context, userID int, method, ipAddress, jti string) error {
+	a.issued = append(a.issued, issuedToken{userID, method, ipAddress, jti})
+	return nil
+}
+
+func TestAuthenticateEmailPassword_AuditsTokenIssuance(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	expectStudentLogin(t, mock, "correct-horse")
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	auditor := &recordingAuditor{}
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	s.SetTokenAuditor(auditor)
+
+	resp, err := s.AuthenticateEmailPassword(context.Background(), "kid1@student.student", "correct-horse", "203.0.113.7", "")
+	if err != nil {
+		t.Fatalf("AuthenticateEmailPassword: %v", err)
+	}
+
+	if len(auditor.issued) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(auditor.issued))
+	}
+	got := auditor.issued[0]
+	if got.userID != 42 || got.method != IssueMethodPassword || got.ipAddr != "203.0.113.7" {
+		t.Errorf("audit event = %+v, want user 42, method %q, IP 203.0.113.7", got, IssueMethodPassword)
+	}
+	claims, err := newTestTokenService().Validate(resp.Token.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got.jti == "" || got.jti != claims.ID {
+		t.Errorf("jti = %q, want the access token's %q", got.jti, claims.ID)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// loginData runs a successful student login through the Login handler and
+// returns the response's data object.
+func loginData(t *testing.T, target string) map[string]json.RawMessage {
+	t.Helper()
+	repo, mock := newMockRepository(t)
+	expectStudentLogin(t, mock, "correct-horse")
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO login_attempts`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	handler := &Handler{service: NewService(repo, newTestTokenService(), nil, &fakeLimiter{}, nopEnqueuer{}, zap.NewNop())}
+	c, w := newTestContext(http.MethodPost, target, `{"email":"kid1@student.student","password":"correct-horse"}`, nil)
+	handler.Login(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp.Data
+}
+
+func TestLogin_TokenOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	full := loginData(t, "/auth/login")
+	for _, key := range []string{"token", "user", "meta"} {
+		if _, ok := full[key]; !ok {
+			t.Errorf("default response is missing %q", key)
+		}
+	}
+
+	trimmed := loginData(t, "/auth/login?token_only=true")
+	if len(trimmed) != 1 {
+		t.Errorf("token_only response keys = %v, want only token", keys(trimmed))
+	}
+	var pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(trimmed["token"], &pair); err != nil || pair.AccessToken == "" || pair.RefreshToken == "" {
+		t.Errorf("token_only response has no usable token pair: %s", trimmed["token"])
+	}
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
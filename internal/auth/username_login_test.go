@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/boddle/reservoir/internal/user"
+)
+
+// keyLimiter is a fakeLimiter that remembers the login key it was asked about.
+type keyLimiter struct {
+	fakeLimiter
+	keys []string
+}
+
+func (k *keyLimiter) CheckAndRecord(ctx context.Context, login, ipAddress string) (bool, int, time.Duration, bool, error) {
+	k.keys = append(k.keys, login)
+	return k.fakeLimiter.CheckAndRecord(ctx, login, ipAddress)
+}
+
+func TestAuthenticateUsernamePassword_NormalizesAndSignsIn(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	digest, err := HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	now := time.Now()
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userColumns).
+			AddRow(42, "Kid One", "kid1@student.student", digest, "uid-42", "Student", 9, nil, 0, "", now, now)
+	}
+	mock.ExpectQuery(`FROM students s\s+JOIN users u .+\s+WHERE LOWER\(s.username\) = \$1`).WithArgs("kido9").WillReturnRows(row())
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(42).WillReturnRows(row())
+	mock.ExpectQuery(`FROM students\s+WHERE id`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(9, nil, nil, nil, nil, nil, now, now))
+	mock.ExpectExec(`INSERT INTO login_attempts`).
+		WithArgs("kido9", "203.0.113.7", true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	limiter := &keyLimiter{}
+	s := NewService(repo, newTestTokenService(), nil, limiter, nopEnqueuer{}, zap.NewNop())
+	resp, err := s.AuthenticateUsernamePassword(context.Background(), "  KidO9 ", "correct-horse", "203.0.113.7", "")
+	if err != nil {
+		t.Fatalf("AuthenticateUsernamePassword: %v", err)
+	}
+	if resp.User.ID != 42 || resp.Token.AccessToken == "" {
+		t.Errorf("got user %d, token %q; want user 42 with a token", resp.User.ID, resp.Token.AccessToken)
+	}
+	if len(limiter.keys) != 1 || limiter.keys[0] != "kido9" {
+		t.Errorf("limiter keys = %v, want [kido9]", limiter.keys)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogin_UnknownUsernameIsInvalidCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`WHERE LOWER\(s.username\) = \$1`).WithArgs("nobody1").WillReturnRows(sqlmock.NewRows(userColumns))
+	mock.ExpectExec(`INSERT INTO login_attempts \(email, ip_address, success, attempted_at, reason\)`).
+		WithArgs("nobody1", sqlmock.AnyArg(), false, sqlmock.AnyArg(), user.LoginFailureUnknownUser).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s := NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())
+	s.SetLoginAttemptReasons(true)
+	handler := &Handler{service: s}
+
+	c, w := newTestContext(http.MethodPost, "/auth/login", `{"username":"Nobody1","password":"whatever"}`, nil)
+	handler.Login(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Error.Code != "INVALID_CREDENTIALS" || body.Error.Message != "Invalid username or password" {
+		t.Errorf("error = %+v, want INVALID_CREDENTIALS for a username", body.Error)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogin_RequiresEmailOrUsername(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for name, body := range map[string]string{
+		"neither": `{"password":"secret"}`,
+		"both":    `{"email":"kid1@student.student","username":"kido9","password":"secret"}`,
+		"blank":   `{"username":"   ","password":"secret"}`,
+	} {
+		// No expectations: nothing reaches the database.
+		repo, mock := newMockRepository(t)
+		handler := &Handler{service: NewService(repo, newTestTokenService(), nil, nil, nopEnqueuer{}, zap.NewNop())}
+		c, w := newTestContext(http.MethodPost, "/auth/login", body, nil)
+		handler.Login(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, w.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -10,6 +10,10 @@ var (
 	// Email validation regex (RFC 5322 simplified)
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 
+	// Locale tag: a 2-3 letter language subtag followed by optional
+	// region/script/variant subtags (a pragmatic subset of BCP 47).
+	localeRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
 	// Password requirements
 	minPasswordLength = 3 // Matches Rails validation
 )
@@ -87,7 +91,50 @@ func SanitizeEmail(email string) string {
 	return strings.ToLower(strings.TrimSpace(email))
 }
 
+// SanitizeUsername normalizes a student username
+func SanitizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
 // IsStudentEmail checks if an email is a student email (username@student.student)
 func IsStudentEmail(email string) bool {
 	return strings.HasSuffix(strings.ToLower(email), "@student.student")
 }
+
+// IsValidLocale checks that a locale looks like a BCP 47 language tag
+func IsValidLocale(locale string) bool {
+	return len(locale) <= 35 && localeRegex.MatchString(locale)
+}
+
+// ValidateRegisterRequest validates a registration request: the email and
+// password rules of a login, a self-service account type and a first name
+func ValidateRegisterRequest(req *RegisterRequest) error {
+	errors := make([]ValidationError, 0)
+
+	if err := ValidateLoginRequest(&LoginRequest{Email: req.Email, Password: req.Password}); err != nil {
+		errors = append(errors, err.(*validationErrors).Errors...)
+	}
+
+	// Admins are never self-registered
+	switch req.MetaType {
+	case "Teacher", "Student", "Parent":
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "meta_type",
+			Message: "Account type must be Teacher, Student or Parent",
+		})
+	}
+
+	if strings.TrimSpace(req.FirstName) == "" {
+		errors = append(errors, ValidationError{
+			Field:   "first_name",
+			Message: "First name is required",
+		})
+	}
+
+	if len(errors) > 0 {
+		return &validationErrors{Errors: errors}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package background
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Group runs the process's background workers (write-behind flushers, purge
+// jobs, refreshers) under one shared context so shutdown can stop them all
+// together. Workers must return promptly once their context is cancelled,
+// finishing any buffered work first.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+// NewGroup creates a new worker group
+func NewGroup(logger *zap.Logger) *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel, logger: logger}
+}
+
+// Go starts fn in its own goroutine with the group's context. name is only
+// used for logging.
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+		g.logger.Debug("background worker stopped", zap.String("worker", name))
+	}()
+}
+
+// Shutdown cancels the group's context and waits for every worker to
+// return. ctx bounds the wait; if it expires first, Shutdown returns
+// ctx.Err() and the stragglers are abandoned (the process is exiting).
+//
+// Call this only after the HTTP server has stopped, so no request can hand
+// a worker new work after it has drained.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestGroup_WorkerExitsOnCancel(t *testing.T) {
+	g := NewGroup(zap.NewNop())
+
+	started := make(chan struct{})
+	drained := false
+	g.Go("test", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		drained = true
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := g.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if !drained {
+		t.Error("worker did not finish before Shutdown returned")
+	}
+}
+
+func TestGroup_ShutdownBoundedByDeadline(t *testing.T) {
+	g := NewGroup(zap.NewNop())
+
+	release := make(chan struct{})
+	defer close(release)
+	g.Go("stuck", func(ctx context.Context) {
+		<-release // ignores cancellation
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := g.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown took %v, want it bounded by the ~50ms deadline", elapsed)
+	}
+}
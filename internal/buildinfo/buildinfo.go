@@ -0,0 +1,55 @@
+// Package buildinfo reports which build of the gateway is running. The
+// values are stamped in at link time:
+//
+//	go build -ldflags "-X github.com/boddle/reservoir/internal/buildinfo.Version=v1.2.3 \
+//	  -X github.com/boddle/reservoir/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/boddle/reservoir/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The Dockerfile and Makefile do this; a plain go build reports "dev".
+package buildinfo
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set with -ldflags -X; see the package comment.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info is the build metadata served at GET /version. It holds nothing
+// sensitive: the same values are in the image tag and the git history.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build's metadata.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String formats i for --version.
+func (i Info) String() string {
+	return fmt.Sprintf("reservoir %s (commit %s, built %s, %s)", i.Version, i.Commit, i.BuildTime, i.GoVersion)
+}
+
+// Handler serves the build metadata. Unauthenticated, and bare JSON like
+// /health, so ops can curl it during an incident.
+// GET /version
+func Handler(c *gin.Context) {
+	c.JSON(http.StatusOK, Get())
+}
@@ -0,0 +1,36 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandler_ServesInjectedBuildInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// What -ldflags -X would have set.
+	oldVersion, oldCommit, oldBuildTime := Version, Commit, BuildTime
+	Version, Commit, BuildTime = "v1.4.2", "0a1b2c3d", "2026-10-15T12:00:00Z"
+	t.Cleanup(func() { Version, Commit, BuildTime = oldVersion, oldCommit, oldBuildTime })
+
+	router := gin.New()
+	router.GET("/version", Handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var got Info
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	want := Info{Version: "v1.4.2", Commit: "0a1b2c3d", BuildTime: "2026-10-15T12:00:00Z", GoVersion: runtime.Version()}
+	if got != want {
+		t.Errorf("GET /version = %+v, want %+v", got, want)
+	}
+}
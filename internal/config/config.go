@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -13,11 +15,97 @@ type Config struct {
 	Port string `envconfig:"PORT" default:"8080"`
 	Env  string `envconfig:"ENV" default:"development"`
 
+	// MaxInFlightRequests caps concurrently processed requests; excess
+	// requests are shed with a 503 + Retry-After. 0 disables shedding.
+	MaxInFlightRequests int `envconfig:"MAX_IN_FLIGHT_REQUESTS" default:"0"`
+
+	// ShutdownDrainDelay is how long the server keeps answering 503
+	// SHUTTING_DOWN (including on /health) after SIGTERM before it stops
+	// accepting connections, giving the load balancer time to deregister the
+	// instance. 0 stops right away.
+	ShutdownDrainDelay time.Duration `envconfig:"SHUTDOWN_DRAIN_DELAY" default:"0s"`
+
+	// ResponseNaming is the casing of response JSON keys for requests
+	// without an X-Naming-Convention header: "snake_case" or "camelCase".
+	ResponseNaming string `envconfig:"RESPONSE_NAMING" default:"snake_case"`
+
+	// AuditTokenIssuance writes an audit_events row (user, method, IP, jti)
+	// for every token pair minted. The token itself is never recorded.
+	AuditTokenIssuance bool `envconfig:"AUDIT_TOKEN_ISSUANCE" default:"false"`
+
+	// PasswordLoginUnavailableError answers a password login for an account
+	// with no usable password_digest (SSO-only) with PASSWORD_LOGIN_UNAVAILABLE
+	// instead of INVALID_CREDENTIALS. This tells the caller the email exists,
+	// so it is off by default.
+	PasswordLoginUnavailableError bool `envconfig:"PASSWORD_LOGIN_UNAVAILABLE_ERROR" default:"false"`
+
+	// BcryptMaxConcurrency caps password comparisons running at once, so a
+	// login storm can't take every core. A login that waits longer than
+	// BcryptQueueTimeout for a slot gets 503 SERVER_BUSY. 0 disables the cap.
+	BcryptMaxConcurrency int           `envconfig:"BCRYPT_MAX_CONCURRENCY" default:"0"`
+	BcryptQueueTimeout   time.Duration `envconfig:"BCRYPT_QUEUE_TIMEOUT" default:"2s"`
+
+	// LoginTokenReplayWindow lets a one-time magic link be consumed again
+	// within this long of its first use, so a double-submitted link signs
+	// in both requests instead of failing one. 0 keeps links strictly
+	// single-use.
+	LoginTokenReplayWindow time.Duration `envconfig:"LOGIN_TOKEN_REPLAY_WINDOW" default:"0"`
+
+	// SessionPerDevice keeps one session per user per device fingerprint
+	// (X-Device-Fingerprint): signing in again from the same device revokes
+	// that device's previous session. Sign-ins without the header are not
+	// limited.
+	SessionPerDevice bool `envconfig:"SESSION_PER_DEVICE" default:"false"`
+
+	// PasswordChangeCacheTTL is how long a user's password_changed_at is
+	// cached in Redis; tokens minted before it are rejected, so a password
+	// change takes effect within this long. 0 disables the check.
+	PasswordChangeCacheTTL time.Duration `envconfig:"PASSWORD_CHANGE_CACHE_TTL" default:"30s"`
+
+	// BoddleContextSecret verifies the X-Boddle-Context header Rails signs
+	// to pass along what only it knows (e.g. beta cohort); at least 32
+	// bytes. Empty ignores the header entirely.
+	BoddleContextSecret string `envconfig:"BODDLE_CONTEXT_SECRET" secret:"true"`
+
+	// IntrospectionAPIKeys are the keys trusted services present in
+	// X-Service-Key to call POST /auth/introspect and /auth/introspect/batch.
+	// List two while rotating. Empty leaves both endpoints unmounted.
+	IntrospectionAPIKeys []string `envconfig:"INTROSPECTION_API_KEYS" secret:"true"`
+
+	// TrustedProxies are the IPs and CIDR ranges of our load balancers and
+	// proxies. Only requests arriving directly from one of them keep the
+	// StripInboundHeaders, and only their X-Forwarded-For entries count
+	// towards the client IP.
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES" default:"127.0.0.1/32,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"`
+	// StripInboundHeaders are removed from requests that don't come through
+	// a trusted proxy, before any middleware or handler sees them.
+	StripInboundHeaders []string `envconfig:"STRIP_INBOUND_HEADERS" default:"X-User-Id,X-Forwarded-For,X-Forwarded-Host,X-Forwarded-Proto,X-Real-IP"`
+
+	// LogRedactQueryParams are the query parameters whose values are masked
+	// in request logs.
+	LogRedactQueryParams []string `envconfig:"LOG_REDACT_QUERY_PARAMS" default:"token,code,state,client_secret,secret,password,access_token,refresh_token"`
+
+	// LogHandlerName adds the Go handler that served each matched route to
+	// the request log line, next to the route template that is always there.
+	LogHandlerName bool `envconfig:"LOG_HANDLER_NAME" default:"false"`
+
 	// Database configuration
 	Database DatabaseConfig
 
 	// Redis configuration
-	RedisURL string `envconfig:"REDIS_URL" required:"true"`
+	RedisURL string `envconfig:"REDIS_URL" required:"true" secret:"true"`
+	// RedisOpTimeout bounds each Redis command (blacklist, rate limit, OAuth
+	// state). A timeout is handled like any Redis error: the rate limiter
+	// fails open, the blacklist fails closed. 0 disables the bound.
+	RedisOpTimeout time.Duration `envconfig:"REDIS_OP_TIMEOUT" default:"500ms"`
+
+	// UserCacheTTL enables an in-process cache of the /auth/me profile. Rails
+	// evicts entries by publishing user.invalidate:<id>; the TTL bounds
+	// staleness if an invalidation is missed. 0 disables the cache.
+	UserCacheTTL time.Duration `envconfig:"USER_CACHE_TTL" default:"0"`
+	// UserCacheMaxEntries caps how many profiles the cache holds; the least
+	// recently used is evicted to make room.
+	UserCacheMaxEntries int `envconfig:"USER_CACHE_MAX_ENTRIES" default:"10000"`
 
 	// JWT configuration
 	JWT JWTConfig
@@ -26,6 +114,48 @@ type Config struct {
 	Google GoogleConfig
 	Clever CleverConfig
 	ICloud ICloudConfig
+	OIDC   OIDCConfig
+
+	// MaxLinkedProviders caps how many distinct SSO providers a single
+	// account may have linked, keyed by meta type (e.g. "Teacher:2"). Linking
+	// that would exceed the cap is rejected. Meta types not listed are uncapped.
+	MaxLinkedProviders map[string]int `envconfig:"OAUTH_MAX_LINKED_PROVIDERS" default:"Teacher:2,Student:3,Parent:1"`
+
+	// OAuthNoAccountRevealEmail includes the provider's verified email in
+	// NO_LINKED_ACCOUNT responses so the client can prefill signup. Turn off
+	// to avoid disclosing it; the code is returned either way.
+	OAuthNoAccountRevealEmail bool `envconfig:"OAUTH_NO_ACCOUNT_REVEAL_EMAIL" default:"true"`
+
+	// OAuthRedirectAllowlist is the comma-separated list of origins or
+	// origin-plus-path prefixes a redirect flow's redirect_url may point at;
+	// anything else is rejected with 400 REDIRECT_URL_NOT_ALLOWED. Paths on
+	// this host are always allowed. Empty falls back to CORS_ALLOWED_ORIGINS,
+	// minus any "*".
+	OAuthRedirectAllowlist string `envconfig:"OAUTH_REDIRECT_ALLOWLIST"`
+
+	// OAuthHTTPTimeout bounds each call to Google, Clever, Apple and the
+	// OIDC providers, so a hung provider can't hold a sign-in open.
+	OAuthHTTPTimeout time.Duration `envconfig:"OAUTH_HTTP_TIMEOUT" default:"10s"`
+
+	// OAuthUserInfoMaxRetries is how many times a Google or Clever userinfo
+	// call is retried after a 5xx or network error; 4xx answers are never
+	// retried. Retry n waits about OAuthUserInfoRetryBaseDelay·2ⁿ, jittered,
+	// and never past the request's deadline. 0 disables retries.
+	OAuthUserInfoMaxRetries     int           `envconfig:"OAUTH_USERINFO_MAX_RETRIES" default:"2"`
+	OAuthUserInfoRetryBaseDelay time.Duration `envconfig:"OAUTH_USERINFO_RETRY_BASE_DELAY" default:"200ms"`
+
+	// AuthCookieDomain is the Domain of the access and refresh token
+	// cookies that ?response=cookie OAuth flows set, e.g. ".boddle.com" to
+	// share them with the apps. Empty scopes them to this host.
+	AuthCookieDomain string `envconfig:"AUTH_COOKIE_DOMAIN"`
+
+	// RegistrationEnabled serves POST /auth/register, self-service signup
+	// of teacher, student and parent accounts. Off while Rails still owns
+	// signup.
+	RegistrationEnabled bool `envconfig:"REGISTRATION_ENABLED" default:"false"`
+
+	// OAuthState configures the Google/Clever redirect-flow state parameter.
+	OAuthState OAuthStateConfig
 
 	// CORS configuration
 	CORS CORSConfig
@@ -43,11 +173,16 @@ type DatabaseConfig struct {
 	ReaderHost         string `envconfig:"DB_READER_HOST"`                    // optional; falls back to DB_HOST when unset
 	Port               int    `envconfig:"DB_PORT" default:"5432"`
 	User               string `envconfig:"DB_USER" required:"true"`
-	Password           string `envconfig:"DB_PASSWORD" required:"true"`
+	Password           string `envconfig:"DB_PASSWORD" required:"true" secret:"true"`
 	Name               string `envconfig:"DB_NAME" required:"true"`
 	SSLMode            string `envconfig:"DB_SSL_MODE" default:"require"`
 	MaxOpenConns       int    `envconfig:"DB_MAX_OPEN_CONNS" default:"25"`        // floor(r7g.8xlarge_max_connections * 0.8 / max_tasks); override per env in SSM
 	ReaderMaxOpenConns int    `envconfig:"DB_READER_MAX_OPEN_CONNS" default:"11"` // floor(serverless_v2_min_acus_max_connections * 0.8 / max_tasks); override per env in SSM
+
+	// QueryTimeout bounds each query whose context has no deadline of its
+	// own, so a slow query can't hold a connection indefinitely. It fails
+	// with an error wrapping context.DeadlineExceeded. 0 disables the bound.
+	QueryTimeout time.Duration `envconfig:"DB_QUERY_TIMEOUT" default:"5s"`
 }
 
 // ConnectionString returns the writer PostgreSQL connection string.
@@ -78,18 +213,87 @@ func (d DatabaseConfig) HasReader() bool {
 
 // JWTConfig holds JWT token configuration
 type JWTConfig struct {
-	SecretKey        string        `envconfig:"JWT_SECRET_KEY" required:"true"`
-	RefreshSecretKey string        `envconfig:"JWT_REFRESH_SECRET_KEY" required:"true"`
+	SecretKey        string        `envconfig:"JWT_SECRET_KEY" required:"true" secret:"true"`
+	RefreshSecretKey string        `envconfig:"JWT_REFRESH_SECRET_KEY" required:"true" secret:"true"`
 	AccessTokenTTL   time.Duration `envconfig:"JWT_ACCESS_TOKEN_TTL" default:"6h"`
 	RefreshTokenTTL  time.Duration `envconfig:"JWT_REFRESH_TOKEN_TTL" default:"720h"`
+	// MaxAccessTTL and MaxRefreshTTL are hard ceilings on the TTLs above:
+	// a larger TTL is capped (with a warning at startup) rather than
+	// honoured. 0 removes a ceiling.
+	MaxAccessTTL  time.Duration `envconfig:"JWT_MAX_ACCESS_TTL" default:"24h"`
+	MaxRefreshTTL time.Duration `envconfig:"JWT_MAX_REFRESH_TTL" default:"2160h"`
+	// PreviousRefreshSecretKeys are rotated-out refresh secrets that still
+	// verify (but no longer sign) refresh tokens. Remove one once
+	// RefreshTokenTTL has passed since it was replaced.
+	PreviousRefreshSecretKeys []string `envconfig:"JWT_REFRESH_SECRET_KEYS_PREVIOUS" secret:"true"`
+	// RefreshReuseDetection tracks each sign-in's current refresh token in
+	// Redis; presenting one that was already rotated (a leak, or two
+	// refreshes racing) revokes the whole chain and forces a new sign-in.
+	RefreshReuseDetection bool `envconfig:"JWT_REFRESH_REUSE_DETECTION" default:"false"`
+	// RefreshTokenIndex records every refresh token issued under
+	// refresh:user:<id>, so users can list theirs (GET /auth/refresh-tokens)
+	// and revoke one by jti. Off by default: a Redis write per issuance.
+	RefreshTokenIndex bool `envconfig:"JWT_REFRESH_TOKEN_INDEX" default:"false"`
+	// IssueSkew backdates iat/nbf on minted tokens so clients with clocks
+	// slightly behind ours don't reject them as not yet valid.
+	IssueSkew time.Duration `envconfig:"JWT_ISSUE_SKEW" default:"5s"`
+	// Leeway is the clock skew tolerated on exp, nbf and iat when we
+	// validate a token.
+	Leeway time.Duration `envconfig:"JWT_LEEWAY" default:"30s"`
+	// ExpiredGrace lets GET/HEAD requests through on an access token that
+	// expired at most this long ago, flagged with X-Token-Expired so the
+	// client refreshes. Mutating requests never get grace. 0 disables it.
+	ExpiredGrace time.Duration `envconfig:"JWT_EXPIRED_GRACE" default:"0"`
+	// OmitStudentEmail drops synthetic username@student.student emails from
+	// student access tokens that carry a username claim (students.username),
+	// which identifies them instead.
+	OmitStudentEmail bool `envconfig:"JWT_OMIT_STUDENT_EMAIL" default:"false"`
+	// MinimalClaims issues identifier-only access tokens (no name, email,
+	// boddle_uid, ...); clients read the profile from /auth/me instead.
+	MinimalClaims bool `envconfig:"JWT_MINIMAL_CLAIMS" default:"false"`
+	// SigningKeyFile is a PEM RSA or ECDSA P-256 private key. When set,
+	// access tokens are signed RS256/ES256 with it instead of SecretKey and
+	// its public key is served at /.well-known/jwks.json.
+	SigningKeyFile string `envconfig:"JWT_SIGNING_KEY_FILE"`
+	// PreviousSigningPublicKeyFiles are PEM public keys of rotated-out
+	// signing keys. Access tokens they signed still verify, and they stay in
+	// the JWKS. Remove one once AccessTokenTTL has passed since it was
+	// replaced.
+	PreviousSigningPublicKeyFiles []string `envconfig:"JWT_SIGNING_PUBLIC_KEY_FILES_PREVIOUS"`
+	// SigningAlgorithm pins the access-token alg: HS256, RS256 or ES256. It
+	// must agree with SigningKeyFile (HS256 means no key file); empty takes
+	// whatever the key implies.
+	SigningAlgorithm string `envconfig:"JWT_SIGNING_ALGORITHM"`
+	// SubjectFormat is what access tokens carry in sub: "user_id" (the
+	// numeric users.id) or "boddle_uid". The user_id claim is set either way.
+	SubjectFormat string `envconfig:"JWT_SUBJECT_FORMAT" default:"user_id"`
+	// Apps lists the app identifiers any sign-in may pass as app (or
+	// X-Boddle-App); the tokens it gets carry that app as aud, and a request naming the
+	// app (X-Boddle-App, or introspection's app) accepts no others. Empty
+	// rejects every app.
+	Apps []string `envconfig:"JWT_APPS"`
+
+	// BlacklistBloom enables an in-process Bloom filter of revoked JTIs so
+	// the common "not revoked" check skips Redis. Revocations from other
+	// instances arrive over pub/sub; while that subscription is down every
+	// check goes to Redis, until it is back and the filter is rebuilt.
+	BlacklistBloom         bool          `envconfig:"BLACKLIST_BLOOM_ENABLED" default:"false"`
+	BlacklistBloomRefresh  time.Duration `envconfig:"BLACKLIST_BLOOM_REFRESH" default:"1m"`
+	BlacklistBloomCapacity int           `envconfig:"BLACKLIST_BLOOM_CAPACITY" default:"100000"`
 }
 
 // GoogleConfig holds Google OAuth2 configuration
 type GoogleConfig struct {
 	ClientID     string `envconfig:"GOOGLE_CLIENT_ID" required:"true"`
-	ClientSecret string `envconfig:"GOOGLE_CLIENT_SECRET" required:"true"`
+	ClientSecret string `envconfig:"GOOGLE_CLIENT_SECRET" required:"true" secret:"true"`
 	RedirectURL  string `envconfig:"GOOGLE_REDIRECT_URL" required:"true"`
 
+	// ExtraRedirectURLs is a comma-separated list of further OAuth callback
+	// URLs (e.g. a mobile app's) that GET /auth/google?redirect_uri=... may
+	// select. Each must also be registered with Google. RedirectURL stays the
+	// default.
+	ExtraRedirectURLs string `envconfig:"GOOGLE_EXTRA_REDIRECT_URLS"`
+
 	// TokenAudiences is the comma-separated allowlist of Google OAuth client
 	// IDs that may present access tokens to POST /auth/google (i.e. the LMS's
 	// own OmniAuth client ID(s), which differ from ClientID above). When set,
@@ -97,13 +301,41 @@ type GoogleConfig struct {
 	// endpoint, preventing a confused-deputy replay of a token minted for an
 	// unrelated OAuth app. Empty disables the check. See LMS-6511 follow-up.
 	TokenAudiences string `envconfig:"GOOGLE_TOKEN_AUDIENCES"`
+
+	// AllowedDomains is a comma-separated list of Google Workspace domains
+	// (e.g. "district.k12.us") whose accounts may sign in with Google. Other
+	// accounts, including personal gmail, are rejected with
+	// DOMAIN_NOT_ALLOWED. Empty accepts any Google account.
+	AllowedDomains string `envconfig:"GOOGLE_ALLOWED_DOMAINS"`
+
+	// Endpoint overrides for mock servers and sandboxes. Empty uses Google's
+	// production endpoints.
+	AuthURL      string `envconfig:"GOOGLE_AUTH_URL"`
+	TokenURL     string `envconfig:"GOOGLE_TOKEN_URL"`
+	UserInfoURL  string `envconfig:"GOOGLE_USERINFO_URL"`
+	TokenInfoURL string `envconfig:"GOOGLE_TOKENINFO_URL"`
 }
 
 // CleverConfig holds Clever SSO configuration
 type CleverConfig struct {
 	ClientID     string `envconfig:"CLEVER_CLIENT_ID" required:"true"`
-	ClientSecret string `envconfig:"CLEVER_CLIENT_SECRET" required:"true"`
+	ClientSecret string `envconfig:"CLEVER_CLIENT_SECRET" required:"true" secret:"true"`
 	RedirectURL  string `envconfig:"CLEVER_REDIRECT_URL" required:"true"`
+
+	// ExtraRedirectURLs is a comma-separated list of further OAuth callback
+	// URLs that GET /auth/clever?redirect_uri=... may select, as for Google.
+	ExtraRedirectURLs string `envconfig:"CLEVER_EXTRA_REDIRECT_URLS"`
+
+	// AdminsAsAdmin lets Clever district and school admins sign in as an
+	// existing Admin user with the same email. Off by default: they are
+	// rejected with UNSUPPORTED_ROLE.
+	AdminsAsAdmin bool `envconfig:"CLEVER_ADMINS_AS_ADMIN" default:"false"`
+
+	// Endpoint overrides for mock servers and sandboxes. Empty uses Clever's
+	// production endpoints.
+	AuthURL     string `envconfig:"CLEVER_AUTH_URL"`
+	TokenURL    string `envconfig:"CLEVER_TOKEN_URL"`
+	UserInfoURL string `envconfig:"CLEVER_USERINFO_URL"`
 }
 
 // ICloudConfig holds Apple "Sign in with Apple" (iCloud) configuration.
@@ -113,6 +345,98 @@ type ICloudConfig struct {
 	// Empty leaves POST /auth/icloud failing closed: it cannot verify a token's
 	// audience, so it rejects every request. Set this in production.
 	ClientIDs string `envconfig:"APPLE_CLIENT_IDS"`
+
+	// JWKSURL overrides where Apple's signing keys are fetched from, for a
+	// mock server. Empty uses https://appleid.apple.com/auth/keys.
+	JWKSURL string `envconfig:"APPLE_JWKS_URL"`
+
+	// TeamID, KeyID and PrivateKey are the Sign in with Apple key used to
+	// sign client secrets for Apple's token endpoint: the developer team ID,
+	// the key's ID, and its .p8 contents (PEM; literal "\n" sequences are
+	// read as newlines). GET /admin/oauth/selftest?provider=icloud checks
+	// them.
+	TeamID     string `envconfig:"APPLE_TEAM_ID"`
+	KeyID      string `envconfig:"APPLE_KEY_ID"`
+	PrivateKey string `envconfig:"APPLE_PRIVATE_KEY" secret:"true"`
+}
+
+// OIDCConfig holds the generic OpenID Connect providers, for SSO partners
+// that need no provider-specific code.
+type OIDCConfig struct {
+	// Providers names the providers to enable, e.g. "acme,northwind". Each
+	// is configured by OIDC_<NAME>_* variables (see OIDCProviderConfig) and
+	// served at /auth/oidc/<name>. Names are lowercase letters, digits and
+	// underscores.
+	Providers []string `envconfig:"OIDC_PROVIDERS"`
+	// DiscoveryTTL is how long a provider's discovery document and signing
+	// keys are cached before being fetched again.
+	DiscoveryTTL time.Duration `envconfig:"OIDC_DISCOVERY_TTL" default:"1h"`
+
+	// Named holds each provider's settings keyed by name, filled in by Load.
+	Named map[string]OIDCProviderConfig `ignored:"true"`
+}
+
+// OIDCProviderConfig is one generic OIDC provider. Its variables are
+// prefixed with OIDC_<NAME>_, e.g. OIDC_ACME_ISSUER.
+type OIDCProviderConfig struct {
+	// Issuer is the provider's issuer URL; its discovery document is read
+	// from <Issuer>/.well-known/openid-configuration.
+	Issuer       string `envconfig:"ISSUER" required:"true"`
+	ClientID     string `envconfig:"CLIENT_ID" required:"true"`
+	ClientSecret string `envconfig:"CLIENT_SECRET" required:"true" secret:"true"`
+	RedirectURL  string `envconfig:"REDIRECT_URL" required:"true"`
+	// Scopes requested; openid is always added.
+	Scopes []string `envconfig:"SCOPES" default:"email,profile"`
+}
+
+// oidcProviderName is what an OIDC_PROVIDERS entry may be: it appears in
+// env var names and URL paths.
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// loadOIDCProviders reads OIDC_<NAME>_* for each provider in
+// c.OIDC.Providers.
+func (c *Config) loadOIDCProviders() error {
+	c.OIDC.Named = make(map[string]OIDCProviderConfig, len(c.OIDC.Providers))
+	for _, name := range c.OIDC.Providers {
+		if !oidcProviderName.MatchString(name) {
+			return fmt.Errorf("invalid OIDC provider name %q: use lowercase letters, digits and underscores", name)
+		}
+		if _, dup := c.OIDC.Named[name]; dup {
+			return fmt.Errorf("OIDC provider %q listed twice", name)
+		}
+		var p OIDCProviderConfig
+		if err := envconfig.Process("OIDC_"+strings.ToUpper(name), &p); err != nil {
+			return fmt.Errorf("failed to load OIDC provider %q: %w", name, err)
+		}
+		c.OIDC.Named[name] = p
+	}
+	return nil
+}
+
+// OAuthStateConfig holds OAuth state (CSRF) configuration
+type OAuthStateConfig struct {
+	// Mode is "redis" (state stored in Redis, single-use) or "signed" (state
+	// carried in the parameter itself, HMAC-signed with Secret; nothing is
+	// stored unless SingleUse is set).
+	Mode string `envconfig:"OAUTH_STATE_MODE" default:"redis"`
+	// MaxAge is how long a user may spend at Google or Clever before the
+	// callback is rejected with OAUTH_SESSION_EXPIRED.
+	MaxAge time.Duration `envconfig:"OAUTH_STATE_MAX_AGE" default:"10m"`
+	// Secret signs states in signed mode; at least 32 bytes.
+	Secret string `envconfig:"OAUTH_STATE_SECRET" secret:"true"`
+	// SingleUse remembers used nonces in Redis so a signed state can't be
+	// replayed within MaxAge. Ignored in redis mode, which is always
+	// single-use.
+	SingleUse bool `envconfig:"OAUTH_STATE_SINGLE_USE" default:"false"`
+	// MaxPerIP caps how many states one client IP may be issued within
+	// MaxAge, so a script hammering /auth/google can't fill Redis with
+	// abandoned states. 0 disables the cap. Redis mode only.
+	MaxPerIP int `envconfig:"OAUTH_STATE_MAX_PER_IP" default:"0"`
+	// ClientBinding ties each state to the client that started the flow, so
+	// a state stolen mid-flow can't be completed from elsewhere: "user_agent"
+	// (the default; survives mobile clients changing IP), "ip" (client IP
+	// and User-Agent) or "off".
+	ClientBinding string `envconfig:"OAUTH_STATE_CLIENT_BINDING" default:"user_agent"`
 }
 
 // CORSConfig holds CORS configuration
@@ -125,6 +449,42 @@ type RateLimitConfig struct {
 	Window          time.Duration `envconfig:"RATE_LIMIT_WINDOW" default:"10m"`
 	MaxAttempts     int           `envconfig:"RATE_LIMIT_MAX_ATTEMPTS" default:"5"`
 	LockoutDuration time.Duration `envconfig:"RATE_LIMIT_LOCKOUT_DURATION" default:"15m"`
+	// LockoutMultiplier lengthens each repeat lockout of an email/IP pair:
+	// the nth lasts LockoutDuration × LockoutMultiplier^(n-1), capped at
+	// MaxLockoutDuration. The pair's lockouts are forgotten after
+	// LockoutResetAfter without a failure. A multiplier of 1 keeps every
+	// lockout at LockoutDuration.
+	LockoutMultiplier  float64       `envconfig:"RATE_LIMIT_LOCKOUT_MULTIPLIER" default:"4"`
+	MaxLockoutDuration time.Duration `envconfig:"RATE_LIMIT_MAX_LOCKOUT_DURATION" default:"24h"`
+	LockoutResetAfter  time.Duration `envconfig:"RATE_LIMIT_LOCKOUT_RESET_AFTER" default:"24h"`
+	// Algorithm is how failures are counted within Window: "sliding" counts
+	// those in the last Window; "fixed" counts from the first failure until
+	// the window expires, which allows a burst of nearly 2×MaxAttempts
+	// across the reset.
+	Algorithm string `envconfig:"RATE_LIMIT_ALGORITHM" default:"sliding"`
+	// CaptchaThreshold is the soft limit: after this many failed logins
+	// (counted since the last solved challenge) the client must solve a
+	// CAPTCHA before trying again. Keep it below MaxAttempts; 0 disables.
+	CaptchaThreshold int `envconfig:"RATE_LIMIT_CAPTCHA_THRESHOLD" default:"0"`
+	// MaxConcurrentLogins caps password and login-token requests in flight
+	// at once from one IP, across all instances; more get a 429. 0 disables
+	// the cap.
+	MaxConcurrentLogins int `envconfig:"RATE_LIMIT_MAX_CONCURRENT_LOGINS" default:"0"`
+	// OAuthMaxFailuresPerIP locks an IP out of the OAuth sign-ins for
+	// LockoutDuration after this many failed ones within Window. Kept well
+	// above MaxAttempts: a whole school may sign in from one IP. 0 disables.
+	OAuthMaxFailuresPerIP int `envconfig:"RATE_LIMIT_OAUTH_MAX_FAILURES_PER_IP" default:"50"`
+	// RequestsPerIP caps requests of any kind from one IP per RequestWindow,
+	// across all instances; more get a 429 with Retry-After. IPs and CIDR
+	// ranges in RequestAllowlist (e.g. health checkers) are exempt. 0
+	// disables the cap.
+	RequestsPerIP    int           `envconfig:"RATE_LIMIT_REQUESTS_PER_IP" default:"0"`
+	RequestWindow    time.Duration `envconfig:"RATE_LIMIT_REQUEST_WINDOW" default:"1m"`
+	RequestAllowlist []string      `envconfig:"RATE_LIMIT_REQUEST_ALLOWLIST"`
+	// RecordAttemptReasons stores why each failed password login failed in
+	// login_attempts.reason. Needs migration 006; the failure metric is
+	// labelled by reason either way.
+	RecordAttemptReasons bool `envconfig:"LOGIN_ATTEMPT_REASONS" default:"false"`
 }
 
 // NewRelicConfig holds New Relic APM configuration. Empty LicenseKey leaves
@@ -132,7 +492,7 @@ type RateLimitConfig struct {
 // become no-ops. Wired in response to PIR 2026-05-19, where the absence of
 // APM let a per-request DB write failure go unobserved for ~31 hours.
 type NewRelicConfig struct {
-	LicenseKey string `envconfig:"NEW_RELIC_LICENSE_KEY"`
+	LicenseKey string `envconfig:"NEW_RELIC_LICENSE_KEY" secret:"true"`
 	AppName    string `envconfig:"NEW_RELIC_APP_NAME" default:"reservoir"`
 }
 
@@ -148,6 +508,9 @@ func Load() (*Config, error) {
 	if err := envconfig.Process("", &cfg); err != nil {
 		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
+	if err := cfg.loadOIDCProviders(); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
 	return &cfg, nil
 }
 
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadOIDCProviders(t *testing.T) {
+	t.Setenv("OIDC_ACME_ISSUER", "https://login.acme.example")
+	t.Setenv("OIDC_ACME_CLIENT_ID", "acme-client")
+	t.Setenv("OIDC_ACME_CLIENT_SECRET", "acme-secret")
+	t.Setenv("OIDC_ACME_REDIRECT_URL", "https://auth.boddle.example/auth/oidc/acme/callback")
+
+	cfg := Config{OIDC: OIDCConfig{Providers: []string{"acme"}}}
+	if err := cfg.loadOIDCProviders(); err != nil {
+		t.Fatalf("loadOIDCProviders: %v", err)
+	}
+	want := OIDCProviderConfig{
+		Issuer:       "https://login.acme.example",
+		ClientID:     "acme-client",
+		ClientSecret: "acme-secret",
+		RedirectURL:  "https://auth.boddle.example/auth/oidc/acme/callback",
+		Scopes:       []string{"email", "profile"},
+	}
+	if got := cfg.OIDC.Named["acme"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("acme = %+v, want %+v", got, want)
+	}
+
+	for _, providers := range [][]string{
+		{"northwind"},    // no OIDC_NORTHWIND_* set
+		{"Acme"},         // names are lowercase
+		{"acme", "acme"}, // listed twice
+	} {
+		cfg := Config{OIDC: OIDCConfig{Providers: providers}}
+		if err := cfg.loadOIDCProviders(); err == nil {
+			t.Errorf("OIDC_PROVIDERS=%v loaded without error", providers)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces any secret that is set. Unset secrets are shown as
+// "" so a missing key is still visible in the dump.
+const redactedValue = "***"
+
+// secretNamePattern is a backstop for fields someone forgets to tag with
+// secret:"true": anything whose env var name looks like a credential is
+// redacted regardless of the tag.
+var secretNamePattern = regexp.MustCompile(`SECRET|PASSWORD|KEY|CREDENTIAL`)
+
+// Redacted returns the effective configuration keyed by env var name, for
+// the startup log. A set value is replaced by "***" when its field is tagged
+// secret:"true" or its env var name looks like a credential (the
+// secretNamePattern backstop); a URL keeps its scheme and host so a wrong
+// endpoint is still diagnosable. Each generic OIDC provider is listed under
+// its OIDC_<NAME>_* names with the same rules. Any other field without an
+// envconfig tag is left out of the dump.
+func (c *Config) Redacted() map[string]string {
+	out := make(map[string]string)
+	collectRedacted(reflect.ValueOf(*c), "", out)
+	for name, p := range c.OIDC.Named {
+		collectRedacted(reflect.ValueOf(p), "OIDC_"+strings.ToUpper(name)+"_", out)
+	}
+	return out
+}
+
+func collectRedacted(v reflect.Value, prefix string, out map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		name, ok := field.Tag.Lookup("envconfig")
+		if !ok {
+			if value.Kind() == reflect.Struct {
+				collectRedacted(value, prefix, out)
+			}
+			continue
+		}
+		name = prefix + name
+
+		str := fmt.Sprint(value.Interface())
+		if value.Kind() == reflect.Slice && value.Len() == 0 {
+			str = ""
+		}
+		switch {
+		case str == "":
+			out[name] = ""
+		case field.Tag.Get("secret") == "true", secretNamePattern.MatchString(name):
+			if u, err := url.Parse(str); err == nil && u.Scheme != "" && u.Host != "" {
+				// Keep the host so a wrong endpoint is still diagnosable.
+				out[name] = u.Scheme + "://" + redactedValue + "@" + u.Host
+			} else {
+				out[name] = redactedValue
+			}
+		default:
+			out[name] = str
+		}
+	}
+}
+
+// EnabledProviders lists the sign-in providers this instance will accept.
+// Google and Clever are always configured (their settings are required);
+// iCloud is only enabled once APPLE_CLIENT_IDS is set, and each generic OIDC
+// provider is listed as "oidc:<name>".
+func (c *Config) EnabledProviders() []string {
+	providers := []string{"password", "google", "clever"}
+	if c.ICloud.ClientIDs != "" {
+		providers = append(providers, "icloud")
+	}
+	for _, name := range c.OIDC.Providers {
+		providers = append(providers, "oidc:"+name)
+	}
+	return providers
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fillStrings sets every string field in v (recursively) to a unique,
+// recognisable value and returns them keyed by env var name.
+func fillStrings(v reflect.Value, set map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			fillStrings(value, set)
+			continue
+		}
+		name := field.Tag.Get("envconfig")
+		if value.Kind() == reflect.String && name != "" {
+			s := "value-of-" + strings.ToLower(name)
+			value.SetString(s)
+			set[name] = s
+		}
+	}
+}
+
+func TestRedacted_HidesSecrets(t *testing.T) {
+	var cfg Config
+	set := make(map[string]string)
+	fillStrings(reflect.ValueOf(&cfg).Elem(), set)
+	cfg.RedisURL = "redis://:hunter2@cache.internal:6379/0"
+
+	dump := cfg.Redacted()
+
+	for _, name := range []string{
+		"DB_PASSWORD",
+		"JWT_SECRET_KEY",
+		"JWT_REFRESH_SECRET_KEY",
+		"GOOGLE_CLIENT_SECRET",
+		"CLEVER_CLIENT_SECRET",
+		"NEW_RELIC_LICENSE_KEY",
+	} {
+		if got := dump[name]; got != redactedValue {
+			t.Errorf("%s = %q, want %q", name, got, redactedValue)
+		}
+	}
+
+	if got, want := dump["REDIS_URL"], "redis://***@cache.internal:6379"; got != want {
+		t.Errorf("REDIS_URL = %q, want %q", got, want)
+	}
+
+	// No secret value may appear anywhere in the dump, under any key.
+	secrets := []string{"hunter2", set["DB_PASSWORD"], set["JWT_SECRET_KEY"], set["JWT_REFRESH_SECRET_KEY"],
+		set["GOOGLE_CLIENT_SECRET"], set["CLEVER_CLIENT_SECRET"], set["NEW_RELIC_LICENSE_KEY"]}
+	for name, value := range dump {
+		for _, secret := range secrets {
+			if strings.Contains(value, secret) {
+				t.Errorf("%s leaks secret %q", name, secret)
+			}
+		}
+	}
+
+	// Non-secret fields are passed through so the dump is still useful.
+	if got := dump["DB_HOST"]; got != set["DB_HOST"] {
+		t.Errorf("DB_HOST = %q, want %q", got, set["DB_HOST"])
+	}
+}
+
+func TestRedacted_IncludesOIDCProviders(t *testing.T) {
+	cfg := Config{}
+	cfg.OIDC.Named = map[string]OIDCProviderConfig{
+		"acme": {Issuer: "https://login.acme.example", ClientID: "acme-client", ClientSecret: "acme-secret"},
+	}
+
+	dump := cfg.Redacted()
+
+	if got := dump["OIDC_ACME_CLIENT_SECRET"]; got != redactedValue {
+		t.Errorf("OIDC_ACME_CLIENT_SECRET = %q, want %q", got, redactedValue)
+	}
+	if got, want := dump["OIDC_ACME_CLIENT_ID"], "acme-client"; got != want {
+		t.Errorf("OIDC_ACME_CLIENT_ID = %q, want %q", got, want)
+	}
+	for name, value := range dump {
+		if strings.Contains(value, "acme-secret") {
+			t.Errorf("%s leaks the OIDC client secret", name)
+		}
+	}
+}
+
+func TestRedacted_UnsetSecretIsEmpty(t *testing.T) {
+	cfg := Config{}
+	if got := cfg.Redacted()["NEW_RELIC_LICENSE_KEY"]; got != "" {
+		t.Errorf("NEW_RELIC_LICENSE_KEY = %q, want empty", got)
+	}
+	if got := cfg.Redacted()["JWT_REFRESH_SECRET_KEYS_PREVIOUS"]; got != "" {
+		t.Errorf("JWT_REFRESH_SECRET_KEYS_PREVIOUS = %q, want empty", got)
+	}
+}
+
+func TestEnabledProviders(t *testing.T) {
+	cfg := Config{}
+	if got, want := cfg.EnabledProviders(), []string{"password", "google", "clever"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EnabledProviders() = %v, want %v", got, want)
+	}
+
+	cfg.ICloud.ClientIDs = "com.boddle.app"
+	if got, want := cfg.EnabledProviders(), []string{"password", "google", "clever", "icloud"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EnabledProviders() = %v, want %v", got, want)
+	}
+
+	cfg.OIDC.Providers = []string{"acme"}
+	if got, want := cfg.EnabledProviders(), []string{"password", "google", "clever", "icloud", "oidc:acme"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EnabledProviders() = %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,12 +14,18 @@ type RedisClient struct {
 	*redis.Client
 }
 
-// NewRedisClient creates a new Redis client
-func NewRedisClient(redisURL string) (*RedisClient, error) {
+// NewRedisClient creates a new Redis client. opTimeout bounds every command
+// (and the dial it may trigger) so a slow Redis can't hold a request for the
+// full server write timeout; 0 leaves commands bounded only by the caller's
+// context. The 5s connection check below is separate and unaffected.
+func NewRedisClient(redisURL string, opTimeout time.Duration) (*RedisClient, error) {
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
+	// Without this go-redis ignores context deadlines on socket reads and
+	// writes, falling back to its own 3s Read/WriteTimeout.
+	opt.ContextTimeoutEnabled = true
 
 	client := redis.NewClient(opt)
 
@@ -30,6 +37,10 @@ func NewRedisClient(redisURL string) (*RedisClient, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	if opTimeout > 0 {
+		client.AddHook(OpTimeoutHook(opTimeout))
+	}
+
 	return &RedisClient{Client: client}, nil
 }
 
@@ -42,3 +53,41 @@ func (r *RedisClient) Close() error {
 func (r *RedisClient) Health(ctx context.Context) error {
 	return r.Ping(ctx).Err()
 }
+
+// OpTimeoutHook returns a go-redis hook that runs each command and pipeline
+// under a context with the given timeout. A caller deadline that is already
+// sooner still wins. The client must have ContextTimeoutEnabled set for the
+// deadline to reach the socket.
+//
+// A timed-out command fails like any other Redis error (an i/o timeout or
+// context.DeadlineExceeded), so callers keep their existing policy: the rate limiter fails open,
+// the token blacklist fails closed.
+func OpTimeoutHook(timeout time.Duration) redis.Hook {
+	return opTimeoutHook{timeout: timeout}
+}
+
+type opTimeoutHook struct {
+	timeout time.Duration
+}
+
+func (h opTimeoutHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h opTimeoutHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, cancel := context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+		return next(ctx, cmd)
+	}
+}
+
+func (h opTimeoutHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, cancel := context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+		return next(ctx, cmds)
+	}
+}
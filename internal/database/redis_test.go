@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/redis/go-redis/v9"
+)
+
+// slowRedis accepts connections and reads whatever is sent but never
+// replies, standing in for a Redis that has stalled.
+func slowRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestOpTimeoutHook_SlowRedis(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:                  slowRedis(t),
+		ContextTimeoutEnabled: true,
+		// Well above the op timeout so only the hook can end the call early.
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		MaxRetries:   -1,
+	})
+	defer client.Close()
+	client.AddHook(OpTimeoutHook(100 * time.Millisecond))
+
+	blacklist := token.NewBlacklist(client)
+
+	start := time.Now()
+	_, err := blacklist.IsBlacklisted(context.Background(), "jti-1")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("expected an error from a stalled Redis")
+	}
+	// go-redis surfaces the deadline either as the context error or as the
+	// socket's i/o timeout, depending on where the call was when it fired.
+	var netErr net.Error
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("call took %v, want it cut off near the 100ms op timeout", elapsed)
+	}
+}
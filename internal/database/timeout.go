@@ -0,0 +1,29 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WithQueryTimeout bounds one query by timeout so a slow one can't hold its
+// connection indefinitely. A ctx that already has a deadline is returned as
+// is: the caller's own bound stands, sooner or later. 0 disables the bound.
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// QueryError reports a query that ctx cut short as ctx's error, wrapping
+// what the driver said: lib/pq answers a cancelled query with its own
+// query_canceled error, which errors.Is(err, context.DeadlineExceeded)
+// wouldn't otherwise match. Other errors are returned as is.
+func QueryError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx, cancel := WithQueryTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected a deadline on a context without one")
+	}
+
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	ctx, cancel = WithQueryTimeout(parent, time.Minute)
+	defer cancel()
+	if ctx != parent {
+		t.Error("a context with its own deadline should be returned as is")
+	}
+
+	ctx, cancel = WithQueryTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("a 0 timeout should add no deadline")
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	driverErr := errors.New("pq: canceling statement due to user request")
+	err := QueryError(ctx, driverErr)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("QueryError = %v, want it to match context.DeadlineExceeded", err)
+	}
+	if got := QueryError(context.Background(), driverErr); got != driverErr {
+		t.Errorf("QueryError with a live context = %v, want the error unchanged", got)
+	}
+	if QueryError(ctx, nil) != nil {
+		t.Error("QueryError(nil) should be nil")
+	}
+}
@@ -0,0 +1,118 @@
+package debug
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// BlacklistChecker is the slice of token.Blacklist the debug handler needs.
+type BlacklistChecker interface {
+	IsBlacklisted(ctx context.Context, tokenID string) (bool, error)
+}
+
+// Handler serves development-only debugging endpoints. Nothing here is safe
+// to expose in production; see RegisterRoutes.
+type Handler struct {
+	tokenService *token.Service
+	blacklist    BlacklistChecker
+}
+
+// NewHandler creates a new debug handler
+func NewHandler(tokenService *token.Service, blacklist BlacklistChecker) *Handler {
+	return &Handler{tokenService: tokenService, blacklist: blacklist}
+}
+
+// RegisterRoutes mounts the debug routes when running with ENV=development.
+// In any other environment the routes are not registered at all, so they
+// 404 rather than merely rejecting requests.
+func RegisterRoutes(r gin.IRoutes, cfg *config.Config, h *Handler) {
+	if !cfg.IsDevelopment() {
+		return
+	}
+	r.POST("/debug/token", h.DecodeToken)
+}
+
+// DecodeTokenRequest is the body of POST /debug/token
+type DecodeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// DecodeToken decodes a JWT and reports whether this server would accept it.
+// Claims are decoded without verification so a token with a bad signature
+// can still be inspected; "valid" and "error" carry the verification result.
+// POST /debug/token
+func (h *Handler) DecodeToken(c *gin.Context) {
+	var req DecodeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": "token is required",
+			},
+		})
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, _, err := jwt.NewParser().ParseUnverified(req.Token, claims)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error": gin.H{
+				"code":    "INVALID_TOKEN",
+				"message": "token is not a decodable JWT: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	result := gin.H{
+		"header": parsed.Header,
+		"claims": claims,
+	}
+
+	// Try the access key first, then the refresh key, to tell the two apart.
+	tokenType := "unknown"
+	var validationErr error
+	if _, err := h.tokenService.Validate(req.Token); err == nil {
+		tokenType = "access"
+	} else if _, refreshErr := h.tokenService.ValidateRefreshToken(req.Token); refreshErr == nil {
+		tokenType = "refresh"
+	} else {
+		validationErr = err
+	}
+	result["token_type"] = tokenType
+	result["valid"] = validationErr == nil
+	if validationErr != nil {
+		result["error"] = validationErr.Error()
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		remaining := time.Until(exp.Time)
+		result["expires_at"] = exp.Time.UTC().Format(time.RFC3339)
+		result["expired"] = remaining <= 0
+		result["expires_in"] = remaining.Round(time.Second).String()
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result["issued_at"] = iat.Time.UTC().Format(time.RFC3339)
+	}
+
+	if jti, ok := claims["jti"].(string); ok && jti != "" && h.blacklist != nil {
+		blacklisted, err := h.blacklist.IsBlacklisted(c.Request.Context(), jti)
+		if err != nil {
+			result["blacklist_error"] = err.Error()
+		} else {
+			result["blacklisted"] = blacklisted
+		}
+	}
+
+	response.Success(c, http.StatusOK, result)
+}
@@ -0,0 +1,107 @@
+package debug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/gin-gonic/gin"
+)
+
+type fakeBlacklist map[string]bool
+
+func (f fakeBlacklist) IsBlacklisted(_ context.Context, tokenID string) (bool, error) {
+	return f[tokenID], nil
+}
+
+func newTestTokenService() *token.Service {
+	return token.NewService("access-secret", "refresh-secret", time.Hour, 24*time.Hour)
+}
+
+func newRouter(env string, svc *token.Service, blacklist BlacklistChecker) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterRoutes(r, &config.Config{Env: env}, NewHandler(svc, blacklist))
+	return r
+}
+
+func postToken(r *gin.Engine, tok string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(DecodeTokenRequest{Token: tok})
+	req := httptest.NewRequest(http.MethodPost, "/debug/token", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterRoutes_AbsentOutsideDevelopment(t *testing.T) {
+	for _, env := range []string{"production", "staging", ""} {
+		r := newRouter(env, newTestTokenService(), nil)
+		if routes := r.Routes(); len(routes) != 0 {
+			t.Errorf("env %q registered routes %v, want none", env, routes)
+		}
+		if w := postToken(r, "x.y.z"); w.Code != http.StatusNotFound {
+			t.Errorf("env %q: status = %d, want %d", env, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDecodeToken_Development(t *testing.T) {
+	svc := newTestTokenService()
+	pair, err := svc.Generate(42, "uid-42", "t@example.com", "Teacher T", "Teacher", 7, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	claims, _ := svc.Validate(pair.AccessToken)
+
+	r := newRouter("development", svc, fakeBlacklist{claims.ID: true})
+
+	w := postToken(r, pair.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var resp struct {
+		Data struct {
+			Valid       bool                   `json:"valid"`
+			TokenType   string                 `json:"token_type"`
+			Expired     bool                   `json:"expired"`
+			Blacklisted bool                   `json:"blacklisted"`
+			Claims      map[string]interface{} `json:"claims"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	d := resp.Data
+	if !d.Valid || d.TokenType != "access" || d.Expired || !d.Blacklisted {
+		t.Errorf("got valid=%v type=%q expired=%v blacklisted=%v", d.Valid, d.TokenType, d.Expired, d.Blacklisted)
+	}
+	if d.Claims["email"] != "t@example.com" {
+		t.Errorf("claims.email = %v", d.Claims["email"])
+	}
+}
+
+func TestDecodeToken_BadSignatureStillDecodes(t *testing.T) {
+	r := newRouter("development", newTestTokenService(), nil)
+	other := token.NewService("other-secret", "other-refresh", time.Hour, time.Hour)
+	pair, _ := other.Generate(1, "", "x@example.com", "X", "Student", 1, 0)
+
+	w := postToken(r, pair.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Data["valid"] != false || resp.Data["error"] == nil {
+		t.Errorf("want valid=false with an error, got %v", resp.Data)
+	}
+}
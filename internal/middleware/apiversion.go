@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersionHeader is the request header a client uses to pick the version
+// of the response shapes it understands. The negotiated version is echoed
+// back in the same header.
+const APIVersionHeader = "X-API-Version"
+
+// apiVersionKey is the gin context key APIVersion stores the version under.
+const apiVersionKey = "api_version"
+
+// APIVersion negotiates the API version from X-API-Version. supported lists
+// the versions served, oldest first; a request without the header gets the
+// last (latest) one, and an unsupported version is rejected with
+// UNSUPPORTED_API_VERSION. Handlers read the result with APIVersionFrom.
+func APIVersion(supported ...string) gin.HandlerFunc {
+	if len(supported) == 0 {
+		panic("middleware: APIVersion needs at least one supported version")
+	}
+	latest := supported[len(supported)-1]
+	ok := make(map[string]bool, len(supported))
+	for _, v := range supported {
+		ok[v] = true
+	}
+
+	return func(c *gin.Context) {
+		version := c.GetHeader(APIVersionHeader)
+		if version == "" {
+			version = latest
+		}
+		if !ok[version] {
+			response.Error(c, apperrors.NewAppError(
+				apperrors.ErrCodeUnsupportedAPIVersion,
+				"Unsupported API version "+version+"; latest is "+latest,
+				http.StatusBadRequest,
+			))
+			c.Abort()
+			return
+		}
+
+		c.Set(apiVersionKey, version)
+		c.Header(APIVersionHeader, version)
+		c.Next()
+	}
+}
+
+// APIVersionFrom returns the version negotiated by APIVersion, or "" if the
+// middleware didn't run for this request.
+func APIVersionFrom(c *gin.Context) string {
+	return c.GetString(apiVersionKey)
+}
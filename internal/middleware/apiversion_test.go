@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(APIVersion("1", "2"))
+	router.GET("/auth/me", func(c *gin.Context) {
+		c.String(http.StatusOK, APIVersionFrom(c))
+	})
+
+	tests := []struct {
+		name        string
+		header      string
+		wantStatus  int
+		wantVersion string
+	}{
+		{"supported older version", "1", http.StatusOK, "1"},
+		{"supported latest version", "2", http.StatusOK, "2"},
+		{"absent defaults to latest", "", http.StatusOK, "2"},
+		{"unsupported version", "3", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			if tt.header != "" {
+				req.Header.Set(APIVersionHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var resp struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				_ = json.Unmarshal(w.Body.Bytes(), &resp)
+				if resp.Error.Code != "UNSUPPORTED_API_VERSION" {
+					t.Errorf("error code = %q, want UNSUPPORTED_API_VERSION", resp.Error.Code)
+				}
+				return
+			}
+			if w.Body.String() != tt.wantVersion {
+				t.Errorf("handler saw version %q, want %q", w.Body.String(), tt.wantVersion)
+			}
+			if got := w.Header().Get(APIVersionHeader); got != tt.wantVersion {
+				t.Errorf("%s response header = %q, want %q", APIVersionHeader, got, tt.wantVersion)
+			}
+		})
+	}
+}
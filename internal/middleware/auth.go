@@ -5,15 +5,30 @@ import (
 	"strings"
 
 	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
 	"github.com/gin-gonic/gin"
 )
 
-// Auth creates an authentication middleware
+// TokenExpiredHeader is set to "true" on a response to a read that was let
+// through on an access token inside its expiry grace (see
+// auth.Service.SetExpiredTokenGrace); the client should refresh now.
+const TokenExpiredHeader = "X-Token-Expired"
+
+// Auth creates an authentication middleware. Reads (GET, HEAD) accept a
+// token within the service's expiry grace; anything that may mutate state
+// requires an unexpired one. A request sending auth.AppHeader only gets in
+// on a token scoped to that app. Without an Authorization header the access
+// token cookie is accepted; being SameSite=Lax, it isn't sent on
+// cross-site writes.
 func Auth(authService *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get token from Authorization header
+		// Get token from Authorization header, or from the access token
+		// cookie of a web OAuth flow
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		cookieToken, _ := c.Cookie(auth.AccessTokenCookieName)
+		if authHeader == "" && cookieToken == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"error": gin.H{
@@ -25,36 +40,112 @@ func Auth(authService *auth.Service) gin.HandlerFunc {
 		}
 
 		// Extract token (format: "Bearer TOKEN")
-		tokenString := ""
-		if strings.HasPrefix(authHeader, "Bearer ") {
+		tokenString := cookieToken
+		if authHeader != "" {
+			if !strings.HasPrefix(authHeader, "Bearer ") {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"success": false,
+					"error": gin.H{
+						"code":    "UNAUTHORIZED",
+						"message": "Invalid Authorization header format",
+					},
+				})
+				return
+			}
 			tokenString = authHeader[7:]
+		}
+
+		// A request naming its app only accepts tokens scoped to it
+		app := c.GetHeader(auth.AppHeader)
+		if err := authService.CheckApp(app); err != nil {
+			response.Error(c, err)
+			c.Abort()
+			return
+		}
+		ctx := auth.WithApp(c.Request.Context(), app)
+
+		// Validate token
+		var claims *token.Claims
+		var err error
+		if isReadMethod(c.Request.Method) {
+			var expired bool
+			claims, expired, err = authService.ValidateTokenWithGrace(ctx, tokenString)
+			if expired {
+				c.Header(TokenExpiredHeader, "true")
+			}
 		} else {
+			claims, err = authService.ValidateToken(ctx, tokenString)
+		}
+		if err != nil {
+			code, message := tokenErrorCode(err)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"error": gin.H{
-					"code":    "UNAUTHORIZED",
-					"message": "Invalid Authorization header format",
+					"code":    code,
+					"message": message,
 				},
 			})
 			return
 		}
 
-		// Validate token
-		claims, err := authService.ValidateToken(c.Request.Context(), tokenString)
-		if err != nil {
+		// Set claims in context
+		c.Set("claims", claims)
+		c.Set("user_id", claims.UserID)
+
+		c.Next()
+	}
+}
+
+// isReadMethod reports whether method is one that must not change state, and
+// so may run on a token inside its expiry grace.
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// tokenErrorCode maps a ValidateToken error to the code the client acts on:
+// TOKEN_EXPIRED means refresh and retry, TOKEN_REVOKED and INVALID_TOKEN
+// mean sign in again. Errors response.FromError doesn't know (e.g. Redis
+// being down) are still reported as INVALID_TOKEN, not a 500.
+func tokenErrorCode(err error) (code, message string) {
+	appErr := response.FromError(err)
+	if appErr == apperrors.ErrInternal {
+		return apperrors.ErrCodeInvalidToken, err.Error()
+	}
+	return appErr.Code, appErr.Message
+}
+
+// RequireRole allows the request through only when the authenticated user's
+// meta_type is one of roles. It must run after Auth, which sets the claims.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		allowed[r] = true
+	}
+
+	return func(c *gin.Context) {
+		claimsInterface, _ := c.Get("claims")
+		claims, ok := claimsInterface.(*token.Claims)
+		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"error": gin.H{
-					"code":    "INVALID_TOKEN",
-					"message": err.Error(),
+					"code":    "UNAUTHORIZED",
+					"message": "Not authenticated",
 				},
 			})
 			return
 		}
 
-		// Set claims in context
-		c.Set("claims", claims)
-		c.Set("user_id", claims.UserID)
+		if !allowed[claims.MetaType] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error": gin.H{
+					"code":    "FORBIDDEN",
+					"message": "Insufficient permissions",
+				},
+			})
+			return
+		}
 
 		c.Next()
 	}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/token"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestAuth_TokenErrorCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	blacklist := token.NewBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	ts := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	expiredTS := token.NewService("access-secret", "refresh-secret", -time.Minute, time.Hour)
+	svc := auth.NewService(nil, ts, blacklist, nil, nil, zap.NewNop())
+
+	generate := func(t *testing.T, s *token.Service) string {
+		t.Helper()
+		pair, err := s.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	revoked := generate(t, ts)
+	claims, err := ts.Validate(revoked)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := blacklist.Add(context.Background(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		t.Fatalf("blacklist.Add: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode string
+	}{
+		{"expired", generate(t, expiredTS), apperrors.ErrCodeTokenExpired},
+		{"revoked", revoked, apperrors.ErrCodeTokenRevoked},
+		{"malformed", "not.a.jwt", apperrors.ErrCodeInvalidToken},
+		{"bad signature", generate(t, token.NewService("other-secret", "refresh-secret", -time.Minute, time.Hour)), apperrors.ErrCodeInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/me", Auth(svc), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			var resp struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuth_ExpiredGraceOnlyForReads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	blacklist := token.NewBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	ts := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	svc := auth.NewService(nil, ts, blacklist, nil, nil, zap.NewNop())
+	svc.SetExpiredTokenGrace(time.Minute)
+
+	generate := func(t *testing.T, ttl time.Duration) string {
+		t.Helper()
+		s := token.NewService("access-secret", "refresh-secret", ttl, time.Hour)
+		pair, err := s.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	justExpired := generate(t, -10*time.Second)
+
+	r := gin.New()
+	r.Use(Auth(svc))
+	r.GET("/me", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.PATCH("/me/locale", func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(method, path, tok string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/me", justExpired)
+	if w.Code != http.StatusOK || w.Header().Get(TokenExpiredHeader) != "true" {
+		t.Errorf("read on just-expired token = %d, %s %q; want 200 with the header", w.Code, TokenExpiredHeader, w.Header().Get(TokenExpiredHeader))
+	}
+
+	w = do(http.MethodPatch, "/me/locale", justExpired)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("write on just-expired token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := do(http.MethodGet, "/me", generate(t, -2*time.Minute)); w.Code != http.StatusUnauthorized {
+		t.Errorf("read past the grace = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := do(http.MethodGet, "/me", generate(t, time.Hour)); w.Code != http.StatusOK || w.Header().Get(TokenExpiredHeader) != "" {
+		t.Errorf("read on live token = %d, %s %q; want 200 without the header", w.Code, TokenExpiredHeader, w.Header().Get(TokenExpiredHeader))
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		claims *token.Claims
+		want   int
+	}{
+		{"admin allowed", &token.Claims{MetaType: "Admin"}, http.StatusOK},
+		{"teacher forbidden", &token.Claims{MetaType: "Teacher"}, http.StatusForbidden},
+		{"no claims unauthorized", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/admin", func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set("claims", tt.claims)
+				}
+				c.Next()
+			}, RequireRole("Admin"), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuth_AppHeaderRequiresScopedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	blacklist := token.NewBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	ts := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	svc := auth.NewService(nil, ts, blacklist, nil, nil, zap.NewNop())
+	svc.SetApps(auth.NewApps([]string{"student-game", "teacher-dashboard"}))
+
+	generate := func(t *testing.T, app string) string {
+		t.Helper()
+		pair, err := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0, token.WithAudience(app))
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	game, unscoped := generate(t, "student-game"), generate(t, "")
+
+	r := gin.New()
+	r.GET("/me", Auth(svc), func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(tok, app string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		if app != "" {
+			req.Header.Set(auth.AppHeader, app)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		app   string
+		want  int
+	}{
+		{"scoped token, no app named", game, "", http.StatusOK},
+		{"scoped token, its own app", game, "student-game", http.StatusOK},
+		{"scoped token, another app", game, "teacher-dashboard", http.StatusUnauthorized},
+		{"unscoped token, app named", unscoped, "student-game", http.StatusUnauthorized},
+		{"unknown app", game, "someone-else", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := do(tt.token, tt.app); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuth_AcceptsAccessTokenCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	blacklist := token.NewBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ts := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	svc := auth.NewService(nil, ts, blacklist, nil, nil, zap.NewNop())
+
+	pair, err := ts.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/me", Auth(svc), func(c *gin.Context) { c.String(http.StatusOK, "%d", c.GetInt("user_id")) })
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookieName, Value: pair.AccessToken})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "42" {
+		t.Errorf("cookie auth = %d %q, want 200 for user 42", w.Code, w.Body.String())
+	}
+
+	// An Authorization header takes precedence over the cookie.
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer not.a.jwt")
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookieName, Value: pair.AccessToken})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("bad header with good cookie = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/boddle/reservoir/pkg/boddlectx"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// BoddleContext verifies the X-Boddle-Context header Rails signs and stores
+// its fields in the request context (read them with boddlectx.FromContext).
+// A request without the header passes through with no fields; one whose
+// header is unsigned, tampered with or expired is rejected with
+// INVALID_CONTEXT_HEADER rather than served with context we can't trust.
+func BoddleContext(v *boddlectx.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(boddlectx.Header)
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		fields, err := v.Verify(header)
+		if err != nil {
+			response.Error(c, apperrors.ErrInvalidContextHeader)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(boddlectx.WithFields(c.Request.Context(), fields))
+		c.Next()
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boddle/reservoir/pkg/boddlectx"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBoddleContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	v, err := boddlectx.NewVerifier([]byte("rails-shared-secret-at-least-32-bytes"))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	other, _ := boddlectx.NewVerifier([]byte("some-other-secret-also-32-bytes-long"))
+
+	valid, err := v.Sign(boddlectx.Fields{"beta_cohort": true, "plan": "school"}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	payload, _, _ := strings.Cut(valid, ".")
+	forged, _ := other.Sign(boddlectx.Fields{"beta_cohort": true}, time.Now().Add(time.Minute))
+	expired, _ := v.Sign(boddlectx.Fields{"beta_cohort": true}, time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantFields bool
+	}{
+		{"valid", valid, http.StatusOK, true},
+		{"missing", "", http.StatusOK, false},
+		{"unsigned", payload, http.StatusBadRequest, false},
+		{"tampered", payload + "x." + strings.SplitN(valid, ".", 2)[1], http.StatusBadRequest, false},
+		{"wrong secret", forged, http.StatusBadRequest, false},
+		{"expired", expired, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields boddlectx.Fields
+			var ok bool
+			r := gin.New()
+			r.Use(BoddleContext(v))
+			r.GET("/me", func(c *gin.Context) {
+				fields, ok = boddlectx.FromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tt.header != "" {
+				req.Header.Set(boddlectx.Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ok != tt.wantFields {
+				t.Fatalf("fields present = %v, want %v", ok, tt.wantFields)
+			}
+			if tt.wantFields && (!fields.Bool("beta_cohort") || fields.String("plan") != "school") {
+				t.Errorf("fields = %v", fields)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(w.Body.String(), apperrors.ErrCodeInvalidContextHeader) {
+				t.Errorf("body = %s, want %s", w.Body.String(), apperrors.ErrCodeInvalidContextHeader)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"strings"
 
+	"github.com/boddle/reservoir/pkg/requestid"
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,8 +26,8 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 		}
 
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Type")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, "+APIVersionHeader+", "+NamingConventionHeader+", "+requestid.Header+", "+TokenExpiredHeader)
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Type, "+APIVersionHeader+", "+NamingConventionHeader+", "+requestid.Header+", "+TokenExpiredHeader)
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Max-Age", "86400") // 24 hours
 
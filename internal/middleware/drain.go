@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+// Drainer tracks whether the server is shutting down. Once Start is called,
+// the Drain middleware turns every new request away so the load balancer
+// moves traffic elsewhere, while requests already in flight run to
+// completion. The zero value is not draining.
+type Drainer struct {
+	draining atomic.Bool
+}
+
+// NewDrainer creates a Drainer that is not draining.
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+// Start begins draining. It cannot be undone.
+func (d *Drainer) Start() {
+	d.draining.Store(true)
+}
+
+// Draining reports whether Start has been called.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Drain rejects requests with 503 SHUTTING_DOWN and Connection: close once d
+// is draining, so clients on a keep-alive connection reconnect (and reach
+// another instance) instead of being cut off mid-request when the server
+// stops. Health checks get the same 503, which takes the instance out of
+// rotation. Register it ahead of anything that does real work.
+func Drain(d *Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !d.Draining() {
+			c.Next()
+			return
+		}
+		c.Header("Connection", "close")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error": gin.H{
+				"code":    apperrors.ErrCodeShuttingDown,
+				"message": "server is shutting down, retry on a new connection",
+			},
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDrain_RejectsNewRequestsOnceDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	drainer := NewDrainer()
+
+	// The in-flight request blocks until released, so the flag flips while
+	// it is being handled.
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(Drain(drainer))
+	router.GET("/slow", func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := serve("/fast"); w.Code != http.StatusOK {
+		t.Fatalf("before draining: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	inFlight := make(chan int)
+	go func() { inFlight <- serve("/slow").Code }()
+	<-entered
+
+	drainer.Start()
+
+	w := serve("/fast")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("while draining: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Connection"); got != "close" {
+		t.Errorf("Connection = %q, want close", got)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != apperrors.ErrCodeShuttingDown {
+		t.Errorf("error code = %q (%v), want %q", body.Error.Code, err, apperrors.ErrCodeShuttingDown)
+	}
+
+	close(release)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Errorf("in-flight request: status = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/boddle/reservoir/internal/ratelimit"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var errTooManyRequests = apperrors.NewAppError(
+	apperrors.ErrCodeRateLimitExceeded,
+	"Too many requests from this address",
+	http.StatusTooManyRequests,
+)
+
+// ParseIPAllowlist parses the IPs and CIDR ranges RateLimitPerIP lets
+// through uncounted, e.g. internal health checkers.
+func ParseIPAllowlist(entries []string) ([]netip.Prefix, error) {
+	return parseIPPrefixes(entries, "allowlisted IP")
+}
+
+// RateLimitPerIP rejects a request with 429 and a Retry-After once its client
+// IP has used up the limiter's requests for the window. Requests from
+// allowlist bypass it. Like the login limits it fails open: if Redis is
+// unavailable the request proceeds. A nil limiter disables the check.
+func RateLimitPerIP(limiter *ratelimit.RequestLimiter, allowlist []netip.Prefix, logger *zap.Logger) gin.HandlerFunc {
+	if limiter == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addr = addr.Unmap()
+			for _, p := range allowlist {
+				if p.Contains(addr) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		allowed, retryAfter, err := limiter.Allow(c.Request.Context(), ip)
+		if err != nil {
+			logger.Warn("per-IP rate limiter error", zap.Error(err))
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+			response.Error(c, errTooManyRequests)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newRateLimitedRouter(t *testing.T, limiter *ratelimit.RequestLimiter, allowlist ...string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	prefixes, err := ParseIPAllowlist(allowlist)
+	if err != nil {
+		t.Fatalf("ParseIPAllowlist: %v", err)
+	}
+	router := gin.New()
+	router.Use(RateLimitPerIP(limiter, prefixes, zap.NewNop()))
+	router.GET("/anything", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func getFrom(router *gin.Engine, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/anything", nil)
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitPerIP_CapsRequestsPerWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	router := newRateLimitedRouter(t, ratelimit.NewRequestLimiter(client, 3, time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if w := getFrom(router, "203.0.113.7"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+
+	w := getFrom(router, "203.0.113.7")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	// Other IPs have their own budget.
+	if w := getFrom(router, "198.51.100.2"); w.Code != http.StatusOK {
+		t.Errorf("other IP: status = %d, want 200", w.Code)
+	}
+
+	// A new window starts the count afresh.
+	mr.FastForward(time.Minute)
+	if w := getFrom(router, "203.0.113.7"); w.Code != http.StatusOK {
+		t.Errorf("after the window: status = %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitPerIP_AllowlistBypasses(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	limiter := ratelimit.NewRequestLimiter(client, 1, time.Minute)
+	router := newRateLimitedRouter(t, limiter, "10.1.0.0/16", "192.0.2.10")
+
+	for _, ip := range []string{"10.1.2.3", "192.0.2.10"} {
+		for i := 0; i < 5; i++ {
+			if w := getFrom(router, ip); w.Code != http.StatusOK {
+				t.Fatalf("%s request %d: status = %d, want 200", ip, i+1, w.Code)
+			}
+		}
+		if mr.Exists(limiter.RequestKey(ip)) {
+			t.Errorf("%s: allowlisted requests should not be counted", ip)
+		}
+	}
+}
+
+func TestRateLimitPerIP_FailsOpen(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	router := newRateLimitedRouter(t, ratelimit.NewRequestLimiter(client, 1, time.Minute))
+	mr.Close()
+
+	for i := 0; i < 3; i++ {
+		if w := getFrom(router, "203.0.113.7"); w.Code != http.StatusOK {
+			t.Fatalf("request %d with Redis down: status = %d, want 200", i+1, w.Code)
+		}
+	}
+}
+
+func TestRateLimitPerIP_NilLimiterDisabled(t *testing.T) {
+	router := newRateLimitedRouter(t, nil)
+	for i := 0; i < 3; i++ {
+		if w := getFrom(router, "203.0.113.7"); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+}
+
+func TestParseIPAllowlist_RejectsGarbage(t *testing.T) {
+	if _, err := ParseIPAllowlist([]string{"10.0.0.0/8", "not-an-ip"}); err == nil {
+		t.Error("expected an error for a non-IP entry")
+	}
+}
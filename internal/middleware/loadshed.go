@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// LoadShed caps the number of requests processed concurrently. Requests that
+// arrive while maxInFlight are already running are rejected immediately with
+// a 503 and a Retry-After hint rather than queueing behind the slow ones —
+// under a dependency brownout, queueing only stretches every request up to
+// the server write timeout. maxInFlight <= 0 disables shedding.
+func LoadShed(maxInFlight int, retryAfter time.Duration) gin.HandlerFunc {
+	if maxInFlight <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	slots := make(chan struct{}, maxInFlight)
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			response.ServiceUnavailable(c, retryAfter, "server is at capacity, retry shortly")
+		}
+	}
+}
@@ -2,56 +2,107 @@ package middleware
 
 import (
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/boddle/reservoir/pkg/requestid"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
-// sensitiveQueryKeys are query-string parameters whose values are credentials
-// and must never be written to logs. The magic-link secret (`token`) is the
-// reason this exists (security review Finding 3 / LMS-6514); the OAuth
-// authorization `code` and the others are redacted as defense-in-depth since
-// they also appear in query strings on callback routes.
-var sensitiveQueryKeys = map[string]bool{
-	"token":         true,
-	"secret":        true,
-	"password":      true,
-	"code":          true,
-	"access_token":  true,
-	"refresh_token": true,
+// DefaultRedactedQueryParams are the query-string parameters whose values
+// Logger masks when no list is configured. The magic-link secret (`token`)
+// is the reason this exists (security review Finding 3 / LMS-6514); the OAuth
+// `code` and `state` appear in callback query strings, and the rest are
+// defense-in-depth.
+var DefaultRedactedQueryParams = []string{
+	"token",
+	"code",
+	"state",
+	"client_secret",
+	"secret",
+	"password",
+	"access_token",
+	"refresh_token",
 }
 
-// redactQuery returns the raw query string with the values of any sensitive
-// keys replaced by "REDACTED". If the query can't be parsed it returns a fixed
-// placeholder rather than risk logging an unparsed secret.
-func redactQuery(rawQuery string) string {
+// redactedValue replaces the value of a sensitive query parameter.
+const redactedValue = "***"
+
+// redactQuery returns the raw query string with the values of the sensitive
+// keys replaced by "***", leaving everything else as sent. If the query can't
+// be parsed it returns a fixed placeholder rather than risk logging an
+// unparsed secret.
+func redactQuery(rawQuery string, sensitive map[string]bool) string {
 	if rawQuery == "" {
 		return ""
 	}
-	values, err := url.ParseQuery(rawQuery)
-	if err != nil {
+	if _, err := url.ParseQuery(rawQuery); err != nil {
 		return "[unparseable query redacted]"
 	}
+
+	pairs := strings.Split(rawQuery, "&")
 	redacted := false
-	for key := range values {
-		if sensitiveQueryKeys[key] {
-			values.Set(key, "REDACTED")
+	for i, pair := range pairs {
+		rawKey, _, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err == nil && sensitive[key] {
+			pairs[i] = rawKey + "=" + redactedValue
 			redacted = true
 		}
 	}
 	if !redacted {
 		return rawQuery
 	}
-	return values.Encode()
+	return strings.Join(pairs, "&")
+}
+
+// LoggerOption configures optional Logger behaviour.
+type LoggerOption func(*loggerOptions)
+
+type loggerOptions struct {
+	handlerName bool
 }
 
-// Logger creates a logging middleware using zap
-func Logger(logger *zap.Logger) gin.HandlerFunc {
+// WithHandlerName adds the name of the handler that served a matched route
+// (e.g. "auth.(*Handler).Login") to each request line.
+func WithHandlerName(on bool) LoggerOption {
+	return func(o *loggerOptions) {
+		o.handlerName = on
+	}
+}
+
+// handlerName shortens gin's handler name to package.Func: the module path
+// and the method-value "-fm" suffix say nothing useful.
+func handlerName(full string) string {
+	if i := strings.LastIndex(full, "/"); i >= 0 {
+		full = full[i+1:]
+	}
+	return strings.TrimSuffix(full, "-fm")
+}
+
+// Logger creates a logging middleware using zap. The values of the
+// redactParams query parameters are masked in the logged query string; an
+// empty list uses DefaultRedactedQueryParams. Besides the raw path, each
+// line carries the matched route template ("" when no route matched), so
+// requests are grouped the same way as in the metrics.
+func Logger(logger *zap.Logger, redactParams []string, opts ...LoggerOption) gin.HandlerFunc {
+	var o loggerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(redactParams) == 0 {
+		redactParams = DefaultRedactedQueryParams
+	}
+	sensitive := make(map[string]bool, len(redactParams))
+	for _, p := range redactParams {
+		sensitive[strings.TrimSpace(p)] = true
+	}
+
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := redactQuery(c.Request.URL.RawQuery)
+		query := redactQuery(c.Request.URL.RawQuery, sensitive)
 
 		// Process request
 		c.Next()
@@ -63,20 +114,30 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 		status := c.Writer.Status()
 
 		// Log request
-		logger.Info("request",
+		route := c.FullPath()
+		fields := []zap.Field{
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
+			zap.String("route", route),
 			zap.String("query", query),
 			zap.Int("status", status),
 			zap.Duration("latency", latency),
 			zap.String("ip", c.ClientIP()),
 			zap.String("user-agent", c.Request.UserAgent()),
-		)
+			zap.String("request_id", requestid.FromContext(c.Request.Context())),
+		}
+		if o.handlerName && route != "" {
+			fields = append(fields, zap.String("handler", handlerName(c.HandlerName())))
+		}
+		logger.Info("request", fields...)
 
 		// Log errors if any
 		if len(c.Errors) > 0 {
 			for _, e := range c.Errors {
-				logger.Error("request error", zap.Error(e.Err))
+				logger.Error("request error",
+					zap.String("request_id", requestid.FromContext(c.Request.Context())),
+					zap.Error(e.Err),
+				)
 			}
 		}
 	}
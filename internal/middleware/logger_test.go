@@ -1,10 +1,24 @@
 package middleware
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
+func defaultSensitive() map[string]bool {
+	m := make(map[string]bool)
+	for _, k := range DefaultRedactedQueryParams {
+		m[k] = true
+	}
+	return m
+}
+
 func TestRedactQuery(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -15,14 +29,14 @@ func TestRedactQuery(t *testing.T) {
 		{
 			name:        "magic-link token is redacted",
 			raw:         "token=super-secret-value",
-			wantContain: []string{"token=REDACTED"},
+			wantContain: []string{"token=***"},
 			wantAbsent:  []string{"super-secret-value"},
 		},
 		{
-			name:        "oauth code is redacted",
+			name:        "oauth code and state are redacted",
 			raw:         "code=auth-code-123&state=xyz",
-			wantContain: []string{"code=REDACTED", "state=xyz"},
-			wantAbsent:  []string{"auth-code-123"},
+			wantContain: []string{"code=***", "state=***"},
+			wantAbsent:  []string{"auth-code-123", "xyz"},
 		},
 		{
 			name:        "non-sensitive params are preserved verbatim",
@@ -32,14 +46,14 @@ func TestRedactQuery(t *testing.T) {
 		{
 			name:        "sensitive mixed with benign",
 			raw:         "token=abc&redirect_url=/x",
-			wantContain: []string{"token=REDACTED", "redirect_url=%2Fx"},
+			wantContain: []string{"token=***&redirect_url=/x"},
 			wantAbsent:  []string{"token=abc"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := redactQuery(tt.raw)
+			got := redactQuery(tt.raw, defaultSensitive())
 			for _, c := range tt.wantContain {
 				if !strings.Contains(got, c) {
 					t.Errorf("redactQuery(%q) = %q, want to contain %q", tt.raw, got, c)
@@ -55,7 +69,7 @@ func TestRedactQuery(t *testing.T) {
 }
 
 func TestRedactQuery_Empty(t *testing.T) {
-	if got := redactQuery(""); got != "" {
+	if got := redactQuery("", defaultSensitive()); got != "" {
 		t.Errorf("redactQuery(\"\") = %q, want \"\"", got)
 	}
 }
@@ -63,8 +77,62 @@ func TestRedactQuery_Empty(t *testing.T) {
 func TestRedactQuery_Unparseable(t *testing.T) {
 	// A stray %ZZ is an invalid percent-encoding; rather than risk logging an
 	// unparsed secret, redactQuery returns a fixed placeholder.
-	got := redactQuery("token=%ZZ")
+	got := redactQuery("token=%ZZ", defaultSensitive())
 	if strings.Contains(got, "%ZZ") || got == "token=%ZZ" {
 		t.Errorf("redactQuery returned the raw unparseable query: %q", got)
 	}
 }
+
+func TestLogger_MasksConfiguredQueryParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+
+	router := gin.New()
+	router.Use(Logger(zap.New(core), []string{"token", "district"}))
+	router.POST("/auth/token", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/auth/token?token=SECRET&district=d-1&redirect_url=/home", nil))
+
+	entries := logs.FilterMessage("request").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d request log entries, want 1", len(entries))
+	}
+	query := entries[0].ContextMap()["query"]
+	if query != "token=***&district=***&redirect_url=/home" {
+		t.Errorf("logged query = %q, want token=***&district=***&redirect_url=/home", query)
+	}
+}
+
+type loggedHandler struct{}
+
+func (loggedHandler) Callback(c *gin.Context) { c.Status(http.StatusOK) }
+
+func TestLogger_LogsRouteTemplateAndHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+
+	router := gin.New()
+	router.Use(Logger(zap.New(core), nil, WithHandlerName(true)))
+	router.GET("/admin/users/:id", loggedHandler{}.Callback)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/users/42", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	entries := logs.FilterMessage("request").All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d request log entries, want 2", len(entries))
+	}
+	matched := entries[0].ContextMap()
+	if matched["path"] != "/admin/users/42" || matched["route"] != "/admin/users/:id" {
+		t.Errorf("path, route = %q, %q; want /admin/users/42, /admin/users/:id", matched["path"], matched["route"])
+	}
+	if matched["handler"] != "middleware.loggedHandler.Callback" {
+		t.Errorf("handler = %q, want middleware.loggedHandler.Callback", matched["handler"])
+	}
+	unmatched := entries[1].ContextMap()
+	if unmatched["route"] != "" {
+		t.Errorf("unmatched route = %q, want empty", unmatched["route"])
+	}
+	if _, ok := unmatched["handler"]; ok {
+		t.Errorf("unmatched request logged a handler: %v", unmatched["handler"])
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/boddle/reservoir/internal/ratelimit"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var errTooManyConcurrentLogins = apperrors.NewAppError(
+	apperrors.ErrCodeRateLimitExceeded,
+	"Too many simultaneous login attempts from this address",
+	http.StatusTooManyRequests,
+)
+
+// LoginConcurrency rejects a login with 429 while the client IP already has
+// the gate's maximum number of logins in flight. Like the attempt limiter it
+// fails open: if Redis is unavailable the login proceeds. A nil gate disables
+// the check.
+func LoginConcurrency(gate *ratelimit.ConcurrencyGate, logger *zap.Logger) gin.HandlerFunc {
+	if gate == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		release, allowed, err := gate.Acquire(c.Request.Context(), c.ClientIP())
+		if err != nil {
+			logger.Warn("login concurrency gate error", zap.Error(err))
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", "1")
+			response.Error(c, errTooManyConcurrentLogins)
+			c.Abort()
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestLoginConcurrency_CapsInFlightLoginsPerIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	gate := ratelimit.NewConcurrencyGate(client, 2)
+
+	// Logins block until released, so they overlap like a stuffing burst.
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	router := gin.New()
+	router.POST("/auth/login", LoginConcurrency(gate, zap.NewNop()), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-unblock
+		c.Status(http.StatusOK)
+	})
+
+	login := func(ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	const attackers = 6
+	codes := make(chan int, attackers)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); codes <- login("203.0.113.7") }()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the first logins to start")
+		}
+	}
+
+	// The gate is full: every further login from the IP is turned away
+	// without reaching the handler.
+	for i := 2; i < attackers; i++ {
+		if code := login("203.0.113.7"); code != http.StatusTooManyRequests {
+			t.Errorf("login %d: status = %d, want %d", i+1, code, http.StatusTooManyRequests)
+		}
+	}
+
+	// Another IP is unaffected.
+	wg.Add(1)
+	go func() { defer wg.Done(); codes <- login("198.51.100.1") }()
+	select {
+	case <-entered:
+	case <-time.After(3 * time.Second):
+		t.Fatal("login from a different IP was blocked")
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("admitted login status = %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	// Finished logins give their slots back.
+	if got, _ := mr.Get(gate.InFlightKey("203.0.113.7")); got != "0" {
+		t.Errorf("in-flight count after all logins finished = %q, want 0", got)
+	}
+	if code := login("203.0.113.7"); code != http.StatusOK {
+		t.Errorf("login after the burst: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestLoginConcurrency_ReleaseAfterSlotExpiredLeavesNoKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	gate := ratelimit.NewConcurrencyGate(client, 1)
+
+	// The slot's key expires while the login is still running.
+	router := gin.New()
+	router.POST("/auth/login", LoginConcurrency(gate, zap.NewNop()), func(c *gin.Context) {
+		mr.FastForward(time.Minute)
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	req.RemoteAddr = "203.0.113.7:40000"
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if mr.Exists(gate.InFlightKey("203.0.113.7")) {
+		got, _ := mr.Get(gate.InFlightKey("203.0.113.7"))
+		t.Errorf("release recreated the expired in-flight key as %q; want it absent", got)
+	}
+}
+
+func TestLoginConcurrency_FailsOpenWithoutRedis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	mr.Close()
+
+	router := gin.New()
+	router.POST("/auth/login", LoginConcurrency(ratelimit.NewConcurrencyGate(client, 1), zap.NewNop()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d when Redis is down", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// NamingConventionHeader is the request header a client uses to pick the
+// casing of response JSON keys: "snake_case" or "camelCase". The convention
+// used is echoed back in the same header.
+const NamingConventionHeader = "X-Naming-Convention"
+
+// NamingConvention sets each request's response naming from
+// X-Naming-Convention, or defaultNaming when the header is absent. An
+// unknown convention is rejected with VALIDATION_FAILED.
+func NamingConvention(defaultNaming response.Naming) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		naming := defaultNaming
+		if h := c.GetHeader(NamingConventionHeader); h != "" {
+			n, err := response.ParseNaming(h)
+			if err != nil {
+				response.ValidationError(c, err.Error())
+				c.Abort()
+				return
+			}
+			naming = n
+		}
+
+		response.SetNaming(c, naming)
+		c.Header(NamingConventionHeader, string(naming))
+		c.Next()
+	}
+}
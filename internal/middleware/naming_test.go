@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNamingConvention(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NamingConvention(response.SnakeCase))
+	router.GET("/auth/me", func(c *gin.Context) {
+		response.Success(c, http.StatusOK, gin.H{"meta_type": "Teacher"})
+	})
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"absent uses the default", "", http.StatusOK, `{"data":{"meta_type":"Teacher"},"success":true}`},
+		{"camelCase", "camelCase", http.StatusOK, `{"data":{"metaType":"Teacher"},"success":true}`},
+		{"snake_case", "snake_case", http.StatusOK, `{"data":{"meta_type":"Teacher"},"success":true}`},
+		{"unknown", "kebab-case", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			if tt.header != "" {
+				req.Header.Set(NamingConventionHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
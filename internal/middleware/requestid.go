@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength bounds a client-supplied request ID.
+const maxRequestIDLength = 128
+
+// RequestID gives every request a correlation ID: the caller's X-Request-ID
+// when it is a sane token (e.g. set by the load balancer), otherwise a new
+// UUID. The ID is echoed in the response header and stored, with the client
+// IP and User-Agent, in the request context, where the service layer picks
+// them up for logs and audit records.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Header(requestid.Header, id)
+		ctx := requestid.WithID(c.Request.Context(), id)
+		ctx = requestid.WithClientIP(ctx, c.ClientIP())
+		c.Request = c.Request.WithContext(requestid.WithUserAgent(ctx, c.Request.UserAgent()))
+		c.Next()
+	}
+}
+
+// validRequestID accepts IDs of letters, digits, '-', '_', '.' and ':' only,
+// so a client can't inject anything odd into our logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
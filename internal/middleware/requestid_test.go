@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated when absent", "", false},
+		{"caller's ID kept", "lb-67891233-abcdef0123456789", true},
+		{"unsafe ID replaced", "abc\ninjected=1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			router := gin.New()
+			router.Use(RequestID())
+			router.GET("/", func(c *gin.Context) {
+				seen = requestid.FromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestid.Header, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			got := w.Header().Get(requestid.Header)
+			if got == "" || got != seen {
+				t.Fatalf("response ID %q, context ID %q; want the same non-empty ID", got, seen)
+			}
+			if (got == tt.incoming) != tt.keep {
+				t.Errorf("ID = %q for incoming %q; keep = %v", got, tt.incoming, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRequestID_StoresClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var ip, ua string
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) {
+		ip = requestid.ClientIP(c.Request.Context())
+		ua = requestid.UserAgent(c.Request.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:4242"
+	req.Header.Set("User-Agent", "BoddleApp/3.2 (iOS)")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if ip != "203.0.113.7" || ua != "BoddleApp/3.2 (iOS)" {
+		t.Errorf("context client = %q, %q; want 203.0.113.7, BoddleApp/3.2 (iOS)", ip, ua)
+	}
+}
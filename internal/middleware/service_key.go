@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceKeyHeader carries the API key a trusted internal service (e.g. the
+// API gateway) presents on service-only endpoints.
+const ServiceKeyHeader = "X-Service-Key"
+
+// ServiceKey admits only requests presenting one of keys in X-Service-Key,
+// and rejects anything else with UNAUTHORIZED. Several keys may be listed so
+// one can be rotated without downtime. Empty keys never match.
+func ServiceKey(keys []string) gin.HandlerFunc {
+	accepted := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		if k != "" {
+			accepted = append(accepted, []byte(k))
+		}
+	}
+	return func(c *gin.Context) {
+		presented := []byte(c.GetHeader(ServiceKeyHeader))
+		if len(presented) > 0 {
+			for _, k := range accepted {
+				if subtle.ConstantTimeCompare(presented, k) == 1 {
+					c.Next()
+					return
+				}
+			}
+		}
+		response.Error(c, apperrors.ErrUnauthorized)
+		c.Abort()
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hopByHopHeaders are meaningful only for a single connection (RFC 9110
+// §7.6.1) and never reach handlers, whoever sent them.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges, e.g.
+// "10.0.0.0/8" or "127.0.0.1", into prefixes.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	return parseIPPrefixes(entries, "trusted proxy")
+}
+
+// parseIPPrefixes parses IPs and CIDR ranges; kind names an entry in errors.
+func parseIPPrefixes(entries []string, kind string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: not an IP or CIDR", kind, entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// StripInboundHeaders deletes headers from requests that don't arrive
+// through a trusted proxy, so a client can't spoof what only our own
+// infrastructure may set: X-Forwarded-For feeding the client IP,
+// X-Forwarded-Proto turning on HSTS, or an internal X-User-Id. A request
+// whose direct peer is in trusted keeps them. Hop-by-hop headers, and any
+// the Connection header names, are dropped from every request.
+//
+// It must run before anything that reads these headers, the request ID and
+// logging middleware included.
+func StripInboundHeaders(headers []string, trusted []netip.Prefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Request.Header
+		for _, v := range h.Values("Connection") {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					h.Del(name)
+				}
+			}
+		}
+		for _, name := range hopByHopHeaders {
+			h.Del(name)
+		}
+
+		if !peerTrusted(c.Request.RemoteAddr, trusted) {
+			for _, name := range headers {
+				h.Del(name)
+			}
+		}
+		c.Next()
+	}
+}
+
+// peerTrusted reports whether remoteAddr, the request's direct peer, is
+// in one of trusted.
+func peerTrusted(remoteAddr string, trusted []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
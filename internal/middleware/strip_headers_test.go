@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStripInboundHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantKept   bool
+	}{
+		{"untrusted client", "203.0.113.7:51234", false},
+		{"trusted proxy", "10.1.2.3:443", true},
+		{"trusted single IP", "127.0.0.1:8080", true},
+		{"IPv4-mapped trusted proxy", "[::ffff:10.1.2.3]:443", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen http.Header
+			r := gin.New()
+			r.Use(StripInboundHeaders([]string{"X-User-Id", "X-Forwarded-Proto"}, trusted))
+			r.GET("/", func(c *gin.Context) {
+				seen = c.Request.Header.Clone()
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-User-Id", "1")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("Authorization", "Bearer t")
+			req.Header.Set("Connection", "X-Debug")
+			req.Header.Set("X-Debug", "on")
+			req.Header.Set("Proxy-Authorization", "Basic eA==")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			for _, name := range []string{"X-User-Id", "X-Forwarded-Proto"} {
+				if kept := seen.Get(name) != ""; kept != tt.wantKept {
+					t.Errorf("%s reached the handler = %v, want %v", name, kept, tt.wantKept)
+				}
+			}
+			for _, name := range []string{"Connection", "X-Debug", "Proxy-Authorization"} {
+				if seen.Get(name) != "" {
+					t.Errorf("hop-by-hop header %s reached the handler", name)
+				}
+			}
+			if seen.Get("Authorization") == "" {
+				t.Error("Authorization was stripped")
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies_RejectsGarbage(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/8", "not-an-ip"}); err == nil {
+		t.Error("expected an error for a non-IP entry")
+	}
+}
@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"go.uber.org/zap"
+)
+
+// AppleName is the name Apple hands the client on a user's first Sign in with
+// Apple. It is never in the ID token and never sent again, so it is the only
+// chance to learn it. It is asserted by the client, so it is only used to
+// fill names that are still empty.
+type AppleName struct {
+	FirstName string
+	LastName  string
+}
+
+// ParseAppleUser reads the "user" value Apple posts alongside the ID token:
+// {"name":{"firstName":"…","lastName":"…"},"email":"…"}. raw may be that
+// object or a JSON string holding it, as it arrives when relayed from a form
+// post. An empty raw yields a zero AppleName.
+func ParseAppleUser(raw []byte) (AppleName, error) {
+	raw = []byte(strings.TrimSpace(string(raw)))
+	if len(raw) == 0 || string(raw) == "null" {
+		return AppleName{}, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return AppleName{}, fmt.Errorf("invalid Apple user: %w", err)
+		}
+		if strings.TrimSpace(s) == "" {
+			return AppleName{}, nil
+		}
+		raw = []byte(s)
+	}
+
+	var u struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return AppleName{}, fmt.Errorf("invalid Apple user: %w", err)
+	}
+	return AppleName{
+		FirstName: strings.TrimSpace(u.Name.FirstName),
+		LastName:  strings.TrimSpace(u.Name.LastName),
+	}, nil
+}
+
+// fillAppleName stores the first-login name from info on a student or parent
+// whose name is still empty, and reflects it on usr/meta so the issued token
+// carries it. A failed write is logged; it never fails the sign-in.
+func (s *AuthService) fillAppleName(ctx context.Context, usr *user.User, meta interface{}, info *OAuthUserInfo) {
+	if info.FirstName == "" && info.LastName == "" {
+		return
+	}
+	log := requestid.Logger(ctx, s.logger)
+
+	switch m := meta.(type) {
+	case *user.Student:
+		if usr.Name != "" {
+			return
+		}
+		name := strings.TrimSpace(info.FirstName + " " + info.LastName)
+		if err := s.userRepo.UpdateStudentName(ctx, m.ID, name); err != nil {
+			log.Warn("failed to store Apple name on student", zap.Int("student_id", m.ID), zap.Error(err))
+			return
+		}
+		usr.Name = name
+		s.evictUser(ctx, usr.ID)
+	case *user.Parent:
+		if m.FirstName != "" || m.LastName != "" {
+			return
+		}
+		if err := s.userRepo.UpdateParentName(ctx, m.ID, info.FirstName, info.LastName); err != nil {
+			log.Warn("failed to store Apple name on parent", zap.Int("parent_id", m.ID), zap.Error(err))
+			return
+		}
+		m.FirstName, m.LastName = info.FirstName, info.LastName
+		s.evictUser(ctx, usr.ID)
+	}
+}
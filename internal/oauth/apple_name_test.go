@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+)
+
+var parentColumns = []string{"id", "first_name", "last_name", "icloud_uid", "created_at", "updated_at"}
+
+func TestParseAppleUser(t *testing.T) {
+	cases := map[string]struct {
+		raw  string
+		want AppleName
+	}{
+		"empty":       {"", AppleName{}},
+		"null":        {"null", AppleName{}},
+		"object":      {`{"name":{"firstName":"Ada","lastName":"Lovelace"},"email":"a@b.c"}`, AppleName{"Ada", "Lovelace"}},
+		"form string": {`"{\"name\":{\"firstName\":\" Ada \",\"lastName\":\"Lovelace\"}}"`, AppleName{"Ada", "Lovelace"}},
+		"no name":     {`{"email":"a@b.c"}`, AppleName{}},
+	}
+	for name, tc := range cases {
+		got, err := ParseAppleUser([]byte(tc.raw))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, tc.want)
+		}
+	}
+
+	if _, err := ParseAppleUser([]byte(`{"name":`)); err == nil {
+		t.Error("malformed user: want error")
+	}
+}
+
+func TestAuthenticateWithiCloud_FirstLoginStoresStudentName(t *testing.T) {
+	s, mock := newNoAccountTestService(t, &OAuthUserInfo{ProviderUserID: "apple-1"})
+	cache := user.NewCache(time.Hour, nil)
+	cache.Set(&user.UserWithMeta{User: user.User{ID: 2}})
+	s.SetUserCache(cache)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM students\s+WHERE icloud_uid`).
+		WithArgs("apple-1").
+		WillReturnRows(sqlmock.NewRows(studentColumns).AddRow(5, nil, nil, nil, "apple-1", nil, now, now))
+	mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+		WithArgs("Student", 5).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, "", "", "", "uid-2", "Student", 5, nil, 0, "", now, now))
+	mock.ExpectExec(`UPDATE users SET name = \$1`).
+		WithArgs("Ada Lovelace", sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := s.AuthenticateWithiCloud(context.Background(), "id-token", AppleName{"Ada", "Lovelace"})
+	if err != nil {
+		t.Fatalf("AuthenticateWithiCloud: %v", err)
+	}
+	if resp.User.Name != "Ada Lovelace" {
+		t.Errorf("user name = %q, want Ada Lovelace", resp.User.Name)
+	}
+	if _, ok := cache.Get(2); ok {
+		t.Error("cached profile survived the name backfill")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthenticateWithiCloud_FirstLoginStoresParentName(t *testing.T) {
+	s, mock := newNoAccountTestService(t, &OAuthUserInfo{ProviderUserID: "apple-1"})
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM students\s+WHERE icloud_uid`).WillReturnRows(sqlmock.NewRows(studentColumns))
+	mock.ExpectQuery(`FROM parents\s+WHERE icloud_uid`).
+		WithArgs("apple-1").
+		WillReturnRows(sqlmock.NewRows(parentColumns).AddRow(8, "", "", "apple-1", now, now))
+	mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+		WithArgs("Parent", 8).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(3, "", "p@example.com", "", "uid-3", "Parent", 8, nil, 0, "", now, now))
+	mock.ExpectExec(`UPDATE parents SET first_name = \$1, last_name = \$2`).
+		WithArgs("Ada", "Lovelace", sqlmock.AnyArg(), 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := s.AuthenticateWithiCloud(context.Background(), "id-token", AppleName{"Ada", "Lovelace"})
+	if err != nil {
+		t.Fatalf("AuthenticateWithiCloud: %v", err)
+	}
+	if p, ok := resp.Meta.(*user.Parent); !ok || p.FirstName != "Ada" || p.LastName != "Lovelace" {
+		t.Errorf("meta = %+v, want parent Ada Lovelace", resp.Meta)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthenticateWithiCloud_LaterLoginLeavesNameAlone(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		desc string
+		name AppleName
+		meta string
+	}{
+		{"no name sent", AppleName{}, ""},
+		{"name already set", AppleName{"Someone", "Else"}, "Grace Hopper"},
+	} {
+		s, mock := newNoAccountTestService(t, &OAuthUserInfo{ProviderUserID: "apple-1"})
+		mock.ExpectQuery(`FROM students\s+WHERE icloud_uid`).
+			WillReturnRows(sqlmock.NewRows(studentColumns).AddRow(5, nil, nil, nil, "apple-1", nil, now, now))
+		mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, tc.meta, "", "", "uid-2", "Student", 5, nil, 0, "", now, now))
+
+		resp, err := s.AuthenticateWithiCloud(context.Background(), "id-token", tc.name)
+		if err != nil {
+			t.Fatalf("%s: AuthenticateWithiCloud: %v", tc.desc, err)
+		}
+		if resp.User.Name != tc.meta {
+			t.Errorf("%s: user name = %q, want %q", tc.desc, resp.User.Name, tc.meta)
+		}
+		// No UPDATE expected: sqlmock fails any unexpected Exec.
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/pkg/utctime"
+	"github.com/gin-gonic/gin"
+)
+
+// newCallbackContext builds a gin context for a GET callback request.
+func newCallbackContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c, w
+}
+
+func decodeErrorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp.Error.Code
+}
+
+// TestCallbacks_MissingParams verifies every provider callback rejects a
+// request missing code or state the same way, before reaching the service.
+func TestCallbacks_MissingParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// authService is nil: missing parameters must be rejected before any call.
+	handler := &Handler{authService: nil}
+
+	callbacks := []struct {
+		name string
+		fn   func(*gin.Context)
+	}{
+		{"google", handler.GoogleCallback},
+		{"clever", handler.CleverCallback},
+	}
+	targets := []string{
+		"/callback",
+		"/callback?code=abc",
+		"/callback?state=xyz",
+	}
+
+	for _, cb := range callbacks {
+		for _, target := range targets {
+			t.Run(cb.name+target, func(t *testing.T) {
+				c, w := newCallbackContext(target)
+				cb.fn(c)
+
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+				}
+				if code := decodeErrorCode(t, w); code != "INVALID_REQUEST" {
+					t.Errorf("error code = %q, want INVALID_REQUEST", code)
+				}
+			})
+		}
+	}
+}
+
+func TestHandleOAuthCallback_AuthFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authFn := func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		return nil, Flow{}, errors.New("invalid state: invalid or expired state token")
+	}
+
+	c, w := newCallbackContext("/callback?code=abc&state=xyz")
+	(&Handler{}).handleOAuthCallback(c, authFn)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if code := decodeErrorCode(t, w); code != "OAUTH_FAILED" {
+		t.Errorf("error code = %q, want OAUTH_FAILED", code)
+	}
+}
+
+func TestHandleOAuthCallback_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotCode, gotState string
+	authFn := func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		gotCode, gotState = code, state
+		return &auth.LoginResponse{Token: &token.TokenPair{AccessToken: "jwt"}}, Flow{RedirectURL: "/dashboard"}, nil
+	}
+
+	c, w := newCallbackContext("/callback?code=abc&state=xyz")
+	(&Handler{}).handleOAuthCallback(c, authFn)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if gotCode != "abc" || gotState != "xyz" {
+		t.Errorf("authFn got code=%q state=%q, want abc/xyz", gotCode, gotState)
+	}
+
+	var resp struct {
+		Data struct {
+			RedirectURL string `json:"redirect_url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.Data.RedirectURL != "/dashboard" {
+		t.Errorf("redirect_url = %q, want /dashboard", resp.Data.RedirectURL)
+	}
+}
+
+func TestHandleOAuthCallback_CookieMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pair := &token.TokenPair{AccessToken: "jwt", ExpiresAt: utctime.New(time.Now().Add(15 * time.Minute))}
+	authFn := func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		return &auth.LoginResponse{Token: pair}, Flow{RedirectURL: "https://app.boddle.com/classes", Response: responseCookie}, nil
+	}
+
+	c, w := newCallbackContext("/callback?code=abc&state=xyz")
+	(&Handler{cookie: auth.AccessTokenCookie{Domain: ".boddle.com"}}).handleOAuthCallback(c, authFn)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://app.boddle.com/classes" {
+		t.Errorf("Location = %q, want the flow's redirect URL", loc)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != auth.AccessTokenCookieName || cookies[0].Value != "jwt" || !cookies[0].HttpOnly {
+		t.Errorf("cookies = %+v, want an HttpOnly access token cookie", cookies)
+	}
+	if strings.Contains(w.Body.String(), "jwt") {
+		t.Error("token leaked into the response body")
+	}
+}
+
+func TestHandleOAuthCallback_FragmentMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	expires := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	pair := &token.TokenPair{AccessToken: "jwt", RefreshToken: "refresh", TokenType: token.TokenTypeBearer, ExpiresAt: utctime.New(expires)}
+	authFn := func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		return &auth.LoginResponse{Token: pair}, Flow{RedirectURL: "https://app.boddle.com/#/classes", Response: responseFragment}, nil
+	}
+
+	c, w := newCallbackContext("/callback?code=abc&state=xyz")
+	(&Handler{}).handleOAuthCallback(c, authFn)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	want := "https://app.boddle.com/#/classes?access_token=jwt&expires_at=2026-10-15T12%3A00%3A00Z&refresh_token=refresh&token_type=Bearer"
+	if loc := w.Header().Get("Location"); loc != want {
+		t.Errorf("Location = %q, want %q", loc, want)
+	}
+}
+
+func TestHandleOAuthCallback_JSONModeSetsNoCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authFn := func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		return &auth.LoginResponse{Token: &token.TokenPair{AccessToken: "jwt"}}, Flow{RedirectURL: "/"}, nil
+	}
+	c, w := newCallbackContext("/callback?code=abc&state=xyz")
+	(&Handler{}).handleOAuthCallback(c, authFn)
+
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("status = %d, cookies = %v; want 200 and none", w.Code, w.Result().Cookies())
+	}
+}
+
+// TestGoogleLogin_SavesResponseMode checks ?response= reaches the callback
+// through either kind of state.
+func TestGoogleLogin_SavesResponseMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	managers := map[string]StateManager{
+		"redis":  newTestStateManager(t),
+		"signed": newTestSignedStateManager(t, nil),
+	}
+	for name, sm := range managers {
+		google := newGoogleService(config.GoogleConfig{ClientID: "id", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}, sm, defaultProviderBuilder)
+		h := NewHandler(nil, google, nil, nil)
+
+		for target, want := range map[string]string{
+			"/auth/google?response=cookie":   responseCookie,
+			"/auth/google?response=fragment": responseFragment,
+			"/auth/google?response=json":     "",
+			"/auth/google":                   "",
+		} {
+			c, w := newCallbackContext(target)
+			h.GoogleLogin(c)
+			if w.Code != http.StatusTemporaryRedirect {
+				t.Fatalf("%s %s: status = %d", name, target, w.Code)
+			}
+			u, _ := url.Parse(w.Header().Get("Location"))
+			flow, err := sm.ValidateState(context.Background(), "google", u.Query().Get("state"))
+			if err != nil || flow.Response != want {
+				t.Errorf("%s %s: flow = %+v, %v; want response %q", name, target, flow, err, want)
+			}
+		}
+
+		c, w := newCallbackContext("/auth/google?response=html")
+		h.GoogleLogin(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unknown response mode: status = %d, want 400", name, w.Code)
+		}
+	}
+}
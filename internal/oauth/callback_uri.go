@@ -0,0 +1,49 @@
+package oauth
+
+import (
+	"golang.org/x/oauth2"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// callbackURIs is a provider's allowlist of OAuth redirect URIs (the
+// callback the provider sends the user back to), so web and mobile flows can
+// share one provider app. The first entry, the configured RedirectURL, is the
+// default.
+type callbackURIs []string
+
+// newCallbackURIs allows primary plus the comma-separated extra URIs.
+func newCallbackURIs(primary, extra string) callbackURIs {
+	uris := callbackURIs{primary}
+	for _, u := range parseAudiences(extra) {
+		if u != primary {
+			uris = append(uris, u)
+		}
+	}
+	return uris
+}
+
+// resolve returns the callback URI to use for a flow that asked for
+// requested: the default when requested is empty, requested itself when it
+// is on the allowlist, and ErrRedirectURINotAllowed otherwise. Matching is
+// exact; a prefix match would let an attacker pick their own path.
+func (c callbackURIs) resolve(requested string) (string, error) {
+	if requested == "" {
+		return c[0], nil
+	}
+	for _, u := range c {
+		if u == requested {
+			return u, nil
+		}
+	}
+	return "", apperrors.ErrRedirectURINotAllowed
+}
+
+// callbackOption returns the options that send callbackURI as redirect_uri
+// to AuthCodeURL and Exchange. The provider requires the same value in both.
+func callbackOption(callbackURI string) []oauth2.AuthCodeOption {
+	if callbackURI == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("redirect_uri", callbackURI)}
+}
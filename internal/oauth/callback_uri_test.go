@@ -0,0 +1,100 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webCallback    = "https://app.example.com/auth/google/callback"
+	mobileCallback = "https://app.example.com/auth/google/callback/mobile"
+)
+
+// newCallbackTestGoogle returns a Google service against a fake provider
+// whose token endpoint reports the redirect_uri each exchange sent.
+func newCallbackTestGoogle(t *testing.T) (*GoogleService, *string) {
+	t.Helper()
+	var exchangedWith string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		exchangedWith = r.PostForm.Get("redirect_uri")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/oauth2/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": "google-sub-1", "email": "teacher@school.edu"})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	b := &providerBuilder{httpClient: srv.Client(), baseURL: srv.URL}
+	gs := newGoogleService(config.GoogleConfig{
+		ClientID: "cid", ClientSecret: "secret",
+		RedirectURL:       webCallback,
+		ExtraRedirectURLs: " " + mobileCallback + " ,",
+	}, newTestStateManager(t), b)
+	return gs, &exchangedWith
+}
+
+func TestGoogleService_SelectsAllowedCallbackURI(t *testing.T) {
+	for name, tc := range map[string]struct{ requested, want string }{
+		"default":       {"", webCallback},
+		"allowed extra": {mobileCallback, mobileCallback},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gs, exchangedWith := newCallbackTestGoogle(t)
+			ctx := context.Background()
+
+			authURL, err := gs.GetAuthURL(ctx, Flow{RedirectURL: "/dashboard", CallbackURI: tc.requested})
+			if err != nil {
+				t.Fatalf("GetAuthURL: %v", err)
+			}
+			u, _ := url.Parse(authURL)
+			if got := u.Query().Get("redirect_uri"); got != tc.want {
+				t.Errorf("authorize redirect_uri = %q, want %q", got, tc.want)
+			}
+
+			// The exchange must repeat the redirect_uri the flow started with.
+			if _, _, err := gs.HandleCallback(ctx, "auth-code", u.Query().Get("state")); err != nil {
+				t.Fatalf("HandleCallback: %v", err)
+			}
+			if *exchangedWith != tc.want {
+				t.Errorf("token exchange redirect_uri = %q, want %q", *exchangedWith, tc.want)
+			}
+		})
+	}
+}
+
+func TestGoogleLogin_RejectsUnlistedRedirectURI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gs, _ := newCallbackTestGoogle(t)
+
+	for _, requested := range []string{"https://evil.example.com/cb", webCallback + "/../steal"} {
+		if _, err := gs.GetAuthURL(context.Background(), Flow{RedirectURL: "/", CallbackURI: requested}); !errors.Is(err, apperrors.ErrRedirectURINotAllowed) {
+			t.Errorf("GetAuthURL(%q) error = %v, want ErrRedirectURINotAllowed", requested, err)
+		}
+	}
+
+	h := &Handler{googleSvc: gs}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/auth/google?redirect_uri="+url.QueryEscape("https://evil.example.com/cb"), nil)
+	h.GoogleLogin(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("redirected to %q for an unlisted redirect_uri", w.Header().Get("Location"))
+	}
+}
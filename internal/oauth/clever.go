@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 
 	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
 	"golang.org/x/oauth2"
 )
 
@@ -21,75 +21,105 @@ const cleverUserInfoURL = "https://api.clever.com/v3.0/me"
 // CleverService handles Clever SSO authentication
 type CleverService struct {
 	config       *oauth2.Config
-	stateManager *StateManager
+	stateManager StateManager
 	userInfoURL  string
 	httpClient   *http.Client
+	callbackURIs callbackURIs
+
+	// adminsAsAdmin maps district/school admins to the Admin meta type
+	// instead of rejecting them (CLEVER_ADMINS_AS_ADMIN).
+	adminsAsAdmin bool
+
+	retry RetryPolicy
+}
+
+// cleverEndpoint is Clever's OAuth authorize/token pair.
+var cleverEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://clever.com/oauth/authorize",
+	TokenURL: "https://clever.com/oauth/tokens",
 }
 
 // NewCleverService creates a new Clever SSO service
-func NewCleverService(cfg config.CleverConfig, stateManager *StateManager) *CleverService {
-	oauthConfig := &oauth2.Config{
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		RedirectURL:  cfg.RedirectURL,
-		Scopes:       []string{}, // Clever doesn't use scopes in the same way
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://clever.com/oauth/authorize",
-			TokenURL: "https://clever.com/oauth/tokens",
-		},
-	}
+func NewCleverService(cfg config.CleverConfig, stateManager StateManager) *CleverService {
+	return newCleverService(cfg, stateManager, defaultProviderBuilder)
+}
 
+func newCleverService(cfg config.CleverConfig, stateManager StateManager, b *providerBuilder) *CleverService {
 	return &CleverService{
-		config:       oauthConfig,
-		stateManager: stateManager,
-		userInfoURL:  cleverUserInfoURL,
-		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		// Clever doesn't use scopes in the same way
+		config:        b.oauthConfig(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, []string{}, cleverEndpoint, cfg.AuthURL, cfg.TokenURL),
+		stateManager:  stateManager,
+		userInfoURL:   b.endpointURL(cfg.UserInfoURL, cleverUserInfoURL),
+		httpClient:    b.httpClient,
+		callbackURIs:  newCallbackURIs(cfg.RedirectURL, cfg.ExtraRedirectURLs),
+		adminsAsAdmin: cfg.AdminsAsAdmin,
+		retry:         defaultRetryPolicy,
 	}
 }
 
-// GetAuthURL generates the Clever OAuth authorization URL
-func (cs *CleverService) GetAuthURL(ctx context.Context, redirectURL string) (string, error) {
-	// Generate and save state
-	state, err := cs.stateManager.GenerateState()
+// SetHTTPClient replaces the client used for token exchange and /me calls
+// (see NewProviderHTTPClient).
+func (cs *CleverService) SetHTTPClient(c *http.Client) {
+	cs.httpClient = c
+}
+
+// SetRetryPolicy sets how /me calls are retried on 5xx and network errors.
+func (cs *CleverService) SetRetryPolicy(p RetryPolicy) {
+	cs.retry = p
+}
+
+// GetAuthURL generates the Clever OAuth authorization URL. flow.CallbackURI
+// picks one of the allowed redirect URIs; "" uses CLEVER_REDIRECT_URL.
+func (cs *CleverService) GetAuthURL(ctx context.Context, flow Flow) (string, error) {
+	callbackURI, err := cs.callbackURIs.resolve(flow.CallbackURI)
 	if err != nil {
 		return "", err
 	}
-
-	if err := cs.stateManager.SaveState(ctx, state, redirectURL); err != nil {
+	flow.CallbackURI = callbackURI
+	state, err := cs.stateManager.IssueState(ctx, "clever", flow)
+	if err != nil {
 		return "", err
 	}
 
 	// Generate OAuth URL with district_id parameter for district-specific login
-	url := cs.config.AuthCodeURL(state)
+	url := cs.config.AuthCodeURL(state, callbackOption(callbackURI)...)
 
 	return url, nil
 }
 
 // HandleCallback handles the Clever OAuth callback and returns user info
-func (cs *CleverService) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, string, error) {
+func (cs *CleverService) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error) {
 	// Validate state
-	redirectURL, err := cs.stateManager.ValidateState(ctx, state)
+	flow, err := cs.stateManager.ValidateState(ctx, "clever", state)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid state: %w", err)
+		return nil, Flow{}, fmt.Errorf("invalid state: %w", err)
 	}
 
-	// Exchange code for token
-	token, err := cs.config.Exchange(ctx, code)
+	// Exchange code for token, with the redirect_uri the flow started with
+	token, err := cs.config.Exchange(exchangeContext(ctx, cs.httpClient), code, callbackOption(flow.CallbackURI)...)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to exchange code: %w", exchangeError("Clever", err))
 	}
 
 	// Fetch user info
 	userInfo, err := cs.fetchUserInfo(ctx, token.AccessToken)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to fetch user info: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to fetch user info: %w", err)
 	}
 
-	return userInfo, redirectURL, nil
+	return userInfo, flow, nil
 }
 
-// fetchUserInfo fetches user information from Clever API
+// fetchUserInfo fetches user information from Clever API, retrying transient
+// failures per cs.retry.
 func (cs *CleverService) fetchUserInfo(ctx context.Context, accessToken string) (*OAuthUserInfo, error) {
+	return withRetry(ctx, cs.retry, func(ctx context.Context) (*OAuthUserInfo, error) {
+		return cs.fetchUserInfoOnce(ctx, accessToken)
+	})
+}
+
+// fetchUserInfoOnce makes a single /me call.
+func (cs *CleverService) fetchUserInfoOnce(ctx context.Context, accessToken string) (*OAuthUserInfo, error) {
 	req, err := http.NewRequestWithContext(
 		ctx,
 		"GET",
@@ -108,17 +138,21 @@ func (cs *CleverService) fetchUserInfo(ctx context.Context, accessToken string)
 	}
 	defer resp.Body.Close()
 
+	if err := throttled("Clever", resp); err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Clever API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &providerStatusError{Provider: "Clever", Status: resp.StatusCode, Body: string(body)}
 	}
 
 	var cleverResponse struct {
 		Data struct {
-			ID    string `json:"id"`
-			Type  string `json:"type"` // "teacher" or "student" or "district_admin"
-			Email string `json:"email"`
-			Name  struct {
+			ID       string `json:"id"`
+			Type     string `json:"type"` // "teacher", "student", "district_admin" or "school_admin"
+			Email    string `json:"email"`
+			District string `json:"district"`
+			Name     struct {
 				First string `json:"first"`
 				Last  string `json:"last"`
 			} `json:"name"`
@@ -137,5 +171,34 @@ func (cs *CleverService) fetchUserInfo(ctx context.Context, accessToken string)
 		FirstName:      data.Name.First,
 		LastName:       data.Name.Last,
 		EmailVerified:  true, // Clever accounts are pre-verified by schools
+		ProviderRole:   data.Type,
+		DistrictID:     data.District,
 	}, nil
 }
+
+// allowsAdmins reports whether district/school admins may sign in as Admin.
+func (cs *CleverService) allowsAdmins() bool {
+	return cs.adminsAsAdmin
+}
+
+// Clever account types that belong to school staff rather than teachers or
+// students. The gateway has no meta type for them beyond Admin.
+const (
+	cleverRoleDistrictAdmin = "district_admin"
+	cleverRoleSchoolAdmin   = "school_admin"
+)
+
+// isCleverAdminRole reports whether role is a Clever admin account type.
+func isCleverAdminRole(role string) bool {
+	return role == cleverRoleDistrictAdmin || role == cleverRoleSchoolAdmin
+}
+
+// unsupportedCleverRoleError explains why a Clever admin can't sign in.
+func unsupportedCleverRoleError(role string) error {
+	who := "district admins"
+	if role == cleverRoleSchoolAdmin {
+		who = "school admins"
+	}
+	return apperrors.NewAppError(apperrors.ErrCodeUnsupportedRole,
+		fmt.Sprintf("%s can't sign in via the student/teacher gateway", who), http.StatusForbidden)
+}
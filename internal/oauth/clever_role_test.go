@@ -0,0 +1,365 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/auth"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCleverFetchUserInfo_CapturesRole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":       "clever-admin-1",
+				"type":     "district_admin",
+				"email":    "admin@district.org",
+				"district": "district-42",
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cs := &CleverService{userInfoURL: srv.URL, httpClient: srv.Client()}
+	info, err := cs.fetchUserInfo(context.Background(), "valid-access-token")
+	if err != nil {
+		t.Fatalf("fetchUserInfo returned error: %v", err)
+	}
+	if info.ProviderRole != "district_admin" {
+		t.Errorf("ProviderRole = %q, want %q", info.ProviderRole, "district_admin")
+	}
+	if info.DistrictID != "district-42" {
+		t.Errorf("DistrictID = %q, want %q", info.DistrictID, "district-42")
+	}
+}
+
+func TestAuthenticateWithCleverToken_DistrictAdmin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":       "clever-admin-1",
+				"type":     "district_admin",
+				"email":    "admin@district.org",
+				"district": "district-42",
+			},
+		})
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("admin@district.org").
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(5, "District Admin", "admin@district.org", "", "uid-5", "Admin", 2, nil, 0, "", now, now))
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	cs := &CleverService{userInfoURL: srv.URL, httpClient: srv.Client(), adminsAsAdmin: true}
+	s := NewAuthService(
+		user.NewRepository(sqlxDB, sqlxDB),
+		token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour),
+		nil, cs, nil, &recordingEnqueuer{},
+	)
+	s.SetLogger(zap.New(core))
+
+	resp, err := s.AuthenticateWithCleverToken(context.Background(), "valid-access-token")
+	if err != nil {
+		t.Fatalf("AuthenticateWithCleverToken: %v", err)
+	}
+	if resp.User.MetaType != "Admin" || resp.Meta != nil {
+		t.Errorf("got meta_type=%q meta=%v, want Admin with no meta record", resp.User.MetaType, resp.Meta)
+	}
+	// The admin branch goes straight to the email lookup, never the
+	// teacher/student UID queries.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	entries := logs.FilterMessage("clever sign-in").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d sign-in log entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["district_id"] != "district-42" || fields["clever_role"] != "district_admin" {
+		t.Errorf("log fields = %v, want district_id=district-42 clever_role=district_admin", fields)
+	}
+}
+
+// A token sign-in is scoped to the app it names, like a redirect flow, and
+// an unknown app is refused before the provider is called.
+func TestCleverTokenAuth_ScopesTokensToApp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":    "clever-admin-1",
+				"type":  "district_admin",
+				"email": "admin@district.org",
+			},
+		})
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("admin@district.org").
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(5, "District Admin", "admin@district.org", "", "uid-5", "Admin", 2, nil, 0, "", now, now))
+
+	tokens := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	cs := &CleverService{userInfoURL: srv.URL, httpClient: srv.Client(), adminsAsAdmin: true}
+	s := NewAuthService(user.NewRepository(sqlxDB, sqlxDB), tokens, nil, cs, nil, &recordingEnqueuer{})
+	h := NewHandler(s, nil, cs, nil)
+	h.SetApps(auth.NewApps([]string{"teacher-dashboard"}))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/auth/clever", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		h.CleverTokenAuth(c)
+		return w
+	}
+
+	if w := post(`{"token":"valid-access-token","app":"unknown"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown app: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := post(`{"token":"valid-access-token","app":"teacher-dashboard"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Token struct {
+				AccessToken string `json:"access_token"`
+			} `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	claims, err := tokens.ValidateAudience(resp.Data.Token.AccessToken, "teacher-dashboard")
+	if err != nil {
+		t.Fatalf("token not scoped to teacher-dashboard: %v", err)
+	}
+	if got := token.AppOf(claims.Audience); got != "teacher-dashboard" {
+		t.Errorf("aud = %q, want teacher-dashboard", got)
+	}
+}
+
+func TestCleverTokenAuth_ClaimsDeviceSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":    "clever-admin-1",
+				"type":  "district_admin",
+				"email": "admin@district.org",
+			},
+		})
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM users\s+WHERE email`).
+			WithArgs("admin@district.org").
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(5, "District Admin", "admin@district.org", "", "uid-5", "Admin", 2, nil, 0, "", now, now))
+	}
+
+	mr := miniredis.RunT(t)
+	sessions := auth.NewDeviceSessions(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+	tokens := token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	cs := &CleverService{userInfoURL: srv.URL, httpClient: srv.Client(), adminsAsAdmin: true}
+	s := NewAuthService(user.NewRepository(sqlxDB, sqlxDB), tokens, nil, cs, nil, &recordingEnqueuer{})
+	s.SetDeviceSessions(sessions)
+	h := NewHandler(s, nil, cs, nil)
+
+	signIn := func() *token.Claims {
+		t.Helper()
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/auth/clever", strings.NewReader(`{"token":"valid-access-token"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set(auth.DeviceFingerprintHeader, "tablet-1")
+		h.CleverTokenAuth(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Token struct {
+					AccessToken string `json:"access_token"`
+				} `json:"token"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		claims, err := tokens.Validate(resp.Data.Token.AccessToken)
+		if err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		return claims
+	}
+
+	first := signIn()
+	if first.Device == "" {
+		t.Fatal("token not tied to the device")
+	}
+	second := signIn()
+
+	ctx := context.Background()
+	if superseded, err := sessions.Superseded(ctx, first); err != nil || !superseded {
+		t.Errorf("first sign-in superseded = %v, %v; want true", superseded, err)
+	}
+	if superseded, err := sessions.Superseded(ctx, second); err != nil || superseded {
+		t.Errorf("second sign-in superseded = %v, %v; want false", superseded, err)
+	}
+}
+
+func newCleverRoleService(t *testing.T, adminsAsAdmin bool) (*AuthService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	cs := &CleverService{adminsAsAdmin: adminsAsAdmin}
+	return NewAuthService(user.NewRepository(sqlxDB, sqlxDB), nil, nil, cs, nil, nil), mock
+}
+
+func TestFindOrCreateCleverUser_RejectsAdminRoles(t *testing.T) {
+	for _, role := range []string{"district_admin", "school_admin"} {
+		t.Run(role, func(t *testing.T) {
+			s, mock := newCleverRoleService(t, false)
+
+			_, _, err := s.findOrCreateCleverUser(context.Background(), &OAuthUserInfo{
+				ProviderUserID: "clever-admin-1",
+				Email:          "admin@district.org",
+				ProviderRole:   role,
+			})
+
+			var appErr *apperrors.AppError
+			if !errors.As(err, &appErr) || appErr.Code != apperrors.ErrCodeUnsupportedRole {
+				t.Fatalf("err = %v, want UNSUPPORTED_ROLE", err)
+			}
+			if appErr.Status != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", appErr.Status, http.StatusForbidden)
+			}
+			// Rejected before any teacher/student lookup.
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFindOrCreateCleverUser_MapsAdminWhenEnabled(t *testing.T) {
+	s, mock := newCleverRoleService(t, true)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("admin@district.org").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+			"last_logged_on", "token_version", "locale", "created_at", "updated_at",
+		}).AddRow(5, "District Admin", "admin@district.org", "", "uid-5", "Admin", 2, nil, 0, "", now, now))
+
+	usr, meta, err := s.findOrCreateCleverUser(context.Background(), &OAuthUserInfo{
+		ProviderUserID: "clever-admin-1",
+		Email:          "admin@district.org",
+		ProviderRole:   "district_admin",
+	})
+	if err != nil {
+		t.Fatalf("findOrCreateCleverUser: %v", err)
+	}
+	if usr.MetaType != "Admin" || meta != nil {
+		t.Errorf("got meta_type=%q meta=%v, want Admin with no meta record", usr.MetaType, meta)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFindOrCreateCleverUser_AdminWithNonAdminAccount(t *testing.T) {
+	s, mock := newCleverRoleService(t, true)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("admin@district.org").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+			"last_logged_on", "token_version", "locale", "created_at", "updated_at",
+		}).AddRow(5, "Ms. Frizzle", "admin@district.org", "", "uid-5", "Teacher", 2, nil, 0, "", now, now))
+
+	_, _, err := s.findOrCreateCleverUser(context.Background(), &OAuthUserInfo{
+		ProviderUserID: "clever-admin-1",
+		Email:          "admin@district.org",
+		ProviderRole:   "district_admin",
+	})
+	if !errors.Is(err, apperrors.ErrForbiddenRole) {
+		t.Errorf("err = %v, want ErrForbiddenRole", err)
+	}
+}
+
+func TestFindOrCreateCleverUser_AdminWithoutAdminAccount(t *testing.T) {
+	s, mock := newCleverRoleService(t, true)
+
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("admin@district.org").
+		WillReturnError(sql.ErrNoRows)
+
+	_, _, err := s.findOrCreateCleverUser(context.Background(), &OAuthUserInfo{
+		ProviderUserID: "clever-admin-1",
+		Email:          "admin@district.org",
+		ProviderRole:   "school_admin",
+	})
+	if err == nil {
+		t.Fatal("expected an error for a Clever admin with no Admin account")
+	}
+}
@@ -3,46 +3,25 @@ package oauth
 import (
 	"strings"
 	"testing"
+
+	"github.com/boddle/reservoir/internal/config"
 )
 
 func TestNewCleverService(t *testing.T) {
-	cfg := struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
-	}{
+	stateManager := &RedisStateManager{} // Mock state manager
+
+	service := newCleverService(config.CleverConfig{
 		ClientID:     "test-client-id",
 		ClientSecret: "test-client-secret",
 		RedirectURL:  "http://localhost:8080/auth/clever/callback",
-	}
-
-	stateManager := &StateManager{} // Mock state manager
-
-	// Convert to config.CleverConfig structure
-	cleverCfg := struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
-	}{
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		RedirectURL:  cfg.RedirectURL,
-	}
-
-	service := &CleverService{
-		stateManager: stateManager,
-	}
+	}, stateManager, defaultProviderBuilder)
 
 	if service.stateManager == nil {
 		t.Error("CleverService stateManager should not be nil")
 	}
-
-	// Verify the service was created (basic test)
-	if service == nil {
-		t.Fatal("NewCleverService returned nil")
+	if service.config.ClientID != "test-client-id" || service.config.RedirectURL != "http://localhost:8080/auth/clever/callback" {
+		t.Errorf("oauth2 config = %+v, want values from CleverConfig", service.config)
 	}
-
-	_ = cleverCfg // Use the config
 }
 
 func TestCleverAuthURL(t *testing.T) {
@@ -8,9 +8,9 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -29,36 +29,54 @@ const googleTokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
 // GoogleService handles Google OAuth2 authentication
 type GoogleService struct {
 	config           *oauth2.Config
-	stateManager     *StateManager
+	stateManager     StateManager
 	userInfoURL      string
 	tokenInfoURL     string
 	allowedAudiences []string
+	allowedDomains   []string
 	httpClient       *http.Client
+	callbackURIs     callbackURIs
+	retry            RetryPolicy
+}
+
+// googleScopes are requested on the redirect flow; they cover the userinfo
+// fields fetchUserInfo reads.
+var googleScopes = []string{
+	"https://www.googleapis.com/auth/userinfo.email",
+	"https://www.googleapis.com/auth/userinfo.profile",
 }
 
 // NewGoogleService creates a new Google OAuth service
-func NewGoogleService(cfg config.GoogleConfig, stateManager *StateManager) *GoogleService {
-	oauthConfig := &oauth2.Config{
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		RedirectURL:  cfg.RedirectURL,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
-	}
+func NewGoogleService(cfg config.GoogleConfig, stateManager StateManager) *GoogleService {
+	return newGoogleService(cfg, stateManager, defaultProviderBuilder)
+}
 
+func newGoogleService(cfg config.GoogleConfig, stateManager StateManager, b *providerBuilder) *GoogleService {
 	return &GoogleService{
-		config:           oauthConfig,
+		config:           b.oauthConfig(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, googleScopes, google.Endpoint, cfg.AuthURL, cfg.TokenURL),
 		stateManager:     stateManager,
-		userInfoURL:      googleUserInfoURL,
-		tokenInfoURL:     googleTokenInfoURL,
+		userInfoURL:      b.endpointURL(cfg.UserInfoURL, googleUserInfoURL),
+		tokenInfoURL:     b.endpointURL(cfg.TokenInfoURL, googleTokenInfoURL),
 		allowedAudiences: parseAudiences(cfg.TokenAudiences),
-		httpClient:       &http.Client{Timeout: 10 * time.Second},
+		allowedDomains:   parseAudiences(strings.ToLower(cfg.AllowedDomains)),
+		httpClient:       b.httpClient,
+		callbackURIs:     newCallbackURIs(cfg.RedirectURL, cfg.ExtraRedirectURLs),
+		retry:            defaultRetryPolicy,
 	}
 }
 
+// SetHTTPClient replaces the client used for token exchange, userinfo and
+// tokeninfo calls (see NewProviderHTTPClient).
+func (gs *GoogleService) SetHTTPClient(c *http.Client) {
+	gs.httpClient = c
+}
+
+// SetRetryPolicy sets how userinfo calls are retried on 5xx and network
+// errors.
+func (gs *GoogleService) SetRetryPolicy(p RetryPolicy) {
+	gs.retry = p
+}
+
 // parseAudiences splits a comma-separated audience allowlist into trimmed,
 // non-empty entries.
 func parseAudiences(raw string) []string {
@@ -95,6 +113,9 @@ func (gs *GoogleService) verifyTokenAudience(ctx context.Context, accessToken st
 	}
 	defer resp.Body.Close()
 
+	if err := throttled("Google", resp); err != nil {
+		return err
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("tokeninfo returned status %d: %s", resp.StatusCode, string(body))
@@ -116,49 +137,105 @@ func (gs *GoogleService) verifyTokenAudience(ctx context.Context, accessToken st
 	return fmt.Errorf("access token audience %q not in allowlist", info.Aud)
 }
 
-// GetAuthURL generates the Google OAuth authorization URL
-func (gs *GoogleService) GetAuthURL(ctx context.Context, redirectURL string) (string, error) {
-	// Generate and save state
-	state, err := gs.stateManager.GenerateState()
+// hostedDomainHint is the hd parameter for the authorization URL: the single
+// allowed domain, or "*" (any Workspace account) when several are allowed.
+// It only steers Google's account picker; checkDomain does the enforcing.
+func (gs *GoogleService) hostedDomainHint() string {
+	switch len(gs.allowedDomains) {
+	case 0:
+		return ""
+	case 1:
+		return gs.allowedDomains[0]
+	default:
+		return "*"
+	}
+}
+
+// checkDomain rejects an account outside GOOGLE_ALLOWED_DOMAINS. The hd
+// claim (the account's Workspace domain) is used when present; userinfo
+// omits it for some accounts, and always for personal gmail, so the email's
+// domain is the fallback, but only for a verified email: anyone can put an
+// unverified school address on a personal Google account. No-op when no
+// domains are configured.
+func (gs *GoogleService) checkDomain(hostedDomain, email string, emailVerified bool) error {
+	if len(gs.allowedDomains) == 0 {
+		return nil
+	}
+	domain := strings.ToLower(hostedDomain)
+	if domain == "" && emailVerified {
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			domain = strings.ToLower(email[at+1:])
+		}
+	}
+	for _, allowed := range gs.allowedDomains {
+		if domain != "" && domain == allowed {
+			return nil
+		}
+	}
+	return apperrors.ErrDomainNotAllowed
+}
+
+// GetAuthURL generates the Google OAuth authorization URL. flow.CallbackURI
+// picks one of the allowed redirect URIs; "" uses GOOGLE_REDIRECT_URL.
+func (gs *GoogleService) GetAuthURL(ctx context.Context, flow Flow) (string, error) {
+	callbackURI, err := gs.callbackURIs.resolve(flow.CallbackURI)
 	if err != nil {
 		return "", err
 	}
-
-	if err := gs.stateManager.SaveState(ctx, state, redirectURL); err != nil {
+	flow.CallbackURI = callbackURI
+	flow.CodeVerifier, err = newCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	state, err := gs.stateManager.IssueState(ctx, "google", flow)
+	if err != nil {
 		return "", err
 	}
 
 	// Generate OAuth URL
-	url := gs.config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	opts := append(callbackOption(callbackURI), oauth2.AccessTypeOffline)
+	if hd := gs.hostedDomainHint(); hd != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("hd", hd))
+	}
+	url := gs.config.AuthCodeURL(state, append(opts, challengeOptions(flow.CodeVerifier)...)...)
 
 	return url, nil
 }
 
 // HandleCallback handles the OAuth callback and returns user info
-func (gs *GoogleService) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, string, error) {
+func (gs *GoogleService) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error) {
 	// Validate state
-	redirectURL, err := gs.stateManager.ValidateState(ctx, state)
+	flow, err := gs.stateManager.ValidateState(ctx, "google", state)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid state: %w", err)
+		return nil, Flow{}, fmt.Errorf("invalid state: %w", err)
 	}
 
-	// Exchange code for token
-	token, err := gs.config.Exchange(ctx, code)
+	// Exchange code for token, with the redirect_uri the flow started with
+	opts := append(callbackOption(flow.CallbackURI), verifierOption(flow.CodeVerifier)...)
+	token, err := gs.config.Exchange(exchangeContext(ctx, gs.httpClient), code, opts...)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to exchange code: %w", exchangeError("Google", err))
 	}
 
 	// Fetch user info
 	userInfo, err := gs.fetchUserInfo(ctx, token.AccessToken)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to fetch user info: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to fetch user info: %w", err)
 	}
 
-	return userInfo, redirectURL, nil
+	return userInfo, flow, nil
 }
 
-// fetchUserInfo fetches user information from Google
+// fetchUserInfo fetches user information from Google, retrying transient
+// failures per gs.retry.
 func (gs *GoogleService) fetchUserInfo(ctx context.Context, accessToken string) (*OAuthUserInfo, error) {
+	return withRetry(ctx, gs.retry, func(ctx context.Context) (*OAuthUserInfo, error) {
+		return gs.fetchUserInfoOnce(ctx, accessToken)
+	})
+}
+
+// fetchUserInfoOnce makes a single userinfo call.
+func (gs *GoogleService) fetchUserInfoOnce(ctx context.Context, accessToken string) (*OAuthUserInfo, error) {
 	req, err := http.NewRequestWithContext(
 		ctx,
 		"GET",
@@ -177,9 +254,12 @@ func (gs *GoogleService) fetchUserInfo(ctx context.Context, accessToken string)
 	}
 	defer resp.Body.Close()
 
+	if err := throttled("Google", resp); err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Google API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &providerStatusError{Provider: "Google", Status: resp.StatusCode, Body: string(body)}
 	}
 
 	var googleUser struct {
@@ -189,12 +269,18 @@ func (gs *GoogleService) fetchUserInfo(ctx context.Context, accessToken string)
 		GivenName     string `json:"given_name"`
 		FamilyName    string `json:"family_name"`
 		Picture       string `json:"picture"`
+		Locale        string `json:"locale"`
+		HostedDomain  string `json:"hd"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&googleUser); err != nil {
 		return nil, fmt.Errorf("failed to decode user info: %w", err)
 	}
 
+	if err := gs.checkDomain(googleUser.HostedDomain, googleUser.Email, googleUser.VerifiedEmail); err != nil {
+		return nil, err
+	}
+
 	return &OAuthUserInfo{
 		ProviderUserID: googleUser.ID,
 		Email:          googleUser.Email,
@@ -202,5 +288,6 @@ func (gs *GoogleService) fetchUserInfo(ctx context.Context, accessToken string)
 		LastName:       googleUser.FamilyName,
 		Picture:        googleUser.Picture,
 		EmailVerified:  googleUser.VerifiedEmail,
+		Locale:         googleUser.Locale,
 	}, nil
 }
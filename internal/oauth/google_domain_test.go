@@ -0,0 +1,98 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+func TestGoogleFetchUserInfo_AllowedDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile map[string]interface{}
+		wantErr bool
+	}{
+		{"hosted domain allowed", map[string]interface{}{"id": "g1", "email": "t@district.k12.us", "hd": "district.k12.us"}, false},
+		{"hd missing, verified email domain allowed", map[string]interface{}{"id": "g2", "email": "T@Academy.org", "verified_email": true}, false},
+		{"hd missing, unverified email domain", map[string]interface{}{"id": "g6", "email": "t@academy.org", "verified_email": false}, true},
+		{"hd missing, email verification absent", map[string]interface{}{"id": "g7", "email": "t@academy.org"}, true},
+		{"personal gmail", map[string]interface{}{"id": "g3", "email": "someone@gmail.com"}, true},
+		{"hd not allowed", map[string]interface{}{"id": "g4", "email": "t@academy.org", "hd": "elsewhere.org"}, true},
+		{"no email or hd", map[string]interface{}{"id": "g5"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(tc.profile)
+			}))
+			defer srv.Close()
+
+			gs := &GoogleService{
+				userInfoURL:    srv.URL,
+				httpClient:     srv.Client(),
+				allowedDomains: []string{"district.k12.us", "academy.org"},
+			}
+			_, err := gs.fetchUserInfo(context.Background(), "valid-access-token")
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("fetchUserInfo: %v", err)
+				}
+				return
+			}
+			var appErr *apperrors.AppError
+			if !errors.As(err, &appErr) || appErr.Code != apperrors.ErrCodeDomainNotAllowed {
+				t.Fatalf("err = %v, want DOMAIN_NOT_ALLOWED", err)
+			}
+			if appErr.Status != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", appErr.Status, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestGoogleFetchUserInfo_NoDomainRestriction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": "g1", "email": "someone@gmail.com"})
+	}))
+	defer srv.Close()
+
+	gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client()}
+	if _, err := gs.fetchUserInfo(context.Background(), "valid-access-token"); err != nil {
+		t.Fatalf("fetchUserInfo: %v", err)
+	}
+}
+
+func TestGoogleGetAuthURL_HostedDomainHint(t *testing.T) {
+	tests := map[string]string{
+		"":                             "",
+		"District.K12.us":              "district.k12.us",
+		"district.k12.us, academy.org": "*",
+	}
+	for domains, want := range tests {
+		cfg := config.GoogleConfig{ClientID: "cid", RedirectURL: webCallback, AllowedDomains: domains}
+		gs := newGoogleService(cfg, newTestStateManager(t), defaultProviderBuilder)
+
+		authURL, err := gs.GetAuthURL(context.Background(), Flow{})
+		if err != nil {
+			t.Fatalf("%q: GetAuthURL: %v", domains, err)
+		}
+		u, _ := url.Parse(authURL)
+		q := u.Query()
+		if got := q.Get("hd"); got != want {
+			t.Errorf("%q: hd = %q, want %q", domains, got, want)
+		}
+		if _, set := q["hd"]; set != (want != "") {
+			t.Errorf("%q: hd present = %v, want %v", domains, set, want != "")
+		}
+	}
+}
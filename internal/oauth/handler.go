@@ -1,10 +1,17 @@
 package oauth
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/boddle/reservoir/internal/auth"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
 	"github.com/boddle/reservoir/pkg/response"
+	"github.com/boddle/reservoir/pkg/utctime"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // Handler handles OAuth HTTP requests
@@ -13,6 +20,10 @@ type Handler struct {
 	googleSvc   *GoogleService
 	cleverSvc   *CleverService
 	icloudSvc   *ICloudService
+	apps        auth.Apps
+	oidc        OIDCProviders
+	redirects   RedirectAllowlist
+	cookie      auth.AccessTokenCookie
 }
 
 // NewHandler creates a new OAuth handler
@@ -27,7 +38,7 @@ func NewHandler(authService *AuthService, googleSvc *GoogleService, cleverSvc *C
 
 // GoogleTokenAuth authenticates using a pre-obtained Google access token.
 // Called by LMS after OmniAuth has already completed the Google OAuth flow.
-// POST /auth/google { "token": "..." }
+// POST /auth/google[?token_only=true] { "token": "...", "app": "..." }
 //
 // Only the access token is trusted: Reservoir verifies it with Google and
 // derives the identity from Google's response. Any uid/email/name in the body
@@ -35,6 +46,7 @@ func NewHandler(authService *AuthService, googleSvc *GoogleService, cleverSvc *C
 func (h *Handler) GoogleTokenAuth(c *gin.Context) {
 	var req struct {
 		Token string `json:"token" binding:"required"`
+		App   string `json:"app"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -48,24 +60,22 @@ func (h *Handler) GoogleTokenAuth(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.AuthenticateWithGoogleToken(c.Request.Context(), req.Token)
+	ctx, ok := h.appContext(c, req.App)
+	if !ok {
+		return
+	}
+	result, err := h.authService.AuthenticateWithGoogleToken(ctx, req.Token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    "OAUTH_FAILED",
-				"message": err.Error(),
-			},
-		})
+		writeOAuthError(c, err)
 		return
 	}
 
-	response.Success(c, http.StatusOK, result)
+	response.Success(c, http.StatusOK, result.ForClient(c))
 }
 
 // CleverTokenAuth authenticates using a pre-obtained Clever access token.
 // Called by LMS after OmniAuth has already completed the Clever SSO flow.
-// POST /auth/clever { "token": "..." }
+// POST /auth/clever[?token_only=true] { "token": "...", "app": "..." }
 //
 // Only the access token is trusted: Reservoir verifies it with Clever and
 // derives the identity from Clever's response. Any uid/email/name in the body
@@ -73,6 +83,7 @@ func (h *Handler) GoogleTokenAuth(c *gin.Context) {
 func (h *Handler) CleverTokenAuth(c *gin.Context) {
 	var req struct {
 		Token string `json:"token" binding:"required"`
+		App   string `json:"app"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -86,31 +97,116 @@ func (h *Handler) CleverTokenAuth(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.AuthenticateWithCleverToken(c.Request.Context(), req.Token)
+	ctx, ok := h.appContext(c, req.App)
+	if !ok {
+		return
+	}
+	result, err := h.authService.AuthenticateWithCleverToken(ctx, req.Token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    "OAUTH_FAILED",
-				"message": err.Error(),
-			},
-		})
+		writeOAuthError(c, err)
 		return
 	}
 
-	response.Success(c, http.StatusOK, result)
+	response.Success(c, http.StatusOK, result.ForClient(c))
+}
+
+// SetApps sets the apps a sign-in may be scoped to: with ?app= on a redirect
+// flow, or "app" (or auth.AppHeader) on a token sign-in.
+func (h *Handler) SetApps(apps auth.Apps) {
+	h.apps = apps
+}
+
+// appContext returns the sign-in context of a token request: scoped to app,
+// or to the app named by auth.AppHeader when app is "", and signing in from
+// the device named by auth.DeviceFingerprintHeader. An unknown app is
+// answered with an error and ok false.
+func (h *Handler) appContext(c *gin.Context, app string) (ctx context.Context, ok bool) {
+	if app == "" {
+		app = c.GetHeader(auth.AppHeader)
+	}
+	if err := h.apps.Check(app); err != nil {
+		response.Error(c, err)
+		return nil, false
+	}
+	return auth.WithApp(deviceContext(c), app), true
+}
+
+// deviceContext returns the request context signing in from the device named
+// by auth.DeviceFingerprintHeader, if any.
+func deviceContext(c *gin.Context) context.Context {
+	return auth.WithDeviceFingerprint(c.Request.Context(), c.GetHeader(auth.DeviceFingerprintHeader))
+}
+
+// SetRedirectAllowlist sets where the redirect flows may send the client
+// once signed in. Without one, only paths on this host are allowed.
+func (h *Handler) SetRedirectAllowlist(a RedirectAllowlist) {
+	h.redirects = a
+}
+
+// SetAccessTokenCookie sets the cookie ?response=cookie flows put the access
+// token in.
+func (h *Handler) SetAccessTokenCookie(k auth.AccessTokenCookie) {
+	h.cookie = k
+}
+
+// responseParam picks how a redirect flow's callback answers: "json" (the
+// default) returns the login response as JSON; "cookie", for browser-only
+// flows, sets the access token cookie and redirects to redirect_url;
+// "fragment", for single-page apps, redirects to redirect_url with the token
+// pair in its fragment, which browsers keep out of logs and Referer headers.
+const (
+	responseParam    = "response"
+	responseCookie   = "cookie"
+	responseFragment = "fragment"
+)
+
+// callbackResponse reads responseParam for the Flow, writing a validation
+// error and returning false when it is not "json", "cookie" or "fragment".
+func callbackResponse(c *gin.Context) (string, bool) {
+	switch mode := c.Query(responseParam); mode {
+	case "", "json":
+		return "", true
+	case responseCookie, responseFragment:
+		return mode, true
+	}
+	response.ValidationError(c, "response must be json, cookie or fragment")
+	return "", false
 }
 
 // GoogleLogin initiates Google OAuth flow
-// GET /auth/google?redirect_url=...
+// GET /auth/google?redirect_url=...[&redirect_uri=...][&app=...][&response=cookie|fragment]
+// redirect_url is where the app lands after sign-in and must be on
+// OAUTH_REDIRECT_ALLOWLIST; redirect_uri picks the
+// OAuth callback from GOOGLE_REDIRECT_URL/GOOGLE_EXTRA_REDIRECT_URLS; app
+// scopes the tokens issued at the callback to one Boddle app (JWT_APPS);
+// response picks the callback's answer (see responseParam).
 func (h *Handler) GoogleLogin(c *gin.Context) {
 	redirectURL := c.Query("redirect_url")
 	if redirectURL == "" {
 		redirectURL = "/" // Default redirect
 	}
+	if !h.redirects.Allows(redirectURL) {
+		response.Error(c, apperrors.ErrRedirectURLNotAllowed)
+		return
+	}
+
+	app := c.Query("app")
+	if err := h.apps.Check(app); err != nil {
+		response.Error(c, err)
+		return
+	}
+	respMode, ok := callbackResponse(c)
+	if !ok {
+		return
+	}
 
 	// Generate OAuth URL
-	authURL, err := h.googleSvc.GetAuthURL(c.Request.Context(), redirectURL)
+	authURL, err := h.googleSvc.GetAuthURL(c.Request.Context(), Flow{
+		RedirectURL: redirectURL,
+		CallbackURI: c.Query("redirect_uri"),
+		App:         app,
+		Response:    respMode,
+	})
 	if err != nil {
 		response.Error(c, err)
 		return
@@ -123,53 +219,40 @@ func (h *Handler) GoogleLogin(c *gin.Context) {
 // GoogleCallback handles Google OAuth callback
 // GET /auth/google/callback?code=...&state=...
 func (h *Handler) GoogleCallback(c *gin.Context) {
-	code := c.Query("code")
-	state := c.Query("state")
-
-	if code == "" || state == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    "INVALID_REQUEST",
-				"message": "Missing code or state parameter",
-			},
-		})
-		return
-	}
-
-	// Authenticate with Google
-	result, redirectURL, err := h.authService.AuthenticateWithGoogle(c.Request.Context(), code, state)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    "OAUTH_FAILED",
-				"message": err.Error(),
-			},
-		})
-		return
-	}
-
-	// For web clients, we can redirect with token in URL (or use a different flow)
-	// For now, return JSON response
-	response.Success(c, http.StatusOK, gin.H{
-		"token":        result.Token,
-		"user":         result.User,
-		"meta":         result.Meta,
-		"redirect_url": redirectURL,
-	})
+	h.handleOAuthCallback(c, h.authService.AuthenticateWithGoogle)
 }
 
 // CleverLogin initiates Clever SSO flow
-// GET /auth/clever?redirect_url=...
+// GET /auth/clever?redirect_url=...[&redirect_uri=...][&app=...][&response=cookie|fragment]
+// redirect_uri picks the OAuth callback from CLEVER_REDIRECT_URL/
+// CLEVER_EXTRA_REDIRECT_URLS; app scopes the tokens as for Google.
 func (h *Handler) CleverLogin(c *gin.Context) {
 	redirectURL := c.Query("redirect_url")
 	if redirectURL == "" {
 		redirectURL = "/" // Default redirect
 	}
+	if !h.redirects.Allows(redirectURL) {
+		response.Error(c, apperrors.ErrRedirectURLNotAllowed)
+		return
+	}
+
+	app := c.Query("app")
+	if err := h.apps.Check(app); err != nil {
+		response.Error(c, err)
+		return
+	}
+	respMode, ok := callbackResponse(c)
+	if !ok {
+		return
+	}
 
 	// Generate OAuth URL
-	authURL, err := h.cleverSvc.GetAuthURL(c.Request.Context(), redirectURL)
+	authURL, err := h.cleverSvc.GetAuthURL(c.Request.Context(), Flow{
+		RedirectURL: redirectURL,
+		CallbackURI: c.Query("redirect_uri"),
+		App:         app,
+		Response:    respMode,
+	})
 	if err != nil {
 		response.Error(c, err)
 		return
@@ -182,6 +265,82 @@ func (h *Handler) CleverLogin(c *gin.Context) {
 // CleverCallback handles Clever OAuth callback
 // GET /auth/clever/callback?code=...&state=...
 func (h *Handler) CleverCallback(c *gin.Context) {
+	h.handleOAuthCallback(c, h.authService.AuthenticateWithClever)
+}
+
+// SetOIDCProviders serves the generic OIDC providers p at /auth/oidc/:name.
+func (h *Handler) SetOIDCProviders(p OIDCProviders) {
+	h.oidc = p
+}
+
+// OIDCLogin initiates the flow of a generic OIDC provider
+// GET /auth/oidc/:name?redirect_url=...[&app=...][&response=cookie|fragment]
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.oidc[c.Param("name")]
+	if !ok {
+		response.Error(c, apperrors.ErrNotFound)
+		return
+	}
+
+	redirectURL := c.Query("redirect_url")
+	if redirectURL == "" {
+		redirectURL = "/" // Default redirect
+	}
+	if !h.redirects.Allows(redirectURL) {
+		response.Error(c, apperrors.ErrRedirectURLNotAllowed)
+		return
+	}
+
+	app := c.Query("app")
+	if err := h.apps.Check(app); err != nil {
+		response.Error(c, err)
+		return
+	}
+	respMode, ok := callbackResponse(c)
+	if !ok {
+		return
+	}
+
+	authURL, err := provider.GetAuthURL(c.Request.Context(), Flow{
+		RedirectURL: redirectURL,
+		App:         app,
+		Response:    respMode,
+	})
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// OIDCCallback handles a generic OIDC provider's callback
+// GET /auth/oidc/:name/callback?code=...&state=...
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := h.oidc[name]; !ok {
+		response.Error(c, apperrors.ErrNotFound)
+		return
+	}
+	h.handleOAuthCallback(c, func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error) {
+		return h.authService.AuthenticateWithOIDC(ctx, name, code, state)
+	})
+}
+
+// callbackAuthFunc completes a redirect-based OAuth flow for one provider,
+// returning the login result and the flow saved with the state.
+type callbackAuthFunc func(ctx context.Context, code, state string) (*auth.LoginResponse, Flow, error)
+
+// handleOAuthCallback is the shared body of the provider callback handlers:
+// it extracts code/state, rejects a request missing either, runs authFn, and
+// writes the response. Keeping this in one place stops the providers drifting
+// apart in how they read parameters and report errors.
+//
+// A flow started with response=cookie gets the access token cookie and a
+// 302 to its redirect URL instead of JSON, and one started with
+// response=fragment a 302 to its redirect URL carrying the token pair in the
+// fragment; errors are JSON either way.
+func (h *Handler) handleOAuthCallback(c *gin.Context, authFn callbackAuthFunc) {
 	code := c.Query("code")
 	state := c.Query("state")
 
@@ -196,26 +355,43 @@ func (h *Handler) CleverCallback(c *gin.Context) {
 		return
 	}
 
-	// Authenticate with Clever
-	result, redirectURL, err := h.authService.AuthenticateWithClever(c.Request.Context(), code, state)
+	result, flow, err := authFn(deviceContext(c), code, state)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    "OAUTH_FAILED",
-				"message": err.Error(),
-			},
+		writeOAuthError(c, err)
+		return
+	}
+
+	switch flow.Response {
+	case responseCookie:
+		h.cookie.Set(c, result.Token)
+		c.Redirect(http.StatusFound, flow.RedirectURL)
+		return
+	case responseFragment:
+		target, err := buildRedirectWithToken(flow.RedirectURL, map[string]string{
+			"access_token":  result.Token.AccessToken,
+			"refresh_token": result.Token.RefreshToken,
+			"token_type":    result.Token.TokenType,
+			"expires_at":    result.Token.ExpiresAt.UTC().Format(utctime.Layout),
 		})
+		if err != nil {
+			response.Error(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, target)
 		return
 	}
 
-	// Return JSON response
-	response.Success(c, http.StatusOK, gin.H{
+	// For web clients, we can redirect with token in URL (or use a different flow)
+	// For now, return JSON response
+	body := gin.H{
 		"token":        result.Token,
-		"user":         result.User,
-		"meta":         result.Meta,
-		"redirect_url": redirectURL,
-	})
+		"redirect_url": flow.RedirectURL,
+	}
+	if !auth.TokenOnlyRequested(c) {
+		body["user"] = result.User
+		body["meta"] = result.Meta
+	}
+	response.Success(c, http.StatusOK, body)
 }
 
 // ICloudNonce issues a single-use nonce for Sign in with Apple. The client
@@ -253,13 +429,21 @@ func (h *Handler) ICloudNonce(c *gin.Context) {
 // The client completes Sign in with Apple (using a nonce from ICloudNonce) and
 // sends the resulting ID token. The server verifies it before issuing a JWT;
 // the caller can no longer assert a bare Apple UID (see LMS-6512).
-// POST /auth/icloud { "identity_token": "<apple-id-token>" }
+//
+// Apple reveals the user's name only on their first sign-in, in a separate
+// "user" value rather than the ID token; clients pass it on unchanged as
+// "user" (an object, or the JSON string Apple form-posts) so a linked
+// student or parent without a name gets one. A form post of Apple's own
+// id_token and user fields is accepted too.
+// POST /auth/icloud[?token_only=true] { "identity_token": "<apple-id-token>", "user": {...}, "app": "..." }
 func (h *Handler) ICloudAuth(c *gin.Context) {
 	var req struct {
-		IdentityToken string `json:"identity_token" binding:"required"`
+		IdentityToken string          `json:"identity_token" form:"id_token" binding:"required"`
+		User          json.RawMessage `json:"user" form:"-"`
+		App           string          `json:"app" form:"app"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error": gin.H{
@@ -271,21 +455,79 @@ func (h *Handler) ICloudAuth(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.AuthenticateWithiCloud(c.Request.Context(), req.IdentityToken)
+	rawUser := []byte(req.User)
+	if c.ContentType() != binding.MIMEJSON {
+		rawUser = []byte(c.PostForm("user"))
+	}
+	name, err := ParseAppleUser(rawUser)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error": gin.H{
-				"code":    "OAUTH_FAILED",
-				"message": err.Error(),
+				"code":    "INVALID_REQUEST",
+				"message": "user is not a valid Apple user object",
+				"details": err.Error(),
 			},
 		})
 		return
 	}
 
-	response.Success(c, http.StatusOK, gin.H{
-		"token": result.Token,
-		"user":  result.User,
-		"meta":  result.Meta,
+	ctx, ok := h.appContext(c, req.App)
+	if !ok {
+		return
+	}
+	result, err := h.authService.AuthenticateWithiCloud(ctx, req.IdentityToken, name)
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	body := gin.H{"token": result.Token}
+	if !auth.TokenOnlyRequested(c) {
+		body["user"] = result.User
+		body["meta"] = result.Meta
+	}
+	response.Success(c, http.StatusOK, body)
+}
+
+// writeOAuthError reports a failed OAuth sign-in. Errors that carry their own
+// code (e.g. TOO_MANY_LINKED_PROVIDERS) are passed through so the client can
+// tell them apart; a provider throttling us is a 503 carrying its
+// Retry-After; an identity matching no account is a 401 NO_LINKED_ACCOUNT
+// naming the provider (and the email, when known); anything else is a
+// generic 401 OAUTH_FAILED.
+func writeOAuthError(c *gin.Context, err error) {
+	var throttledErr *ThrottledError
+	if errors.As(err, &throttledErr) {
+		response.ServiceUnavailable(c, throttledErr.RetryAfter, throttledErr.Error())
+		return
+	}
+
+	var noAccountErr *NoLinkedAccountError
+	if errors.As(err, &noAccountErr) {
+		body := gin.H{
+			"code":     apperrors.ErrCodeNoLinkedAccount,
+			"message":  noAccountErr.Error(),
+			"provider": noAccountErr.Provider,
+		}
+		if noAccountErr.Email != "" {
+			body["email"] = noAccountErr.Email
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": body})
+		return
+	}
+
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		response.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"success": false,
+		"error": gin.H{
+			"code":    "OAUTH_FAILED",
+			"message": err.Error(),
+		},
 	})
 }
@@ -2,6 +2,7 @@ package oauth
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
@@ -40,6 +41,12 @@ type ICloudService struct {
 	httpClient       *http.Client
 	nonces           nonceStore
 
+	// Sign in with Apple key for client secrets (APPLE_TEAM_ID,
+	// APPLE_KEY_ID, APPLE_PRIVATE_KEY). Unset unless configured.
+	teamID        string
+	keyID         string
+	privateKeyPEM string
+
 	// JWKS cache. Apple rotates keys, so entries are refreshed past keysTTL and
 	// whenever a token references a kid we don't have.
 	mu          sync.RWMutex
@@ -52,23 +59,76 @@ type ICloudService struct {
 // allowlist; when empty the service fails closed (every verification errors)
 // rather than trusting unaudienced tokens.
 func NewICloudService(cfg config.ICloudConfig, redisClient *redis.Client) *ICloudService {
+	return newICloudService(cfg, redisClient, defaultProviderBuilder)
+}
+
+func newICloudService(cfg config.ICloudConfig, redisClient *redis.Client, b *providerBuilder) *ICloudService {
 	return &ICloudService{
 		issuer:           appleIssuer,
-		jwksURL:          appleJWKSURL,
+		jwksURL:          b.endpointURL(cfg.JWKSURL, appleJWKSURL),
 		allowedAudiences: parseAudienceList(cfg.ClientIDs),
-		httpClient:       &http.Client{Timeout: 10 * time.Second},
+		httpClient:       b.httpClient,
 		nonces:           &redisNonceStore{client: redisClient, ttl: 10 * time.Minute},
+		teamID:           cfg.TeamID,
+		keyID:            cfg.KeyID,
+		privateKeyPEM:    strings.ReplaceAll(cfg.PrivateKey, `\n`, "\n"),
 		keys:             map[string]*rsa.PublicKey{},
 		keysTTL:          1 * time.Hour,
 	}
 }
 
+// SetHTTPClient replaces the client used to fetch Apple's JWKS (see
+// NewProviderHTTPClient).
+func (is *ICloudService) SetHTTPClient(c *http.Client) {
+	is.httpClient = c
+}
+
 // Configured reports whether an audience allowlist is present. When false the
 // endpoint cannot verify tokens and rejects all requests.
 func (is *ICloudService) Configured() bool {
 	return len(is.allowedAudiences) > 0
 }
 
+// appleClientSecretTTL is how long a generated client secret is valid.
+// Apple accepts up to six months; a short life limits a leaked one.
+const appleClientSecretTTL = time.Hour
+
+// privateKey parses the Sign in with Apple .p8 key.
+func (is *ICloudService) privateKey() (*ecdsa.PrivateKey, error) {
+	if is.privateKeyPEM == "" {
+		return nil, fmt.Errorf("APPLE_PRIVATE_KEY is not set")
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(is.privateKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("parse APPLE_PRIVATE_KEY: %w", err)
+	}
+	return key, nil
+}
+
+// clientSecret signs the ES256 client secret Apple's token endpoint expects
+// in place of a static one: iss is the team, sub the first client ID.
+func (is *ICloudService) clientSecret(now time.Time) (string, error) {
+	if is.teamID == "" || is.keyID == "" {
+		return "", fmt.Errorf("APPLE_TEAM_ID and APPLE_KEY_ID must be set")
+	}
+	if !is.Configured() {
+		return "", fmt.Errorf("APPLE_CLIENT_IDS is not set")
+	}
+	key, err := is.privateKey()
+	if err != nil {
+		return "", err
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    is.teamID,
+		Subject:   is.allowedAudiences[0],
+		Audience:  jwt.ClaimStrings{appleIssuer},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appleClientSecretTTL)),
+	})
+	tok.Header["kid"] = is.keyID
+	return tok.SignedString(key)
+}
+
 // IssueNonce mints a single-use nonce, stores it, and returns it. The client
 // must feed this value into Sign in with Apple so it reappears as the `nonce`
 // claim of the resulting ID token.
@@ -153,6 +153,8 @@ func TestVerifyIDToken_Rejects(t *testing.T) {
 		{"missing sub", func(c jwt.MapClaims) { delete(c, "sub") }, "n1"},
 		{"nonce not issued", func(c jwt.MapClaims) { c["nonce"] = "never-issued" }, ""},
 		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, ""},
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "n2" }, "n1"},
+		{"missing nonce with one issued", func(c jwt.MapClaims) { delete(c, "nonce") }, "n1"},
 	}
 
 	for _, tc := range cases {
@@ -212,6 +214,19 @@ func TestVerifyIDToken_NonceIsSingleUse(t *testing.T) {
 	}
 }
 
+func TestVerifyIDToken_WrongNonceLeavesIssuedNonce(t *testing.T) {
+	h := newICloudTestHarness(t, []string{"com.boddle.app"})
+	h.nonces.preload("issued")
+
+	if _, err := h.svc.VerifyIDToken(context.Background(), h.sign(t, validClaims("replayed"))); err == nil {
+		t.Fatal("token carrying a nonce we never issued was accepted")
+	}
+	// The mismatch must not burn the nonce the genuine sign-in will carry.
+	if _, err := h.svc.VerifyIDToken(context.Background(), h.sign(t, validClaims("issued"))); err != nil {
+		t.Errorf("token carrying the issued nonce rejected: %v", err)
+	}
+}
+
 func TestVerifyIDToken_FailsClosedWhenUnconfigured(t *testing.T) {
 	h := newICloudTestHarness(t, nil) // no audiences configured
 	h.nonces.preload("n1")
@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+func uid(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func TestCheckLinkLimit(t *testing.T) {
+	s := &AuthService{maxLinkedProviders: map[string]int{"Teacher": 2, "Parent": 1}}
+
+	tests := []struct {
+		name     string
+		metaType string
+		meta     interface{}
+		provider string
+		wantErr  bool
+	}{
+		{"teacher first provider", "Teacher", &user.Teacher{}, "google", false},
+		{"teacher second provider", "Teacher", &user.Teacher{GoogleUID: uid("g-1")}, "clever", false},
+		{"teacher third provider", "Teacher", &user.Teacher{GoogleUID: uid("g-1"), CleverUID: uid("c-1")}, "icloud", true},
+		{"teacher relinking existing provider", "Teacher", &user.Teacher{GoogleUID: uid("g-1"), CleverUID: uid("c-1")}, "google", false},
+		{"parent at cap", "Parent", &user.Parent{ICloudUID: uid("a-1")}, "google", true},
+		{"uncapped meta type", "Student", &user.Student{GoogleUID: uid("g-1"), CleverUID: uid("c-1"), ICloudUID: uid("a-1")}, "google", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkLinkLimit(tt.metaType, tt.meta, tt.provider)
+			if tt.wantErr && !errors.Is(err, apperrors.ErrTooManyLinkedProviders) {
+				t.Errorf("err = %v, want ErrTooManyLinkedProviders", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected err: %v", err)
+			}
+		})
+	}
+}
+
+// A teacher already at the cap who signs in with a new provider matching
+// their email must be rejected without the UID being written.
+func TestFindOrCreateCleverUser_RejectsLinkOverCap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	s := NewAuthService(user.NewRepository(sqlxDB, sqlxDB), nil, nil, nil, nil, nil)
+	s.SetMaxLinkedProviders(map[string]int{"Teacher": 1})
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM teachers\s+WHERE clever_uid`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`FROM students\s+WHERE clever_uid`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("teacher@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+			"last_logged_on", "token_version", "locale", "created_at", "updated_at",
+		}).AddRow(1, "Ms. Frizzle", "teacher@example.com", "", "uid-1", "Teacher", 7, nil, 0, "", now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "first_name", "last_name", "google_uid", "clever_uid", "is_verified", "created_at", "updated_at",
+		}).AddRow(7, "Valerie", "Frizzle", "g-1", nil, true, now, now))
+	mock.ExpectRollback()
+
+	_, _, err = s.findOrCreateCleverUser(context.Background(), &OAuthUserInfo{
+		ProviderUserID: "c-1",
+		Email:          "teacher@example.com",
+	})
+	if !errors.Is(err, apperrors.ErrTooManyLinkedProviders) {
+		t.Fatalf("err = %v, want ErrTooManyLinkedProviders", err)
+	}
+	// No UPDATE teachers SET clever_uid was expected, so an attempted write
+	// would have failed the call above.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,83 @@
+package oauth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+)
+
+// countingEnqueuer is a last-login enqueuer safe for concurrent sign-ins.
+type countingEnqueuer struct{ n atomic.Int32 }
+
+func (c *countingEnqueuer) Enqueue(int) { c.n.Add(1) }
+
+func TestAuthenticateWithGoogle_ConcurrentCallbacksLinkOnce(t *testing.T) {
+	s, mock, _ := newGoogleTestService(t, &OAuthUserInfo{
+		ProviderUserID: "google-456",
+		Email:          "teacher@school.org",
+	})
+	enq := &countingEnqueuer{}
+	s.lastLogin = enq
+	queue, mr := newTestLinkRetryQueue(t)
+	s.linkRetries = queue
+	mock.MatchExpectationsInOrder(false)
+	now := time.Now()
+
+	// Both callbacks miss on the UID and find the teacher by email.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM teachers\s+WHERE google_uid`).WithArgs("google-456").WillReturnRows(sqlmock.NewRows(teacherColumns))
+		mock.ExpectQuery(`FROM students\s+WHERE google_uid`).WithArgs("google-456").WillReturnRows(sqlmock.NewRows(studentColumns))
+		mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("teacher@school.org").
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "Ms. Frizzle", "teacher@school.org", "", "uid-1", "Teacher", 7, nil, 0, "", now, now))
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+	}
+	// The row lock serializes them: whichever gets it first sees no UID and
+	// writes it; the other then sees the UID already linked.
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, nil, true, now, now))
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", "google-456", nil, true, now, now))
+	// Only one link write is expected; a second would fail and be queued.
+	mock.ExpectExec(`UPDATE teachers SET google_uid`).WithArgs("google-456", sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	metas := make([]interface{}, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+			errs[i] = err
+			if err == nil {
+				metas[i] = resp.Meta
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("callback %d: %v", i, err)
+		}
+		if teacher := metas[i].(*user.Teacher); teacher.GoogleUID.String != "google-456" {
+			t.Errorf("callback %d: GoogleUID = %q, want google-456 either way", i, teacher.GoogleUID.String)
+		}
+	}
+	if mr.Exists(linkRetryKey) {
+		t.Error("a second link write was attempted and queued for retry")
+	}
+	if enq.n.Load() != 2 {
+		t.Errorf("sign-ins = %d, want 2", enq.n.Load())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,259 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// linkRetryKey is the Redis list of provider links waiting to be retried.
+	linkRetryKey = "oauth:link_retry"
+
+	linkRetryInterval    = 30 * time.Second
+	maxLinkRetryAttempts = 5
+)
+
+// PendingLink is a provider UID that should be written to a meta record but
+// couldn't be during the login that discovered it.
+type PendingLink struct {
+	Provider    string    `json:"provider"` // "google" or "clever"
+	MetaType    string    `json:"meta_type"`
+	MetaID      int       `json:"meta_id"`
+	ProviderUID string    `json:"provider_uid"`
+	UserID      int       `json:"user_id"`
+	Attempts    int       `json:"attempts"`
+	QueuedAt    time.Time `json:"queued_at"`
+}
+
+// applyLink writes l's provider UID to its meta record.
+func applyLink(ctx context.Context, repo *user.Repository, l PendingLink) error {
+	switch {
+	case l.Provider == "google" && l.MetaType == "Teacher":
+		return repo.UpdateTeacherGoogleUID(ctx, l.MetaID, l.ProviderUID)
+	case l.Provider == "google" && l.MetaType == "Student":
+		return repo.UpdateStudentGoogleUID(ctx, l.MetaID, l.ProviderUID)
+	case l.Provider == "clever" && l.MetaType == "Teacher":
+		return repo.UpdateTeacherCleverUID(ctx, l.MetaID, l.ProviderUID)
+	case l.Provider == "clever" && l.MetaType == "Student":
+		return repo.UpdateStudentCleverUID(ctx, l.MetaID, l.ProviderUID)
+	default:
+		return fmt.Errorf("unsupported link: %s for %s", l.Provider, l.MetaType)
+	}
+}
+
+// linkWriteError is a failed UID write inside linkByEmail's transaction,
+// told apart from the errors that fail the sign-in.
+type linkWriteError struct {
+	err error
+}
+
+func (e *linkWriteError) Error() string { return e.err.Error() }
+
+func (e *linkWriteError) Unwrap() error { return e.err }
+
+// lockMeta reads the Teacher or Student meta record that links are written
+// to, holding its row lock for the rest of repo's transaction.
+func lockMeta(ctx context.Context, repo *user.Repository, metaType string, metaID int) (interface{}, error) {
+	switch metaType {
+	case "Teacher":
+		teacher, err := repo.LockTeacher(ctx, metaID)
+		if err != nil {
+			return nil, err
+		}
+		if teacher == nil {
+			return nil, fmt.Errorf("teacher meta not found")
+		}
+		return teacher, nil
+	case "Student":
+		student, err := repo.LockStudent(ctx, metaID)
+		if err != nil {
+			return nil, err
+		}
+		if student == nil {
+			return nil, fmt.Errorf("student meta not found")
+		}
+		return student, nil
+	default:
+		return nil, fmt.Errorf("unsupported link target: %s", metaType)
+	}
+}
+
+// linkedUID returns the provider UID meta already has, "" if none.
+func linkedUID(meta interface{}, provider string) string {
+	switch m := meta.(type) {
+	case *user.Teacher:
+		if provider == "google" {
+			return m.GoogleUID.String
+		}
+		return m.CleverUID.String
+	case *user.Student:
+		if provider == "google" {
+			return m.GoogleUID.String
+		}
+		return m.CleverUID.String
+	}
+	return ""
+}
+
+// setLinkedUID records on meta a provider UID just written to its row.
+func setLinkedUID(meta interface{}, provider, uid string) {
+	v := sql.NullString{String: uid, Valid: true}
+	switch m := meta.(type) {
+	case *user.Teacher:
+		if provider == "google" {
+			m.GoogleUID = v
+		} else {
+			m.CleverUID = v
+		}
+	case *user.Student:
+		if provider == "google" {
+			m.GoogleUID = v
+		} else {
+			m.CleverUID = v
+		}
+	}
+}
+
+// LinkRetryQueue holds provider links that failed during sign-in, in Redis,
+// and retries them in the background. A nil *LinkRetryQueue drops deferred
+// links; the next sign-in with that provider tries to link again anyway.
+type LinkRetryQueue struct {
+	client *redis.Client
+	logger *zap.Logger
+
+	// userCache, if set, is evicted for each link applied.
+	userCache *user.Cache
+}
+
+// NewLinkRetryQueue creates a link retry queue
+func NewLinkRetryQueue(client *redis.Client, logger *zap.Logger) *LinkRetryQueue {
+	return &LinkRetryQueue{client: client, logger: logger}
+}
+
+// SetUserCache makes q evict the linked user's cached /auth/me profile once a
+// deferred link is applied. Off until this is called.
+func (q *LinkRetryQueue) SetUserCache(c *user.Cache) {
+	q.userCache = c
+}
+
+// Defer queues l for retry after it failed with cause.
+func (q *LinkRetryQueue) Defer(ctx context.Context, l PendingLink, cause error) {
+	if q == nil {
+		return
+	}
+	if l.QueuedAt.IsZero() {
+		l.QueuedAt = time.Now().UTC()
+	}
+
+	logger := requestid.Logger(ctx, q.logger)
+	logFields := []zap.Field{
+		zap.String("provider", l.Provider),
+		zap.String("meta_type", l.MetaType),
+		zap.Int("meta_id", l.MetaID),
+		zap.Int("user_id", l.UserID),
+		zap.Int("attempts", l.Attempts),
+		zap.Error(cause),
+	}
+
+	data, err := json.Marshal(l)
+	if err == nil {
+		err = q.client.RPush(ctx, linkRetryKey, data).Err()
+	}
+	if err != nil {
+		logger.Error("failed to queue provider link for retry; link dropped",
+			append(logFields, zap.NamedError("queue_error", err))...)
+		return
+	}
+	logger.Warn("provider link failed; queued for retry", logFields...)
+}
+
+// Run retries queued links with apply every linkRetryInterval until ctx is
+// cancelled. apply is normally AuthService.ApplyPendingLink. Meant to run
+// under a background.Group.
+func (q *LinkRetryQueue) Run(ctx context.Context, apply func(context.Context, PendingLink) error) {
+	if q == nil {
+		return
+	}
+	ticker := time.NewTicker(linkRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.drain(ctx, apply)
+		}
+	}
+}
+
+// drain makes one pass over the links queued when it starts. Links that fail
+// again go back on the queue until they have had maxLinkRetryAttempts; one
+// the account no longer has room for is dropped.
+func (q *LinkRetryQueue) drain(ctx context.Context, apply func(context.Context, PendingLink) error) {
+	n, err := q.client.LLen(ctx, linkRetryKey).Result()
+	if err != nil {
+		q.logger.Warn("failed to read provider link retry queue", zap.Error(err))
+		return
+	}
+
+	for i := int64(0); i < n; i++ {
+		raw, err := q.client.LPop(ctx, linkRetryKey).Result()
+		if err == redis.Nil {
+			return
+		}
+		if err != nil {
+			q.logger.Warn("failed to read provider link retry queue", zap.Error(err))
+			return
+		}
+
+		var l PendingLink
+		if err := json.Unmarshal([]byte(raw), &l); err != nil {
+			q.logger.Error("dropping malformed provider link retry", zap.String("entry", raw), zap.Error(err))
+			continue
+		}
+
+		err = apply(ctx, l)
+		if err == nil {
+			if err := q.userCache.Invalidate(ctx, l.UserID); err != nil {
+				q.logger.Warn("failed to invalidate user cache", zap.Int("user_id", l.UserID), zap.Error(err))
+			}
+			q.logger.Info("deferred provider link applied",
+				zap.String("provider", l.Provider),
+				zap.Int("user_id", l.UserID),
+				zap.Int("attempts", l.Attempts+1),
+			)
+			continue
+		}
+		if errors.Is(err, apperrors.ErrTooManyLinkedProviders) {
+			q.logger.Warn("dropping provider link: account is at its linked-provider limit",
+				zap.String("provider", l.Provider),
+				zap.String("meta_type", l.MetaType),
+				zap.Int("meta_id", l.MetaID),
+				zap.Int("user_id", l.UserID),
+			)
+			continue
+		}
+		l.Attempts++
+		if l.Attempts >= maxLinkRetryAttempts {
+			q.logger.Error("giving up on provider link",
+				zap.String("provider", l.Provider),
+				zap.String("meta_type", l.MetaType),
+				zap.Int("meta_id", l.MetaID),
+				zap.Int("user_id", l.UserID),
+				zap.Error(err),
+			)
+			continue
+		}
+		q.Defer(ctx, l, err)
+	}
+}
@@ -0,0 +1,143 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestLinkRetryQueue(t *testing.T) (*LinkRetryQueue, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewLinkRetryQueue(client, zap.NewNop()), mr
+}
+
+func TestAuthenticateWithGoogle_LinkFailureIsDeferred(t *testing.T) {
+	s, mock, _ := newGoogleTestService(t, &OAuthUserInfo{
+		ProviderUserID: "google-456",
+		Email:          "teacher@school.org",
+	})
+	queue, mr := newTestLinkRetryQueue(t)
+	s.linkRetries = queue
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM teachers\s+WHERE google_uid`).WillReturnRows(sqlmock.NewRows(teacherColumns))
+	mock.ExpectQuery(`FROM students\s+WHERE google_uid`).WillReturnRows(sqlmock.NewRows(studentColumns))
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "Ms. Frizzle", "teacher@school.org", "", "uid-1", "Teacher", 7, nil, 0, "", now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, nil, true, now, now))
+	mock.ExpectExec(`UPDATE teachers SET google_uid`).
+		WithArgs("google-456", sqlmock.AnyArg(), 7).
+		WillReturnError(errors.New("connection reset by peer"))
+	mock.ExpectRollback()
+
+	resp, _, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+	if err != nil {
+		t.Fatalf("login failed because the link write failed: %v", err)
+	}
+	if resp.Token == nil || resp.User.ID != 1 {
+		t.Errorf("got user %d, token %v; want user 1 with a token", resp.User.ID, resp.Token)
+	}
+	if teacher := resp.Meta.(*user.Teacher); teacher.GoogleUID.Valid {
+		t.Error("response reports the Google UID as linked though the write failed")
+	}
+
+	queued, err := mr.List(linkRetryKey)
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("retry queue = %v, %v; want one entry", queued, err)
+	}
+	var link PendingLink
+	if err := json.Unmarshal([]byte(queued[0]), &link); err != nil {
+		t.Fatalf("queued entry: %v", err)
+	}
+	if link.Provider != "google" || link.MetaType != "Teacher" || link.MetaID != 7 || link.ProviderUID != "google-456" || link.UserID != 1 {
+		t.Errorf("queued link = %+v", link)
+	}
+
+	// The background retry applies it once the database recovers, and drops
+	// the profile cached without the link.
+	cache := user.NewCache(time.Hour, nil)
+	cache.Set(&user.UserWithMeta{User: user.User{ID: 1}})
+	queue.SetUserCache(cache)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, nil, true, now, now))
+	mock.ExpectExec(`UPDATE teachers SET google_uid`).
+		WithArgs("google-456", sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	queue.drain(context.Background(), s.ApplyPendingLink)
+
+	if mr.Exists(linkRetryKey) {
+		t.Error("link still queued after a successful retry")
+	}
+	if _, ok := cache.Get(1); ok {
+		t.Error("cached profile survived the deferred link")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLinkRetryQueue_GivesUpAfterMaxAttempts(t *testing.T) {
+	queue, mr := newTestLinkRetryQueue(t)
+	attempts := 0
+	apply := func(context.Context, PendingLink) error {
+		attempts++
+		return errors.New("timeout")
+	}
+
+	queue.Defer(context.Background(), PendingLink{Provider: "clever", MetaType: "Student", MetaID: 3, ProviderUID: "c-3"}, errors.New("timeout"))
+	for i := 0; i < maxLinkRetryAttempts; i++ {
+		queue.drain(context.Background(), apply)
+	}
+
+	if mr.Exists(linkRetryKey) {
+		t.Errorf("link still queued after %d failed retries", maxLinkRetryAttempts)
+	}
+	if attempts != maxLinkRetryAttempts {
+		t.Errorf("applied %d times, want %d", attempts, maxLinkRetryAttempts)
+	}
+}
+
+func TestApplyPendingLink_RechecksLinkLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	s := NewAuthService(user.NewRepository(sqlxDB, sqlxDB), nil, nil, nil, nil, nil)
+	s.SetMaxLinkedProviders(map[string]int{"Teacher": 1})
+	queue, mr := newTestLinkRetryQueue(t)
+	now := time.Now()
+
+	// Since the Google link was deferred, the teacher linked Clever and so
+	// used up their one provider. The retry must not write.
+	queue.Defer(context.Background(), PendingLink{Provider: "google", MetaType: "Teacher", MetaID: 7, ProviderUID: "google-456", UserID: 1}, errors.New("timeout"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, "clever-9", true, now, now))
+	mock.ExpectRollback()
+	queue.drain(context.Background(), s.ApplyPendingLink)
+
+	if mr.Exists(linkRetryKey) {
+		t.Error("link over the limit was queued again")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,32 @@
+package oauth
+
+// NoLinkedAccountError reports a sign-in the provider vouched for that
+// matches no Boddle account. The handlers answer NO_LINKED_ACCOUNT rather
+// than OAUTH_FAILED, so the client can offer signup instead. Email is the
+// provider's email for the user, set only if the provider verified it and
+// revealing it is enabled (see AuthService.SetRevealNoLinkedAccountEmail),
+// for prefilling the signup form.
+type NoLinkedAccountError struct {
+	Provider string
+	Email    string
+	message  string
+}
+
+func (e *NoLinkedAccountError) Error() string {
+	return e.message
+}
+
+// SetRevealNoLinkedAccountEmail makes NO_LINKED_ACCOUNT responses carry the
+// provider's verified email for the user. Off until this is called.
+func (s *AuthService) SetRevealNoLinkedAccountEmail(reveal bool) {
+	s.revealNoLinkedAccountEmail = reveal
+}
+
+// noLinkedAccount is the error for info, from provider, matching no account.
+func (s *AuthService) noLinkedAccount(provider, message string, info *OAuthUserInfo) error {
+	err := &NoLinkedAccountError{Provider: provider, message: message}
+	if s.revealNoLinkedAccountEmail && info.EmailVerified {
+		err.Email = info.Email
+	}
+	return err
+}
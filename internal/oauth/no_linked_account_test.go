@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/jmoiron/sqlx"
+)
+
+func (f *fakeProvider) VerifyIDToken(ctx context.Context, idToken string) (*OAuthUserInfo, error) {
+	return f.info, nil
+}
+
+// newNoAccountTestService serves info from every provider, with no
+// accounts in the database.
+func newNoAccountTestService(t *testing.T, info *OAuthUserInfo) (*AuthService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	p := &fakeProvider{info: info}
+	s := NewAuthService(
+		user.NewRepository(sqlxDB, sqlxDB),
+		token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour),
+		p, p, p, &recordingEnqueuer{},
+	)
+	return s, mock
+}
+
+func TestNoLinkedAccount_DistinctFromOtherFailures(t *testing.T) {
+	empty := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}) }
+	signIns := map[string]struct {
+		expect func(sqlmock.Sqlmock)
+		signIn func(*AuthService) error
+	}{
+		"google": {
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`FROM teachers\s+WHERE google_uid`).WillReturnRows(empty())
+				m.ExpectQuery(`FROM students\s+WHERE google_uid`).WillReturnRows(empty())
+				m.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(empty())
+			},
+			signIn: func(s *AuthService) error {
+				_, _, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+				return err
+			},
+		},
+		"clever": {
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`FROM teachers\s+WHERE clever_uid`).WillReturnRows(empty())
+				m.ExpectQuery(`FROM students\s+WHERE clever_uid`).WillReturnRows(empty())
+				m.ExpectQuery(`FROM users\s+WHERE email`).WillReturnRows(empty())
+			},
+			signIn: func(s *AuthService) error {
+				_, err := s.AuthenticateWithCleverToken(context.Background(), "access-token")
+				return err
+			},
+		},
+		"icloud": {
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`FROM students\s+WHERE icloud_uid`).WillReturnRows(empty())
+				m.ExpectQuery(`FROM parents\s+WHERE icloud_uid`).WillReturnRows(empty())
+			},
+			signIn: func(s *AuthService) error {
+				_, err := s.AuthenticateWithiCloud(context.Background(), "id-token", AppleName{})
+				return err
+			},
+		},
+	}
+
+	for provider, tc := range signIns {
+		for _, verified := range []bool{true, false} {
+			info := &OAuthUserInfo{ProviderUserID: "uid-1", Email: "new@school.edu", EmailVerified: verified}
+			s, mock := newNoAccountTestService(t, info)
+			s.SetRevealNoLinkedAccountEmail(true)
+			tc.expect(mock)
+
+			err := tc.signIn(s)
+			var noAccount *NoLinkedAccountError
+			if !errors.As(err, &noAccount) {
+				t.Fatalf("%s: err = %v, want NoLinkedAccountError", provider, err)
+			}
+			if noAccount.Provider != provider {
+				t.Errorf("%s: Provider = %q", provider, noAccount.Provider)
+			}
+			wantEmail := ""
+			if verified {
+				wantEmail = "new@school.edu"
+			}
+			if noAccount.Email != wantEmail {
+				t.Errorf("%s (verified=%v): Email = %q, want %q", provider, verified, noAccount.Email, wantEmail)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("%s: %v", provider, err)
+			}
+		}
+	}
+}
+
+func TestNoLinkedAccount_EmailHiddenUnlessRevealed(t *testing.T) {
+	s, _ := newNoAccountTestService(t, nil)
+	err := s.noLinkedAccount("google", "no account", &OAuthUserInfo{Email: "new@school.edu", EmailVerified: true})
+	if err.(*NoLinkedAccountError).Email != "" {
+		t.Error("email revealed without SetRevealNoLinkedAccountEmail")
+	}
+}
+
+func TestWriteOAuthError_NoLinkedAccount(t *testing.T) {
+	for _, email := range []string{"new@school.edu", ""} {
+		c, w := newCallbackContext("/auth/google/callback")
+		writeOAuthError(c, &NoLinkedAccountError{Provider: "google", Email: email, message: "no account found"})
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", w.Code)
+		}
+		var resp struct {
+			Error map[string]string `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Error["code"] != "NO_LINKED_ACCOUNT" || resp.Error["provider"] != "google" {
+			t.Errorf("error = %v, want NO_LINKED_ACCOUNT from google", resp.Error)
+		}
+		if got, ok := resp.Error["email"]; got != email || ok != (email != "") {
+			t.Errorf("email = %q (present %v), want %q", got, ok, email)
+		}
+	}
+
+	c, w := newCallbackContext("/auth/google/callback")
+	writeOAuthError(c, errors.New("failed to exchange code"))
+	if code := decodeErrorCode(t, w); code != "OAUTH_FAILED" {
+		t.Errorf("other failures: code = %q, want OAUTH_FAILED", code)
+	}
+}
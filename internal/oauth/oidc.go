@@ -0,0 +1,360 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// oidcDiscoveryPath is where an issuer publishes its discovery document
+// (OpenID Connect Discovery 1.0 §4).
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// oidcDocument is the part of a discovery document we use.
+type oidcDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// GenericOIDCService signs users in with any OpenID Connect provider given
+// only its issuer URL: the authorization, token and JWKS endpoints come from
+// the issuer's discovery document. The identity is read from the ID token,
+// whose signature, issuer, audience, expiry and nonce are all verified, so
+// no userinfo call is needed.
+//
+// The flow uses PKCE, and the nonce is derived from the flow's code_verifier,
+// so it is bound to the state without storing anything more.
+type GenericOIDCService struct {
+	name         string
+	issuer       string
+	config       *oauth2.Config
+	stateManager StateManager
+	httpClient   *http.Client
+	ttl          time.Duration
+
+	// Discovery and JWKS cache, refreshed past ttl. The keys are also
+	// refreshed whenever a token references a kid we don't have.
+	mu          sync.RWMutex
+	doc         *oidcDocument
+	docFetched  time.Time
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// NewGenericOIDCService creates the OIDC provider name, caching its
+// discovery document and keys for ttl.
+func NewGenericOIDCService(name string, cfg config.OIDCProviderConfig, ttl time.Duration, stateManager StateManager) *GenericOIDCService {
+	return newGenericOIDCService(name, cfg, ttl, stateManager, defaultProviderBuilder)
+}
+
+func newGenericOIDCService(name string, cfg config.OIDCProviderConfig, ttl time.Duration, stateManager StateManager, b *providerBuilder) *GenericOIDCService {
+	scopes := []string{"openid"}
+	for _, s := range cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return &GenericOIDCService{
+		name:   name,
+		issuer: cfg.Issuer,
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+		},
+		stateManager: stateManager,
+		httpClient:   b.httpClient,
+		ttl:          ttl,
+		keys:         map[string]*rsa.PublicKey{},
+	}
+}
+
+// Name is the provider's configured name, as in /auth/oidc/:name.
+func (o *GenericOIDCService) Name() string {
+	return o.name
+}
+
+// stateProvider is the provider name the flow's state is issued for, so a
+// state from one OIDC provider can't complete another's flow.
+func (o *GenericOIDCService) stateProvider() string {
+	return "oidc:" + o.name
+}
+
+// GetAuthURL generates the provider's authorization URL. flow.CallbackURI
+// must be empty or the configured redirect URL.
+func (o *GenericOIDCService) GetAuthURL(ctx context.Context, flow Flow) (string, error) {
+	callbackURI, err := newCallbackURIs(o.config.RedirectURL, "").resolve(flow.CallbackURI)
+	if err != nil {
+		return "", err
+	}
+	doc, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	flow.CallbackURI = callbackURI
+	flow.CodeVerifier, err = newCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	state, err := o.stateManager.IssueState(ctx, o.stateProvider(), flow)
+	if err != nil {
+		return "", err
+	}
+
+	opts := append(challengeOptions(flow.CodeVerifier), oauth2.SetAuthURLParam("nonce", oidcNonce(flow.CodeVerifier)))
+	return o.oauthConfig(doc).AuthCodeURL(state, opts...), nil
+}
+
+// HandleCallback validates the state, exchanges the code and returns the
+// identity asserted by the verified ID token.
+func (o *GenericOIDCService) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error) {
+	flow, err := o.stateManager.ValidateState(ctx, o.stateProvider(), state)
+	if err != nil {
+		return nil, Flow{}, fmt.Errorf("invalid state: %w", err)
+	}
+	if flow.CodeVerifier == "" {
+		return nil, Flow{}, fmt.Errorf("invalid state: no PKCE verifier")
+	}
+
+	doc, err := o.discover(ctx)
+	if err != nil {
+		return nil, Flow{}, err
+	}
+	tok, err := o.oauthConfig(doc).Exchange(exchangeContext(ctx, o.httpClient), code, verifierOption(flow.CodeVerifier)...)
+	if err != nil {
+		return nil, Flow{}, fmt.Errorf("failed to exchange code: %w", exchangeError(o.name, err))
+	}
+	rawIDToken, _ := tok.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, Flow{}, fmt.Errorf("%s returned no ID token", o.name)
+	}
+
+	info, err := o.verifyIDToken(ctx, rawIDToken, oidcNonce(flow.CodeVerifier))
+	if err != nil {
+		return nil, Flow{}, err
+	}
+	return info, flow, nil
+}
+
+// verifyIDToken checks rawIDToken's signature against the provider's JWKS,
+// its issuer, audience and expiry, and that it carries nonce.
+func (o *GenericOIDCService) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OAuthUserInfo, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(o.issuer),
+		jwt.WithAudience(o.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, o.keyFunc(ctx)); err != nil {
+		return nil, fmt.Errorf("invalid %s ID token: %w", o.name, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("invalid %s ID token: nonce mismatch", o.name)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("invalid %s ID token: missing sub", o.name)
+	}
+
+	email, _ := claims["email"].(string)
+	givenName, _ := claims["given_name"].(string)
+	familyName, _ := claims["family_name"].(string)
+	picture, _ := claims["picture"].(string)
+	locale, _ := claims["locale"].(string)
+	emailVerified := claims["email_verified"]
+	return &OAuthUserInfo{
+		ProviderUserID: sub,
+		Email:          email,
+		FirstName:      givenName,
+		LastName:       familyName,
+		Picture:        picture,
+		EmailVerified:  emailVerified == "true" || emailVerified == true,
+		Locale:         locale,
+	}, nil
+}
+
+// oauthConfig is the provider's config with doc's endpoints.
+func (o *GenericOIDCService) oauthConfig(doc *oidcDocument) *oauth2.Config {
+	c := *o.config
+	c.Endpoint = oauth2.Endpoint{AuthURL: doc.AuthorizationEndpoint, TokenURL: doc.TokenEndpoint}
+	return &c
+}
+
+// discover returns the provider's discovery document, fetching it if the
+// cached one is older than ttl. A stale document is used if the fetch fails.
+func (o *GenericOIDCService) discover(ctx context.Context) (*oidcDocument, error) {
+	o.mu.RLock()
+	doc := o.doc
+	fresh := time.Since(o.docFetched) < o.ttl
+	o.mu.RUnlock()
+	if doc != nil && fresh {
+		return doc, nil
+	}
+
+	fetched, err := o.fetchDiscovery(ctx)
+	if err != nil {
+		if doc != nil {
+			return doc, nil
+		}
+		return nil, err
+	}
+	o.mu.Lock()
+	o.doc = fetched
+	o.docFetched = time.Now()
+	o.mu.Unlock()
+	return fetched, nil
+}
+
+func (o *GenericOIDCService) fetchDiscovery(ctx context.Context) (*oidcDocument, error) {
+	var doc oidcDocument
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.issuer, "/")+oidcDiscoveryPath, &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s discovery document: %w", o.name, err)
+	}
+	// The document must be the issuer's own (Discovery §4.3), or tokens it
+	// points us at would be checked against the wrong issuer.
+	if doc.Issuer != o.issuer {
+		return nil, fmt.Errorf("%s discovery document is for issuer %q, want %q", o.name, doc.Issuer, o.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery document is missing an endpoint", o.name)
+	}
+	return &doc, nil
+}
+
+func (o *GenericOIDCService) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("missing kid header")
+		}
+		return o.publicKey(ctx, kid)
+	}
+}
+
+func (o *GenericOIDCService) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	o.mu.RLock()
+	key, ok := o.keys[kid]
+	fresh := time.Since(o.keysFetched) < o.ttl
+	o.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := o.refreshKeys(ctx); err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	o.mu.RLock()
+	key, ok = o.keys[kid]
+	o.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no %s signing key for kid %q", o.name, kid)
+	}
+	return key, nil
+}
+
+func (o *GenericOIDCService) refreshKeys(ctx context.Context) error {
+	doc, err := o.discover(ctx)
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, doc.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch %s JWKS: %w", o.name, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pk, err := parseRSAPublicKey(k.N, k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s JWKS contained no usable RSA keys", o.name)
+	}
+
+	o.mu.Lock()
+	o.keys = keys
+	o.keysFetched = time.Now()
+	o.mu.Unlock()
+	return nil
+}
+
+// getJSON fetches url and decodes its JSON body into v.
+func (o *GenericOIDCService) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := throttled(o.name, resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// oidcNonce derives the nonce for the flow with PKCE verifier: a hash, so
+// the verifier itself never appears in the authorization URL.
+func oidcNonce(verifier string) string {
+	sum := sha256.Sum256([]byte("oidc-nonce:" + verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// OIDCProviders are the configured generic OIDC providers by name.
+type OIDCProviders map[string]*GenericOIDCService
+
+// NewOIDCProviders creates a GenericOIDCService for each provider in cfg.
+func NewOIDCProviders(cfg config.OIDCConfig, stateManager StateManager) OIDCProviders {
+	providers := make(OIDCProviders, len(cfg.Named))
+	for name, p := range cfg.Named {
+		providers[name] = NewGenericOIDCService(name, p, cfg.DiscoveryTTL, stateManager)
+	}
+	return providers
+}
+
+// SetHTTPClient replaces every provider's client for discovery, JWKS and
+// token exchange (see NewProviderHTTPClient).
+func (p OIDCProviders) SetHTTPClient(c *http.Client) {
+	for _, o := range p {
+		o.httpClient = c
+	}
+}
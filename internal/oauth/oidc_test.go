@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDCProvider is an httptest OpenID Connect provider. Its token
+// endpoint mints an ID token for the exchanged code_verifier's nonce, which
+// mutate may then alter.
+type fakeOIDCProvider struct {
+	srv         *httptest.Server
+	priv        *rsa.PrivateKey
+	discoveries atomic.Int32
+	issuer      string // "" means srv.URL
+	mutate      func(jwt.MapClaims)
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	p := &fakeOIDCProvider{priv: priv}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		p.discoveries.Add(1)
+		issuer := p.issuer
+		if issuer == "" {
+			issuer = p.srv.URL
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		claims := jwt.MapClaims{
+			"iss":            p.srv.URL,
+			"aud":            "partner-client",
+			"sub":            "partner-sub-1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          oidcNonce(r.PostForm.Get("code_verifier")),
+			"email":          "teacher@school.edu",
+			"email_verified": true,
+			"given_name":     "Ada",
+			"family_name":    "Lovelace",
+		}
+		if p.mutate != nil {
+			p.mutate(claims)
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tok.Header["kid"] = "k1"
+		idToken, _ := tok.SignedString(priv)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at", "token_type": "Bearer", "id_token": idToken,
+		})
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) service(t *testing.T) *GenericOIDCService {
+	t.Helper()
+	return newGenericOIDCService("partner", config.OIDCProviderConfig{
+		Issuer:       p.srv.URL,
+		ClientID:     "partner-client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/partner/callback",
+		Scopes:       []string{"email", "profile"},
+	}, time.Hour, newTestStateManager(t), &providerBuilder{httpClient: p.srv.Client()})
+}
+
+// signIn runs the flow through GetAuthURL and HandleCallback.
+func (p *fakeOIDCProvider) signIn(t *testing.T, svc *GenericOIDCService) (*OAuthUserInfo, *url.URL, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, err := svc.GetAuthURL(ctx, Flow{RedirectURL: "/classes"})
+	if err != nil {
+		t.Fatalf("GetAuthURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	info, _, err := svc.HandleCallback(ctx, "code", u.Query().Get("state"))
+	return info, u, err
+}
+
+func TestGenericOIDCService_SignIn(t *testing.T) {
+	p := newFakeOIDCProvider(t)
+	svc := p.service(t)
+
+	info, authURL, err := p.signIn(t, svc)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if !strings.HasPrefix(authURL.String(), p.srv.URL+"/authorize?") {
+		t.Errorf("auth URL %q isn't the discovered authorization endpoint", authURL)
+	}
+	q := authURL.Query()
+	if q.Get("nonce") == "" || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("auth URL lacks nonce or PKCE challenge: %v", q)
+	}
+	if scope := q.Get("scope"); scope != "openid email profile" {
+		t.Errorf("scope = %q, want %q", scope, "openid email profile")
+	}
+	want := OAuthUserInfo{ProviderUserID: "partner-sub-1", Email: "teacher@school.edu", FirstName: "Ada", LastName: "Lovelace", EmailVerified: true}
+	if *info != want {
+		t.Errorf("info = %+v, want %+v", *info, want)
+	}
+
+	// The discovery document is cached for the TTL.
+	if _, _, err := p.signIn(t, svc); err != nil {
+		t.Fatalf("second sign-in: %v", err)
+	}
+	if n := p.discoveries.Load(); n != 1 {
+		t.Errorf("discovery document fetched %d times, want 1", n)
+	}
+}
+
+func TestGenericOIDCService_RejectsBadIDTokens(t *testing.T) {
+	cases := map[string]func(jwt.MapClaims){
+		"wrong nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"missing nonce":  func(c jwt.MapClaims) { delete(c, "nonce") },
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "some-other-client" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"missing sub":    func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeOIDCProvider(t)
+			p.mutate = mutate
+			if _, _, err := p.signIn(t, p.service(t)); err == nil {
+				t.Error("ID token accepted")
+			}
+		})
+	}
+}
+
+func TestGenericOIDCService_RejectsDiscoveryForAnotherIssuer(t *testing.T) {
+	p := newFakeOIDCProvider(t)
+	p.issuer = "https://evil.example.com"
+
+	if _, err := p.service(t).GetAuthURL(context.Background(), Flow{}); err == nil {
+		t.Error("discovery document for another issuer accepted")
+	}
+}
+
+func TestGenericOIDCService_StateIsPerProvider(t *testing.T) {
+	p := newFakeOIDCProvider(t)
+	sm := newTestStateManager(t)
+	svc := p.service(t)
+	svc.stateManager = sm
+	state, err := sm.IssueState(context.Background(), "google", Flow{CodeVerifier: "v"})
+	if err != nil {
+		t.Fatalf("IssueState: %v", err)
+	}
+
+	if _, _, err := svc.HandleCallback(context.Background(), "code", state); err == nil {
+		t.Error("another provider's state completed the OIDC flow")
+	}
+}
+
+func TestAuthenticateWithOIDC_MatchesVerifiedEmail(t *testing.T) {
+	p := newFakeOIDCProvider(t)
+	s, mock, enq := newGoogleTestService(t, nil)
+	svc := p.service(t)
+	s.SetOIDCProviders(OIDCProviders{"partner": svc})
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM users\s+WHERE email`).WithArgs("teacher@school.edu").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(3, "", "teacher@school.edu", "", nil, "Teacher", 7, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM users\s+WHERE id`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(3, "", "teacher@school.edu", "", nil, "Teacher", 7, nil, 0, "", now, now))
+	mock.ExpectQuery(`FROM teachers\s+WHERE id`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, nil, true, now, now))
+
+	ctx := context.Background()
+	authURL, err := svc.GetAuthURL(ctx, Flow{RedirectURL: "/classes"})
+	if err != nil {
+		t.Fatalf("GetAuthURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+
+	resp, flow, err := s.AuthenticateWithOIDC(ctx, "partner", "code", u.Query().Get("state"))
+	if err != nil {
+		t.Fatalf("AuthenticateWithOIDC: %v", err)
+	}
+	if resp.User.ID != 3 || flow.RedirectURL != "/classes" {
+		t.Errorf("user %d, redirect %q; want 3, /classes", resp.User.ID, flow.RedirectURL)
+	}
+	if len(enq.ids) != 1 {
+		t.Errorf("last_logged_on enqueued %d times, want 1", len(enq.ids))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := s.AuthenticateWithOIDC(ctx, "unknown", "code", "state"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("unknown provider: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAuthenticateWithOIDC_RejectsUnverifiedEmail(t *testing.T) {
+	p := newFakeOIDCProvider(t)
+	p.mutate = func(c jwt.MapClaims) { c["email_verified"] = false }
+	s, mock, _ := newGoogleTestService(t, nil)
+	svc := p.service(t)
+	s.SetOIDCProviders(OIDCProviders{"partner": svc})
+
+	authURL, err := svc.GetAuthURL(context.Background(), Flow{})
+	if err != nil {
+		t.Fatalf("GetAuthURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	_, _, err = s.AuthenticateWithOIDC(context.Background(), "partner", "code", u.Query().Get("state"))
+	if !errors.Is(err, apperrors.ErrEmailNotVerified) {
+		t.Errorf("err = %v, want ErrEmailNotVerified", err)
+	}
+	// No account is looked up by an unverified email.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/boddle/reservoir/internal/config"
+)
+
+func TestNewCodeVerifier(t *testing.T) {
+	// RFC 7636 §4.1: 43-128 characters of [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~".
+	unreserved := regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		v, err := newCodeVerifier()
+		if err != nil {
+			t.Fatalf("newCodeVerifier: %v", err)
+		}
+		if !unreserved.MatchString(v) {
+			t.Fatalf("verifier %q is not 43-128 unreserved characters", v)
+		}
+		if seen[v] {
+			t.Fatalf("verifier %q generated twice", v)
+		}
+		seen[v] = true
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// The example from RFC 7636 Appendix B.
+	got := codeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("codeChallengeS256 = %q, want %q", got, want)
+	}
+}
+
+func TestGoogleService_PKCE(t *testing.T) {
+	var exchangedVerifier string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		exchangedVerifier = r.PostForm.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/oauth2/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": "google-sub-1", "email": "teacher@school.edu"})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	for name, sm := range map[string]StateManager{
+		"redis":  newTestStateManager(t),
+		"signed": newTestSignedStateManager(t, nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			exchangedVerifier = ""
+			b := &providerBuilder{httpClient: srv.Client(), baseURL: srv.URL}
+			gs := newGoogleService(config.GoogleConfig{ClientID: "cid", ClientSecret: "secret", RedirectURL: webCallback}, sm, b)
+			ctx := context.Background()
+
+			authURL, err := gs.GetAuthURL(ctx, Flow{RedirectURL: "/dashboard"})
+			if err != nil {
+				t.Fatalf("GetAuthURL: %v", err)
+			}
+			u, _ := url.Parse(authURL)
+			q := u.Query()
+			if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+				t.Fatalf("authorize URL lacks an S256 code_challenge: %s", authURL)
+			}
+
+			if _, _, err := gs.HandleCallback(ctx, "auth-code", q.Get("state")); err != nil {
+				t.Fatalf("HandleCallback: %v", err)
+			}
+			if exchangedVerifier == "" || codeChallengeS256(exchangedVerifier) != q.Get("code_challenge") {
+				t.Errorf("exchange sent code_verifier %q, which doesn't match the challenge %q", exchangedVerifier, q.Get("code_challenge"))
+			}
+			// The verifier must never be readable from the redirect.
+			if strings.Contains(authURL, exchangedVerifier) {
+				t.Error("code_verifier appears in the authorize URL")
+			}
+		})
+	}
+}
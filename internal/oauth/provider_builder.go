@@ -0,0 +1,98 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// providerHTTPTimeout bounds every call Reservoir makes to an identity
+// provider (token exchange, userinfo, tokeninfo, JWKS).
+const providerHTTPTimeout = 10 * time.Second
+
+// providerBuilder is the one place provider services get their oauth2.Config
+// and HTTP client, so the providers can't drift apart in timeouts or in how
+// they build their configs. Production code uses defaultProviderBuilder;
+// tests build their own pointed at an httptest server.
+type providerBuilder struct {
+	httpClient *http.Client
+
+	// baseURL, when set, replaces the scheme and host of every provider URL
+	// (keeping the path), so a single test server can stand in for Google's
+	// or Clever's authorize, token and userinfo endpoints.
+	baseURL string
+}
+
+// NewProviderHTTPClient returns a client for provider services' upstream
+// calls, each bounded by timeout (providerHTTPTimeout when timeout <= 0).
+// Every call also carries its request's context, so whichever of the two
+// deadlines comes first wins. Share one across providers so they share a
+// connection pool.
+func NewProviderHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = providerHTTPTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// defaultProviderBuilder is shared by all providers so they share one
+// connection pool.
+var defaultProviderBuilder = &providerBuilder{
+	httpClient: NewProviderHTTPClient(providerHTTPTimeout),
+}
+
+// oauthConfig builds the authorization-code config for a provider. authURL
+// and tokenURL are configured overrides (see endpointURL); empty means the
+// provider's production endpoint.
+func (b *providerBuilder) oauthConfig(clientID, clientSecret, redirectURL string, scopes []string, production oauth2.Endpoint, authURL, tokenURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   b.endpointURL(authURL, production.AuthURL),
+			TokenURL:  b.endpointURL(tokenURL, production.TokenURL),
+			AuthStyle: production.AuthStyle,
+		},
+	}
+}
+
+// endpointURL returns a configured override (e.g. CLEVER_TOKEN_URL) as is,
+// and otherwise the production URL resolved against baseURL.
+func (b *providerBuilder) endpointURL(override, production string) string {
+	if override != "" {
+		return override
+	}
+	return b.url(production)
+}
+
+// url resolves a provider URL against baseURL.
+func (b *providerBuilder) url(raw string) string {
+	if b.baseURL == "" || raw == "" {
+		return raw
+	}
+	base, err := url.Parse(b.baseURL)
+	if err != nil {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	return u.String()
+}
+
+// exchangeContext makes oauth2's token exchange use client instead of
+// http.DefaultClient, which has no timeout.
+func exchangeContext(ctx context.Context, client *http.Client) context.Context {
+	if client == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
@@ -0,0 +1,281 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// newFakeProvider serves the token and userinfo endpoints of both Google and
+// Clever at their real paths, so a providerBuilder with baseURL set to the
+// server reaches it for every call. tokenRequests records the code posted to
+// each token endpoint.
+func newFakeProvider(t *testing.T) (*httptest.Server, map[string]string) {
+	t.Helper()
+	tokenRequests := make(map[string]string)
+	issueToken := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse token request: %v", err)
+		}
+		tokenRequests[r.URL.Path] = r.PostForm.Get("code")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}
+	requireBearer := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer provider-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", issueToken)        // Google
+	mux.HandleFunc("/oauth/tokens", issueToken) // Clever
+	mux.HandleFunc("/oauth2/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if !requireBearer(w, r) {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "google-sub-1", "email": "teacher@school.edu", "verified_email": true,
+			"given_name": "Test", "family_name": "Teacher",
+		})
+	})
+	mux.HandleFunc("/v3.0/me", func(w http.ResponseWriter, r *http.Request) {
+		if !requireBearer(w, r) {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id": "clever-id-1", "type": "teacher", "email": "clever@school.edu",
+				"name": map[string]string{"first": "Clever", "last": "Teacher"},
+			},
+		})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, tokenRequests
+}
+
+func newTestStateManager(t *testing.T) *RedisStateManager {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStateManager(client, 0)
+}
+
+// completeRedirectFlow starts the redirect flow, checks the authorize URL
+// points at the fake provider, and completes the callback with its state.
+func completeRedirectFlow(t *testing.T, svc ProviderService, srv *httptest.Server, authPath string) *OAuthUserInfo {
+	t.Helper()
+	ctx := context.Background()
+
+	authURL, err := svc.GetAuthURL(ctx, Flow{RedirectURL: "/dashboard"})
+	if err != nil {
+		t.Fatalf("GetAuthURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	if !strings.HasPrefix(authURL, srv.URL+authPath) {
+		t.Errorf("auth URL = %q, want it on the test endpoint %s%s", authURL, srv.URL, authPath)
+	}
+
+	info, flow, err := svc.HandleCallback(ctx, "auth-code", u.Query().Get("state"))
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if flow.RedirectURL != "/dashboard" {
+		t.Errorf("redirect URL = %q, want %q", flow.RedirectURL, "/dashboard")
+	}
+	return info
+}
+
+func TestProviderBuilder_GoogleAgainstTestEndpoint(t *testing.T) {
+	srv, tokenRequests := newFakeProvider(t)
+	b := &providerBuilder{httpClient: srv.Client(), baseURL: srv.URL}
+	gs := newGoogleService(config.GoogleConfig{ClientID: "cid", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}, newTestStateManager(t), b)
+
+	info := completeRedirectFlow(t, gs, srv, "/o/oauth2/auth")
+
+	if tokenRequests["/token"] != "auth-code" {
+		t.Errorf("token endpoint received code %q, want %q", tokenRequests["/token"], "auth-code")
+	}
+	if info.ProviderUserID != "google-sub-1" || info.Email != "teacher@school.edu" {
+		t.Errorf("user info = %+v, want the test endpoint's identity", info)
+	}
+}
+
+func TestProviderBuilder_CleverAgainstTestEndpoint(t *testing.T) {
+	srv, tokenRequests := newFakeProvider(t)
+	b := &providerBuilder{httpClient: srv.Client(), baseURL: srv.URL}
+	cs := newCleverService(config.CleverConfig{ClientID: "cid", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}, newTestStateManager(t), b)
+
+	info := completeRedirectFlow(t, cs, srv, "/oauth/authorize")
+
+	if tokenRequests["/oauth/tokens"] != "auth-code" {
+		t.Errorf("token endpoint received code %q, want %q", tokenRequests["/oauth/tokens"], "auth-code")
+	}
+	if info.ProviderUserID != "clever-id-1" || info.Email != "clever@school.edu" {
+		t.Errorf("user info = %+v, want the test endpoint's identity", info)
+	}
+}
+
+func TestProviderBuilder_DefaultsUseRealEndpoints(t *testing.T) {
+	gs := NewGoogleService(config.GoogleConfig{ClientID: "cid"}, nil)
+	cs := NewCleverService(config.CleverConfig{ClientID: "cid"}, nil)
+	is := NewICloudService(config.ICloudConfig{}, nil)
+
+	if gs.userInfoURL != googleUserInfoURL || cs.userInfoURL != cleverUserInfoURL || is.jwksURL != appleJWKSURL {
+		t.Error("production constructors must not rewrite provider URLs")
+	}
+	if cs.config.Endpoint.TokenURL != "https://clever.com/oauth/tokens" {
+		t.Errorf("Clever token URL = %q", cs.config.Endpoint.TokenURL)
+	}
+	// All providers share one client, and it has a timeout.
+	if gs.httpClient != cs.httpClient || cs.httpClient != is.httpClient || gs.httpClient.Timeout == 0 {
+		t.Error("providers should share the default builder's HTTP client")
+	}
+}
+
+func TestEndpointOverrides_TokenURLRoutesExchangeToMock(t *testing.T) {
+	var exchanged string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sandbox/oauth/tokens" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = r.ParseForm()
+		exchanged = r.PostForm.Get("code")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"sandbox-token","token_type":"Bearer"}`))
+	}))
+	defer mock.Close()
+
+	// The production builder: only the configured override redirects traffic.
+	cs := NewCleverService(config.CleverConfig{
+		ClientID: "cid",
+		TokenURL: mock.URL + "/sandbox/oauth/tokens",
+	}, nil)
+
+	tok, err := cs.config.Exchange(exchangeContext(context.Background(), cs.httpClient), "sandbox-code")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if exchanged != "sandbox-code" || tok.AccessToken != "sandbox-token" {
+		t.Errorf("exchange went to %q / got token %q; want the mock", exchanged, tok.AccessToken)
+	}
+	// Endpoints without an override keep their production value.
+	if cs.config.Endpoint.AuthURL != cleverEndpoint.AuthURL || cs.userInfoURL != cleverUserInfoURL {
+		t.Errorf("unrelated endpoints changed: auth=%q userinfo=%q", cs.config.Endpoint.AuthURL, cs.userInfoURL)
+	}
+}
+
+func TestEndpointOverrides_AllProviders(t *testing.T) {
+	gs := NewGoogleService(config.GoogleConfig{
+		AuthURL:      "http://mock/auth",
+		TokenURL:     "http://mock/token",
+		UserInfoURL:  "http://mock/userinfo",
+		TokenInfoURL: "http://mock/tokeninfo",
+	}, nil)
+	if gs.config.Endpoint.AuthURL != "http://mock/auth" || gs.config.Endpoint.TokenURL != "http://mock/token" ||
+		gs.userInfoURL != "http://mock/userinfo" || gs.tokenInfoURL != "http://mock/tokeninfo" {
+		t.Errorf("Google overrides not applied: %+v userinfo=%q tokeninfo=%q", gs.config.Endpoint, gs.userInfoURL, gs.tokenInfoURL)
+	}
+
+	cs := NewCleverService(config.CleverConfig{AuthURL: "http://mock/authorize", UserInfoURL: "http://mock/me"}, nil)
+	if cs.config.Endpoint.AuthURL != "http://mock/authorize" || cs.userInfoURL != "http://mock/me" {
+		t.Errorf("Clever overrides not applied: %+v userinfo=%q", cs.config.Endpoint, cs.userInfoURL)
+	}
+
+	is := NewICloudService(config.ICloudConfig{JWKSURL: "http://mock/keys"}, nil)
+	if is.jwksURL != "http://mock/keys" {
+		t.Errorf("Apple JWKS override not applied: %q", is.jwksURL)
+	}
+}
+
+// stalledServer accepts requests and never answers until the test ends.
+func stalledServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestProviderHTTPClient_StalledUpstreamFailsFast(t *testing.T) {
+	srv := stalledServer(t)
+
+	gs := NewGoogleService(config.GoogleConfig{ClientID: "cid", UserInfoURL: srv.URL}, nil)
+	cs := NewCleverService(config.CleverConfig{ClientID: "cid", UserInfoURL: srv.URL}, nil)
+	client := NewProviderHTTPClient(50 * time.Millisecond)
+	gs.SetHTTPClient(client)
+	cs.SetHTTPClient(client)
+	gs.SetRetryPolicy(RetryPolicy{})
+	cs.SetRetryPolicy(RetryPolicy{})
+
+	for name, fetch := range map[string]func(context.Context, string) (*OAuthUserInfo, error){
+		"google": gs.fetchUserInfo,
+		"clever": cs.fetchUserInfo,
+	} {
+		start := time.Now()
+		if _, err := fetch(context.Background(), "access-token"); err == nil {
+			t.Errorf("%s: expected a timeout error", name)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: took %v, want the 50ms client timeout to apply", name, elapsed)
+		}
+	}
+}
+
+func TestProviderHTTPClient_RequestDeadlineStillApplies(t *testing.T) {
+	srv := stalledServer(t)
+
+	gs := NewGoogleService(config.GoogleConfig{ClientID: "cid", UserInfoURL: srv.URL}, nil)
+	gs.SetHTTPClient(NewProviderHTTPClient(time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := gs.fetchUserInfo(ctx, "access-token")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v, want the request's 50ms deadline to apply", elapsed)
+	}
+}
+
+func TestNewProviderHTTPClient_DefaultTimeout(t *testing.T) {
+	if got := NewProviderHTTPClient(0).Timeout; got != providerHTTPTimeout {
+		t.Errorf("Timeout = %v, want %v", got, providerHTTPTimeout)
+	}
+	if got := NewProviderHTTPClient(3 * time.Second).Timeout; got != 3*time.Second {
+		t.Errorf("Timeout = %v, want 3s", got)
+	}
+}
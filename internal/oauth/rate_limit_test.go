@@ -0,0 +1,66 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/ratelimit"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// rejectingProvider fails every callback, as for a forged or replayed code.
+type rejectingProvider struct {
+	fakeProvider
+	calls int
+}
+
+func (p *rejectingProvider) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error) {
+	p.calls++
+	return nil, Flow{}, errors.New("invalid code")
+}
+
+func TestOAuthCallback_LocksOutIPAfterRepeatedFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	limiter := ratelimit.NewLimiter(client, 10*time.Minute, 5, 15*time.Minute, zap.NewNop())
+	limiter.SetIPMaxAttempts(3)
+
+	provider := &rejectingProvider{}
+	s := NewAuthService(nil, nil, provider, nil, nil, nil)
+	s.SetRateLimiter(limiter)
+	handler := &Handler{authService: s}
+
+	callback := func(ip string) (int, string) {
+		t.Helper()
+		c, w := newCallbackContext("/auth/google/callback?code=forged&state=xyz")
+		c.Request = c.Request.WithContext(requestid.WithClientIP(c.Request.Context(), ip))
+		handler.GoogleCallback(c)
+		return w.Code, decodeErrorCode(t, w)
+	}
+
+	for i := 0; i < 3; i++ {
+		if status, code := callback("203.0.113.7"); status != http.StatusUnauthorized {
+			t.Fatalf("callback %d: got %d %s, want 401", i, status, code)
+		}
+	}
+	if status, code := callback("203.0.113.7"); status != http.StatusTooManyRequests || code != "RATE_LIMIT_EXCEEDED" {
+		t.Errorf("callback past the limit: got %d %s, want 429 RATE_LIMIT_EXCEEDED", status, code)
+	}
+	if provider.calls != 3 {
+		t.Errorf("provider called %d times, want 3: a locked-out IP must not reach it", provider.calls)
+	}
+
+	// Another IP is unaffected.
+	if status, _ := callback("198.51.100.2"); status != http.StatusUnauthorized {
+		t.Errorf("callback from another IP: got %d, want 401", status)
+	}
+}
@@ -0,0 +1,86 @@
+package oauth
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// redirectPlacement is where buildRedirectURL puts its parameters.
+type redirectPlacement int
+
+const (
+	// placeInFragment keeps the parameters out of server logs and Referer
+	// headers: browsers never send the fragment.
+	placeInFragment redirectPlacement = iota
+	placeInQuery
+)
+
+// buildRedirectWithToken returns base with params (typically the issued
+// tokens) merged into its fragment. See buildRedirectURL.
+func buildRedirectWithToken(base string, params map[string]string) (string, error) {
+	return buildRedirectURL(base, params, placeInFragment)
+}
+
+// buildRedirectURL merges params into base's query string or fragment,
+// leaving every other component as it was. A parameter already present is
+// replaced; other existing parameters keep their order and encoding.
+//
+// A fragment is treated as query-style when it is empty or contains '=' (e.g.
+// "#tab=2"). A hash-router fragment like "#/dashboard" or "#/x?tab=2" gets the
+// parameters in its own query part, so the client route survives.
+func buildRedirectURL(base string, params map[string]string, placement redirectPlacement) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect URL: %w", err)
+	}
+
+	if placement == placeInQuery {
+		u.RawQuery = mergeRawQuery(u.RawQuery, params)
+		return u.String(), nil
+	}
+
+	fragment := u.EscapedFragment()
+	switch {
+	case strings.Contains(fragment, "?"):
+		route, query, _ := strings.Cut(fragment, "?")
+		fragment = route + "?" + mergeRawQuery(query, params)
+	case fragment == "" || strings.Contains(fragment, "="):
+		fragment = mergeRawQuery(fragment, params)
+	default:
+		fragment += "?" + mergeRawQuery("", params)
+	}
+
+	u.Fragment, u.RawFragment = "", ""
+	return u.String() + "#" + fragment, nil
+}
+
+// mergeRawQuery appends params to the encoded query raw, dropping any
+// existing pairs with the same keys. New pairs are added in key order so the
+// result is deterministic.
+func mergeRawQuery(raw string, params map[string]string) string {
+	var pairs []string
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			if _, replaced := params[k]; replaced {
+				continue
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(params[k]))
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,81 @@
+package oauth
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// RedirectAllowlist is where a redirect flow may send the client once signed
+// in (the redirect_url saved with the state). Entries are origins
+// ("https://app.boddle.com") or origin-plus-path prefixes
+// ("https://app.boddle.com/classes"); "*" allows any http(s) URL. Relative
+// paths on this host are always allowed.
+type RedirectAllowlist []string
+
+// NewRedirectAllowlist parses a comma-separated allowlist.
+func NewRedirectAllowlist(raw string) RedirectAllowlist {
+	return RedirectAllowlist(parseAudiences(raw))
+}
+
+// WithoutWildcard returns a without its "*" entries. A list borrowed from
+// CORS_ALLOWED_ORIGINS goes through it: "*" is a sane CORS setting but would
+// make every host a redirect target.
+func (a RedirectAllowlist) WithoutWildcard() RedirectAllowlist {
+	out := make(RedirectAllowlist, 0, len(a))
+	for _, entry := range a {
+		if entry != "*" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// Allows reports whether redirectURL is on the allowlist. A path entry
+// matches itself and anything below it, but not siblings sharing its prefix:
+// "/classes" allows "/classes/7", not "/classes-admin".
+func (a RedirectAllowlist) Allows(redirectURL string) bool {
+	if isLocalPath(redirectURL) {
+		return true
+	}
+
+	u, err := url.Parse(redirectURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false
+	}
+	for _, entry := range a {
+		if entry == "*" {
+			return true
+		}
+		allowed, err := url.Parse(entry)
+		if err != nil || !strings.EqualFold(allowed.Scheme, u.Scheme) || !strings.EqualFold(allowed.Host, u.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if prefix == "" || u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalPath reports whether p is a path on this host. "//evil.com" and
+// "/\evil.com" are excluded: browsers treat both as another host. So is any
+// control character or whitespace, raw or percent-encoded: browsers strip
+// tabs and newlines, so "/%09/evil.com" would become "//evil.com".
+func isLocalPath(p string) bool {
+	decoded, err := url.PathUnescape(p)
+	if err != nil {
+		return false
+	}
+	for _, s := range []string{p, decoded} {
+		if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+			return false
+		}
+		if strings.IndexFunc(s, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0 {
+			return false
+		}
+	}
+	u, err := url.Parse(p)
+	return err == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
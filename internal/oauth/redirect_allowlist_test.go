@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedirectAllowlist_Allows(t *testing.T) {
+	a := NewRedirectAllowlist("https://app.boddle.com, https://lms.boddle.com/classes/")
+	cases := map[string]bool{
+		"/":                              true,
+		"/dashboard?tab=1":               true,
+		"https://app.boddle.com":         true,
+		"https://APP.boddle.com/play":    true,
+		"https://lms.boddle.com/classes": true,
+		"https://lms.boddle.com/classes/7/roster": true,
+
+		"https://lms.boddle.com/":                 false,
+		"https://lms.boddle.com/classes-admin":    false,
+		"http://app.boddle.com":                   false,
+		"https://app.boddle.com.evil.com":         false,
+		"https://evil.com":                        false,
+		"https://app.boddle.com@evil.com":         false,
+		"//evil.com":                              false,
+		"/\\evil.com":                             false,
+		"/%09/evil.com":                           false,
+		"/\t/evil.com":                            false,
+		"/%0a/evil.com":                           false,
+		"/%5cevil.com":                            false,
+		"/%2f/evil.com":                           false,
+		"/ /evil.com":                             false,
+		"javascript:alert(1)":                     false,
+		"JavaScript://app.boddle.com/%0aalert(1)": false,
+		"data:text/html,hi":                       false,
+		"":                                        false,
+	}
+	for redirectURL, want := range cases {
+		if got := a.Allows(redirectURL); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", redirectURL, got, want)
+		}
+	}
+}
+
+func TestRedirectAllowlist_Wildcard(t *testing.T) {
+	a := NewRedirectAllowlist("*")
+	if !a.Allows("https://anywhere.example.com/x") {
+		t.Error("* rejected an https URL")
+	}
+	if a.Allows("javascript:alert(1)") {
+		t.Error("* allowed a javascript: URL")
+	}
+}
+
+func TestRedirectAllowlist_WithoutWildcard(t *testing.T) {
+	a := NewRedirectAllowlist("*, https://app.boddle.com").WithoutWildcard()
+	if a.Allows("https://evil.com") {
+		t.Error("a wildcard survived WithoutWildcard")
+	}
+	if !a.Allows("https://app.boddle.com/play") {
+		t.Error("WithoutWildcard dropped a real origin")
+	}
+}
+
+func TestRedirectAllowlist_EmptyAllowsOnlyLocalPaths(t *testing.T) {
+	var a RedirectAllowlist
+	if !a.Allows("/classes") || a.Allows("https://app.boddle.com") {
+		t.Error("empty allowlist should allow exactly local paths")
+	}
+}
+
+func TestLogin_RejectsRedirectURLOffAllowlist(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil)
+	h.SetRedirectAllowlist(NewRedirectAllowlist("https://app.boddle.com"))
+
+	for _, login := range []func(*gin.Context){h.GoogleLogin, h.CleverLogin} {
+		c, w := newCallbackContext("/auth/google?redirect_url=" + url.QueryEscape("https://evil.com/steal"))
+		login(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+		if code := decodeErrorCode(t, w); code != "REDIRECT_URL_NOT_ALLOWED" {
+			t.Errorf("code = %q, want REDIRECT_URL_NOT_ALLOWED", code)
+		}
+	}
+}
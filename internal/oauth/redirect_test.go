@@ -0,0 +1,64 @@
+package oauth
+
+import "testing"
+
+func TestBuildRedirectWithToken(t *testing.T) {
+	params := map[string]string{"access_token": "a.b.c", "refresh_token": "r+/="}
+
+	tests := []struct {
+		name string
+		base string
+		want string
+	}{
+		{"plain", "https://app.boddle.com/home", "https://app.boddle.com/home#access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"trailing slash", "https://app.boddle.com/", "https://app.boddle.com/#access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"relative path", "/", "/#access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"existing query left alone", "https://app.boddle.com/home?class=7&lang=es", "https://app.boddle.com/home?class=7&lang=es#access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"query-style fragment", "https://app.boddle.com/#tab=2", "https://app.boddle.com/#tab=2&access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"stale token in fragment replaced", "https://app.boddle.com/#access_token=old&tab=2", "https://app.boddle.com/#tab=2&access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"hash route", "https://app.boddle.com/#/dashboard", "https://app.boddle.com/#/dashboard?access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+		{"hash route with query", "https://app.boddle.com/?x=1#/dashboard?tab=2", "https://app.boddle.com/?x=1#/dashboard?tab=2&access_token=a.b.c&refresh_token=r%2B%2F%3D"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildRedirectWithToken(tt.base, params)
+			if err != nil {
+				t.Fatalf("buildRedirectWithToken(%q): %v", tt.base, err)
+			}
+			if got != tt.want {
+				t.Errorf("buildRedirectWithToken(%q)\n got  %s\n want %s", tt.base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRedirectURL_InQuery(t *testing.T) {
+	params := map[string]string{"token": "a.b.c"}
+
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://app.boddle.com/home", "https://app.boddle.com/home?token=a.b.c"},
+		{"https://app.boddle.com/home/", "https://app.boddle.com/home/?token=a.b.c"},
+		{"https://app.boddle.com/home?next=%2Fplay&token=old", "https://app.boddle.com/home?next=%2Fplay&token=a.b.c"},
+		{"https://app.boddle.com/home?lang=es#section", "https://app.boddle.com/home?lang=es&token=a.b.c#section"},
+	}
+
+	for _, tt := range tests {
+		got, err := buildRedirectURL(tt.base, params, placeInQuery)
+		if err != nil {
+			t.Fatalf("buildRedirectURL(%q): %v", tt.base, err)
+		}
+		if got != tt.want {
+			t.Errorf("buildRedirectURL(%q)\n got  %s\n want %s", tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestBuildRedirectWithToken_InvalidBase(t *testing.T) {
+	if _, err := buildRedirectWithToken("https://app.boddle.com/%zz", map[string]string{"token": "x"}); err == nil {
+		t.Error("malformed base URL accepted")
+	}
+}
@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy bounds how provider userinfo calls are retried when a provider
+// answers 5xx or the connection fails, as Google and Clever sometimes do
+// under back-to-school load. Attempt n (from 0) waits BaseDelay·2ⁿ, jittered
+// down by up to half. The zero value never retries.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// defaultRetryPolicy is what the production constructors use until
+// SetRetryPolicy says otherwise.
+var defaultRetryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: 200 * time.Millisecond}
+
+// providerStatusError is a non-200 answer from a provider API.
+type providerStatusError struct {
+	Provider string
+	Status   int
+	Body     string
+}
+
+func (e *providerStatusError) Error() string {
+	return fmt.Sprintf("%s API returned status %d: %s", e.Provider, e.Status, e.Body)
+}
+
+// retryable reports whether err is worth another attempt: a 5xx from the
+// provider or a failed connection. 4xx answers (including 429, which
+// surfaces as a ThrottledError) are final.
+func retryable(err error) bool {
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// withRetry calls fn until it succeeds, fails with a non-retryable error, or
+// p.MaxRetries retries are spent. It gives up early, returning the last
+// error, once ctx is done or its deadline falls before the next attempt.
+func withRetry[T any](ctx context.Context, p RetryPolicy, fn func(context.Context) (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		v, err := fn(ctx)
+		if err == nil || attempt >= p.MaxRetries || !retryable(err) || ctx.Err() != nil {
+			return v, err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return v, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is the wait before retry attempt+1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 {
+		return 0
+	}
+	return d - rand.N(d/2+1)
+}
@@ -0,0 +1,148 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers failStatus to the first failures requests and a
+// userinfo profile after that. calls counts every request.
+func flakyServer(t *testing.T, failures int32, failStatus int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(failStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "google-sub-1",
+			"email": "teacher@school.edu",
+			"data":  map[string]interface{}{"id": "clever-1", "type": "teacher"},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+var fastRetry = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+func TestFetchUserInfo_RetriesUntilSuccess(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		srv, calls := flakyServer(t, 2, status)
+
+		gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client(), retry: fastRetry}
+		info, err := gs.fetchUserInfo(context.Background(), "access-token")
+		if err != nil {
+			t.Fatalf("google %d: fetchUserInfo: %v", status, err)
+		}
+		if info.ProviderUserID != "google-sub-1" || calls.Load() != 3 {
+			t.Errorf("google %d: got %q after %d calls, want google-sub-1 on the third", status, info.ProviderUserID, calls.Load())
+		}
+
+		calls.Store(0)
+		cs := &CleverService{userInfoURL: srv.URL, httpClient: srv.Client(), retry: fastRetry}
+		cinfo, err := cs.fetchUserInfo(context.Background(), "access-token")
+		if err != nil {
+			t.Fatalf("clever %d: fetchUserInfo: %v", status, err)
+		}
+		if cinfo.ProviderUserID != "clever-1" || calls.Load() != 3 {
+			t.Errorf("clever %d: got %q after %d calls, want clever-1 on the third", status, cinfo.ProviderUserID, calls.Load())
+		}
+	}
+}
+
+func TestFetchUserInfo_GivesUpAfterMaxRetries(t *testing.T) {
+	srv, calls := flakyServer(t, 10, http.StatusInternalServerError)
+
+	gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client(), retry: fastRetry}
+	_, err := gs.fetchUserInfo(context.Background(), "access-token")
+	var statusErr *providerStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want the last 500", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 1 + 2 retries", calls.Load())
+	}
+}
+
+func TestFetchUserInfo_DoesNotRetry4xx(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		srv, calls := flakyServer(t, 10, status)
+
+		gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client(), retry: fastRetry}
+		if _, err := gs.fetchUserInfo(context.Background(), "access-token"); err == nil {
+			t.Fatalf("%d: expected an error", status)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("%d: calls = %d, want 1", status, calls.Load())
+		}
+	}
+}
+
+func TestFetchUserInfo_RetriesNetworkErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Drop the first two connections without answering.
+		if calls.Add(1) <= 2 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": "google-sub-1"})
+	}))
+	defer srv.Close()
+
+	gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client(), retry: fastRetry}
+	if _, err := gs.fetchUserInfo(context.Background(), "access-token"); err != nil {
+		t.Fatalf("fetchUserInfo: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestWithRetry_HonorsContextDeadline(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 5, BaseDelay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	_, err := withRetry(ctx, p, func(context.Context) (struct{}, error) {
+		calls++
+		return struct{}{}, &providerStatusError{Provider: "Google", Status: http.StatusBadGateway}
+	})
+	if err == nil {
+		t.Fatal("expected the 502 back")
+	}
+	// The first backoff (≥500ms) doesn't fit in the deadline, so no retry.
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %v; should give up rather than sleep past the deadline", elapsed)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond}
+	for attempt, ceil := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			d := p.backoff(attempt)
+			if d < ceil/2 || d > ceil {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", attempt, d, ceil/2, ceil)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("zero policy backoff = %v, want 0", d)
+	}
+}
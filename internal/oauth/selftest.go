@@ -0,0 +1,168 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// SelfTestCheck is one pass/fail line of a SelfTestReport.
+type SelfTestCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SelfTestReport is the outcome of SelfTester.SelfTest for one provider. OK
+// is true when every check passed.
+type SelfTestReport struct {
+	Provider string          `json:"provider"`
+	OK       bool            `json:"ok"`
+	Checks   []SelfTestCheck `json:"checks"`
+}
+
+func (r *SelfTestReport) add(name string, err error) {
+	check := SelfTestCheck{Name: name, OK: err == nil}
+	if err != nil {
+		check.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// SelfTester checks each provider's configuration without signing anyone
+// in: that it is constructed, its callback URLs are well-formed and, for
+// Apple, that the private key loads and signs a client secret. Nothing is
+// sent to the providers.
+type SelfTester struct {
+	google *GoogleService
+	clever *CleverService
+	icloud *ICloudService
+	oidc   OIDCProviders
+	now    func() time.Time
+}
+
+// NewSelfTester creates a SelfTester over the configured providers.
+func NewSelfTester(google *GoogleService, clever *CleverService, icloud *ICloudService, oidc OIDCProviders) *SelfTester {
+	return &SelfTester{google: google, clever: clever, icloud: icloud, oidc: oidc, now: time.Now}
+}
+
+// SelfTest runs the checks for provider: "google", "clever", "icloud" or
+// "oidc:<name>". An unknown provider returns ErrNotFound.
+func (t *SelfTester) SelfTest(ctx context.Context, provider string) (SelfTestReport, error) {
+	r := SelfTestReport{Provider: provider}
+	switch {
+	case provider == "google":
+		r.add("constructed", oauthConfigured(t.google != nil, func() (string, string) {
+			return t.google.config.ClientID, t.google.config.ClientSecret
+		}))
+		if t.google != nil {
+			r.add("redirect_url", checkCallbackURLs(t.google.callbackURIs))
+		}
+	case provider == "clever":
+		r.add("constructed", oauthConfigured(t.clever != nil, func() (string, string) {
+			return t.clever.config.ClientID, t.clever.config.ClientSecret
+		}))
+		if t.clever != nil {
+			r.add("redirect_url", checkCallbackURLs(t.clever.callbackURIs))
+		}
+	case provider == "icloud":
+		t.selfTestICloud(&r)
+	case strings.HasPrefix(provider, "oidc:"):
+		o, ok := t.oidc[strings.TrimPrefix(provider, "oidc:")]
+		if !ok {
+			return r, apperrors.ErrNotFound
+		}
+		r.add("constructed", oauthConfigured(true, func() (string, string) {
+			return o.config.ClientID, o.config.ClientSecret
+		}))
+		r.add("issuer", checkAbsoluteURL(o.issuer))
+		r.add("redirect_url", checkAbsoluteURL(o.config.RedirectURL))
+	default:
+		return r, apperrors.ErrNotFound
+	}
+
+	r.OK = true
+	for _, c := range r.Checks {
+		r.OK = r.OK && c.OK
+	}
+	return r, nil
+}
+
+func (t *SelfTester) selfTestICloud(r *SelfTestReport) {
+	if t.icloud == nil {
+		r.add("constructed", fmt.Errorf("iCloud service is not constructed"))
+		return
+	}
+	if !t.icloud.Configured() {
+		r.add("constructed", fmt.Errorf("APPLE_CLIENT_IDS is not set"))
+	} else {
+		r.add("constructed", nil)
+	}
+
+	key, err := t.icloud.privateKey()
+	r.add("private_key", err)
+	if err != nil {
+		return
+	}
+
+	// Sign a client secret and check it verifies with the key's public half,
+	// so a key that parses but can't sign is caught here too.
+	secret, err := t.icloud.clientSecret(t.now())
+	if err == nil {
+		_, err = jwt.Parse(secret, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil },
+			jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithTimeFunc(t.now))
+	}
+	r.add("client_secret", err)
+}
+
+// oauthConfigured checks a provider was built with a client ID and secret.
+// creds is only called when constructed is true.
+func oauthConfigured(constructed bool, creds func() (id, secret string)) error {
+	if !constructed {
+		return fmt.Errorf("provider is not constructed")
+	}
+	id, secret := creds()
+	switch {
+	case id == "":
+		return fmt.Errorf("client ID is not set")
+	case secret == "":
+		return fmt.Errorf("client secret is not set")
+	}
+	return nil
+}
+
+// checkCallbackURLs checks every allowed callback URL with checkAbsoluteURL.
+func checkCallbackURLs(uris callbackURIs) error {
+	for _, u := range uris {
+		if err := checkAbsoluteURL(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAbsoluteURL checks raw is an absolute http(s) URL with a host and no
+// fragment, as providers require of redirect URIs and issuers.
+func checkAbsoluteURL(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("URL is not set")
+	}
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%q is not a valid URL: %w", raw, err)
+	case u.Scheme != "https" && u.Scheme != "http":
+		return fmt.Errorf("%q must be an http(s) URL", raw)
+	case u.Host == "":
+		return fmt.Errorf("%q has no host", raw)
+	case u.Fragment != "":
+		return fmt.Errorf("%q must not have a fragment", raw)
+	}
+	return nil
+}
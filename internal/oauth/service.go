@@ -2,31 +2,100 @@ package oauth
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 
+	"go.uber.org/zap"
+
 	"github.com/boddle/reservoir/internal/auth"
 	"github.com/boddle/reservoir/internal/token"
 	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
 )
 
+// ProviderService is the redirect-based OAuth flow of a provider: build the
+// authorization URL, then turn the callback's code/state into the user's
+// identity and the redirect URL saved with the state.
+type ProviderService interface {
+	GetAuthURL(ctx context.Context, flow Flow) (string, error)
+	HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error)
+}
+
+// googleProvider is what AuthService needs from Google: the redirect flow
+// plus verification of LMS-supplied access tokens. Satisfied by
+// *GoogleService; tests substitute a fake.
+type googleProvider interface {
+	ProviderService
+	verifyTokenAudience(ctx context.Context, accessToken string) error
+	fetchUserInfo(ctx context.Context, accessToken string) (*OAuthUserInfo, error)
+}
+
+// cleverProvider is what AuthService needs from Clever. Satisfied by
+// *CleverService.
+type cleverProvider interface {
+	ProviderService
+	fetchUserInfo(ctx context.Context, accessToken string) (*OAuthUserInfo, error)
+	allowsAdmins() bool
+}
+
+// icloudProvider is what AuthService needs from Sign in with Apple.
+// Satisfied by *ICloudService.
+type icloudProvider interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*OAuthUserInfo, error)
+}
+
 // AuthService handles OAuth authentication business logic
 type AuthService struct {
 	userRepo     *user.Repository
 	tokenService *token.Service
-	googleSvc    *GoogleService
-	cleverSvc    *CleverService
-	icloudSvc    *ICloudService
+	googleSvc    googleProvider
+	cleverSvc    cleverProvider
+	icloudSvc    icloudProvider
 	lastLogin    user.LastLoginEnqueuer
+
+	// maxLinkedProviders caps distinct linked providers per meta type; a
+	// meta type with no entry is uncapped.
+	maxLinkedProviders map[string]int
+
+	// linkRetries receives links whose UID write failed during sign-in.
+	linkRetries *LinkRetryQueue
+
+	logger *zap.Logger
+
+	// tokenAuditor, if set, records every token pair minted.
+	tokenAuditor auth.TokenAuditor
+
+	// rateLimiter, if set, locks out IPs making too many failed sign-ins.
+	rateLimiter auth.RateLimiter
+
+	// refreshIndex, if set, lists each user's outstanding refresh tokens.
+	refreshIndex *token.RefreshTokenIndex
+
+	// refreshFamilies, if set, tracks each sign-in's current refresh token.
+	refreshFamilies *token.RefreshFamilies
+
+	// deviceSessions, if set, keeps one session per user per device.
+	deviceSessions *auth.DeviceSessions
+
+	// userCache, if set, is evicted when a sign-in writes to the user.
+	userCache *user.Cache
+
+	// oidc are the generic OIDC providers, by name.
+	oidc OIDCProviders
+
+	// revealNoLinkedAccountEmail includes the provider's verified email in
+	// NoLinkedAccountErrors.
+	revealNoLinkedAccountEmail bool
 }
 
 // NewAuthService creates a new OAuth authentication service
 func NewAuthService(
 	userRepo *user.Repository,
 	tokenService *token.Service,
-	googleSvc *GoogleService,
-	cleverSvc *CleverService,
-	icloudSvc *ICloudService,
+	googleSvc googleProvider,
+	cleverSvc cleverProvider,
+	icloudSvc icloudProvider,
 	lastLogin user.LastLoginEnqueuer,
 ) *AuthService {
 	return &AuthService{
@@ -36,21 +105,216 @@ func NewAuthService(
 		cleverSvc:    cleverSvc,
 		icloudSvc:    icloudSvc,
 		lastLogin:    lastLogin,
+		logger:       zap.NewNop(),
+	}
+}
+
+// SetLogger makes s log to logger. Logging is off until this is called.
+func (s *AuthService) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// SetMaxLinkedProviders caps the distinct providers linked per meta type; a
+// meta type with no entry is uncapped. Uncapped until this is called.
+func (s *AuthService) SetMaxLinkedProviders(max map[string]int) {
+	s.maxLinkedProviders = max
+}
+
+// SetLinkRetries queues links whose UID write fails during sign-in on q for
+// retry. Until this is called such links are dropped.
+func (s *AuthService) SetLinkRetries(q *LinkRetryQueue) {
+	s.linkRetries = q
+}
+
+// SetTokenAuditor makes s report every token it issues to a. Auditing is off
+// until this is called.
+func (s *AuthService) SetTokenAuditor(a auth.TokenAuditor) {
+	s.tokenAuditor = a
+}
+
+// SetRateLimiter limits failed OAuth sign-ins per client IP with l's
+// CheckByIP/RecordByIP; there is no email to key on until the provider has
+// answered. Off until this is called.
+func (s *AuthService) SetRateLimiter(l auth.RateLimiter) {
+	s.rateLimiter = l
+}
+
+// SetRefreshTokenIndex records every refresh token s issues in x. Off until
+// this is called.
+func (s *AuthService) SetRefreshTokenIndex(x *token.RefreshTokenIndex) {
+	s.refreshIndex = x
+}
+
+// SetRefreshFamilies seeds the family of every refresh token s issues, for
+// auth.Service's reuse detection. Off until this is called.
+func (s *AuthService) SetRefreshFamilies(f *token.RefreshFamilies) {
+	s.refreshFamilies = f
+}
+
+// SetUserCache makes s evict a user's cached /auth/me profile when a sign-in
+// links a provider to the account or fills in its name. Off until this is
+// called.
+func (s *AuthService) SetUserCache(c *user.Cache) {
+	s.userCache = c
+}
+
+// evictUser drops userID's cached profile after a sign-in wrote to it.
+func (s *AuthService) evictUser(ctx context.Context, userID int) {
+	if err := s.userCache.Invalidate(ctx, userID); err != nil {
+		requestid.Logger(ctx, s.logger).Warn("failed to invalidate user cache", zap.Int("user_id", userID), zap.Error(err))
 	}
 }
 
-// AuthenticateWithGoogle authenticates a user with Google OAuth
-func (s *AuthService) AuthenticateWithGoogle(ctx context.Context, code, state string) (*auth.LoginResponse, string, error) {
+// SetDeviceSessions limits each user to one session per device fingerprint,
+// as auth.Service.SetDeviceSessions does for password sign-ins. Off (nil) by
+// default.
+func (s *AuthService) SetDeviceSessions(d *auth.DeviceSessions) {
+	s.deviceSessions = d
+}
+
+// SetOIDCProviders enables sign-in with the generic OIDC providers p.
+func (s *AuthService) SetOIDCProviders(p OIDCProviders) {
+	s.oidc = p
+}
+
+// checkIPLimit rejects a sign-in from a locked-out client IP with
+// ErrRateLimitExceeded. Like password login it fails open if the limiter
+// can't be reached.
+func (s *AuthService) checkIPLimit(ctx context.Context) error {
+	if s.rateLimiter == nil {
+		return nil
+	}
+	allowed, _, err := s.rateLimiter.CheckByIP(ctx, requestid.ClientIP(ctx))
+	if err != nil {
+		requestid.Logger(ctx, s.logger).Warn("rate limiter error", zap.Error(err))
+		return nil
+	}
+	if !allowed {
+		return apperrors.ErrRateLimitExceeded
+	}
+	return nil
+}
+
+// recordIPFailure counts a failed sign-in (err != nil) against its client IP.
+// Rejections by the limiter itself, and a provider throttling us, don't count.
+func (s *AuthService) recordIPFailure(ctx context.Context, err error) {
+	var throttledErr *ThrottledError
+	if s.rateLimiter == nil || err == nil || errors.Is(err, apperrors.ErrRateLimitExceeded) || errors.As(err, &throttledErr) {
+		return
+	}
+	if err := s.rateLimiter.RecordByIP(ctx, requestid.ClientIP(ctx)); err != nil {
+		requestid.Logger(ctx, s.logger).Warn("failed to record failed OAuth sign-in", zap.Error(err))
+	}
+}
+
+// linkByEmail links provider's providerUID to the Teacher or Student meta
+// record of usr, whom the provider has just authenticated and who was found
+// by email. The record is re-read and written in one transaction holding its
+// row lock, so of two callbacks racing to link the same account only one
+// writes; the other finds the UID already there. Returns the meta record.
+//
+// A failed UID write doesn't fail the sign-in: the link is queued for retry
+// and the record is returned unlinked.
+func (s *AuthService) linkByEmail(ctx context.Context, usr *user.User, provider, providerUID string) (interface{}, error) {
+	link := PendingLink{
+		Provider:    provider,
+		MetaType:    usr.MetaType,
+		MetaID:      usr.MetaID,
+		ProviderUID: providerUID,
+		UserID:      usr.ID,
+	}
+
+	meta, linked, err := s.lockAndLink(ctx, link)
+
+	var writeErr *linkWriteError
+	if errors.As(err, &writeErr) {
+		s.deferLink(ctx, link, writeErr.err)
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if linked {
+		setLinkedUID(meta, provider, providerUID)
+		s.evictUser(ctx, usr.ID)
+	}
+	return meta, nil
+}
+
+// lockAndLink writes link inside a transaction holding its meta record's row
+// lock, unless the record already has the UID, and only if the account has
+// room for the provider under checkLinkLimit. It returns the record as read
+// and whether the UID was written; a failed write is a *linkWriteError.
+func (s *AuthService) lockAndLink(ctx context.Context, link PendingLink) (meta interface{}, linked bool, err error) {
+	err = s.userRepo.WithTx(ctx, func(repo *user.Repository) error {
+		var err error
+		meta, err = lockMeta(ctx, repo, link.MetaType, link.MetaID)
+		if err != nil {
+			return err
+		}
+		if linkedUID(meta, link.Provider) == link.ProviderUID {
+			// A concurrent callback linked it first.
+			return nil
+		}
+		if err := s.checkLinkLimit(link.MetaType, meta, link.Provider); err != nil {
+			return err
+		}
+		if err := applyLink(ctx, repo, link); err != nil {
+			return &linkWriteError{err: err}
+		}
+		linked = true
+		return nil
+	})
+	return meta, linked, err
+}
+
+// ApplyPendingLink retries a link deferred by a sign-in, with the same row
+// lock and linked-provider limit as the sign-in itself: the account may have
+// linked other providers since. An account now at its limit gets
+// ErrTooManyLinkedProviders. For LinkRetryQueue.Run.
+func (s *AuthService) ApplyPendingLink(ctx context.Context, link PendingLink) error {
+	_, _, err := s.lockAndLink(ctx, link)
+	var writeErr *linkWriteError
+	if errors.As(err, &writeErr) {
+		return writeErr.err
+	}
+	return err
+}
+
+// deferLink queues a link whose UID write failed for retry.
+func (s *AuthService) deferLink(ctx context.Context, link PendingLink, err error) {
+	if s.linkRetries == nil {
+		requestid.Logger(ctx, s.logger).Warn("failed to link provider UID; no retry queue, link dropped",
+			zap.String("provider", link.Provider),
+			zap.Int("user_id", link.UserID),
+			zap.Error(err),
+		)
+		return
+	}
+	s.linkRetries.Defer(ctx, link, err)
+}
+
+// AuthenticateWithGoogle authenticates a user with Google OAuth. The flow
+// saved with the state is returned for the callback's response.
+func (s *AuthService) AuthenticateWithGoogle(ctx context.Context, code, state string) (_ *auth.LoginResponse, _ Flow, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, Flow{}, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
 	// Handle Google OAuth callback
-	oauthUserInfo, redirectURL, err := s.googleSvc.HandleCallback(ctx, code, state)
+	oauthUserInfo, flow, err := s.googleSvc.HandleCallback(ctx, code, state)
 	if err != nil {
-		return nil, "", err
+		return nil, Flow{}, err
 	}
 
 	// Find or create user
 	usr, meta, err := s.findOrCreateGoogleUser(ctx, oauthUserInfo)
 	if err != nil {
-		return nil, "", err
+		return nil, Flow{}, err
+	}
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, Flow{}, err
 	}
 
 	s.lastLogin.Enqueue(usr.ID)
@@ -74,16 +338,27 @@ func (s *AuthService) AuthenticateWithGoogle(ctx context.Context, code, state st
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(meta)),
+		token.WithLocale(tokenLocale(usr, oauthUserInfo)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(flow.App),
+		s.deviceSessions.Option(ctx),
 	)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, Flow{}, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "google", "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &auth.LoginResponse{
 		Token: tokenPair,
 		User:  usr,
 		Meta:  meta,
-	}, redirectURL, nil
+	}, flow, nil
 }
 
 // findOrCreateGoogleUser finds an existing user by Google UID or email, or returns error
@@ -124,44 +399,17 @@ func (s *AuthService) findOrCreateGoogleUser(ctx context.Context, info *OAuthUse
 	}
 
 	if usr == nil {
-		return nil, nil, fmt.Errorf("no account found for this Google account. Please sign up first.")
+		return nil, nil, s.noLinkedAccount("google", "no account found for this Google account. Please sign up first.", info)
 	}
 
 	// Link account by updating Google UID
 	switch usr.MetaType {
-	case "Teacher":
-		teacher, err := s.userRepo.FindTeacher(ctx, usr.MetaID)
+	case "Teacher", "Student":
+		meta, err := s.linkByEmail(ctx, usr, "google", info.ProviderUserID)
 		if err != nil {
 			return nil, nil, err
 		}
-		if teacher == nil {
-			return nil, nil, fmt.Errorf("teacher meta not found")
-		}
-
-		// Update Google UID
-		if err := s.userRepo.UpdateTeacherGoogleUID(ctx, teacher.ID, info.ProviderUserID); err != nil {
-			return nil, nil, fmt.Errorf("failed to link Google account: %w", err)
-		}
-
-		teacher.GoogleUID = sql.NullString{String: info.ProviderUserID, Valid: true}
-		return usr, teacher, nil
-
-	case "Student":
-		student, err := s.userRepo.FindStudent(ctx, usr.MetaID)
-		if err != nil {
-			return nil, nil, err
-		}
-		if student == nil {
-			return nil, nil, fmt.Errorf("student meta not found")
-		}
-
-		// Update Google UID
-		if err := s.userRepo.UpdateStudentGoogleUID(ctx, student.ID, info.ProviderUserID); err != nil {
-			return nil, nil, fmt.Errorf("failed to link Google account: %w", err)
-		}
-
-		student.GoogleUID = sql.NullString{String: info.ProviderUserID, Valid: true}
-		return usr, student, nil
+		return usr, meta, nil
 
 	default:
 		return nil, nil, fmt.Errorf("unsupported user type for Google OAuth: %s", usr.MetaType)
@@ -177,7 +425,12 @@ func (s *AuthService) findOrCreateGoogleUser(ctx context.Context, info *OAuthUse
 // A caller can therefore only mint a JWT for an identity it holds a valid
 // Google token for — it cannot assert an arbitrary uid/email. See LMS-6511 /
 // security review Finding 0.
-func (s *AuthService) AuthenticateWithGoogleToken(ctx context.Context, accessToken string) (*auth.LoginResponse, error) {
+func (s *AuthService) AuthenticateWithGoogleToken(ctx context.Context, accessToken string) (_ *auth.LoginResponse, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
 	// Reject tokens minted for an OAuth app other than the LMS (no-op unless
 	// GOOGLE_TOKEN_AUDIENCES is configured). Guards against confused-deputy
 	// replay, since userinfo below does not check audience.
@@ -194,6 +447,9 @@ func (s *AuthService) AuthenticateWithGoogleToken(ctx context.Context, accessTok
 	if err != nil {
 		return nil, err
 	}
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, err
+	}
 
 	s.lastLogin.Enqueue(usr.ID)
 
@@ -209,10 +465,21 @@ func (s *AuthService) AuthenticateWithGoogleToken(ctx context.Context, accessTok
 
 	tokenPair, err := s.tokenService.Generate(
 		usr.ID, boddleUID, usr.Email, fullName, usr.MetaType, usr.MetaID, usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(meta)),
+		token.WithLocale(tokenLocale(usr, oauthUserInfo)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(auth.AppFromContext(ctx)),
+		s.deviceSessions.Option(ctx),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "google", "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &auth.LoginResponse{Token: tokenPair, User: usr, Meta: meta}, nil
 }
@@ -226,7 +493,12 @@ func (s *AuthService) AuthenticateWithGoogleToken(ctx context.Context, accessTok
 // A caller can therefore only mint a JWT for an identity it holds a valid
 // Clever token for — it cannot assert an arbitrary uid/email. See LMS-6511 /
 // security review Finding 0.
-func (s *AuthService) AuthenticateWithCleverToken(ctx context.Context, accessToken string) (*auth.LoginResponse, error) {
+func (s *AuthService) AuthenticateWithCleverToken(ctx context.Context, accessToken string) (_ *auth.LoginResponse, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
 	oauthUserInfo, err := s.cleverSvc.fetchUserInfo(ctx, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify Clever access token: %w", err)
@@ -236,6 +508,10 @@ func (s *AuthService) AuthenticateWithCleverToken(ctx context.Context, accessTok
 	if err != nil {
 		return nil, err
 	}
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, err
+	}
+	s.logCleverSignIn(ctx, usr, oauthUserInfo)
 
 	s.lastLogin.Enqueue(usr.ID)
 
@@ -251,27 +527,47 @@ func (s *AuthService) AuthenticateWithCleverToken(ctx context.Context, accessTok
 
 	tokenPair, err := s.tokenService.Generate(
 		usr.ID, boddleUID, usr.Email, fullName, usr.MetaType, usr.MetaID, usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(meta)),
+		token.WithLocale(tokenLocale(usr, oauthUserInfo)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(auth.AppFromContext(ctx)),
+		s.deviceSessions.Option(ctx),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "clever", "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &auth.LoginResponse{Token: tokenPair, User: usr, Meta: meta}, nil
 }
 
 // AuthenticateWithClever authenticates a user with Clever SSO
-func (s *AuthService) AuthenticateWithClever(ctx context.Context, code, state string) (*auth.LoginResponse, string, error) {
+func (s *AuthService) AuthenticateWithClever(ctx context.Context, code, state string) (_ *auth.LoginResponse, _ Flow, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, Flow{}, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
 	// Handle Clever OAuth callback
-	oauthUserInfo, redirectURL, err := s.cleverSvc.HandleCallback(ctx, code, state)
+	oauthUserInfo, flow, err := s.cleverSvc.HandleCallback(ctx, code, state)
 	if err != nil {
-		return nil, "", err
+		return nil, Flow{}, err
 	}
 
 	// Find or create user
 	usr, meta, err := s.findOrCreateCleverUser(ctx, oauthUserInfo)
 	if err != nil {
-		return nil, "", err
+		return nil, Flow{}, err
+	}
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, Flow{}, err
 	}
+	s.logCleverSignIn(ctx, usr, oauthUserInfo)
 
 	s.lastLogin.Enqueue(usr.ID)
 
@@ -294,21 +590,41 @@ func (s *AuthService) AuthenticateWithClever(ctx context.Context, code, state st
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(meta)),
+		token.WithLocale(tokenLocale(usr, oauthUserInfo)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(flow.App),
+		s.deviceSessions.Option(ctx),
 	)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, Flow{}, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, Flow{}, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "clever", "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &auth.LoginResponse{
 		Token: tokenPair,
 		User:  usr,
 		Meta:  meta,
-	}, redirectURL, nil
+	}, flow, nil
 }
 
 // findOrCreateCleverUser finds an existing user by Clever UID or email, or returns error
 // Note: User creation is handled by Rails, so we only link existing accounts
 func (s *AuthService) findOrCreateCleverUser(ctx context.Context, info *OAuthUserInfo) (*user.User, interface{}, error) {
+	// District/school admins have no teacher or student record; catch them
+	// before the UID lookups so they get a clear answer.
+	if isCleverAdminRole(info.ProviderRole) {
+		if !s.cleverSvc.allowsAdmins() {
+			return nil, nil, unsupportedCleverRoleError(info.ProviderRole)
+		}
+		return s.findCleverAdmin(ctx, info)
+	}
+
 	// Try to find teacher by Clever UID
 	teacher, err := s.userRepo.FindTeacherByCleverUID(ctx, info.ProviderUserID)
 	if err != nil {
@@ -344,67 +660,83 @@ func (s *AuthService) findOrCreateCleverUser(ctx context.Context, info *OAuthUse
 	}
 
 	if usr == nil {
-		return nil, nil, fmt.Errorf("no account found for this Clever account. Please sign up first.")
+		return nil, nil, s.noLinkedAccount("clever", "no account found for this Clever account. Please sign up first.", info)
 	}
 
 	// Link account by updating Clever UID
 	switch usr.MetaType {
-	case "Teacher":
-		teacher, err := s.userRepo.FindTeacher(ctx, usr.MetaID)
-		if err != nil {
-			return nil, nil, err
-		}
-		if teacher == nil {
-			return nil, nil, fmt.Errorf("teacher meta not found")
-		}
-
-		// Update Clever UID
-		if err := s.userRepo.UpdateTeacherCleverUID(ctx, teacher.ID, info.ProviderUserID); err != nil {
-			return nil, nil, fmt.Errorf("failed to link Clever account: %w", err)
-		}
-
-		teacher.CleverUID = sql.NullString{String: info.ProviderUserID, Valid: true}
-		return usr, teacher, nil
-
-	case "Student":
-		student, err := s.userRepo.FindStudent(ctx, usr.MetaID)
+	case "Teacher", "Student":
+		meta, err := s.linkByEmail(ctx, usr, "clever", info.ProviderUserID)
 		if err != nil {
 			return nil, nil, err
 		}
-		if student == nil {
-			return nil, nil, fmt.Errorf("student meta not found")
-		}
-
-		// Update Clever UID
-		if err := s.userRepo.UpdateStudentCleverUID(ctx, student.ID, info.ProviderUserID); err != nil {
-			return nil, nil, fmt.Errorf("failed to link Clever account: %w", err)
-		}
-
-		student.CleverUID = sql.NullString{String: info.ProviderUserID, Valid: true}
-		return usr, student, nil
+		return usr, meta, nil
 
 	default:
 		return nil, nil, fmt.Errorf("unsupported user type for Clever SSO: %s", usr.MetaType)
 	}
 }
 
+// findCleverAdmin resolves a Clever admin to an existing Admin user by email.
+// Admins have no meta table to store a Clever UID in, so there is no linking;
+// a Clever admin without an account is NO_LINKED_ACCOUNT, and one whose
+// account isn't an Admin is FORBIDDEN_ROLE.
+func (s *AuthService) findCleverAdmin(ctx context.Context, info *OAuthUserInfo) (*user.User, interface{}, error) {
+	usr, err := s.userRepo.FindByEmail(ctx, info.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if usr == nil {
+		return nil, nil, s.noLinkedAccount("clever", "no admin account found for this Clever account", info)
+	}
+	if usr.MetaType != "Admin" {
+		return nil, nil, apperrors.ErrForbiddenRole
+	}
+	return usr, nil, nil
+}
+
+// logCleverSignIn records which district a Clever sign-in came from.
+func (s *AuthService) logCleverSignIn(ctx context.Context, usr *user.User, info *OAuthUserInfo) {
+	requestid.Logger(ctx, s.logger).Info("clever sign-in",
+		zap.Int("user_id", usr.ID),
+		zap.String("meta_type", usr.MetaType),
+		zap.String("clever_role", info.ProviderRole),
+		zap.String("district_id", info.DistrictID),
+	)
+}
+
 // AuthenticateWithiCloud authenticates a user from an Apple "Sign in with Apple"
 // ID token. The client completes Sign in with Apple and sends the resulting ID
 // token; Reservoir verifies its signature (Apple JWKS), issuer, audience, expiry
 // and a server-issued single-use nonce before trusting the `sub` claim. The
 // Apple UID is therefore taken only from a verified token, never asserted by the
 // caller. See LMS-6512 / security review Finding 1.
-func (s *AuthService) AuthenticateWithiCloud(ctx context.Context, idToken string) (*auth.LoginResponse, error) {
+//
+// name is what Apple gave the client on the user's first sign-in (zero on
+// later ones); it fills in a linked student or parent that has no name yet.
+func (s *AuthService) AuthenticateWithiCloud(ctx context.Context, idToken string, name AppleName) (_ *auth.LoginResponse, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
 	info, err := s.icloudSvc.VerifyIDToken(ctx, idToken)
 	if err != nil {
 		return nil, err
 	}
 
+	if info.FirstName == "" && info.LastName == "" {
+		info.FirstName, info.LastName = name.FirstName, name.LastName
+	}
+
 	// Find user by the verified iCloud UID
 	usr, meta, err := s.findOrCreateiCloudUser(ctx, info)
 	if err != nil {
 		return nil, err
 	}
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, err
+	}
 
 	s.lastLogin.Enqueue(usr.ID)
 
@@ -427,10 +759,21 @@ func (s *AuthService) AuthenticateWithiCloud(ctx context.Context, idToken string
 		usr.MetaType,
 		usr.MetaID,
 		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(meta)),
+		token.WithLocale(tokenLocale(usr, info)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(auth.AppFromContext(ctx)),
+		s.deviceSessions.Option(ctx),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "icloud", "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
 
 	return &auth.LoginResponse{
 		Token: tokenPair,
@@ -455,6 +798,9 @@ func (s *AuthService) findOrCreateiCloudUser(ctx context.Context, info *OAuthUse
 		if err != nil {
 			return nil, nil, err
 		}
+		if usr != nil {
+			s.fillAppleName(ctx, usr, student, info)
+		}
 		return usr, student, nil
 	}
 
@@ -469,8 +815,126 @@ func (s *AuthService) findOrCreateiCloudUser(ctx context.Context, info *OAuthUse
 		if err != nil {
 			return nil, nil, err
 		}
+		if usr != nil {
+			s.fillAppleName(ctx, usr, parent, info)
+		}
 		return usr, parent, nil
 	}
 
-	return nil, nil, fmt.Errorf("no account found for this iCloud UID. Please sign up first.")
+	return nil, nil, s.noLinkedAccount("icloud", "no account found for this iCloud UID. Please sign up first.", info)
+}
+
+// AuthenticateWithOIDC completes the redirect flow of the generic OIDC
+// provider name.
+func (s *AuthService) AuthenticateWithOIDC(ctx context.Context, name, code, state string) (_ *auth.LoginResponse, _ Flow, err error) {
+	if err := s.checkIPLimit(ctx); err != nil {
+		return nil, Flow{}, err
+	}
+	defer func() { s.recordIPFailure(ctx, err) }()
+
+	provider, ok := s.oidc[name]
+	if !ok {
+		return nil, Flow{}, apperrors.ErrNotFound
+	}
+	oauthUserInfo, flow, err := provider.HandleCallback(ctx, code, state)
+	if err != nil {
+		return nil, Flow{}, err
+	}
+
+	userWithMeta, err := s.findOIDCUser(ctx, name, oauthUserInfo)
+	if err != nil {
+		return nil, Flow{}, err
+	}
+	usr := &userWithMeta.User
+	if err := auth.CheckAccountStatus(usr); err != nil {
+		return nil, Flow{}, err
+	}
+
+	s.lastLogin.Enqueue(usr.ID)
+
+	boddleUID := ""
+	if usr.BoddleUID.Valid {
+		boddleUID = usr.BoddleUID.String
+	}
+
+	tokenPair, err := s.tokenService.Generate(
+		usr.ID,
+		boddleUID,
+		usr.Email,
+		userWithMeta.GetFullName(),
+		usr.MetaType,
+		usr.MetaID,
+		usr.TokenVersion,
+		token.WithUsername(user.StudentUsername(userWithMeta.Meta)),
+		token.WithLocale(tokenLocale(usr, oauthUserInfo)),
+		token.WithPasswordChangedAt(usr.PasswordChangedAt.Time),
+		token.WithAudience(flow.App),
+		s.deviceSessions.Option(ctx),
+	)
+	if err != nil {
+		return nil, Flow{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	if err := s.deviceSessions.ClaimSignIn(ctx, usr.ID, tokenPair); err != nil {
+		return nil, Flow{}, err
+	}
+	auth.SeedRefreshFamily(ctx, s.refreshFamilies, s.logger, usr.ID, tokenPair)
+	auth.AuditTokenIssued(ctx, s.tokenAuditor, s.logger, usr.ID, "oidc:"+name, "", tokenPair)
+	auth.IndexRefreshToken(ctx, s.refreshIndex, s.logger, usr.ID, tokenPair)
+
+	return &auth.LoginResponse{
+		Token: tokenPair,
+		User:  usr,
+		Meta:  userWithMeta.Meta,
+	}, flow, nil
+}
+
+// findOIDCUser finds the existing account for a generic OIDC identity. There
+// is nowhere to link an OIDC subject, so accounts are matched by email, and
+// only an email the provider has verified; an unverified or missing one is
+// EMAIL_NOT_VERIFIED.
+// Note: User creation is handled by Rails, so we only look up existing accounts.
+func (s *AuthService) findOIDCUser(ctx context.Context, name string, info *OAuthUserInfo) (*user.UserWithMeta, error) {
+	if info.Email == "" || !info.EmailVerified {
+		return nil, apperrors.ErrEmailNotVerified
+	}
+	usr, err := s.userRepo.FindByEmail(ctx, info.Email)
+	if err != nil {
+		return nil, err
+	}
+	if usr == nil {
+		return nil, s.noLinkedAccount("oidc:"+name, "no account found for this "+name+" account. Please sign up first.", info)
+	}
+	return s.userRepo.FindWithMeta(ctx, usr.ID)
+}
+
+// checkLinkLimit returns ErrTooManyLinkedProviders when linking provider to
+// meta would take the account past the cap configured for its meta type.
+// Re-linking a provider the account already has (a changed UID) doesn't add
+// to the count, so it's always allowed.
+func (s *AuthService) checkLinkLimit(metaType string, meta interface{}, provider string) error {
+	max, ok := s.maxLinkedProviders[metaType]
+	if !ok {
+		return nil
+	}
+
+	linked := (&user.UserWithMeta{Meta: meta}).LinkedProviders()
+	for _, p := range linked {
+		if p == provider {
+			return nil
+		}
+	}
+	if len(linked) >= max {
+		return apperrors.ErrTooManyLinkedProviders
+	}
+	return nil
+}
+
+// tokenLocale picks the locale claim for a login: the user's stored preference
+// wins, falling back to the provider-reported locale (Google) so a user who
+// never set one is still localized.
+func tokenLocale(usr *user.User, info *OAuthUserInfo) string {
+	if usr.Locale != "" {
+		return usr.Locale
+	}
+	return info.Locale
 }
@@ -0,0 +1,141 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/token"
+	"github.com/boddle/reservoir/internal/user"
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeProvider returns a canned identity from every flow, standing in for
+// Google/Clever without Redis state or network calls.
+type fakeProvider struct {
+	info *OAuthUserInfo
+	flow Flow
+}
+
+func (f *fakeProvider) GetAuthURL(ctx context.Context, flow Flow) (string, error) {
+	return "https://provider.example/authorize", nil
+}
+
+func (f *fakeProvider) HandleCallback(ctx context.Context, code, state string) (*OAuthUserInfo, Flow, error) {
+	return f.info, f.flow, nil
+}
+
+func (f *fakeProvider) verifyTokenAudience(ctx context.Context, accessToken string) error {
+	return nil
+}
+
+func (f *fakeProvider) fetchUserInfo(ctx context.Context, accessToken string) (*OAuthUserInfo, error) {
+	return f.info, nil
+}
+
+func (f *fakeProvider) allowsAdmins() bool { return false }
+
+// recordingEnqueuer captures last_logged_on enqueues.
+type recordingEnqueuer struct{ ids []int }
+
+func (r *recordingEnqueuer) Enqueue(userID int) { r.ids = append(r.ids, userID) }
+
+var (
+	userColumns    = []string{"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id", "last_logged_on", "token_version", "locale", "created_at", "updated_at"}
+	teacherColumns = []string{"id", "first_name", "last_name", "google_uid", "clever_uid", "is_verified", "created_at", "updated_at"}
+	studentColumns = []string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}
+)
+
+func newGoogleTestService(t *testing.T, info *OAuthUserInfo) (*AuthService, sqlmock.Sqlmock, *recordingEnqueuer) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	enq := &recordingEnqueuer{}
+	s := NewAuthService(
+		user.NewRepository(sqlxDB, sqlxDB),
+		token.NewService("access-secret", "refresh-secret", time.Hour, time.Hour),
+		&fakeProvider{info: info, flow: Flow{RedirectURL: "/dashboard"}},
+		nil, nil, enq,
+	)
+	return s, mock, enq
+}
+
+func TestAuthenticateWithGoogle_LinkedByUID(t *testing.T) {
+	s, mock, enq := newGoogleTestService(t, &OAuthUserInfo{
+		ProviderUserID: "google-123",
+		Email:          "teacher@school.org",
+	})
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM teachers\s+WHERE google_uid`).
+		WithArgs("google-123").
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", "google-123", nil, true, now, now))
+	mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+		WithArgs("Teacher", 7).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "Ms. Frizzle", "teacher@school.org", "", "uid-1", "Teacher", 7, nil, 0, "", now, now))
+
+	resp, flow, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+	if err != nil {
+		t.Fatalf("AuthenticateWithGoogle: %v", err)
+	}
+	if flow.RedirectURL != "/dashboard" {
+		t.Errorf("redirectURL = %q, want /dashboard", flow.RedirectURL)
+	}
+	if resp.User.ID != 1 || resp.Token == nil {
+		t.Errorf("got user %d, token %v; want user 1 with a token", resp.User.ID, resp.Token)
+	}
+	if len(enq.ids) != 1 || enq.ids[0] != 1 {
+		t.Errorf("last_logged_on enqueues = %v, want [1]", enq.ids)
+	}
+	// Already linked: no UID write.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthenticateWithGoogle_LinksByEmail(t *testing.T) {
+	s, mock, _ := newGoogleTestService(t, &OAuthUserInfo{
+		ProviderUserID: "google-456",
+		Email:          "teacher@school.org",
+	})
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM teachers\s+WHERE google_uid`).
+		WithArgs("google-456").
+		WillReturnRows(sqlmock.NewRows(teacherColumns))
+	mock.ExpectQuery(`FROM students\s+WHERE google_uid`).
+		WithArgs("google-456").
+		WillReturnRows(sqlmock.NewRows(studentColumns))
+	mock.ExpectQuery(`FROM users\s+WHERE email`).
+		WithArgs("teacher@school.org").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "Ms. Frizzle", "teacher@school.org", "", "uid-1", "Teacher", 7, nil, 0, "", now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = \$1\s+FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", nil, nil, true, now, now))
+	mock.ExpectExec(`UPDATE teachers SET google_uid`).
+		WithArgs("google-456", sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, _, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+	if err != nil {
+		t.Fatalf("AuthenticateWithGoogle: %v", err)
+	}
+	teacher, ok := resp.Meta.(*user.Teacher)
+	if !ok {
+		t.Fatalf("meta = %T, want *user.Teacher", resp.Meta)
+	}
+	if teacher.GoogleUID.String != "google-456" {
+		t.Errorf("returned teacher GoogleUID = %q, want newly linked google-456", teacher.GoogleUID.String)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,28 +4,138 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/redis/go-redis/v9"
 )
 
-// StateManager manages OAuth state tokens for CSRF prevention
-type StateManager struct {
-	client *redis.Client
-	ttl    time.Duration
+const (
+	// defaultStateMaxAge applies when no max age is configured.
+	defaultStateMaxAge = 10 * time.Minute
+
+	// staleStateRetention keeps a state in Redis this long past its max age,
+	// so a callback that arrives late can be told its session expired
+	// instead of failing like a forged state.
+	staleStateRetention = 30 * time.Minute
+
+	// purgeScanCount is the COUNT hint for each SCAN call in PurgeExpired.
+	purgeScanCount = 500
+)
+
+var (
+	oauthStatesOutstanding = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "oauth_states_outstanding",
+			Help: "OAuth states still within their max age, as of the last PurgeExpired run",
+		},
+	)
+
+	oauthStatesPurgedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "oauth_states_purged_total",
+			Help: "Expired OAuth states deleted by PurgeExpired",
+		},
+	)
+)
+
+// StateManager issues the state parameter of a redirect-based OAuth flow and
+// checks it when the provider calls back, for CSRF prevention. provider
+// ("google", "clever") binds a state to the flow it was issued for.
+type StateManager interface {
+	// IssueState returns a new state for flow.
+	IssueState(ctx context.Context, provider string, flow Flow) (string, error)
+	// ValidateState checks a callback's state and returns the flow it was
+	// issued for.
+	// A state older than the max age returns apperrors.ErrOAuthSessionExpired:
+	// the user took too long at the provider and should simply start again,
+	// which is a different story from an unknown (possibly forged) state.
+	ValidateState(ctx context.Context, provider, state string) (Flow, error)
+	// PurgeExpired deletes stored states past their max age and returns how
+	// many it removed. Managers that store nothing return 0.
+	PurgeExpired(ctx context.Context) (int, error)
 }
 
-// NewStateManager creates a new OAuth state manager
-func NewStateManager(client *redis.Client) *StateManager {
-	return &StateManager{
+// Flow is what a redirect flow carries from its start to the callback.
+type Flow struct {
+	// RedirectURL is where the client is sent once signed in.
+	RedirectURL string
+	// CallbackURI is the redirect_uri the provider was sent, "" for the
+	// configured default; the code exchange must repeat it.
+	CallbackURI string
+	// App scopes the issued tokens to one Boddle app (see auth.Apps); ""
+	// for unscoped tokens.
+	App string
+	// CodeVerifier is the flow's PKCE code_verifier, which the code exchange
+	// must present; "" for providers without PKCE. State managers keep it
+	// server-side or sealed: it must never reach the browser in the clear.
+	CodeVerifier string
+	// Response is how the callback answers: "" for JSON, responseCookie to
+	// set the access token cookie and redirect to RedirectURL, or
+	// responseFragment to redirect to RedirectURL with the tokens in its
+	// fragment.
+	Response string
+}
+
+var errInvalidState = errors.New("invalid or expired state token")
+
+// RedisStateManager keeps each state in Redis, which makes it single-use.
+type RedisStateManager struct {
+	client   *redis.Client
+	ttl      time.Duration
+	maxAge   time.Duration
+	maxPerIP int // 0: no per-IP cap
+	binding  ClientBinding
+}
+
+// stateData is what SaveState stores under each state token.
+type stateData struct {
+	RedirectURL  string    `json:"redirect_url"`
+	CallbackURI  string    `json:"callback_uri,omitempty"`
+	App          string    `json:"app,omitempty"`
+	Provider     string    `json:"provider,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Client       string    `json:"client,omitempty"` // ClientBinding fingerprint
+	Response     string    `json:"response,omitempty"`
+	CodeVerifier string    `json:"code_verifier,omitempty"`
+}
+
+// NewRedisStateManager creates a Redis-backed state manager. A callback more
+// than maxAge after its flow started is rejected with ErrOAuthSessionExpired;
+// maxAge <= 0 uses the 10 minute default.
+func NewRedisStateManager(client *redis.Client, maxAge time.Duration) *RedisStateManager {
+	if maxAge <= 0 {
+		maxAge = defaultStateMaxAge
+	}
+	return &RedisStateManager{
 		client: client,
-		ttl:    10 * time.Minute, // State expires after 10 minutes
+		ttl:    maxAge + staleStateRetention,
+		maxAge: maxAge,
 	}
 }
 
+// SetMaxPerIP caps the states issued to one client IP within the max age;
+// IssueState returns apperrors.ErrTooManyOAuthFlows past it. n <= 0 (the
+// default) disables the cap, as does a request with no client IP.
+func (sm *RedisStateManager) SetMaxPerIP(n int) {
+	sm.maxPerIP = n
+}
+
+// SetClientBinding binds each state to the client that started its flow;
+// ValidateState rejects a callback from a client that doesn't match.
+// ClientBindingOff (the default) disables it.
+func (sm *RedisStateManager) SetClientBinding(b ClientBinding) {
+	sm.binding = b
+}
+
 // GenerateState generates a random state token
-func (sm *StateManager) GenerateState() (string, error) {
+func (sm *RedisStateManager) GenerateState() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", fmt.Errorf("failed to generate random state: %w", err)
@@ -33,11 +143,69 @@ func (sm *StateManager) GenerateState() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// IssueState generates a state token and saves it for provider's flow.
+func (sm *RedisStateManager) IssueState(ctx context.Context, provider string, flow Flow) (string, error) {
+	if err := sm.checkIPCap(ctx); err != nil {
+		return "", err
+	}
+	state, err := sm.GenerateState()
+	if err != nil {
+		return "", err
+	}
+	if err := sm.SaveState(ctx, state, provider, flow); err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+// checkIPCap counts a new state against the client IP's window and rejects
+// it once the IP is over maxPerIP. The window starts with the IP's first
+// state and lasts maxAge, after which every state it covered has expired.
+func (sm *RedisStateManager) checkIPCap(ctx context.Context) error {
+	if sm.maxPerIP <= 0 {
+		return nil
+	}
+	ip := requestid.ClientIP(ctx)
+	if ip == "" {
+		return nil
+	}
+
+	key := fmt.Sprintf("oauth:state-ip:%s", ip)
+	count, err := sm.client.Incr(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to count OAuth states for IP: %w", err)
+	}
+	if count == 1 {
+		if err := sm.client.Expire(ctx, key, sm.maxAge).Err(); err != nil {
+			return fmt.Errorf("failed to count OAuth states for IP: %w", err)
+		}
+	}
+	if count > int64(sm.maxPerIP) {
+		return apperrors.ErrTooManyOAuthFlows
+	}
+	return nil
+}
+
 // SaveState saves a state token to Redis
-func (sm *StateManager) SaveState(ctx context.Context, state, redirectURL string) error {
+func (sm *RedisStateManager) SaveState(ctx context.Context, state, provider string, flow Flow) error {
 	key := fmt.Sprintf("oauth:state:%s", state)
 
-	err := sm.client.Set(ctx, key, redirectURL, sm.ttl).Err()
+	data, err := json.Marshal(stateData{
+		RedirectURL: flow.RedirectURL,
+		CallbackURI: flow.CallbackURI,
+		App:         flow.App,
+		Provider:    provider,
+		CreatedAt:   time.Now().UTC(),
+		Client:      sm.binding.fingerprint(ctx),
+		Response:    flow.Response,
+
+		CodeVerifier: flow.CodeVerifier,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode OAuth state: %w", err)
+	}
+
+	err = sm.client.Set(ctx, key, data, sm.ttl).Err()
 	if err != nil {
 		return fmt.Errorf("failed to save OAuth state: %w", err)
 	}
@@ -45,22 +213,79 @@ func (sm *StateManager) SaveState(ctx context.Context, state, redirectURL string
 	return nil
 }
 
-// ValidateState validates a state token and returns the redirect URL
-func (sm *StateManager) ValidateState(ctx context.Context, state string) (string, error) {
+// ValidateState validates a state token and returns its flow. The token is
+// deleted, so it can be used only once.
+func (sm *RedisStateManager) ValidateState(ctx context.Context, provider, state string) (Flow, error) {
 	key := fmt.Sprintf("oauth:state:%s", state)
 
-	redirectURL, err := sm.client.Get(ctx, key).Result()
+	raw, err := sm.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("invalid or expired state token")
+		return Flow{}, errInvalidState
 	}
 	if err != nil {
-		return "", fmt.Errorf("failed to validate OAuth state: %w", err)
+		return Flow{}, fmt.Errorf("failed to validate OAuth state: %w", err)
 	}
 
 	// Delete state after use (one-time use)
 	_ = sm.client.Del(ctx, key).Err()
 
-	return redirectURL, nil
+	var data stateData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		// Saved before states carried a creation time: the value is the bare
+		// redirect URL, and the Redis TTL has already bounded its age.
+		return Flow{RedirectURL: raw}, nil
+	}
+	if data.Provider != "" && data.Provider != provider {
+		return Flow{}, errInvalidState
+	}
+	if !sm.binding.matches(ctx, data.Client) {
+		return Flow{}, errInvalidState
+	}
+	if time.Since(data.CreatedAt) > sm.maxAge {
+		return Flow{}, apperrors.ErrOAuthSessionExpired
+	}
+
+	return Flow{RedirectURL: data.RedirectURL, CallbackURI: data.CallbackURI, App: data.App, CodeVerifier: data.CodeVerifier, Response: data.Response}, nil
+}
+
+// PurgeExpired deletes states whose flows are past the max age, for
+// maintenance when abandoned sign-ins pile up faster than their TTLs clear
+// them. A state is kept for staleStateRetention past its max age only so a
+// late callback reads as OAUTH_SESSION_EXPIRED; once purged, such a callback
+// gets the generic invalid-state error instead.
+//
+// Keys are found with SCAN, so a large keyspace isn't blocked. The states
+// left behind are published as the oauth_states_outstanding gauge.
+func (sm *RedisStateManager) PurgeExpired(ctx context.Context) (int, error) {
+	purged, outstanding := 0, 0
+	iter := sm.client.Scan(ctx, 0, "oauth:state:*", purgeScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		ttl, err := sm.client.TTL(ctx, key).Result()
+		if err != nil {
+			return purged, fmt.Errorf("failed to read OAuth state TTL: %w", err)
+		}
+		// Every state is saved with a TTL of maxAge + staleStateRetention,
+		// so less than staleStateRetention left means the flow is over.
+		// Keys without a TTL (-1) or already gone (-2) are left alone.
+		if ttl < 0 || ttl >= staleStateRetention {
+			if ttl >= 0 {
+				outstanding++
+			}
+			continue
+		}
+		if err := sm.client.Del(ctx, key).Err(); err != nil {
+			return purged, fmt.Errorf("failed to purge OAuth state: %w", err)
+		}
+		purged++
+	}
+	if err := iter.Err(); err != nil {
+		return purged, fmt.Errorf("failed to scan OAuth states: %w", err)
+	}
+
+	oauthStatesPurgedTotal.Add(float64(purged))
+	oauthStatesOutstanding.Set(float64(outstanding))
+	return purged, nil
 }
 
 // OAuthUserInfo represents user information from OAuth provider
@@ -71,4 +296,7 @@ type OAuthUserInfo struct {
 	LastName       string
 	Picture        string
 	EmailVerified  bool
+	Locale         string // provider-reported locale (Google and OIDC only); empty when unknown
+	ProviderRole   string // provider-reported account type (Clever only), e.g. "teacher", "district_admin"
+	DistrictID     string // Clever district the account belongs to (Clever only); empty when unknown
 }
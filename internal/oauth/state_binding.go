@@ -0,0 +1,61 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/boddle/reservoir/pkg/requestid"
+)
+
+// ClientBinding is what a state is bound to besides its random value: the
+// callback must come from a client with the same fingerprint as the one
+// that started the flow, so a state lifted mid-flow can't be completed
+// elsewhere. The fingerprint is read from the request context (see
+// requestid.ClientIP and requestid.UserAgent).
+type ClientBinding string
+
+const (
+	// ClientBindingOff binds states to nothing.
+	ClientBindingOff ClientBinding = "off"
+	// ClientBindingUserAgent binds states to the User-Agent, which
+	// survives a mobile client changing networks mid-flow.
+	ClientBindingUserAgent ClientBinding = "user_agent"
+	// ClientBindingIP binds states to both the client IP and User-Agent.
+	ClientBindingIP ClientBinding = "ip"
+)
+
+// ParseClientBinding validates an OAUTH_STATE_CLIENT_BINDING value.
+func ParseClientBinding(s string) (ClientBinding, error) {
+	switch b := ClientBinding(s); b {
+	case ClientBindingOff, ClientBindingUserAgent, ClientBindingIP:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown OAuth state client binding %q", s)
+}
+
+// fingerprint hashes the parts of ctx's client that b binds to; "" when b
+// binds to nothing. Only the hash is stored, so a signed state doesn't
+// carry the client IP around in the clear.
+func (b ClientBinding) fingerprint(ctx context.Context) string {
+	var parts string
+	switch b {
+	case ClientBindingUserAgent:
+		parts = "ua:" + requestid.UserAgent(ctx)
+	case ClientBindingIP:
+		parts = "ip:" + requestid.ClientIP(ctx) + "\x00ua:" + requestid.UserAgent(ctx)
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(parts))
+	return hex.EncodeToString(sum[:16])
+}
+
+// matches reports whether the callback in ctx may complete a flow whose
+// state recorded stored. With b off, or a state issued with no fingerprint
+// (before binding was enabled), any client matches.
+func (b ClientBinding) matches(ctx context.Context, stored string) bool {
+	current := b.fingerprint(ctx)
+	return current == "" || stored == "" || stored == current
+}
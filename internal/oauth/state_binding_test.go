@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boddle/reservoir/pkg/requestid"
+)
+
+// bindingStateManager is a StateManager that can be bound to its client.
+type bindingStateManager interface {
+	StateManager
+	SetClientBinding(ClientBinding)
+}
+
+func clientContext(ip, ua string) context.Context {
+	return requestid.WithUserAgent(requestid.WithClientIP(context.Background(), ip), ua)
+}
+
+func TestStateClientBinding(t *testing.T) {
+	const phone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)"
+	start := clientContext("203.0.113.7", phone)
+	cases := []struct {
+		name     string
+		binding  ClientBinding
+		callback context.Context
+		ok       bool
+	}{
+		{"ua: same client", ClientBindingUserAgent, clientContext("203.0.113.7", phone), true},
+		{"ua: new IP, same browser", ClientBindingUserAgent, clientContext("198.51.100.9", phone), true},
+		{"ua: other browser", ClientBindingUserAgent, clientContext("203.0.113.7", "curl/8.4.0"), false},
+		{"ip: same client", ClientBindingIP, clientContext("203.0.113.7", phone), true},
+		{"ip: new IP, same browser", ClientBindingIP, clientContext("198.51.100.9", phone), false},
+		{"ip: other browser", ClientBindingIP, clientContext("203.0.113.7", "curl/8.4.0"), false},
+		{"off: other client", ClientBindingOff, clientContext("198.51.100.9", "curl/8.4.0"), true},
+	}
+
+	managers := map[string]func(*testing.T) bindingStateManager{
+		"redis":  func(t *testing.T) bindingStateManager { return newTestStateManager(t) },
+		"signed": func(t *testing.T) bindingStateManager { return newTestSignedStateManager(t, nil) },
+	}
+	for mode, newManager := range managers {
+		for _, tc := range cases {
+			t.Run(mode+"/"+tc.name, func(t *testing.T) {
+				sm := newManager(t)
+				sm.SetClientBinding(tc.binding)
+				state, err := sm.IssueState(start, "google", Flow{RedirectURL: "/classes"})
+				if err != nil {
+					t.Fatalf("IssueState: %v", err)
+				}
+
+				flow, err := sm.ValidateState(tc.callback, "google", state)
+				if tc.ok && (err != nil || flow.RedirectURL != "/classes") {
+					t.Errorf("ValidateState = %+v, %v; want the flow", flow, err)
+				}
+				if !tc.ok && !errors.Is(err, errInvalidState) {
+					t.Errorf("ValidateState err = %v, want errInvalidState", err)
+				}
+			})
+		}
+	}
+}
+
+func TestStateClientBinding_UnboundStateStillValid(t *testing.T) {
+	// States issued before binding was turned on carry no fingerprint and
+	// must not fail the flows in progress at deploy time.
+	sm := newTestStateManager(t)
+	state, err := sm.IssueState(context.Background(), "google", Flow{RedirectURL: "/"})
+	if err != nil {
+		t.Fatalf("IssueState: %v", err)
+	}
+	sm.SetClientBinding(ClientBindingIP)
+	if _, err := sm.ValidateState(clientContext("198.51.100.9", "curl/8.4.0"), "google", state); err != nil {
+		t.Errorf("ValidateState: %v", err)
+	}
+}
+
+func TestParseClientBinding(t *testing.T) {
+	for _, s := range []string{"off", "user_agent", "ip"} {
+		if b, err := ParseClientBinding(s); err != nil || string(b) != s {
+			t.Errorf("ParseClientBinding(%q) = %q, %v", s, b, err)
+		}
+	}
+	if _, err := ParseClientBinding("strict"); err == nil {
+		t.Error("ParseClientBinding accepted an unknown mode")
+	}
+}
@@ -0,0 +1,202 @@
+package oauth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// minStateSecretLength matches what we require of the JWT secrets.
+const minStateSecretLength = 32
+
+// SignedStateManager carries the state data in the state parameter itself,
+// HMAC-signed, so nothing is stored between the redirect and the callback.
+//
+// Without storage a state can't be deleted once used: within maxAge it can
+// be replayed. Keep maxAge short, or pass a Redis client to NewSignedStateManager
+// to remember used nonces and make each state single-use again.
+type SignedStateManager struct {
+	secret  []byte
+	maxAge  time.Duration
+	nonces  *redis.Client // nil: no single-use check
+	binding ClientBinding
+
+	// sealer encrypts a flow's PKCE verifier inside the state, which the
+	// browser and provider both see; its key is derived from secret.
+	sealer cipher.AEAD
+}
+
+// signedState is the payload of a signed state token.
+type signedState struct {
+	RedirectURL string `json:"r"`
+	CallbackURI string `json:"c,omitempty"`
+	App         string `json:"a,omitempty"`
+	Provider    string `json:"p"`
+	Nonce       string `json:"n"`
+	IssuedAt    int64  `json:"t"`
+	Verifier    string `json:"v,omitempty"` // sealed PKCE code_verifier
+	Client      string `json:"b,omitempty"` // ClientBinding fingerprint
+	Response    string `json:"m,omitempty"`
+}
+
+// NewSignedStateManager creates a stateless state manager keyed by secret.
+// maxAge <= 0 uses the 10 minute default. nonces, if not nil, records each
+// state's nonce on first use and rejects it after that.
+func NewSignedStateManager(secret []byte, maxAge time.Duration, nonces *redis.Client) (*SignedStateManager, error) {
+	if len(secret) < minStateSecretLength {
+		return nil, fmt.Errorf("OAuth state secret must be at least %d bytes", minStateSecretLength)
+	}
+	if maxAge <= 0 {
+		maxAge = defaultStateMaxAge
+	}
+	key := hmac.New(sha256.New, secret)
+	key.Write([]byte("oauth-state-pkce-verifier"))
+	block, err := aes.NewCipher(key.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth state cipher: %w", err)
+	}
+	sealer, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth state cipher: %w", err)
+	}
+	return &SignedStateManager{secret: secret, maxAge: maxAge, nonces: nonces, sealer: sealer}, nil
+}
+
+// SetClientBinding binds each state to the client that started its flow,
+// as for RedisStateManager.SetClientBinding.
+func (sm *SignedStateManager) SetClientBinding(b ClientBinding) {
+	sm.binding = b
+}
+
+// IssueState encodes and signs the state for provider's flow.
+func (sm *SignedStateManager) IssueState(ctx context.Context, provider string, flow Flow) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate random state: %w", err)
+	}
+
+	verifier, err := sm.sealVerifier(flow.CodeVerifier)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(signedState{
+		RedirectURL: flow.RedirectURL,
+		CallbackURI: flow.CallbackURI,
+		App:         flow.App,
+		Provider:    provider,
+		Nonce:       hex.EncodeToString(nonce),
+		IssuedAt:    time.Now().Unix(),
+		Verifier:    verifier,
+		Client:      sm.binding.fingerprint(ctx),
+		Response:    flow.Response,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode OAuth state: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sm.sign(encoded)), nil
+}
+
+// PurgeExpired is a no-op: signed states aren't stored, and used nonces
+// expire with their TTL.
+func (sm *SignedStateManager) PurgeExpired(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// ValidateState verifies the signature, provider and age of state and
+// returns its flow.
+func (sm *SignedStateManager) ValidateState(ctx context.Context, provider, state string) (Flow, error) {
+	encoded, sig, ok := strings.Cut(state, ".")
+	if !ok {
+		return Flow{}, errInvalidState
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, sm.sign(encoded)) {
+		return Flow{}, errInvalidState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Flow{}, errInvalidState
+	}
+	var data signedState
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return Flow{}, errInvalidState
+	}
+	if data.Provider != provider {
+		return Flow{}, errInvalidState
+	}
+	if !sm.binding.matches(ctx, data.Client) {
+		return Flow{}, errInvalidState
+	}
+	if time.Since(time.Unix(data.IssuedAt, 0)) > sm.maxAge {
+		return Flow{}, apperrors.ErrOAuthSessionExpired
+	}
+
+	if sm.nonces != nil {
+		// The nonce only needs remembering while the state is still young
+		// enough to pass the age check above.
+		first, err := sm.nonces.SetNX(ctx, "oauth:state-nonce:"+data.Nonce, 1, sm.maxAge).Result()
+		if err != nil {
+			return Flow{}, fmt.Errorf("failed to validate OAuth state: %w", err)
+		}
+		if !first {
+			return Flow{}, errInvalidState
+		}
+	}
+
+	verifier, err := sm.openVerifier(data.Verifier)
+	if err != nil {
+		return Flow{}, errInvalidState
+	}
+
+	return Flow{RedirectURL: data.RedirectURL, CallbackURI: data.CallbackURI, App: data.App, CodeVerifier: verifier, Response: data.Response}, nil
+}
+
+// sealVerifier encrypts a PKCE verifier for the state payload; "" stays "".
+func (sm *SignedStateManager) sealVerifier(verifier string) (string, error) {
+	if verifier == "" {
+		return "", nil
+	}
+	nonce := make([]byte, sm.sealer.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to seal PKCE verifier: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sm.sealer.Seal(nonce, nonce, []byte(verifier), nil)), nil
+}
+
+// openVerifier reverses sealVerifier.
+func (sm *SignedStateManager) openVerifier(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < sm.sealer.NonceSize() {
+		return "", errInvalidState
+	}
+	nonce, ciphertext := raw[:sm.sealer.NonceSize()], raw[sm.sealer.NonceSize():]
+	verifier, err := sm.sealer.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errInvalidState
+	}
+	return string(verifier), nil
+}
+
+func (sm *SignedStateManager) sign(encoded string) []byte {
+	h := hmac.New(sha256.New, sm.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
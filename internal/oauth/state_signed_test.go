@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const testStateSecret = "test-oauth-state-secret-32-chars"
+
+func newTestSignedStateManager(t *testing.T, nonces *redis.Client) *SignedStateManager {
+	t.Helper()
+	sm, err := NewSignedStateManager([]byte(testStateSecret), 5*time.Minute, nonces)
+	if err != nil {
+		t.Fatalf("NewSignedStateManager: %v", err)
+	}
+	return sm
+}
+
+// signState signs an arbitrary payload the way IssueState does.
+func signState(sm *SignedStateManager, data signedState) string {
+	payload, _ := json.Marshal(data)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sm.sign(encoded))
+}
+
+func TestSignedStateManager_RoundTrip(t *testing.T) {
+	sm := newTestSignedStateManager(t, nil)
+	ctx := context.Background()
+
+	state, err := sm.IssueState(ctx, "clever", Flow{RedirectURL: "/classes?id=3", App: "teacher-web"})
+	if err != nil {
+		t.Fatalf("IssueState: %v", err)
+	}
+	flow, err := sm.ValidateState(ctx, "clever", state)
+	if err != nil || flow.RedirectURL != "/classes?id=3" {
+		t.Errorf("ValidateState = %q, %v; want /classes?id=3", flow.RedirectURL, err)
+	}
+	if flow.App != "teacher-web" {
+		t.Errorf("flow app = %q, want teacher-web", flow.App)
+	}
+}
+
+func TestSignedStateManager_RejectsBadSignature(t *testing.T) {
+	sm := newTestSignedStateManager(t, nil)
+	ctx := context.Background()
+	state, _ := sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"})
+	encoded, sig, _ := strings.Cut(state, ".")
+
+	// Same payload with the redirect swapped, keeping the original signature.
+	forged := signState(sm, signedState{RedirectURL: "https://evil.example", Provider: "google", IssuedAt: time.Now().Unix()})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	other, err := NewSignedStateManager([]byte("another-secret-that-is-32-chars!"), time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherState, _ := other.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"})
+
+	for name, s := range map[string]string{
+		"tampered payload": forgedPayload + "." + sig,
+		"truncated mac":    encoded + "." + sig[:10],
+		"no signature":     encoded,
+		"other secret":     otherState,
+		"garbage":          "not-a-state",
+	} {
+		if _, err := sm.ValidateState(ctx, "google", s); err == nil {
+			t.Errorf("%s: state accepted", name)
+		}
+	}
+
+	if _, err := sm.ValidateState(ctx, "clever", state); err == nil {
+		t.Error("Google state accepted on the Clever callback")
+	}
+}
+
+func TestSignedStateManager_Expiry(t *testing.T) {
+	sm := newTestSignedStateManager(t, nil)
+	stale := signState(sm, signedState{
+		RedirectURL: "/dashboard",
+		Provider:    "google",
+		Nonce:       "abc",
+		IssuedAt:    time.Now().Add(-6 * time.Minute).Unix(),
+	})
+
+	_, err := sm.ValidateState(context.Background(), "google", stale)
+	if !errors.Is(err, apperrors.ErrOAuthSessionExpired) {
+		t.Errorf("ValidateState error = %v, want ErrOAuthSessionExpired", err)
+	}
+}
+
+func TestSignedStateManager_SingleUse(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+
+	replayable := newTestSignedStateManager(t, nil)
+	state, _ := replayable.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"})
+	for i := 0; i < 2; i++ {
+		if _, err := replayable.ValidateState(ctx, "google", state); err != nil {
+			t.Fatalf("validation %d without a nonce cache: %v", i+1, err)
+		}
+	}
+
+	singleUse := newTestSignedStateManager(t, client)
+	state, _ = singleUse.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"})
+	if _, err := singleUse.ValidateState(ctx, "google", state); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := singleUse.ValidateState(ctx, "google", state); err == nil {
+		t.Error("state replayed with the nonce cache enabled")
+	}
+}
+
+func TestNewSignedStateManager_RejectsShortSecret(t *testing.T) {
+	if _, err := NewSignedStateManager([]byte("short"), time.Minute, nil); err == nil {
+		t.Error("expected an error for a short secret")
+	}
+}
@@ -1,14 +1,27 @@
 package oauth
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/boddle/reservoir/internal/config"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestStateManager_GenerateState(t *testing.T) {
 	// Note: This test requires Redis to be running
 	// In a real scenario, we'd mock Redis for unit tests
 
-	sm := &StateManager{
+	sm := &RedisStateManager{
 		client: nil, // Would use mock client
 	}
 
@@ -36,6 +49,142 @@ func TestStateManager_GenerateState(t *testing.T) {
 	}
 }
 
+func TestStateManager_ValidateStateRoundTrip(t *testing.T) {
+	sm := newTestStateManager(t)
+	ctx := context.Background()
+
+	state, err := sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard", App: "student-ios"})
+	if err != nil {
+		t.Fatalf("IssueState: %v", err)
+	}
+	flow, err := sm.ValidateState(ctx, "google", state)
+	if err != nil || flow.RedirectURL != "/dashboard" {
+		t.Fatalf("ValidateState = %q, %v; want /dashboard", flow.RedirectURL, err)
+	}
+	if flow.App != "student-ios" {
+		t.Errorf("flow app = %q, want student-ios", flow.App)
+	}
+	if _, err := sm.ValidateState(ctx, "google", state); err == nil {
+		t.Error("state accepted twice")
+	}
+
+	// A Google state can't complete a Clever callback.
+	state, _ = sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"})
+	if _, err := sm.ValidateState(ctx, "clever", state); err == nil {
+		t.Error("state accepted for another provider")
+	}
+}
+
+func TestStateManager_StaleStateIsSessionExpired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	sm := NewRedisStateManager(client, 5*time.Minute)
+
+	// Still in Redis, but the flow started 20 minutes ago.
+	stale, _ := json.Marshal(stateData{RedirectURL: "/dashboard", CreatedAt: time.Now().Add(-20 * time.Minute)})
+	mr.Set("oauth:state:stale", string(stale))
+
+	gs := NewGoogleService(config.GoogleConfig{ClientID: "cid"}, sm)
+	_, _, err := gs.HandleCallback(context.Background(), "auth-code", "stale")
+	if !errors.Is(err, apperrors.ErrOAuthSessionExpired) {
+		t.Fatalf("HandleCallback error = %v, want ErrOAuthSessionExpired", err)
+	}
+	if mr.Exists("oauth:state:stale") {
+		t.Error("stale state was not consumed")
+	}
+
+	// Reported with its own code, not as a generic OAuth/CSRF failure.
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	writeOAuthError(c, err)
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusUnauthorized || resp.Error.Code != apperrors.ErrCodeOAuthSessionExpired {
+		t.Errorf("response = %d %q, want 401 %s", w.Code, resp.Error.Code, apperrors.ErrCodeOAuthSessionExpired)
+	}
+}
+
+func TestStateManager_LegacyPlainState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	sm := NewRedisStateManager(client, 0)
+
+	// Saved by a release that stored only the redirect URL.
+	mr.Set("oauth:state:legacy", "/classes")
+	flow, err := sm.ValidateState(context.Background(), "google", "legacy")
+	if err != nil || flow.RedirectURL != "/classes" {
+		t.Errorf("ValidateState = %q, %v; want /classes", flow.RedirectURL, err)
+	}
+}
+
+func TestStateManager_PurgeExpired(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	sm := NewRedisStateManager(client, 5*time.Minute)
+	ctx := context.Background()
+
+	old, _ := sm.IssueState(ctx, "google", Flow{RedirectURL: "/old"})
+	// Past the 5 minute max age, inside the stale retention.
+	mr.FastForward(6 * time.Minute)
+	fresh, _ := sm.IssueState(ctx, "clever", Flow{RedirectURL: "/fresh"})
+	mr.Set("oauth:state-nonce:abc", "1")
+
+	purged, err := sm.PurgeExpired(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeExpired = %d, %v; want 1", purged, err)
+	}
+	if mr.Exists("oauth:state:" + old) {
+		t.Error("expired state was not purged")
+	}
+	if !mr.Exists("oauth:state:" + fresh) {
+		t.Error("live state was purged")
+	}
+	if !mr.Exists("oauth:state-nonce:abc") {
+		t.Error("nonce key was purged")
+	}
+	if flow, err := sm.ValidateState(ctx, "clever", fresh); err != nil || flow.RedirectURL != "/fresh" {
+		t.Errorf("ValidateState after purge = %q, %v; want /fresh", flow.RedirectURL, err)
+	}
+}
+
+func TestStateManager_MaxPerIP(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	sm := NewRedisStateManager(client, 5*time.Minute)
+	sm.SetMaxPerIP(2)
+	ctx := requestid.WithClientIP(context.Background(), "198.51.100.7")
+
+	for i := 0; i < 2; i++ {
+		if _, err := sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"}); err != nil {
+			t.Fatalf("IssueState %d: %v", i, err)
+		}
+	}
+	if _, err := sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"}); !errors.Is(err, apperrors.ErrTooManyOAuthFlows) {
+		t.Fatalf("IssueState over cap error = %v, want ErrTooManyOAuthFlows", err)
+	}
+
+	// Other clients have their own count.
+	other := requestid.WithClientIP(context.Background(), "198.51.100.8")
+	if _, err := sm.IssueState(other, "google", Flow{RedirectURL: "/dashboard"}); err != nil {
+		t.Errorf("IssueState for another IP: %v", err)
+	}
+
+	// The window closes with the max age.
+	mr.FastForward(5 * time.Minute)
+	if _, err := sm.IssueState(ctx, "google", Flow{RedirectURL: "/dashboard"}); err != nil {
+		t.Errorf("IssueState after window: %v", err)
+	}
+}
+
 func TestOAuthUserInfo(t *testing.T) {
 	info := &OAuthUserInfo{
 		ProviderUserID: "google-123",
@@ -0,0 +1,47 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/boddle/reservoir/internal/user"
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+func TestAuthenticateWithGoogle_BlockedStatus(t *testing.T) {
+	for _, tt := range []struct {
+		status string
+		want   *apperrors.AppError
+	}{
+		{user.StatusSuspended, apperrors.ErrAccountSuspended},
+		{user.StatusDeleted, apperrors.ErrAccountDisabled},
+	} {
+		t.Run(tt.status, func(t *testing.T) {
+			s, mock, enq := newGoogleTestService(t, &OAuthUserInfo{
+				ProviderUserID: "google-123",
+				Email:          "teacher@school.org",
+			})
+			now := time.Now()
+
+			mock.ExpectQuery(`FROM teachers\s+WHERE google_uid`).
+				WillReturnRows(sqlmock.NewRows(teacherColumns).AddRow(7, "Valerie", "Frizzle", "google-123", nil, true, now, now))
+			mock.ExpectQuery(`FROM users\s+WHERE meta_type = \$1 AND meta_id = \$2`).
+				WillReturnRows(sqlmock.NewRows(append(userColumns, "status")).
+					AddRow(1, "Ms. Frizzle", "teacher@school.org", "", "uid-1", "Teacher", 7, nil, 0, "", now, now, tt.status))
+
+			resp, _, err := s.AuthenticateWithGoogle(context.Background(), "code", "state")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if resp != nil {
+				t.Error("tokens issued for a blocked account")
+			}
+			if len(enq.ids) != 0 {
+				t.Errorf("last_logged_on enqueued for a blocked account: %v", enq.ids)
+			}
+		})
+	}
+}
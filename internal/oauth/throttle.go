@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ThrottledError reports that a provider rate-limited us (HTTP 429).
+// RetryAfter is its Retry-After hint, 0 if it gave none. The handlers answer
+// 503 with the same hint, so clients back off instead of retrying into the
+// throttle.
+type ThrottledError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s is rate limiting sign-ins; retry after %v", e.Provider, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s is rate limiting sign-ins", e.Provider)
+}
+
+// throttled returns a *ThrottledError if resp is a 429 from provider, else nil.
+func throttled(provider string, resp *http.Response) error {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	return &ThrottledError{
+		Provider:   provider,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// exchangeError turns a failed code exchange into a *ThrottledError when the
+// token endpoint answered 429, and otherwise returns err unchanged.
+func exchangeError(provider string, err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if t := throttled(provider, retrieveErr.Response); t != nil {
+			return t
+		}
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After value, either delay-seconds or an
+// HTTP-date (RFC 9110 §10.2.3). Missing, malformed or past values give 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+func TestGoogleTokenAuth_EchoesProviderRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client()}
+	handler := &Handler{authService: NewAuthService(nil, nil, gs, nil, nil, nil)}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/google", bytes.NewBufferString(`{"token":"ya29.token"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler.GoogleTokenAuth(c)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want the provider's 30", got)
+	}
+	if code := decodeErrorCode(t, w); code != "SERVICE_UNAVAILABLE" {
+		t.Errorf("error code = %q, want SERVICE_UNAVAILABLE", code)
+	}
+}
+
+func TestExchangeError_ThrottledTokenEndpoint(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"12"}}}
+	err := fmt.Errorf("failed to exchange code: %w", exchangeError("Clever", &oauth2.RetrieveError{Response: resp}))
+
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) || throttledErr.Provider != "Clever" || throttledErr.RetryAfter != 12*time.Second {
+		t.Errorf("exchangeError(429) = %v, want Clever throttled for 12s", err)
+	}
+
+	other := &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}}
+	if got := exchangeError("Clever", other); got != error(other) {
+		t.Errorf("exchangeError(400) = %v, want the error unchanged", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
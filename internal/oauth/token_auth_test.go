@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/boddle/reservoir/internal/user"
 	"github.com/gin-gonic/gin"
 )
 
@@ -258,3 +259,38 @@ func TestTokenAuth_RequiresToken(t *testing.T) {
 		}
 	}
 }
+
+// TestGoogleFetchUserInfo_CapturesLocale verifies Google's locale is carried
+// into OAuthUserInfo so it can seed the token's locale claim.
+func TestGoogleFetchUserInfo_CapturesLocale(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     "google-sub-123",
+			"email":  "real@school.edu",
+			"locale": "es-MX",
+		})
+	}))
+	defer srv.Close()
+
+	gs := &GoogleService{userInfoURL: srv.URL, httpClient: srv.Client()}
+
+	info, err := gs.fetchUserInfo(context.Background(), "valid-access-token")
+	if err != nil {
+		t.Fatalf("fetchUserInfo returned error: %v", err)
+	}
+	if info.Locale != "es-MX" {
+		t.Errorf("Locale = %q, want %q", info.Locale, "es-MX")
+	}
+}
+
+func TestTokenLocale_StoredPreferenceWins(t *testing.T) {
+	info := &OAuthUserInfo{Locale: "es-MX"}
+
+	if got := tokenLocale(&user.User{Locale: "fr"}, info); got != "fr" {
+		t.Errorf("tokenLocale with stored locale = %q, want fr", got)
+	}
+	if got := tokenLocale(&user.User{}, info); got != "es-MX" {
+		t.Errorf("tokenLocale without stored locale = %q, want provider es-MX", got)
+	}
+}
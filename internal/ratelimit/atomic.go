@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// checkAndRecordScript is CheckAndRecord's check-then-count, run as one
+// Redis command so concurrent logins can't all read the same count.
+//
+// KEYS: lockout, attempt counter, challenge counter, lockout count.
+// ARGV: maxAttempts, challengeThreshold, window ms, lockout ms, algorithm,
+// now ms, sliding-window member, lockout multiplier (1 = no escalation), max
+// lockout ms, escalation quiet period ms.
+// Returns {allowed, remaining, lockout remaining ms, challenge required}.
+var checkAndRecordScript = redis.NewScript(`
+local maxAttempts = tonumber(ARGV[1])
+local threshold = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local lockout = tonumber(ARGV[4])
+local sliding = ARGV[5] == "sliding"
+local now = tonumber(ARGV[6])
+local multiplier = tonumber(ARGV[8])
+local maxLockout = tonumber(ARGV[9])
+local quiet = tonumber(ARGV[10])
+
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	return {0, 0, ttl, 0}
+end
+
+local function count(key)
+	if sliding then
+		redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+		return redis.call("ZCARD", key)
+	end
+	return tonumber(redis.call("GET", key) or "0")
+end
+
+local function add(key)
+	if sliding then
+		redis.call("ZADD", key, now, ARGV[7])
+		redis.call("PEXPIRE", key, window)
+	elseif redis.call("INCR", key) == 1 then
+		redis.call("PEXPIRE", key, window)
+	end
+end
+
+-- The same sum as Limiter.lockoutFor.
+local function nextLockout()
+	if multiplier <= 1 then
+		return lockout
+	end
+	local n = redis.call("INCR", KEYS[4])
+	local d = math.min(lockout * multiplier ^ (n - 1), maxLockout)
+	d = math.floor(d)
+	redis.call("PEXPIRE", KEYS[4], d + quiet)
+	return d
+end
+
+local attempts = count(KEYS[2])
+if attempts >= maxAttempts then
+	lockout = nextLockout()
+	redis.call("SET", KEYS[1], "1", "PX", lockout)
+	redis.call("DEL", KEYS[2], KEYS[3])
+	return {0, 0, lockout, 0}
+end
+
+if threshold > 0 and count(KEYS[3]) >= threshold then
+	return {1, maxAttempts - attempts, 0, 1}
+end
+
+add(KEYS[2])
+if threshold > 0 then
+	add(KEYS[3])
+end
+if multiplier > 1 then
+	redis.call("PEXPIRE", KEYS[4], quiet)
+end
+return {1, maxAttempts - attempts - 1, 0, 0}
+`)
+
+// CheckAndRecord is CheckLoginAttempt and RecordFailedAttempt in one atomic
+// step: when the attempt is allowed it is counted as a failure straight
+// away, so however many logins race, no more than maxAttempts get through
+// per window. The caller clears the count with RecordSuccessfulAttempt if
+// the login then succeeds.
+//
+// When challengeRequired is true the attempt has not been counted; the
+// caller must have the client solve a CAPTCHA, ClearChallenge, and call
+// CheckAndRecord again.
+// Returns: allowed (bool), remainingAttempts (int), lockoutRemaining (time.Duration),
+// challengeRequired (bool), error
+func (l *Limiter) CheckAndRecord(ctx context.Context, email, ipAddress string) (bool, int, time.Duration, bool, error) {
+	now := l.now()
+	member := slidingMember(now)
+	multiplier := 1.0
+	if l.escalates() {
+		multiplier = l.lockoutMultiplier
+	}
+	res, err := checkAndRecordScript.Run(ctx, l.client,
+		[]string{l.LoginLockoutKey(email, ipAddress), l.LoginAttemptKey(email, ipAddress), l.LoginChallengeKey(email, ipAddress), l.LoginEscalationKey(email, ipAddress)},
+		l.maxAttempts, l.challengeThreshold, l.window.Milliseconds(), l.lockoutDuration.Milliseconds(),
+		string(l.algorithm), now.UnixMilli(), member,
+		multiplier, l.maxLockoutDuration.Milliseconds(), l.escalationQuietPeriod.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, 0, false, fmt.Errorf("failed to check and record login attempt: %w", err)
+	}
+	if len(res) != 4 {
+		return false, 0, 0, false, fmt.Errorf("unexpected check-and-record result %v", res)
+	}
+	return res[0] == 1, int(res[1]), time.Duration(res[2]) * time.Millisecond, res[3] == 1, nil
+}
+
+// refundScript takes back one attempt CheckAndRecord counted. A fixed-window
+// counter is only decremented while positive, and DECR keeps its TTL; a
+// sliding window drops its newest member.
+//
+// KEYS: attempt counter, challenge counter. ARGV: algorithm.
+var refundScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if ARGV[1] == "sliding" then
+		redis.call("ZPOPMAX", key)
+	elseif tonumber(redis.call("GET", key) or "0") > 0 then
+		redis.call("DECR", key)
+	end
+end
+return 0
+`)
+
+// RefundAttempt takes back the attempt CheckAndRecord counted for a login
+// that then failed for a reason other than bad credentials (the server was
+// busy, the database errored), so outages don't lock users out.
+func (l *Limiter) RefundAttempt(ctx context.Context, email, ipAddress string) error {
+	keys := []string{l.LoginAttemptKey(email, ipAddress)}
+	if l.challengeThreshold > 0 {
+		keys = append(keys, l.LoginChallengeKey(email, ipAddress))
+	}
+	if err := refundScript.Run(ctx, l.client, keys, string(l.algorithm)).Err(); err != nil {
+		return fmt.Errorf("failed to refund login attempt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestCheckAndRecord_ConcurrentAttemptsCapped(t *testing.T) {
+	for _, a := range []Algorithm{FixedWindow, SlidingWindow} {
+		t.Run(string(a), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			const maxAttempts, workers = 5, 50
+			l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: workers}), 10*time.Minute, maxAttempts, 15*time.Minute, zap.NewNop())
+			l.SetAlgorithm(a)
+			ctx := context.Background()
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					ok, _, _, _, err := l.CheckAndRecord(ctx, "kid1@student.student", "203.0.113.7")
+					if err != nil {
+						t.Errorf("CheckAndRecord: %v", err)
+						return
+					}
+					if ok {
+						allowed.Add(1)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if got := allowed.Load(); got != maxAttempts {
+				t.Errorf("%d of %d concurrent attempts allowed, want exactly %d", got, workers, maxAttempts)
+			}
+			if ttl := mr.TTL(l.LoginLockoutKey("kid1@student.student", "203.0.113.7")); ttl <= 0 {
+				t.Error("expected a lockout once the attempts ran out")
+			}
+		})
+	}
+}
+
+func TestCheckAndRecord_CountsAndLocksOut(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 3, 15*time.Minute, zap.NewNop())
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	for want := 2; want >= 0; want-- {
+		allowed, remaining, _, _, err := l.CheckAndRecord(ctx, email, ip)
+		if err != nil {
+			t.Fatalf("CheckAndRecord: %v", err)
+		}
+		if !allowed || remaining != want {
+			t.Fatalf("allowed = %v, remaining = %d; want true, %d", allowed, remaining, want)
+		}
+	}
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 3 {
+		t.Errorf("attempt count = %d, want 3", count)
+	}
+
+	allowed, _, lockout, _, err := l.CheckAndRecord(ctx, email, ip)
+	if err != nil {
+		t.Fatalf("CheckAndRecord: %v", err)
+	}
+	if allowed || lockout != 15*time.Minute {
+		t.Fatalf("allowed = %v, lockout = %v; want locked out for 15m", allowed, lockout)
+	}
+
+	// A success clears the count; ClearLockout lifts the lockout.
+	if err := l.ClearLockout(ctx, email, ip); err != nil {
+		t.Fatalf("ClearLockout: %v", err)
+	}
+	if _, _, _, _, err := l.CheckAndRecord(ctx, email, ip); err != nil {
+		t.Fatalf("CheckAndRecord: %v", err)
+	}
+	if err := l.RecordSuccessfulAttempt(ctx, email, ip); err != nil {
+		t.Fatalf("RecordSuccessfulAttempt: %v", err)
+	}
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 0 {
+		t.Errorf("attempt count after success = %d, want 0", count)
+	}
+}
+
+func TestCheckAndRecord_ChallengeIsNotCounted(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 5, 15*time.Minute, zap.NewNop())
+	l.SetChallengeThreshold(2)
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, challenge, err := l.CheckAndRecord(ctx, email, ip); err != nil || challenge {
+			t.Fatalf("attempt %d: challenge = %v, err = %v; want an unchallenged attempt", i+1, challenge, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		allowed, _, _, challenge, err := l.CheckAndRecord(ctx, email, ip)
+		if err != nil || !allowed || !challenge {
+			t.Fatalf("allowed = %v, challenge = %v, err = %v; want a challenge", allowed, challenge, err)
+		}
+	}
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 2 {
+		t.Errorf("attempt count = %d, want 2: challenged attempts aren't counted", count)
+	}
+
+	if err := l.ClearChallenge(ctx, email, ip); err != nil {
+		t.Fatalf("ClearChallenge: %v", err)
+	}
+	if _, _, _, challenge, err := l.CheckAndRecord(ctx, email, ip); err != nil || challenge {
+		t.Fatalf("after a solved challenge: challenge = %v, err = %v", challenge, err)
+	}
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 3 {
+		t.Errorf("attempt count = %d, want 3", count)
+	}
+}
+
+func TestRefundAttempt(t *testing.T) {
+	for _, a := range []Algorithm{FixedWindow, SlidingWindow} {
+		t.Run(string(a), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 3, 15*time.Minute, zap.NewNop())
+			l.SetAlgorithm(a)
+			ctx := context.Background()
+			const email, ip = "kid1@student.student", "203.0.113.7"
+
+			for i := 0; i < 2; i++ {
+				if _, _, _, _, err := l.CheckAndRecord(ctx, email, ip); err != nil {
+					t.Fatalf("CheckAndRecord: %v", err)
+				}
+			}
+			if err := l.RefundAttempt(ctx, email, ip); err != nil {
+				t.Fatalf("RefundAttempt: %v", err)
+			}
+			if count, _ := l.GetAttemptCount(ctx, email, ip); count != 1 {
+				t.Errorf("attempt count after one refund = %d, want 1", count)
+			}
+			if ttl := mr.TTL(l.LoginAttemptKey(email, ip)); ttl <= 0 {
+				t.Errorf("counter TTL after refund = %v, want it kept", ttl)
+			}
+
+			// Refunding more than was counted never goes below zero.
+			for i := 0; i < 3; i++ {
+				if err := l.RefundAttempt(ctx, email, ip); err != nil {
+					t.Fatalf("RefundAttempt: %v", err)
+				}
+			}
+			if count, _ := l.GetAttemptCount(ctx, email, ip); count != 0 {
+				t.Errorf("attempt count after over-refunding = %d, want 0", count)
+			}
+		})
+	}
+}
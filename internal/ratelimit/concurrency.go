@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// inFlightKeyTTL bounds how long a slot outlives an instance that died
+// before releasing it. Longer than any login can take (the server write
+// timeout is 15s), and refreshed by every acquire.
+const inFlightKeyTTL = 30 * time.Second
+
+// releaseScript gives back one in-flight slot. It only decrements a key that
+// still exists with a positive count: a bare DECR on a key that expired while
+// the login ran would create it at -1 with no TTL, handing that IP an extra
+// slot forever. DECR keeps the key's TTL.
+//
+// KEYS: in-flight counter.
+var releaseScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") > 0 then
+	redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// ConcurrencyGate caps how many logins from one IP may be in flight at once,
+// across every instance. Credential-stuffing tools open many connections in
+// parallel, so a burst can get through before the windowed attempt counter
+// in Limiter catches up; the gate stops the burst itself.
+type ConcurrencyGate struct {
+	client      *redis.Client
+	maxInFlight int
+}
+
+// NewConcurrencyGate creates a gate allowing maxInFlight concurrent logins
+// per IP.
+func NewConcurrencyGate(client *redis.Client, maxInFlight int) *ConcurrencyGate {
+	return &ConcurrencyGate{client: client, maxInFlight: maxInFlight}
+}
+
+// InFlightKey returns the Redis key counting in-flight logins from ipAddress
+func (g *ConcurrencyGate) InFlightKey(ipAddress string) string {
+	return fmt.Sprintf("ratelimit:inflight:%s", ipAddress)
+}
+
+// Acquire takes a slot for ipAddress. When allowed, release must be called
+// once the login has finished; when not, no slot is held.
+func (g *ConcurrencyGate) Acquire(ctx context.Context, ipAddress string) (release func(), allowed bool, err error) {
+	key := g.InFlightKey(ipAddress)
+
+	var incr *redis.IntCmd
+	_, err = g.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, inFlightKeyTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to acquire login slot: %w", err)
+	}
+
+	// The slot is given back even if the request's context is cancelled.
+	release = func() {
+		_ = releaseScript.Run(context.WithoutCancel(ctx), g.client, []string{key}).Err()
+	}
+	if incr.Val() > int64(g.maxInFlight) {
+		release()
+		return nil, false, nil
+	}
+	return release, true, nil
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SetLockoutEscalation makes repeat lockouts of one email/IP pair longer:
+// the nth lockout lasts lockoutDuration × multiplier^(n-1), capped at
+// maxLockout. The count of lockouts is forgotten once the pair has gone
+// quietPeriod without a failure or lockout. Escalation is off (every lockout
+// lasts lockoutDuration) unless multiplier > 1 and maxLockout is longer than
+// lockoutDuration.
+func (l *Limiter) SetLockoutEscalation(multiplier float64, maxLockout, quietPeriod time.Duration) {
+	l.lockoutMultiplier = multiplier
+	l.maxLockoutDuration = maxLockout
+	l.escalationQuietPeriod = quietPeriod
+}
+
+// escalates reports whether SetLockoutEscalation turned escalation on.
+func (l *Limiter) escalates() bool {
+	return l.lockoutMultiplier > 1 && l.maxLockoutDuration > l.lockoutDuration
+}
+
+// LoginEscalationKey returns the Redis key counting an email/IP pair's
+// recent lockouts
+func (l *Limiter) LoginEscalationKey(email, ipAddress string) string {
+	return fmt.Sprintf("ratelimit:lockouts:%s:%s", ipAddress, email)
+}
+
+// lockoutFor is how long the nth lockout lasts. checkAndRecordScript does
+// the same sum in Lua.
+func (l *Limiter) lockoutFor(n int64) time.Duration {
+	if !l.escalates() || n <= 1 {
+		return l.lockoutDuration
+	}
+	d := float64(l.lockoutDuration) * math.Pow(l.lockoutMultiplier, float64(n-1))
+	if d >= float64(l.maxLockoutDuration) {
+		return l.maxLockoutDuration
+	}
+	return time.Duration(d)
+}
+
+// nextLockout counts a lockout of the pair and returns how long it lasts. The
+// count lives until quietPeriod after the lockout ends.
+func (l *Limiter) nextLockout(ctx context.Context, email, ipAddress string) (time.Duration, error) {
+	if !l.escalates() {
+		return l.lockoutDuration, nil
+	}
+	key := l.LoginEscalationKey(email, ipAddress)
+	n, err := l.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count lockout: %w", err)
+	}
+	d := l.lockoutFor(n)
+	if err := l.client.PExpire(ctx, key, d+l.escalationQuietPeriod).Err(); err != nil {
+		return 0, fmt.Errorf("failed to set lockout count expiry: %w", err)
+	}
+	return d, nil
+}
+
+// extendEscalation restarts the pair's quiet period after a failure, so only
+// a quiet period free of failures forgets its lockouts. A pair with no
+// lockouts on record is left alone.
+func (l *Limiter) extendEscalation(ctx context.Context, email, ipAddress string) error {
+	if !l.escalates() {
+		return nil
+	}
+	return l.client.PExpire(ctx, l.LoginEscalationKey(email, ipAddress), l.escalationQuietPeriod).Err()
+}
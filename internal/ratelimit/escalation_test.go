@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const escEmail, escIP = "kid1@student.student", "203.0.113.7"
+
+// newEscalatingLimiter locks out after one failure, for 15m, 1h, 4h, 16h
+// and then 24h, forgetting after a quiet day.
+func newEscalatingLimiter(t *testing.T) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 1, 15*time.Minute, zap.NewNop())
+	l.SetLockoutEscalation(4, 24*time.Hour, 24*time.Hour)
+	return l, mr
+}
+
+// lockOut makes failures until the pair is locked out and returns the lockout.
+func lockOut(t *testing.T, l *Limiter) time.Duration {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		allowed, _, lockout, _, err := l.CheckAndRecord(ctx, escEmail, escIP)
+		if err != nil {
+			t.Fatalf("CheckAndRecord: %v", err)
+		}
+		if !allowed {
+			return lockout
+		}
+	}
+	t.Fatal("never locked out")
+	return 0
+}
+
+func TestLockoutEscalation_GrowsAcrossLockouts(t *testing.T) {
+	l, mr := newEscalatingLimiter(t)
+	for i, want := range []time.Duration{15 * time.Minute, time.Hour, 4 * time.Hour, 16 * time.Hour, 24 * time.Hour, 24 * time.Hour} {
+		got := lockOut(t, l)
+		if got != want {
+			t.Fatalf("lockout %d = %v, want %v", i+1, got, want)
+		}
+		if ttl := mr.TTL(l.LoginLockoutKey(escEmail, escIP)); ttl != want {
+			t.Errorf("lockout %d: key TTL = %v, want %v", i+1, ttl, want)
+		}
+		mr.FastForward(got)
+	}
+}
+
+func TestLockoutEscalation_ResetsAfterQuietPeriod(t *testing.T) {
+	l, mr := newEscalatingLimiter(t)
+	mr.FastForward(lockOut(t, l))
+	mr.FastForward(lockOut(t, l))
+
+	// A day without failures forgets both lockouts.
+	mr.FastForward(24 * time.Hour)
+	if got := lockOut(t, l); got != 15*time.Minute {
+		t.Errorf("lockout after a quiet day = %v, want 15m", got)
+	}
+}
+
+func TestLockoutEscalation_FailuresRestartQuietPeriod(t *testing.T) {
+	l, mr := newEscalatingLimiter(t)
+	mr.FastForward(lockOut(t, l))
+
+	// A failure 20h in keeps the lockout on record for another day.
+	mr.FastForward(20 * time.Hour)
+	if allowed, _, _, _, err := l.CheckAndRecord(context.Background(), escEmail, escIP); err != nil || !allowed {
+		t.Fatalf("allowed = %v, err = %v", allowed, err)
+	}
+	mr.FastForward(20 * time.Hour)
+	if got := lockOut(t, l); got != time.Hour {
+		t.Errorf("lockout = %v, want 1h: the failure should have kept the first lockout counted", got)
+	}
+}
+
+func TestLockoutEscalation_ClearLockoutResets(t *testing.T) {
+	l, _ := newEscalatingLimiter(t)
+	lockOut(t, l)
+	if err := l.ClearLockout(context.Background(), escEmail, escIP); err != nil {
+		t.Fatalf("ClearLockout: %v", err)
+	}
+	if got := lockOut(t, l); got != 15*time.Minute {
+		t.Errorf("lockout after ClearLockout = %v, want 15m", got)
+	}
+}
+
+func TestLockoutEscalation_CheckLoginAttempt(t *testing.T) {
+	l, mr := newEscalatingLimiter(t)
+	ctx := context.Background()
+	for i, want := range []time.Duration{15 * time.Minute, time.Hour} {
+		if err := l.RecordFailedAttempt(ctx, escEmail, escIP); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+		allowed, _, lockout, _, err := l.CheckLoginAttempt(ctx, escEmail, escIP)
+		if err != nil || allowed || lockout != want {
+			t.Fatalf("lockout %d: allowed = %v, lockout = %v, err = %v; want %v", i+1, allowed, lockout, err, want)
+		}
+		mr.FastForward(lockout)
+	}
+}
+
+func TestLockoutEscalation_OffByDefault(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 1, 15*time.Minute, zap.NewNop())
+	for i := 0; i < 3; i++ {
+		if got := lockOut(t, l); got != 15*time.Minute {
+			t.Fatalf("lockout %d = %v, want a flat 15m", i+1, got)
+		}
+		mr.FastForward(15 * time.Minute)
+	}
+	if mr.Exists(l.LoginEscalationKey(escEmail, escIP)) {
+		t.Error("no lockout count should be kept without escalation")
+	}
+}
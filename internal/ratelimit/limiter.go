@@ -16,6 +16,23 @@ type Limiter struct {
 	maxAttempts     int           // Maximum attempts allowed in window
 	lockoutDuration time.Duration // How long to block after exceeding limit
 	logger          *zap.Logger
+
+	// challengeThreshold is the soft limit: once this many failures have
+	// been made since the last solved challenge, CheckLoginAttempt asks for
+	// a CAPTCHA. 0 disables challenges.
+	challengeThreshold int
+
+	// ipMaxAttempts is how many failures one IP may make in a window on the
+	// sign-ins without an email up front (OAuth); see CheckByIP. 0 disables.
+	ipMaxAttempts int
+
+	// Lockout escalation for repeat offenders; see SetLockoutEscalation.
+	lockoutMultiplier     float64
+	maxLockoutDuration    time.Duration
+	escalationQuietPeriod time.Duration
+
+	algorithm Algorithm        // FixedWindow unless SetAlgorithm says otherwise
+	now       func() time.Time // clock for sliding-window scores; overridden in tests
 }
 
 // NewLimiter creates a new rate limiter
@@ -26,12 +43,32 @@ func NewLimiter(client *redis.Client, window time.Duration, maxAttempts int, loc
 		maxAttempts:     maxAttempts,
 		lockoutDuration: lockoutDuration,
 		logger:          logger,
+		algorithm:       FixedWindow,
+		now:             time.Now,
 	}
 }
 
+// SetChallengeThreshold sets the soft limit that triggers a CAPTCHA
+// challenge; set it below maxAttempts. 0 (the default) disables challenges.
+func (l *Limiter) SetChallengeThreshold(n int) {
+	l.challengeThreshold = n
+}
+
+// SetIPMaxAttempts sets how many failed sign-ins CheckByIP lets one IP make
+// per window before locking it out. 0 (the default) disables the IP limit.
+func (l *Limiter) SetIPMaxAttempts(n int) {
+	l.ipMaxAttempts = n
+}
+
 // LoginAttemptKey returns the Redis key for tracking login attempts
 func (l *Limiter) LoginAttemptKey(email, ipAddress string) string {
-	return fmt.Sprintf("ratelimit:login:%s:%s", ipAddress, email)
+	return l.counterKey("login", ipAddress+":"+email)
+}
+
+// LoginChallengeKey returns the Redis key counting failures since the last
+// solved CAPTCHA challenge
+func (l *Limiter) LoginChallengeKey(email, ipAddress string) string {
+	return l.counterKey("challenge", ipAddress+":"+email)
 }
 
 // LoginLockoutKey returns the Redis key for lockout status
@@ -40,43 +77,59 @@ func (l *Limiter) LoginLockoutKey(email, ipAddress string) string {
 }
 
 // CheckLoginAttempt checks if a login attempt is allowed
-// Returns: allowed (bool), remainingAttempts (int), lockoutRemaining (time.Duration), error
-func (l *Limiter) CheckLoginAttempt(ctx context.Context, email, ipAddress string) (bool, int, time.Duration, error) {
+// Returns: allowed (bool), remainingAttempts (int), lockoutRemaining (time.Duration),
+// challengeRequired (bool, only when allowed: the caller must solve a CAPTCHA first), error
+func (l *Limiter) CheckLoginAttempt(ctx context.Context, email, ipAddress string) (bool, int, time.Duration, bool, error) {
 	lockoutKey := l.LoginLockoutKey(email, ipAddress)
 
 	// Check if currently locked out
 	ttl, err := l.client.TTL(ctx, lockoutKey).Result()
 	if err != nil && err != redis.Nil {
-		return false, 0, 0, fmt.Errorf("failed to check lockout status: %w", err)
+		return false, 0, 0, false, fmt.Errorf("failed to check lockout status: %w", err)
 	}
 
 	if ttl > 0 {
 		// Still locked out
-		return false, 0, ttl, nil
+		return false, 0, ttl, false, nil
 	}
 
 	// Check attempt count
 	attemptKey := l.LoginAttemptKey(email, ipAddress)
-	count, err := l.client.Get(ctx, attemptKey).Int()
-	if err != nil && err != redis.Nil {
-		return false, 0, 0, fmt.Errorf("failed to get attempt count: %w", err)
+	count, err := l.count(ctx, attemptKey)
+	if err != nil {
+		return false, 0, 0, false, fmt.Errorf("failed to get attempt count: %w", err)
 	}
 
 	remaining := l.maxAttempts - count
 	if remaining <= 0 {
 		// Exceeded max attempts, initiate lockout
-		if err := l.client.Set(ctx, lockoutKey, "1", l.lockoutDuration).Err(); err != nil {
-			return false, 0, 0, fmt.Errorf("failed to set lockout: %w", err)
+		lockout, err := l.nextLockout(ctx, email, ipAddress)
+		if err != nil {
+			return false, 0, 0, false, err
+		}
+		if err := l.client.Set(ctx, lockoutKey, "1", lockout).Err(); err != nil {
+			return false, 0, 0, false, fmt.Errorf("failed to set lockout: %w", err)
 		}
 		// Clear attempt counter
-		if err := l.client.Del(ctx, attemptKey).Err(); err != nil {
+		if err := l.client.Del(ctx, attemptKey, l.LoginChallengeKey(email, ipAddress)).Err(); err != nil {
 			l.logger.Warn("failed to clear attempt counter", zap.Error(err))
 		}
-		return false, 0, l.lockoutDuration, nil
+		return false, 0, lockout, false, nil
+	}
+
+	// Past the soft limit a human has to prove themselves before trying again
+	if l.challengeThreshold > 0 {
+		sinceChallenge, err := l.count(ctx, l.LoginChallengeKey(email, ipAddress))
+		if err != nil {
+			return false, 0, 0, false, fmt.Errorf("failed to get challenge count: %w", err)
+		}
+		if sinceChallenge >= l.challengeThreshold {
+			return true, remaining, 0, true, nil
+		}
 	}
 
 	// Attempt allowed
-	return true, remaining, 0, nil
+	return true, remaining, 0, false, nil
 }
 
 // RecordFailedAttempt records a failed login attempt
@@ -84,18 +137,51 @@ func (l *Limiter) RecordFailedAttempt(ctx context.Context, email, ipAddress stri
 	attemptKey := l.LoginAttemptKey(email, ipAddress)
 
 	// Increment attempt counter
-	count, err := l.client.Incr(ctx, attemptKey).Result()
-	if err != nil {
+	if err := l.incrWithinWindow(ctx, attemptKey); err != nil {
 		return fmt.Errorf("failed to increment attempt counter: %w", err)
 	}
 
+	// The soft counter runs alongside, but a solved challenge resets it
+	if l.challengeThreshold > 0 {
+		if err := l.incrWithinWindow(ctx, l.LoginChallengeKey(email, ipAddress)); err != nil {
+			return fmt.Errorf("failed to increment challenge counter: %w", err)
+		}
+	}
+
+	if err := l.extendEscalation(ctx, email, ipAddress); err != nil {
+		return fmt.Errorf("failed to extend lockout count: %w", err)
+	}
+
+	return nil
+}
+
+// incrWithinWindow counts a failure against key. A fixed window starts on
+// the first hit; a sliding window is always the last l.window.
+func (l *Limiter) incrWithinWindow(ctx context.Context, key string) error {
+	if l.algorithm == SlidingWindow {
+		return l.recordSliding(ctx, key)
+	}
+
+	count, err := l.client.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
 	// Set expiry on first attempt
 	if count == 1 {
-		if err := l.client.Expire(ctx, attemptKey, l.window).Err(); err != nil {
+		if err := l.client.Expire(ctx, key, l.window).Err(); err != nil {
 			return fmt.Errorf("failed to set expiry: %w", err)
 		}
 	}
+	return nil
+}
 
+// ClearChallenge resets the soft counter after a solved CAPTCHA. The attempt
+// counter is left alone, so the hard lockout still applies.
+func (l *Limiter) ClearChallenge(ctx context.Context, email, ipAddress string) error {
+	if err := l.client.Del(ctx, l.LoginChallengeKey(email, ipAddress)).Err(); err != nil {
+		return fmt.Errorf("failed to clear challenge counter: %w", err)
+	}
 	return nil
 }
 
@@ -104,20 +190,21 @@ func (l *Limiter) RecordSuccessfulAttempt(ctx context.Context, email, ipAddress
 	attemptKey := l.LoginAttemptKey(email, ipAddress)
 
 	// Clear attempt counter
-	if err := l.client.Del(ctx, attemptKey).Err(); err != nil {
+	if err := l.client.Del(ctx, attemptKey, l.LoginChallengeKey(email, ipAddress)).Err(); err != nil {
 		return fmt.Errorf("failed to clear attempt counter: %w", err)
 	}
 
 	return nil
 }
 
-// ClearLockout manually clears a lockout (admin function)
+// ClearLockout manually clears a lockout (admin function), along with the
+// pair's escalation, so its next lockout is back to lockoutDuration
 func (l *Limiter) ClearLockout(ctx context.Context, email, ipAddress string) error {
 	lockoutKey := l.LoginLockoutKey(email, ipAddress)
 	attemptKey := l.LoginAttemptKey(email, ipAddress)
 
-	// Clear both lockout and attempt counter
-	if err := l.client.Del(ctx, lockoutKey, attemptKey).Err(); err != nil {
+	// Clear lockout, both attempt counters and the lockout count
+	if err := l.client.Del(ctx, lockoutKey, attemptKey, l.LoginChallengeKey(email, ipAddress), l.LoginEscalationKey(email, ipAddress)).Err(); err != nil {
 		return fmt.Errorf("failed to clear lockout: %w", err)
 	}
 
@@ -128,13 +215,66 @@ func (l *Limiter) ClearLockout(ctx context.Context, email, ipAddress string) err
 func (l *Limiter) GetAttemptCount(ctx context.Context, email, ipAddress string) (int, error) {
 	attemptKey := l.LoginAttemptKey(email, ipAddress)
 
-	count, err := l.client.Get(ctx, attemptKey).Int()
-	if err == redis.Nil {
-		return 0, nil
-	}
+	count, err := l.count(ctx, attemptKey)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get attempt count: %w", err)
 	}
 
 	return count, nil
 }
+
+// IPAttemptKey returns the Redis key counting failed sign-ins from an IP
+func (l *Limiter) IPAttemptKey(ipAddress string) string {
+	return l.counterKey("ip", ipAddress)
+}
+
+// IPLockoutKey returns the Redis key for an IP's lockout status
+func (l *Limiter) IPLockoutKey(ipAddress string) string {
+	return fmt.Sprintf("ratelimit:ip-lockout:%s", ipAddress)
+}
+
+// CheckByIP is CheckLoginAttempt for sign-ins with no email to key on (OAuth
+// callbacks): it counts failures per IP alone. Many students sign in from one
+// school IP, so only failures count, against their own, higher limit.
+// Returns: allowed (bool), lockoutRemaining (time.Duration), error
+func (l *Limiter) CheckByIP(ctx context.Context, ipAddress string) (bool, time.Duration, error) {
+	if l.ipMaxAttempts <= 0 || ipAddress == "" {
+		return true, 0, nil
+	}
+	lockoutKey := l.IPLockoutKey(ipAddress)
+
+	ttl, err := l.client.TTL(ctx, lockoutKey).Result()
+	if err != nil && err != redis.Nil {
+		return false, 0, fmt.Errorf("failed to check IP lockout status: %w", err)
+	}
+	if ttl > 0 {
+		return false, ttl, nil
+	}
+
+	attemptKey := l.IPAttemptKey(ipAddress)
+	count, err := l.count(ctx, attemptKey)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to get IP attempt count: %w", err)
+	}
+	if count >= l.ipMaxAttempts {
+		if err := l.client.Set(ctx, lockoutKey, "1", l.lockoutDuration).Err(); err != nil {
+			return false, 0, fmt.Errorf("failed to set IP lockout: %w", err)
+		}
+		if err := l.client.Del(ctx, attemptKey).Err(); err != nil {
+			l.logger.Warn("failed to clear IP attempt counter", zap.Error(err))
+		}
+		return false, l.lockoutDuration, nil
+	}
+	return true, 0, nil
+}
+
+// RecordByIP records a failed sign-in from ipAddress against CheckByIP's limit
+func (l *Limiter) RecordByIP(ctx context.Context, ipAddress string) error {
+	if l.ipMaxAttempts <= 0 || ipAddress == "" {
+		return nil
+	}
+	if err := l.incrWithinWindow(ctx, l.IPAttemptKey(ipAddress)); err != nil {
+		return fmt.Errorf("failed to increment IP attempt counter: %w", err)
+	}
+	return nil
+}
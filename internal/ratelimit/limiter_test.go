@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestLimiter_ChallengeBeforeLockout(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 4, 15*time.Minute, zap.NewNop())
+	l.SetChallengeThreshold(2)
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	check := func(wantAllowed, wantChallenge bool) {
+		t.Helper()
+		allowed, _, _, challenge, err := l.CheckLoginAttempt(ctx, email, ip)
+		if err != nil {
+			t.Fatalf("CheckLoginAttempt: %v", err)
+		}
+		if allowed != wantAllowed || challenge != wantChallenge {
+			t.Fatalf("allowed = %v, challenge = %v; want %v, %v", allowed, challenge, wantAllowed, wantChallenge)
+		}
+	}
+	fail := func() {
+		t.Helper()
+		if err := l.RecordFailedAttempt(ctx, email, ip); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+	}
+
+	fail()
+	check(true, false)
+	fail()
+	check(true, true) // soft limit reached
+
+	// A solved challenge resets the soft counter, not the hard one.
+	if err := l.ClearChallenge(ctx, email, ip); err != nil {
+		t.Fatalf("ClearChallenge: %v", err)
+	}
+	check(true, false)
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 2 {
+		t.Errorf("attempt count = %d, want 2", count)
+	}
+
+	fail()
+	fail()
+	check(false, false) // hard limit: locked out, challenge or not
+}
+
+func TestLimiter_NoChallengeWhenDisabled(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 4, 15*time.Minute, zap.NewNop())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := l.RecordFailedAttempt(ctx, "a@b.c", "203.0.113.7"); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+	}
+	if _, _, _, challenge, err := l.CheckLoginAttempt(ctx, "a@b.c", "203.0.113.7"); err != nil || challenge {
+		t.Errorf("challenge = %v, err = %v; want no challenge", challenge, err)
+	}
+}
+
+// burstAcrossBoundary opens a window with one failure, then tries
+// maxAttempts-2 times just before a fixed window would reset and
+// maxAttempts-1 times just after. It reports how many of those attempts,
+// all within two seconds, CheckLoginAttempt let through.
+func burstAcrossBoundary(t *testing.T, a Algorithm) int {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	const window, maxAttempts = 10 * time.Minute, 5
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), window, maxAttempts, 15*time.Minute, zap.NewNop())
+	l.SetAlgorithm(a)
+	now := time.Unix(1_700_000_000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	allowed := 0
+	attempt := func() {
+		t.Helper()
+		ok, _, _, _, err := l.CheckLoginAttempt(ctx, email, ip)
+		if err != nil {
+			t.Fatalf("CheckLoginAttempt: %v", err)
+		}
+		if !ok {
+			return
+		}
+		allowed++
+		if err := l.RecordFailedAttempt(ctx, email, ip); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+	}
+
+	// The first failure opens the window; the burst comes at its very end.
+	attempt()
+	allowed = 0
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.FastForward(d)
+	}
+	advance(window - time.Second)
+	for i := 0; i < maxAttempts-2; i++ {
+		attempt()
+	}
+	advance(2 * time.Second) // the fixed window has just expired
+	for i := 0; i < maxAttempts-1; i++ {
+		attempt()
+	}
+	return allowed
+}
+
+func TestLimiter_BoundaryBurst(t *testing.T) {
+	// Fixed: the counter resets at the boundary, so all 7 get through in
+	// two seconds, well over maxAttempts.
+	if got := burstAcrossBoundary(t, FixedWindow); got != 7 {
+		t.Errorf("fixed window allowed %d failures in the burst, want 7", got)
+	}
+	// Sliding: the burst falls in one window, so it is held to maxAttempts.
+	if got := burstAcrossBoundary(t, SlidingWindow); got != 5 {
+		t.Errorf("sliding window allowed %d failures in the burst, want 5", got)
+	}
+}
+
+func TestLimiter_SlidingWindowForgetsOldFailures(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 3, 15*time.Minute, zap.NewNop())
+	l.SetAlgorithm(SlidingWindow)
+	now := time.Unix(1_700_000_000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	for i := 0; i < 2; i++ {
+		if err := l.RecordFailedAttempt(ctx, email, ip); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+		now = now.Add(4 * time.Minute)
+	}
+	// t=8m: both failures are within the window.
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 2 {
+		t.Errorf("count at 8m = %d, want 2", count)
+	}
+	// t=12m: the first has slid out.
+	now = now.Add(4 * time.Minute)
+	if count, _ := l.GetAttemptCount(ctx, email, ip); count != 1 {
+		t.Errorf("count at 12m = %d, want 1", count)
+	}
+}
+
+func TestLimiter_SlidingWindowLocksOut(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 10*time.Minute, 3, 15*time.Minute, zap.NewNop())
+	l.SetAlgorithm(SlidingWindow)
+	ctx := context.Background()
+	const email, ip = "kid1@student.student", "203.0.113.7"
+
+	for i := 0; i < 3; i++ {
+		if err := l.RecordFailedAttempt(ctx, email, ip); err != nil {
+			t.Fatalf("RecordFailedAttempt: %v", err)
+		}
+	}
+	allowed, _, lockout, _, err := l.CheckLoginAttempt(ctx, email, ip)
+	if err != nil {
+		t.Fatalf("CheckLoginAttempt: %v", err)
+	}
+	if allowed || lockout != 15*time.Minute {
+		t.Fatalf("allowed = %v, lockout = %v; want locked out for 15m", allowed, lockout)
+	}
+	// The lockout outlives the window.
+	mr.FastForward(11 * time.Minute)
+	if allowed, _, _, _, _ := l.CheckLoginAttempt(ctx, email, ip); allowed {
+		t.Error("still within the lockout, want denied")
+	}
+	if mr.Exists(l.LoginAttemptKey(email, ip)) {
+		t.Error("attempt set should be cleared when the lockout starts")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for in, want := range map[string]Algorithm{"": FixedWindow, "fixed": FixedWindow, "sliding": SlidingWindow} {
+		if got, err := ParseAlgorithm(in); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("token-bucket"); err == nil {
+		t.Error("ParseAlgorithm(token-bucket): want error")
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// requestCountScript counts a request in KEYS[1]'s fixed window, starting
+// the window (ARGV[1] ms) on the first one. Returns {count, ms left}.
+var requestCountScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// RequestLimiter caps how many requests of any kind one IP may make per
+// window, across every instance. It sits in front of the endpoint-specific
+// limits to blunt scraping and brute force everywhere at once.
+type RequestLimiter struct {
+	client *redis.Client
+	limit  int
+	window time.Duration
+}
+
+// NewRequestLimiter allows limit requests per IP per window.
+func NewRequestLimiter(client *redis.Client, limit int, window time.Duration) *RequestLimiter {
+	return &RequestLimiter{client: client, limit: limit, window: window}
+}
+
+// RequestKey returns the Redis key counting requests from ipAddress
+func (r *RequestLimiter) RequestKey(ipAddress string) string {
+	return fmt.Sprintf("ratelimit:requests:%s", ipAddress)
+}
+
+// Allow counts a request from ipAddress. When it is over the limit, retryAfter
+// is how long until the window resets.
+func (r *RequestLimiter) Allow(ctx context.Context, ipAddress string) (allowed bool, retryAfter time.Duration, err error) {
+	res, err := requestCountScript.Run(ctx, r.client, []string{r.RequestKey(ipAddress)}, r.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to count request: %w", err)
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected request count result %v", res)
+	}
+	if res[0] <= int64(r.limit) {
+		return true, 0, nil
+	}
+	return false, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithm selects how a Limiter counts failures within its window.
+type Algorithm string
+
+const (
+	// FixedWindow counts in a window that starts at the first failure and
+	// resets when it expires. A burst straddling the reset can make close
+	// to twice maxAttempts attempts in one window's time.
+	FixedWindow Algorithm = "fixed"
+	// SlidingWindow counts the failures in the window ending now, from a
+	// sorted set of their timestamps, so there is no boundary to burst at.
+	SlidingWindow Algorithm = "sliding"
+)
+
+// ParseAlgorithm reads RATE_LIMIT_ALGORITHM. "" is FixedWindow.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case "":
+		return FixedWindow, nil
+	case FixedWindow, SlidingWindow:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit algorithm %q (want fixed or sliding)", s)
+	}
+}
+
+// SetAlgorithm switches how failures are counted. The two algorithms keep
+// their counters under different keys, so switching starts every count
+// afresh; lockouts already in force are kept.
+func (l *Limiter) SetAlgorithm(a Algorithm) {
+	l.algorithm = a
+}
+
+// counterKey names a failure counter: "ratelimit:<kind>:<rest>", with the
+// sliding window's sorted sets under "<kind>-sliding" so they never collide
+// with fixed-window string counters.
+func (l *Limiter) counterKey(kind, rest string) string {
+	if l.algorithm == SlidingWindow {
+		kind += "-sliding"
+	}
+	return "ratelimit:" + kind + ":" + rest
+}
+
+// count returns how many failures key holds within the window.
+func (l *Limiter) count(ctx context.Context, key string) (int, error) {
+	if l.algorithm != SlidingWindow {
+		n, err := l.client.Get(ctx, key).Int()
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return n, err
+	}
+
+	var card *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", l.expiredScore())
+		card = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(card.Val()), nil
+}
+
+// recordSliding adds a failure at now to key's sorted set, drops those that
+// have left the window and keeps the set alive for one window past it.
+func (l *Limiter) recordSliding(ctx context.Context, key string) error {
+	now := l.now()
+	member := slidingMember(now)
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", l.expiredScore())
+		pipe.PExpire(ctx, key, l.window)
+		return nil
+	})
+	return err
+}
+
+// slidingMember names a failure in a sliding-window set. It only has to be
+// unique; the score carries the time.
+func slidingMember(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// expiredScore is the newest score that has left the window.
+func (l *Limiter) expiredScore() string {
+	return strconv.FormatInt(l.now().Add(-l.window).UnixMilli(), 10)
+}
@@ -0,0 +1,59 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGenerate_AudiencePerApp(t *testing.T) {
+	s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+
+	for _, app := range []string{"student-game", "teacher-dashboard"} {
+		pair, err := s.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 0, WithAudience(app))
+		if err != nil {
+			t.Fatalf("Generate(%s): %v", app, err)
+		}
+		claims, err := s.Validate(pair.AccessToken)
+		if err != nil {
+			t.Fatalf("Validate(%s): %v", app, err)
+		}
+		if got := AppOf(claims.Audience); got != app {
+			t.Errorf("aud = %q, want %q", got, app)
+		}
+		refresh, err := s.ValidateRefreshToken(pair.RefreshToken)
+		if err != nil {
+			t.Fatalf("ValidateRefreshToken(%s): %v", app, err)
+		}
+		if got := AppOf(refresh.Audience); got != app {
+			t.Errorf("refresh aud = %q, want %q", got, app)
+		}
+	}
+}
+
+func TestValidateAudience_RejectsOtherApps(t *testing.T) {
+	s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	generate := func(t *testing.T, opts ...ClaimOption) string {
+		t.Helper()
+		pair, err := s.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 0, opts...)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair.AccessToken
+	}
+	game := generate(t, WithAudience("student-game"))
+
+	if _, err := s.ValidateAudience(game, "student-game"); err != nil {
+		t.Errorf("ValidateAudience for its own app: %v", err)
+	}
+	if _, err := s.ValidateAudience(game, "teacher-dashboard"); !errors.Is(err, ErrWrongAudience) {
+		t.Errorf("ValidateAudience for another app = %v, want ErrWrongAudience", err)
+	}
+	if _, err := s.ValidateAudience(generate(t), "teacher-dashboard"); !errors.Is(err, ErrWrongAudience) {
+		t.Errorf("ValidateAudience for an unscoped token = %v, want ErrWrongAudience", err)
+	}
+	// Plain Validate doesn't care which app a token is for.
+	if _, err := s.Validate(game); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -2,25 +2,55 @@ package token
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	blacklistKeyPrefix = "blacklist:jti:"
+
+	// blacklistChannel carries newly revoked JTIs to every instance so their
+	// bloom filters pick them up without waiting for the next reload.
+	blacklistChannel = "blacklist:revoked"
+)
+
 // Blacklist handles token revocation using Redis
 type Blacklist struct {
 	client *redis.Client
+	bloom  *bloomCache // nil unless WithBloomFilter is set
+}
+
+// BlacklistOption configures optional Blacklist behaviour.
+type BlacklistOption func(*Blacklist)
+
+// WithBloomFilter enables an in-process Bloom filter of revoked JTIs sized
+// for capacity entries at fpRate. A filter miss answers IsBlacklisted without
+// touching Redis; a hit (possibly a false positive) still goes to Redis. The
+// filter only takes effect once RunBloomRefresh has loaded it.
+func WithBloomFilter(capacity int, fpRate float64) BlacklistOption {
+	return func(b *Blacklist) {
+		b.bloom = &bloomCache{capacity: capacity, fpRate: fpRate}
+	}
 }
 
 // NewBlacklist creates a new token blacklist
-func NewBlacklist(client *redis.Client) *Blacklist {
-	return &Blacklist{client: client}
+func NewBlacklist(client *redis.Client, opts ...BlacklistOption) *Blacklist {
+	b := &Blacklist{client: client}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 // Add adds a token to the blacklist
 func (b *Blacklist) Add(ctx context.Context, tokenID string, expiry time.Time) error {
-	key := fmt.Sprintf("blacklist:jti:%s", tokenID)
+	key := blacklistKeyPrefix + tokenID
 	ttl := time.Until(expiry)
 
 	if ttl <= 0 {
@@ -33,12 +63,22 @@ func (b *Blacklist) Add(ctx context.Context, tokenID string, expiry time.Time) e
 		return fmt.Errorf("failed to blacklist token: %w", err)
 	}
 
+	if b.bloom != nil {
+		b.bloom.add(tokenID)
+	}
+	// Best effort: instances that miss this fall back to their next reload.
+	_ = b.client.Publish(ctx, blacklistChannel, tokenID).Err()
+
 	return nil
 }
 
 // IsBlacklisted checks if a token is blacklisted
 func (b *Blacklist) IsBlacklisted(ctx context.Context, tokenID string) (bool, error) {
-	key := fmt.Sprintf("blacklist:jti:%s", tokenID)
+	if b.bloom != nil && b.bloom.definitelyAbsent(tokenID) {
+		return false, nil
+	}
+
+	key := blacklistKeyPrefix + tokenID
 
 	exists, err := b.client.Exists(ctx, key).Result()
 	if err != nil {
@@ -48,9 +88,37 @@ func (b *Blacklist) IsBlacklisted(ctx context.Context, tokenID string) (bool, er
 	return exists > 0, nil
 }
 
+// AreBlacklisted checks many tokens at once, returning one result per ID in
+// order. IDs the bloom filter rules out are answered locally; the rest are
+// checked with a single pipelined round-trip instead of one per token.
+func (b *Blacklist) AreBlacklisted(ctx context.Context, tokenIDs []string) ([]bool, error) {
+	results := make([]bool, len(tokenIDs))
+
+	pipe := b.client.Pipeline()
+	cmds := make(map[int]*redis.IntCmd, len(tokenIDs))
+	for i, id := range tokenIDs {
+		if b.bloom != nil && b.bloom.definitelyAbsent(id) {
+			continue
+		}
+		cmds[i] = pipe.Exists(ctx, blacklistKeyPrefix+id)
+	}
+	if len(cmds) == 0 {
+		return results, nil
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check blacklist: %w", err)
+	}
+	for i, cmd := range cmds {
+		results[i] = cmd.Val() > 0
+	}
+
+	return results, nil
+}
+
 // Remove removes a token from the blacklist (mainly for testing)
 func (b *Blacklist) Remove(ctx context.Context, tokenID string) error {
-	key := fmt.Sprintf("blacklist:jti:%s", tokenID)
+	key := blacklistKeyPrefix + tokenID
 
 	err := b.client.Del(ctx, key).Err()
 	if err != nil {
@@ -59,3 +127,178 @@ func (b *Blacklist) Remove(ctx context.Context, tokenID string) error {
 
 	return nil
 }
+
+// bloomPingInterval is how long the revocation subscription may stay quiet
+// before RunBloomRefresh pings it, and how long it then waits for the pong
+// before treating the subscription as lost. A variable so tests can shorten
+// it.
+var bloomPingInterval = 5 * time.Second
+
+// RunBloomRefresh keeps the Bloom filter in sync with Redis until ctx is
+// cancelled: it subscribes to revocations from other instances, then reloads
+// the full set every interval (which also drops expired JTIs). It returns
+// immediately when the filter isn't enabled. Meant to run under a
+// background.Group.
+//
+// The filter is only trusted while the subscription is known to be up. When
+// it fails, or a ping goes unanswered, the filter is dropped (every check
+// goes to Redis) and the subscription is re-established, after which the
+// filter is rebuilt from scratch. A failed reload likewise disables the fast
+// path until the next successful one, so a filter that may be missing
+// revocations is never trusted.
+func (b *Blacklist) RunBloomRefresh(ctx context.Context, interval time.Duration) {
+	if b.bloom == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		b.followRevocations(ctx, ticker.C)
+		b.bloom.subscriptionDown()
+
+		// Don't spin against a Redis that is down.
+		select {
+		case <-ctx.Done():
+		case <-time.After(bloomPingInterval):
+		}
+	}
+}
+
+// followRevocations subscribes to revocations and feeds them to the filter,
+// reloading it on every tick, until the subscription fails or ctx is
+// cancelled. The filter is (re)built once the subscription is confirmed, so
+// nothing revoked in between is missed.
+func (b *Blacklist) followRevocations(ctx context.Context, reload <-chan time.Time) {
+	sub := b.client.Subscribe(ctx, blacklistChannel)
+	defer sub.Close()
+	// A blocked receive only notices cancellation once the socket closes.
+	defer context.AfterFunc(ctx, func() { _ = sub.Close() })()
+
+	pingPending := false
+	for {
+		select {
+		case <-reload:
+			b.reloadBloom(ctx)
+		default:
+		}
+
+		msg, err := sub.ReceiveTimeout(ctx, bloomPingInterval)
+		if err != nil {
+			var netErr net.Error
+			if ctx.Err() != nil || pingPending || !errors.As(err, &netErr) || !netErr.Timeout() {
+				return
+			}
+			// Quiet for a while: make sure the connection is still there.
+			if err := sub.Ping(ctx); err != nil {
+				return
+			}
+			pingPending = true
+			continue
+		}
+		pingPending = false
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				b.bloom.subscriptionUp()
+				b.reloadBloom(ctx)
+			}
+		case *redis.Message:
+			b.bloom.add(m.Payload)
+		}
+	}
+}
+
+// reloadBloom rebuilds the filter from every blacklist key in Redis.
+func (b *Blacklist) reloadBloom(ctx context.Context) {
+	epoch := b.bloom.beginReload()
+
+	filter := newBloomFilter(b.bloom.capacity, b.bloom.fpRate)
+	iter := b.client.Scan(ctx, 0, blacklistKeyPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		filter.add(strings.TrimPrefix(iter.Val(), blacklistKeyPrefix))
+	}
+
+	b.bloom.finishReload(filter, iter.Err() == nil, epoch)
+}
+
+// bloomCache holds the current filter and tracks JTIs added while a reload
+// is scanning Redis, so the swap can't drop a revocation the scan missed.
+type bloomCache struct {
+	capacity int
+	fpRate   float64
+
+	mu         sync.RWMutex
+	filter     *bloomFilter // nil until loaded while subscribed
+	subscribed bool         // the revocation subscription is known to be up
+	epoch      uint64       // bumped each time the subscription is lost
+	reloading  bool
+	pending    []string
+}
+
+func (c *bloomCache) add(tokenID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.filter != nil {
+		c.filter.add(tokenID)
+	}
+	if c.reloading {
+		c.pending = append(c.pending, tokenID)
+	}
+}
+
+// definitelyAbsent reports whether the filter proves tokenID isn't revoked.
+// False whenever the filter isn't loaded or the subscription that keeps it
+// current is down.
+func (c *bloomCache) definitelyAbsent(tokenID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.subscribed && c.filter != nil && !c.filter.mayContain(tokenID)
+}
+
+// subscriptionUp records that revocations are being received again. The
+// filter stays unused until a reload rebuilds it.
+func (c *bloomCache) subscriptionUp() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = true
+}
+
+// subscriptionDown drops the filter: revocations published from now on may
+// never arrive. A reload already in flight is discarded too, since its scan
+// may have finished before a missed revocation.
+func (c *bloomCache) subscriptionDown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = false
+	c.filter = nil
+	c.epoch++
+}
+
+// beginReload starts tracking added JTIs for the coming swap and returns the
+// epoch to hand finishReload.
+func (c *bloomCache) beginReload() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloading = true
+	c.pending = nil
+	return c.epoch
+}
+
+func (c *bloomCache) finishReload(filter *bloomFilter, ok bool, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloading = false
+	if !ok || epoch != c.epoch || !c.subscribed {
+		c.filter = nil
+		c.pending = nil
+		return
+	}
+	for _, id := range c.pending {
+		filter.add(id)
+	}
+	c.pending = nil
+	c.filter = filter
+}
@@ -0,0 +1,199 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+// startBloom runs RunBloomRefresh and waits for the first load.
+func startBloom(t *testing.T, b *Blacklist, interval time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.RunBloomRefresh(ctx, interval)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b.bloom.mu.RLock()
+		loaded := b.bloom.filter != nil
+		b.bloom.mu.RUnlock()
+		if loaded {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bloom filter never loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBloomFilter_NoFalseNegatives(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.add(fmt.Sprintf("jti-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.mayContain(fmt.Sprintf("jti-%d", i)) {
+			t.Fatalf("jti-%d added but reported absent", i)
+		}
+	}
+}
+
+func TestBlacklist_BloomNeverAllowsRevokedToken(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	// Revoked before the filter loads: picked up by the initial scan.
+	other := NewBlacklist(client)
+	if err := other.Add(ctx, "revoked-before-load", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBlacklist(client, WithBloomFilter(1000, 0.01))
+	startBloom(t, b, time.Hour)
+
+	// Revoked locally after load: added to the filter synchronously.
+	if err := b.Add(ctx, "revoked-locally", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked by another instance after load: arrives over pub/sub.
+	if err := other.Add(ctx, "revoked-elsewhere", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jti := range []string{"revoked-before-load", "revoked-locally"} {
+		revoked, err := b.IsBlacklisted(ctx, jti)
+		if err != nil || !revoked {
+			t.Errorf("IsBlacklisted(%q) = %v, %v; want true", jti, revoked, err)
+		}
+	}
+
+	// Pub/sub delivery is asynchronous; until it lands the filter misses the
+	// JTI, so wait for the filter itself to report it before asserting.
+	deadline := time.Now().Add(2 * time.Second)
+	for b.bloom.definitelyAbsent("revoked-elsewhere") {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation from another instance never reached the filter")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if revoked, err := b.IsBlacklisted(ctx, "revoked-elsewhere"); err != nil || !revoked {
+		t.Errorf("IsBlacklisted(revoked-elsewhere) = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestBlacklist_BloomMissSkipsRedis(t *testing.T) {
+	mr, client := newTestRedis(t)
+	b := NewBlacklist(client, WithBloomFilter(1000, 0.01))
+	startBloom(t, b, time.Hour)
+
+	before := mr.CommandCount()
+	revoked, err := b.IsBlacklisted(context.Background(), "never-revoked")
+	if err != nil {
+		t.Fatalf("IsBlacklisted: %v", err)
+	}
+	if revoked {
+		t.Error("IsBlacklisted = true for a token that was never revoked")
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Errorf("a filter miss sent %d commands to Redis, want 0", n)
+	}
+}
+
+func TestBlacklist_BloomDistrustedUntilResubscribedAndReloaded(t *testing.T) {
+	// Restored by the last cleanup, once RunBloomRefresh has stopped.
+	saved := bloomPingInterval
+	t.Cleanup(func() { bloomPingInterval = saved })
+	bloomPingInterval = 20 * time.Millisecond
+
+	mr, client := newTestRedis(t)
+	ctx := context.Background()
+	b := NewBlacklist(client, WithBloomFilter(1000, 0.01))
+	startBloom(t, b, time.Hour)
+
+	// The subscription drops, and a revocation published meanwhile is lost.
+	mr.Close()
+	waitFor(t, "filter dropped", func() bool { return !b.bloom.definitelyAbsent("revoked-while-down") })
+	mr.Set(blacklistKeyPrefix+"revoked-while-down", "1")
+
+	// Down: the filter can't vouch for anything, so checks go to Redis.
+	if _, err := b.IsBlacklisted(ctx, "never-revoked"); err == nil {
+		t.Error("IsBlacklisted answered from the filter while the subscription was down")
+	}
+
+	// Back up: the rebuilt filter includes what pub/sub missed.
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "filter rebuilt", func() bool { return b.bloom.definitelyAbsent("never-revoked") })
+	if revoked, err := b.IsBlacklisted(ctx, "revoked-while-down"); err != nil || !revoked {
+		t.Errorf("IsBlacklisted(revoked-while-down) = %v, %v; want true", revoked, err)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBlacklist_BloomHitFallsBackToRedis(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+	b := NewBlacklist(client, WithBloomFilter(1000, 0.01))
+	startBloom(t, b, time.Hour)
+
+	// Simulate a false positive: the filter says "maybe" but Redis has no key.
+	b.bloom.add("false-positive")
+
+	revoked, err := b.IsBlacklisted(ctx, "false-positive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("bloom hit must be confirmed against Redis, got revoked=true")
+	}
+}
+
+func TestBlacklist_BloomDisabledUntilLoaded(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := NewBlacklist(client).Add(ctx, "revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBlacklist(client, WithBloomFilter(1000, 0.01))
+
+	// RunBloomRefresh hasn't run, so every check goes to Redis.
+	revoked, err := b.IsBlacklisted(ctx, "revoked")
+	if err != nil || !revoked {
+		t.Errorf("IsBlacklisted = %v, %v; want true", revoked, err)
+	}
+}
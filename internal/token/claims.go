@@ -1,39 +1,175 @@
 package token
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/boddle/reservoir/pkg/utctime"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // Claims represents the JWT access-token claims structure
 type Claims struct {
 	UserID    int    `json:"user_id"`
-	BoddleUID string `json:"boddle_uid"`
-	Email     string `json:"email"`
-	Name      string `json:"name"`
+	BoddleUID string `json:"boddle_uid,omitempty"`
+	Email     string `json:"email,omitempty"` // see WithStudentEmailOmitted, WithMinimalClaims
+	Name      string `json:"name,omitempty"`
 	MetaType  string `json:"meta_type"` // "Student", "Teacher", "Parent", "Admin"
 	MetaID    int    `json:"meta_id"`
 	// TokenVersion mirrors users.token_version at issue time. Logout bumps the
 	// column, after which tokens carrying the old version are rejected. See
 	// security review Finding 2 / LMS-6513.
 	TokenVersion int `json:"tver"`
+	// Username is the student's students.username (see WithUsername).
+	// Empty for everyone else.
+	Username string `json:"username,omitempty"`
+	// Locale is the user's preferred BCP 47 locale, when known.
+	Locale string `json:"locale,omitempty"`
+	// Device is the hashed device fingerprint of a sign-in limited to one
+	// session per device (see WithDevice), and Session that sign-in's
+	// refresh family. Both empty otherwise.
+	Device  string `json:"dfp,omitempty"`
+	Session string `json:"sid,omitempty"`
+	// PasswordChangedAt is the user's password_changed_at (Unix seconds)
+	// when the pair was minted; see WithPasswordChangedAt.
+	PasswordChangedAt int64 `json:"pwc,omitempty"`
 	jwt.RegisteredClaims
+
+	// refreshFamily is the family given to the refresh token minted
+	// alongside; see WithRefreshFamily. Never part of the access token.
+	refreshFamily string
+}
+
+// resolveUserID fills UserID from a numeric sub when the user_id claim is
+// missing, so consumers can treat sub as the one canonical identifier.
+func (c *Claims) resolveUserID() error {
+	if c.UserID != 0 {
+		return nil
+	}
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("token carries no user ID")
+	}
+	c.UserID = id
+	return nil
+}
+
+// SubjectFormat selects what an access token's sub claim holds.
+type SubjectFormat string
+
+const (
+	// SubjectUserID makes sub the decimal users.id, matching user_id.
+	SubjectUserID SubjectFormat = "user_id"
+	// SubjectBoddleUID makes sub the user's boddle_uid, the identifier
+	// shared with Rails. Users without a boddle_uid get their user ID.
+	SubjectBoddleUID SubjectFormat = "boddle_uid"
+)
+
+// ParseSubjectFormat parses a JWT_SUBJECT_FORMAT value.
+func ParseSubjectFormat(s string) (SubjectFormat, error) {
+	switch f := SubjectFormat(s); f {
+	case SubjectUserID, SubjectBoddleUID:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown subject format %q (want %q or %q)", s, SubjectUserID, SubjectBoddleUID)
+	}
+}
+
+// ClaimOption sets optional access-token claims at generation time.
+type ClaimOption func(*Claims)
+
+// WithLocale sets the locale claim. An empty locale leaves it omitted.
+func WithLocale(locale string) ClaimOption {
+	return func(c *Claims) {
+		c.Locale = locale
+	}
+}
+
+// WithUsername sets the username claim from students.username. An empty
+// username leaves it omitted.
+func WithUsername(username string) ClaimOption {
+	return func(c *Claims) {
+		c.Username = username
+	}
+}
+
+// WithAudience scopes the pair to app: aud is set to it on both tokens, and
+// the app's own verifiers reject tokens minted for another app. An empty app
+// leaves aud unset, so the token is valid for every app.
+func WithAudience(app string) ClaimOption {
+	return func(c *Claims) {
+		if app != "" {
+			c.Audience = jwt.ClaimStrings{app}
+		}
+	}
+}
+
+// AppOf returns the app aud scopes a token to (see WithAudience), or "".
+func AppOf(aud jwt.ClaimStrings) string {
+	if len(aud) == 0 {
+		return ""
+	}
+	return aud[0]
+}
+
+// WithPasswordChangedAt records when the user's password last changed, so
+// the pair stays valid across that change but not across a later one. A zero
+// time (never changed) leaves the claim omitted.
+func WithPasswordChangedAt(t time.Time) ClaimOption {
+	return func(c *Claims) {
+		if !t.IsZero() {
+			c.PasswordChangedAt = t.Unix()
+		}
+	}
+}
+
+// WithDevice ties the pair to a device, so a later sign-in from the same
+// device can supersede it: the access token carries device and the sign-in's
+// refresh family, and the refresh token carries device so rotations keep it.
+func WithDevice(device string) ClaimOption {
+	return func(c *Claims) {
+		c.Device = device
+	}
+}
+
+// WithRefreshFamily puts the new refresh token in an existing family, for a
+// rotation. Without it each pair starts a new family.
+func WithRefreshFamily(family string) ClaimOption {
+	return func(c *Claims) {
+		c.refreshFamily = family
+	}
 }
 
 // RefreshClaims represents the JWT refresh-token claims. It carries the same
 // TokenVersion so a refresh is rejected once the user's version is bumped.
 type RefreshClaims struct {
 	TokenVersion int `json:"tver"`
+	// Family ties together a sign-in's chain of rotated refresh tokens (see
+	// RefreshFamilies). Empty on tokens issued before families existed.
+	Family string `json:"fam,omitempty"`
+	// Device is carried over from the access token; see WithDevice.
+	Device string `json:"dfp,omitempty"`
+	// PasswordChangedAt is carried over from the access token.
+	PasswordChangedAt int64 `json:"pwc,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // TokenPair represents an access and refresh token pair
 type TokenPair struct {
-	AccessToken  string    `json:"access_token"`
-	RefreshToken string    `json:"refresh_token"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	TokenType    string    `json:"token_type"`
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresAt    utctime.Time `json:"expires_at"`
+	TokenType    string       `json:"token_type"`
+	// JTI is the access token's jti, for auditing issuance without handling
+	// the token itself. Never serialized.
+	JTI string `json:"-"`
+	// RefreshJTI and RefreshFamily identify the refresh token, for rotation
+	// tracking. Never serialized.
+	RefreshJTI    string `json:"-"`
+	RefreshFamily string `json:"-"`
+	// RefreshExpiresAt is when the refresh token expires. Never serialized.
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
 // TokenType constants
@@ -0,0 +1,135 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestGenerate_BackdatesIssuedAt verifies the configured issue skew is
+// subtracted from iat and nbf on both tokens while exp still counts from now.
+func TestGenerate_BackdatesIssuedAt(t *testing.T) {
+	const skew = 5 * time.Second
+	svc := NewService(
+		"test-secret-key-minimum-32-chars",
+		"test-refresh-secret-key-32-chars",
+		6*time.Hour,
+		720*time.Hour,
+		WithIssueSkew(skew),
+	)
+
+	before := time.Now()
+	pair, err := svc.Generate(1, "uid", "a@b.com", "A B", "Teacher", 10, 1)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	access, err := svc.Validate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	refresh, err := svc.ValidateRefreshToken(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("ValidateRefreshToken: %v", err)
+	}
+
+	// NumericDate has one-second resolution, so allow a second of slack.
+	wantIssued := before.Add(-skew)
+	for name, got := range map[string]time.Time{
+		"access iat":  access.IssuedAt.Time,
+		"access nbf":  access.NotBefore.Time,
+		"refresh iat": refresh.IssuedAt.Time,
+		"refresh nbf": refresh.NotBefore.Time,
+	} {
+		if diff := got.Sub(wantIssued).Abs(); diff > time.Second {
+			t.Errorf("%s = %v, want ~%v (diff %v)", name, got, wantIssued, diff)
+		}
+	}
+
+	wantExpiry := before.Add(6 * time.Hour)
+	if diff := access.ExpiresAt.Time.Sub(wantExpiry).Abs(); diff > time.Second {
+		t.Errorf("exp = %v, want ~%v (skew must not shorten the TTL)", access.ExpiresAt.Time, wantExpiry)
+	}
+}
+
+func TestGenerate_NoSkewByDefault(t *testing.T) {
+	svc := newTestService(6 * time.Hour)
+
+	before := time.Now()
+	pair, err := svc.Generate(1, "uid", "a@b.com", "A B", "Teacher", 10, 1)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	claims, err := svc.Validate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if diff := claims.IssuedAt.Time.Sub(before).Abs(); diff > time.Second {
+		t.Errorf("iat = %v, want ~%v", claims.IssuedAt.Time, before)
+	}
+}
+
+func TestValidate_Leeway(t *testing.T) {
+	const secret = "test-secret-key-minimum-32-chars"
+	const refreshSecret = "test-refresh-secret-key-32-chars"
+	svc := NewService(secret, refreshSecret, time.Hour, time.Hour, WithLeeway(30*time.Second))
+
+	// Tokens stamped by a clock running ahead of ours.
+	aheadBy := func(d time.Duration) jwt.RegisteredClaims {
+		now := time.Now().Add(d)
+		return jwt.RegisteredClaims{
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	}
+	expiredAgo := func(d time.Duration) jwt.RegisteredClaims {
+		now := time.Now()
+		return jwt.RegisteredClaims{
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Hour)),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(-d)),
+		}
+	}
+	sign := func(t *testing.T, key string, claims jwt.Claims) string {
+		t.Helper()
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+		want   error // nil means valid
+	}{
+		{"nbf and iat within leeway", aheadBy(10 * time.Second), nil},
+		{"nbf and iat beyond leeway", aheadBy(time.Minute), ErrNotYetValid},
+		{"expired within leeway", expiredAgo(10 * time.Second), nil},
+		{"expired beyond leeway", expiredAgo(time.Minute), ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.Validate(sign(t, secret, Claims{UserID: 1, RegisteredClaims: tt.claims}))
+			if (tt.want == nil && err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("Validate = %v, want %v", err, tt.want)
+			}
+			_, err = svc.ValidateRefreshToken(sign(t, refreshSecret, RefreshClaims{RegisteredClaims: tt.claims}))
+			if (tt.want == nil && err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("ValidateRefreshToken = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Without a leeway, a token from a few seconds in the future is rejected.
+	strict := NewService(secret, refreshSecret, time.Hour, time.Hour)
+	if _, err := strict.Validate(sign(t, secret, Claims{UserID: 1, RegisteredClaims: aheadBy(10 * time.Second)})); !errors.Is(err, ErrNotYetValid) {
+		t.Errorf("Validate without leeway = %v, want ErrNotYetValid", err)
+	}
+}
@@ -0,0 +1,111 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	issuedAtCutoffKeyPrefix = "token:min_iat:"
+
+	// defaultCutoffCacheTTL bounds how long an instance trusts its copy of a
+	// meta type's cutoff, and so how long a new cutoff takes to reach the
+	// instances that didn't set it.
+	defaultCutoffCacheTTL = 5 * time.Second
+)
+
+// IssuedAtCutoffs revokes tokens in bulk: every token whose meta type has a
+// cutoff and that was issued before it is rejected. It is the coarse
+// counterpart to the per-JTI Blacklist, for forcing a whole class of accounts
+// (e.g. all students) to sign in again. Cutoffs live in Redis and are cached
+// in-process. A nil *IssuedAtCutoffs has no cutoffs.
+type IssuedAtCutoffs struct {
+	client    *redis.Client
+	issueSkew time.Duration
+	cacheTTL  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedCutoff
+}
+
+type cachedCutoff struct {
+	cutoff    time.Time // zero when the meta type has none
+	fetchedAt time.Time
+}
+
+// NewIssuedAtCutoffs creates a cutoff store. issueSkew must match the token
+// service's (see WithIssueSkew): a token minted just after a cutoff carries
+// an iat backdated by that much and must not be mistaken for an older one.
+func NewIssuedAtCutoffs(client *redis.Client, issueSkew time.Duration) *IssuedAtCutoffs {
+	return &IssuedAtCutoffs{
+		client:    client,
+		issueSkew: issueSkew,
+		cacheTTL:  defaultCutoffCacheTTL,
+		cache:     make(map[string]cachedCutoff),
+	}
+}
+
+// Set rejects tokens for metaType issued before cutoff.
+func (c *IssuedAtCutoffs) Set(ctx context.Context, metaType string, cutoff time.Time) error {
+	err := c.client.Set(ctx, issuedAtCutoffKeyPrefix+metaType, cutoff.Unix(), 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set issued-at cutoff: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cache[metaType] = cachedCutoff{cutoff: time.Unix(cutoff.Unix(), 0), fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return nil
+}
+
+// Get returns metaType's cutoff, or the zero time if it has none.
+func (c *IssuedAtCutoffs) Get(ctx context.Context, metaType string) (time.Time, error) {
+	if c == nil || metaType == "" {
+		return time.Time{}, nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.cache[metaType]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.cacheTTL {
+		return entry.cutoff, nil
+	}
+
+	var cutoff time.Time
+	raw, err := c.client.Get(ctx, issuedAtCutoffKeyPrefix+metaType).Result()
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		return time.Time{}, fmt.Errorf("failed to get issued-at cutoff: %w", err)
+	default:
+		unix, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("malformed issued-at cutoff for %s: %q", metaType, raw)
+		}
+		cutoff = time.Unix(unix, 0)
+	}
+
+	c.mu.Lock()
+	c.cache[metaType] = cachedCutoff{cutoff: cutoff, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return cutoff, nil
+}
+
+// IssuedBefore reports whether a token for metaType issued at iat predates
+// the meta type's cutoff. A token with no iat predates any cutoff.
+func (c *IssuedAtCutoffs) IssuedBefore(ctx context.Context, metaType string, iat *jwt.NumericDate) (bool, error) {
+	cutoff, err := c.Get(ctx, metaType)
+	if err != nil || cutoff.IsZero() {
+		return false, err
+	}
+	if iat == nil {
+		return true, nil
+	}
+	return iat.Time.Add(c.issueSkew).Before(cutoff), nil
+}
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestIssuedAtCutoffs_IssuedBefore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+	cutoff := time.Unix(1_760_000_000, 0)
+
+	c := NewIssuedAtCutoffs(client, 30*time.Second)
+	if err := c.Set(ctx, "Student", cutoff); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		metaType string
+		iat      *jwt.NumericDate
+		want     bool
+	}{
+		{"well before", "Student", jwt.NewNumericDate(cutoff.Add(-time.Hour)), true},
+		// Minted right after the cutoff, iat backdated by the issue skew.
+		{"minted at the cutoff", "Student", jwt.NewNumericDate(cutoff.Add(-30 * time.Second)), false},
+		{"after", "Student", jwt.NewNumericDate(cutoff.Add(time.Minute)), false},
+		{"no iat", "Student", nil, true},
+		{"other meta type", "Teacher", jwt.NewNumericDate(cutoff.Add(-time.Hour)), false},
+	}
+	for _, tt := range tests {
+		got, err := c.IssuedBefore(ctx, tt.metaType, tt.iat)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: IssuedBefore = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// Another instance sees the cutoff from Redis.
+	other := NewIssuedAtCutoffs(client, 30*time.Second)
+	if got, _ := other.Get(ctx, "Student"); !got.Equal(cutoff) {
+		t.Errorf("other instance cutoff = %v, want %v", got, cutoff)
+	}
+}
+
+func TestIssuedAtCutoffs_NilHasNoCutoffs(t *testing.T) {
+	var c *IssuedAtCutoffs
+	if got, err := c.IssuedBefore(context.Background(), "Student", nil); got || err != nil {
+		t.Errorf("nil IssuedBefore = %v, %v; want false, nil", got, err)
+	}
+}
@@ -0,0 +1,112 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is a public key in RFC 7517 form. Only the members needed for RSA and
+// EC P-256 verification keys are included.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// verificationKey is a rotated-out signing key that still verifies access
+// tokens carrying its kid.
+type verificationKey struct {
+	kid string
+	alg jwt.SigningMethod
+	key crypto.PublicKey
+}
+
+// JWKS returns the public keys access tokens are verified with, the current
+// signing key first, and false when tokens are HMAC-signed (there is no
+// public key to publish).
+func (s *Service) JWKS() (*JWKS, bool) {
+	if s.jwks == nil {
+		return nil, false
+	}
+	return s.jwks, true
+}
+
+// JWKSJSON is JWKS already serialized, for serving on every request without
+// re-encoding the keys.
+func (s *Service) JWKSJSON() ([]byte, bool) {
+	if s.jwksJSON == nil {
+		return nil, false
+	}
+	return s.jwksJSON, true
+}
+
+// buildJWKS renders the current and previous signing keys.
+func (s *Service) buildJWKS() (*JWKS, []byte) {
+	jwks := &JWKS{Keys: []JWK{newJWK(s.keyID, s.signing.alg, s.signing.signer.Public())}}
+	for _, prev := range s.previousSigningKeys {
+		if prev.kid != s.keyID {
+			jwks.Keys = append(jwks.Keys, newJWK(prev.kid, prev.alg, prev.key))
+		}
+	}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		// Only strings: can't happen.
+		panic(fmt.Sprintf("token: encode JWKS: %v", err))
+	}
+	return jwks, data
+}
+
+// newJWK describes an RSA or ECDSA P-256 public key as a JWK.
+func newJWK(kid string, alg jwt.SigningMethod, public crypto.PublicKey) JWK {
+	key := JWK{Kid: kid, Use: "sig", Alg: alg.Alg()}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = b64(k.N.Bytes())
+		key.E = b64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = k.Curve.Params().Name
+		key.X = b64(k.X.FillBytes(make([]byte, size)))
+		key.Y = b64(k.Y.FillBytes(make([]byte, size)))
+	}
+	return key
+}
+
+// keyID derives a stable kid from the public key, so a rotated key gets a
+// new kid without any extra configuration.
+func keyID(public crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return b64(sum[:16])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
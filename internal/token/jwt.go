@@ -1,38 +1,240 @@
 package token
 
 import (
+	"crypto"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/boddle/reservoir/pkg/utctime"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// Why Validate, ValidateAudience and ValidateRefreshToken rejected a token;
+// match with errors.Is. Only ErrExpired means the token is genuine and the
+// caller should refresh rather than sign in again. Each wraps the AppError a
+// client is shown for it, so response.Error needs no knowledge of them.
+var (
+	ErrExpired          = &validationError{"token expired", apperrors.ErrTokenExpired}
+	ErrNotYetValid      = &validationError{"token not valid yet", apperrors.ErrInvalidToken}
+	ErrSignatureInvalid = &validationError{"token signature invalid", apperrors.ErrInvalidToken}
+	ErrMalformed        = &validationError{"token malformed", apperrors.ErrInvalidToken}
+	ErrWrongAudience    = &validationError{"token minted for another app", apperrors.ErrInvalidToken}
+)
+
+// validationError is a token rejection that unwraps to its client-facing
+// AppError.
+type validationError struct {
+	msg    string
+	appErr *apperrors.AppError
+}
+
+func (e *validationError) Error() string { return e.msg }
+
+func (e *validationError) Unwrap() error { return e.appErr }
+
 // Service handles JWT token operations
 type Service struct {
 	secretKey        []byte
 	refreshSecretKey []byte
 	accessTokenTTL   time.Duration
 	refreshTokenTTL  time.Duration
+	issueSkew        time.Duration // iat/nbf are backdated by this much
+	leeway           time.Duration // clock skew tolerated on exp/nbf/iat when validating
+	omitStudentEmail bool          // drop synthetic student emails from access tokens
+	minimalClaims    bool          // access tokens carry identifiers only
+	subjectFormat    SubjectFormat // what access-token sub holds; "" means SubjectUserID
+
+	// maxAccessTTL and maxRefreshTTL cap the configured TTLs; 0 means no cap.
+	maxAccessTTL  time.Duration
+	maxRefreshTTL time.Duration
+
+	// previousRefreshKeys still verify refresh tokens (but never sign them)
+	// while a rotated refresh secret's old tokens age out.
+	previousRefreshKeys [][]byte
+
+	// signing, when set, signs access tokens in place of secretKey (see
+	// WithSigner). keyID is the kid header and JWKS entry for its key.
+	signing *signerMethod
+	keyID   string
+
+	// previousSigningKeys still verify access tokens whose kid names them
+	// while a rotated-out signing key's tokens age out.
+	previousSigningKeys []verificationKey
+
+	// jwks and jwksJSON are built once in NewService; the keys never change
+	// for the life of a Service.
+	jwks     *JWKS
+	jwksJSON []byte
+}
+
+// Option configures optional Service behaviour.
+type Option func(*Service)
+
+// WithIssueSkew backdates the iat and nbf claims of generated tokens by d.
+// Clients whose clocks run slightly behind ours would otherwise reject a
+// freshly minted token as "used before issued". Expiry is unaffected.
+func WithIssueSkew(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.issueSkew = d
+		}
+	}
+}
+
+// WithLeeway tolerates clock skew of up to d when validating exp, nbf and
+// iat, so a token minted by a server whose clock runs ahead of ours (or
+// checked just after it expired) isn't rejected outright. WithIssueSkew covers
+// the opposite direction, for clients checking our tokens.
+func WithLeeway(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.leeway = d
+		}
+	}
+}
+
+// WithMaxTTL caps the access and refresh TTLs the Service was created with,
+// whatever they were configured to: a guardrail against a mistyped TTL
+// minting month-long access tokens. A zero ceiling leaves that TTL uncapped.
+func WithMaxTTL(access, refresh time.Duration) Option {
+	return func(s *Service) {
+		s.maxAccessTTL = access
+		s.maxRefreshTTL = refresh
+	}
+}
+
+// WithStudentEmailOmitted drops the email claim from student access tokens
+// whose email is the synthetic username@student.student placeholder and that
+// carry a username claim (see WithUsername), which downstream services should
+// use to identify students instead.
+func WithStudentEmailOmitted(omit bool) Option {
+	return func(s *Service) {
+		s.omitStudentEmail = omit
+	}
+}
+
+// WithMinimalClaims trims access tokens to identifiers: user_id, meta_type,
+// meta_id and the registered claims (jti, sub, exp, ...) plus tver, which
+// logout invalidation depends on. Profile claims (boddle_uid, email, name,
+// username, locale) are left out; clients fetch them from /auth/me. This
+// shrinks the Authorization header on every request.
+func WithMinimalClaims(minimal bool) Option {
+	return func(s *Service) {
+		s.minimalClaims = minimal
+	}
+}
+
+// WithSubjectFormat selects what access tokens carry in sub: the numeric
+// user ID (the default) or the boddle_uid. Either way the user_id claim is
+// still set and Validate returns it. Refresh tokens always use the user ID.
+func WithSubjectFormat(f SubjectFormat) Option {
+	return func(s *Service) {
+		s.subjectFormat = f
+	}
+}
+
+// WithPreviousRefreshSecrets keeps accepting refresh tokens signed with
+// secrets that have been rotated out. New refresh tokens are always signed
+// with the current secret; drop a previous secret once the refresh TTL has
+// passed since it was replaced. Empty secrets are ignored.
+func WithPreviousRefreshSecrets(secrets ...string) Option {
+	return func(s *Service) {
+		for _, secret := range secrets {
+			if secret != "" {
+				s.previousRefreshKeys = append(s.previousRefreshKeys, []byte(secret))
+			}
+		}
+	}
+}
+
+// WithSigner signs access tokens with an asymmetric Signer (RS256 or ES256)
+// instead of the HMAC secret, so the private key can live in a KMS/HSM.
+// Validation then accepts only tokens signed by that key, and JWKS publishes
+// its public half. Refresh tokens are only ever read by this service and stay
+// HMAC-signed with the refresh secret. signer must hold an RSA or ECDSA P-256
+// key, which NewLocalSigner and NewKMSSigner already guarantee.
+func WithSigner(signer Signer) Option {
+	return func(s *Service) {
+		alg, err := signingAlg(signer.Public())
+		if err != nil {
+			panic(fmt.Sprintf("token: %v", err))
+		}
+		s.signing = &signerMethod{alg: alg, signer: signer}
+		s.keyID = keyID(signer.Public())
+	}
+}
+
+// WithPreviousSigningKeys keeps accepting access tokens signed by keys that
+// have been rotated out, and keeps publishing them in JWKS so downstream
+// verifiers do too. Tokens pick the key by their kid header. Only takes
+// effect alongside WithSigner; drop a key once the access TTL has passed
+// since it was replaced. Each key must be RSA or ECDSA P-256.
+func WithPreviousSigningKeys(keys ...crypto.PublicKey) Option {
+	return func(s *Service) {
+		for _, key := range keys {
+			alg, err := signingAlg(key)
+			if err != nil {
+				panic(fmt.Sprintf("token: %v", err))
+			}
+			s.previousSigningKeys = append(s.previousSigningKeys, verificationKey{kid: keyID(key), alg: alg, key: key})
+		}
+	}
 }
 
 // NewService creates a new token service
-func NewService(secretKey, refreshSecretKey string, accessTTL, refreshTTL time.Duration) *Service {
-	return &Service{
+func NewService(secretKey, refreshSecretKey string, accessTTL, refreshTTL time.Duration, opts ...Option) *Service {
+	s := &Service{
 		secretKey:        []byte(secretKey),
 		refreshSecretKey: []byte(refreshSecretKey),
 		accessTokenTTL:   accessTTL,
 		refreshTokenTTL:  refreshTTL,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.signing != nil {
+		s.jwks, s.jwksJSON = s.buildJWKS()
+	}
+	return s
+}
+
+// AccessTTL returns how long Generate's access tokens live: the configured
+// TTL, capped by WithMaxTTL.
+func (s *Service) AccessTTL() time.Duration {
+	return capTTL(s.accessTokenTTL, s.maxAccessTTL)
+}
+
+// RefreshTTL is AccessTTL for refresh tokens.
+func (s *Service) RefreshTTL() time.Duration {
+	return capTTL(s.refreshTokenTTL, s.maxRefreshTTL)
+}
+
+func capTTL(ttl, ceiling time.Duration) time.Duration {
+	if ceiling > 0 && ttl > ceiling {
+		return ceiling
+	}
+	return ttl
 }
 
 // Generate generates a new token pair (access + refresh). tokenVersion is the
 // user's current users.token_version; it is embedded in both tokens so logout
 // (which bumps the column) can invalidate them (see Finding 2 / LMS-6513).
-func (s *Service) Generate(userID int, boddleUID, email, name, metaType string, metaID, tokenVersion int) (*TokenPair, error) {
+// Optional access-token claims (e.g. locale) are supplied via claimOpts.
+func (s *Service) Generate(userID int, boddleUID, email, name, metaType string, metaID, tokenVersion int, claimOpts ...ClaimOption) (*TokenPair, error) {
 	now := time.Now()
-	accessExpiry := now.Add(s.accessTokenTTL)
-	refreshExpiry := now.Add(s.refreshTokenTTL)
+	accessExpiry := now.Add(s.AccessTTL())
+	refreshExpiry := now.Add(s.RefreshTTL())
+	issuedAt := now.Add(-s.issueSkew)
+
+	subject := strconv.Itoa(userID)
+	if s.subjectFormat == SubjectBoddleUID && boddleUID != "" {
+		subject = boddleUID
+	}
 
 	// Generate access token
 	accessClaims := Claims{
@@ -45,29 +247,63 @@ func (s *Service) Generate(userID int, boddleUID, email, name, metaType string,
 		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(accessExpiry),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
 			Issuer:    "boddle-auth-gateway",
-			Subject:   fmt.Sprintf("%d", userID),
+			Subject:   subject,
 			ID:        uuid.New().String(), // JTI for token revocation
 		},
 	}
+	for _, opt := range claimOpts {
+		opt(&accessClaims)
+	}
+	family := accessClaims.refreshFamily
+	if family == "" {
+		family = uuid.New().String()
+	}
+	if accessClaims.Device != "" {
+		accessClaims.Session = family
+	}
+	if metaType != "Student" {
+		accessClaims.Username = ""
+	} else if s.omitStudentEmail && accessClaims.Username != "" && isSyntheticStudentEmail(email) {
+		accessClaims.Email = ""
+	}
+	if s.minimalClaims {
+		accessClaims.BoddleUID = ""
+		accessClaims.Email = ""
+		accessClaims.Name = ""
+		accessClaims.Username = ""
+		accessClaims.Locale = ""
+	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(s.secretKey)
+	var accessTokenString string
+	var err error
+	if s.signing != nil {
+		accessToken := jwt.NewWithClaims(s.signing, accessClaims)
+		accessToken.Header["kid"] = s.keyID
+		accessTokenString, err = accessToken.SignedString(nil)
+	} else {
+		accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
+		accessTokenString, err = accessToken.SignedString(s.secretKey)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign access token: %w", err)
 	}
 
 	// Generate refresh token
 	refreshClaims := RefreshClaims{
-		TokenVersion: tokenVersion,
+		TokenVersion:      tokenVersion,
+		Family:            family,
+		Device:            accessClaims.Device,
+		PasswordChangedAt: accessClaims.PasswordChangedAt,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(refreshExpiry),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
 			Issuer:    "boddle-auth-gateway",
-			Subject:   fmt.Sprintf("%d", userID),
+			Subject:   strconv.Itoa(userID),
+			Audience:  accessClaims.Audience,
 			ID:        uuid.New().String(),
 		},
 	}
@@ -79,35 +315,61 @@ func (s *Service) Generate(userID int, boddleUID, email, name, metaType string,
 	}
 
 	return &TokenPair{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
-		ExpiresAt:    accessExpiry,
-		TokenType:    TokenTypeBearer,
+		AccessToken:   accessTokenString,
+		RefreshToken:  refreshTokenString,
+		ExpiresAt:     utctime.New(accessExpiry),
+		TokenType:     TokenTypeBearer,
+		JTI:           accessClaims.ID,
+		RefreshJTI:    refreshClaims.ID,
+		RefreshFamily: family,
+
+		RefreshExpiresAt: refreshExpiry,
 	}, nil
 }
 
 // Validate validates an access token and returns the claims
 func (s *Service) Validate(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return s.secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.accessKey, s.timeChecks()...)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, classifyParseError("failed to parse token", err)
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid token claims")
 	}
+	if err := claims.resolveUserID(); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
 
+// ValidateAudience is Validate for a token that must have been minted for
+// app (see WithAudience). A token for another app, or for no app in
+// particular, is rejected with ErrWrongAudience.
+func (s *Service) ValidateAudience(tokenString, app string) (*Claims, error) {
+	claims, err := s.Validate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckAudience(claims, app); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
 
+// CheckAudience returns ErrWrongAudience unless claims were minted for app.
+// For claims that have been validated some other way, e.g. through
+// ValidateAllowExpired.
+func CheckAudience(claims *Claims, app string) error {
+	if AppOf(claims.Audience) != app {
+		return fmt.Errorf("token audience %q: %w", AppOf(claims.Audience), ErrWrongAudience)
+	}
+	return nil
+}
+
 // ValidateRefreshToken validates a refresh token and returns its claims
 func (s *Service) ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &RefreshClaims{}, func(token *jwt.Token) (interface{}, error) {
@@ -115,11 +377,11 @@ func (s *Service) ValidateRefreshToken(tokenString string) (*RefreshClaims, erro
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return s.refreshSecretKey, nil
-	})
+		return s.refreshKeys(), nil
+	}, s.timeChecks()...)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse refresh token: %w", err)
+		return nil, classifyParseError("failed to parse refresh token", err)
 	}
 
 	claims, ok := token.Claims.(*RefreshClaims)
@@ -130,17 +392,52 @@ func (s *Service) ValidateRefreshToken(tokenString string) (*RefreshClaims, erro
 	return claims, nil
 }
 
+// classifyParseError wraps a jwt parse error with msg and the matching
+// sentinel. jwt checks the signature before exp/nbf/iat, so ErrExpired and
+// ErrNotYetValid are only reported for genuine tokens. A token we can't
+// verify at all (unknown kid, unexpected alg) counts as a bad signature.
+func classifyParseError(msg string, err error) error {
+	var sentinel error
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		sentinel = ErrMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		sentinel = ErrSignatureInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		sentinel = ErrExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		sentinel = ErrNotYetValid
+	default:
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return fmt.Errorf("%s: %w: %w", msg, sentinel, err)
+}
+
+// timeChecks are the parser options for exp/nbf/iat validation: iat is
+// checked too (jwt skips it by default), and all three get the leeway.
+func (s *Service) timeChecks() []jwt.ParserOption {
+	return []jwt.ParserOption{jwt.WithLeeway(s.leeway), jwt.WithIssuedAt()}
+}
+
+// refreshKeys returns what refresh tokens are verified against: the current
+// secret, plus any previous ones during a rotation.
+func (s *Service) refreshKeys() interface{} {
+	if len(s.previousRefreshKeys) == 0 {
+		return s.refreshSecretKey
+	}
+	keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{s.refreshSecretKey}}
+	for _, k := range s.previousRefreshKeys {
+		keys.Keys = append(keys.Keys, k)
+	}
+	return keys
+}
+
 // ValidateAllowExpired verifies an access token's signature but tolerates an
 // expired token, returning its claims. Used at logout so a user whose access
 // token has already expired can still revoke their session — verifying the
 // signature prevents an attacker from forcing logout of an arbitrary user.
 func (s *Service) ValidateAllowExpired(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return s.secretKey, nil
-	}, jwt.WithoutClaimsValidation()) // skip exp/nbf checks; signature is still verified
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.accessKey, jwt.WithoutClaimsValidation()) // skip exp/nbf checks; signature is still verified
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -150,10 +447,41 @@ func (s *Service) ValidateAllowExpired(tokenString string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid token claims")
 	}
+	if err := claims.resolveUserID(); err != nil {
+		return nil, err
+	}
 
 	return claims, nil
 }
 
+// accessKey is the jwt.Keyfunc for access tokens. Only the configured
+// algorithm is accepted, so an HS256 token can't be passed off as signed by
+// the Signer or vice versa.
+func (s *Service) accessKey(token *jwt.Token) (interface{}, error) {
+	if s.signing != nil {
+		if kid, _ := token.Header["kid"].(string); kid != "" && kid != s.keyID {
+			for _, prev := range s.previousSigningKeys {
+				if prev.kid != kid {
+					continue
+				}
+				if token.Method.Alg() != prev.alg.Alg() {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				}
+				return prev.key, nil
+			}
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != s.signing.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.signing.signer.Public(), nil
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return s.secretKey, nil
+}
+
 // ExtractTokenID extracts the JTI (JWT ID) from a token string without full validation
 // This is useful for blacklist checking before expensive validation
 func (s *Service) ExtractTokenID(tokenString string) (string, error) {
@@ -169,3 +497,13 @@ func (s *Service) ExtractTokenID(tokenString string) (string, error) {
 
 	return claims.ID, nil
 }
+
+// studentEmailDomain is the suffix of the placeholder emails students are
+// created with (see auth.IsStudentEmail).
+const studentEmailDomain = "@student.student"
+
+// isSyntheticStudentEmail reports whether email is a placeholder student
+// address rather than a real one.
+func isSyntheticStudentEmail(email string) bool {
+	return len(email) > len(studentEmailDomain) && strings.HasSuffix(strings.ToLower(email), studentEmailDomain)
+}
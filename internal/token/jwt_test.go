@@ -1,8 +1,15 @@
 package token
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestService_Generate(t *testing.T) {
@@ -53,6 +60,11 @@ func TestService_Generate(t *testing.T) {
 	if diff > time.Minute {
 		t.Errorf("ExpiresAt = %v, expected around %v (diff: %v)", tokenPair.ExpiresAt, expectedExpiry, diff)
 	}
+
+	// Serialized in UTC whatever the server's local zone is.
+	if body, _ := tokenPair.ExpiresAt.MarshalJSON(); !strings.HasSuffix(string(body), `Z"`) {
+		t.Errorf("expires_at = %s, want a UTC timestamp", body)
+	}
 }
 
 func TestService_Validate(t *testing.T) {
@@ -218,3 +230,102 @@ func TestService_ExtractTokenID(t *testing.T) {
 		t.Errorf("ExtractTokenID() = %q, but Validate() claims.ID = %q", tokenID, claims.ID)
 	}
 }
+
+func TestService_GenerateLocaleClaim(t *testing.T) {
+	service := NewService(
+		"test-secret-key-minimum-32-chars",
+		"test-refresh-secret-key-32-chars",
+		6*time.Hour,
+		720*time.Hour,
+	)
+
+	tokenPair, err := service.Generate(1, "boddle-uid-123", "test@example.com", "Test User", "Teacher", 10, 1, WithLocale("es-MX"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	claims, err := service.Validate(tokenPair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	if claims.Locale != "es-MX" {
+		t.Errorf("Locale = %q, want %q", claims.Locale, "es-MX")
+	}
+
+	// Without a locale the claim is omitted from the payload entirely.
+	tokenPair, err = service.Generate(1, "boddle-uid-123", "test@example.com", "Test User", "Teacher", 10, 1)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	parts := strings.Split(tokenPair.AccessToken, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if strings.Contains(string(payload), `"locale"`) {
+		t.Errorf("payload %s should omit an empty locale claim", payload)
+	}
+}
+
+func TestService_ValidateClassifiesErrors(t *testing.T) {
+	const secret = "test-secret-key-minimum-32-chars"
+	service := NewService(secret, "test-refresh-secret-key-32-chars", time.Hour, time.Hour)
+
+	sign := func(t *testing.T, key string, claims Claims) string {
+		t.Helper()
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		return s
+	}
+	claimsAt := func(iat, exp time.Time) Claims {
+		return Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(iat),
+			NotBefore: jwt.NewNumericDate(iat),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		}}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", sign(t, secret, claimsAt(now.Add(-2*time.Hour), now.Add(-time.Hour))), ErrExpired},
+		{"not yet valid", sign(t, secret, claimsAt(now.Add(time.Hour), now.Add(2*time.Hour))), ErrNotYetValid},
+		{"wrong key", sign(t, "some-other-secret-minimum-32-chars", claimsAt(now, now.Add(time.Hour))), ErrSignatureInvalid},
+		{"expired with wrong key", sign(t, "some-other-secret-minimum-32-chars", claimsAt(now.Add(-2*time.Hour), now.Add(-time.Hour))), ErrSignatureInvalid},
+		{"malformed", "not.a.jwt", ErrMalformed},
+		{"empty", "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.Validate(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsUnwrapToAppErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want *apperrors.AppError
+	}{
+		{ErrExpired, apperrors.ErrTokenExpired},
+		{ErrNotYetValid, apperrors.ErrInvalidToken},
+		{ErrSignatureInvalid, apperrors.ErrInvalidToken},
+		{ErrMalformed, apperrors.ErrInvalidToken},
+		{ErrWrongAudience, apperrors.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		var appErr *apperrors.AppError
+		if !errors.As(fmt.Errorf("invalid token: %w", tt.err), &appErr) || appErr != tt.want {
+			t.Errorf("%v unwraps to %v, want %v", tt.err, appErr, tt.want)
+		}
+	}
+}
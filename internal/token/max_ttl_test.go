@@ -0,0 +1,56 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerate_CapsTTLAtCeiling(t *testing.T) {
+	tests := []struct {
+		name                    string
+		accessTTL, refreshTTL   time.Duration
+		maxAccess, maxRefresh   time.Duration
+		wantAccess, wantRefresh time.Duration
+	}{
+		{"within ceilings", 6 * time.Hour, 720 * time.Hour, 24 * time.Hour, 2160 * time.Hour, 6 * time.Hour, 720 * time.Hour},
+		{"access over ceiling", 720 * time.Hour, 720 * time.Hour, 24 * time.Hour, 2160 * time.Hour, 24 * time.Hour, 720 * time.Hour},
+		{"refresh over ceiling", 6 * time.Hour, 8760 * time.Hour, 24 * time.Hour, 2160 * time.Hour, 6 * time.Hour, 2160 * time.Hour},
+		{"no ceilings", 720 * time.Hour, 8760 * time.Hour, 0, 0, 720 * time.Hour, 8760 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService("test-secret-key-minimum-32-chars", "test-refresh-secret-key-32-chars",
+				tt.accessTTL, tt.refreshTTL, WithMaxTTL(tt.maxAccess, tt.maxRefresh))
+			if svc.AccessTTL() != tt.wantAccess || svc.RefreshTTL() != tt.wantRefresh {
+				t.Errorf("TTLs = %v, %v; want %v, %v", svc.AccessTTL(), svc.RefreshTTL(), tt.wantAccess, tt.wantRefresh)
+			}
+
+			before := time.Now()
+			pair, err := svc.Generate(1, "uid", "a@b.com", "A B", "Teacher", 10, 1)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			access, err := svc.Validate(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			refresh, err := svc.ValidateRefreshToken(pair.RefreshToken)
+			if err != nil {
+				t.Fatalf("ValidateRefreshToken: %v", err)
+			}
+			// NumericDate has one-second resolution, so allow a second of slack.
+			for name, c := range map[string]struct {
+				got  time.Time
+				want time.Duration
+			}{
+				"access":  {access.ExpiresAt.Time, tt.wantAccess},
+				"refresh": {refresh.ExpiresAt.Time, tt.wantRefresh},
+			} {
+				want := before.Add(c.want)
+				if d := c.got.Sub(want); d < -time.Second || d > time.Second {
+					t.Errorf("%s exp = %v, want %v", name, c.got, want)
+				}
+			}
+		})
+	}
+}
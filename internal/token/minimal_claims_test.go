@@ -0,0 +1,76 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// payloadKeys decodes a JWT's payload without verifying it and returns the
+// claim names present.
+func payloadKeys(t *testing.T, tok string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token: %q", tok)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatalf("unmarshal payload: %v", err)
+	}
+	return payload
+}
+
+func TestGenerate_MinimalClaims(t *testing.T) {
+	full := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	minimal := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithMinimalClaims(true))
+
+	gen := func(s *Service) *TokenPair {
+		pair, err := s.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 3, WithLocale("en-US"))
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return pair
+	}
+	fullPair, minPair := gen(full), gen(minimal)
+
+	payload := payloadKeys(t, minPair.AccessToken)
+	for _, heavy := range []string{"boddle_uid", "email", "name", "locale", "username"} {
+		if _, ok := payload[heavy]; ok {
+			t.Errorf("minimal token carries %q", heavy)
+		}
+	}
+	for _, id := range []string{"user_id", "meta_type", "meta_id", "jti", "sub", "tver"} {
+		if _, ok := payload[id]; !ok {
+			t.Errorf("minimal token is missing %q", id)
+		}
+	}
+
+	if len(minPair.AccessToken) >= len(fullPair.AccessToken) {
+		t.Errorf("minimal token (%d bytes) is not smaller than full token (%d bytes)",
+			len(minPair.AccessToken), len(fullPair.AccessToken))
+	}
+
+	claims, err := minimal.Validate(minPair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if claims.UserID != 42 || claims.MetaType != "Teacher" || claims.MetaID != 7 || claims.TokenVersion != 3 {
+		t.Errorf("claims = %+v, want user 42 / Teacher 7 / tver 3", claims)
+	}
+
+	// Full mode is the default and keeps the profile claims.
+	fullClaims, err := full.Validate(fullPair.AccessToken)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if fullClaims.Email != "teacher@school.org" || fullClaims.Name != "Ms. Frizzle" {
+		t.Errorf("full-mode claims lost profile fields: %+v", fullClaims)
+	}
+}
@@ -0,0 +1,79 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshFamilyKeyPrefix = "refresh:family:"
+
+	// refreshFamilyRevoked replaces a family's current JTI once reuse is
+	// detected, so no token of the family is ever accepted again.
+	refreshFamilyRevoked = "revoked"
+)
+
+// ErrRefreshReused is returned when a refresh token that has already been
+// rotated away is presented again: either it leaked, or two refreshes raced
+// with the same token. We can't tell which, so the family is revoked.
+var ErrRefreshReused = errors.New("refresh token reused")
+
+// checkAndRotateScript swaps a family's current JTI from ARGV[1] to ARGV[2].
+// A missing family (pre-dating tracking, or lost from Redis) is adopted; any
+// other JTI is a reuse and revokes the family.
+var checkAndRotateScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false or current == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[4])
+	return 1
+end
+redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+return 0
+`)
+
+// RefreshFamilies tracks the current refresh token of each family, the chain
+// of tokens one sign-in rotates through, so replaying a rotated-away token is
+// caught and the whole chain revoked. A user signed in on several devices has
+// one family per device, so their refreshes don't trip each other.
+type RefreshFamilies struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRefreshFamilies creates a family tracker. ttl should be the refresh
+// token lifetime: a family is forgotten once its newest token has expired.
+func NewRefreshFamilies(client *redis.Client, ttl time.Duration) *RefreshFamilies {
+	return &RefreshFamilies{client: client, ttl: ttl}
+}
+
+// StoreRefreshJTI records jti as family's current refresh token.
+func (f *RefreshFamilies) StoreRefreshJTI(ctx context.Context, family, jti string) error {
+	if err := f.client.Set(ctx, refreshFamilyKeyPrefix+family, jti, f.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store refresh token family: %w", err)
+	}
+	return nil
+}
+
+// CheckAndRotate atomically replaces family's current refresh token,
+// presented, with next. If presented isn't the current one, the family is
+// revoked and ErrRefreshReused returned; so is every later call for it.
+func (f *RefreshFamilies) CheckAndRotate(ctx context.Context, family, presented, next string) error {
+	ok, err := checkAndRotateScript.Run(ctx, f.client, []string{refreshFamilyKeyPrefix + family},
+		presented, next, refreshFamilyRevoked, f.ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token family: %w", err)
+	}
+	if ok != 1 {
+		return ErrRefreshReused
+	}
+	return nil
+}
+
+// Revoke marks family as revoked, so none of its tokens can be exchanged.
+func (f *RefreshFamilies) Revoke(ctx context.Context, family string) error {
+	return f.StoreRefreshJTI(ctx, family, refreshFamilyRevoked)
+}
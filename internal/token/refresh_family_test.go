@@ -0,0 +1,118 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRefreshFamilies(t *testing.T) (*RefreshFamilies, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRefreshFamilies(client, time.Hour), mr
+}
+
+func TestRefreshFamilies_RotateThenReplay(t *testing.T) {
+	f, mr := newTestRefreshFamilies(t)
+	ctx := context.Background()
+
+	if err := f.StoreRefreshJTI(ctx, "fam-1", "jti-1"); err != nil {
+		t.Fatalf("StoreRefreshJTI: %v", err)
+	}
+	if err := f.CheckAndRotate(ctx, "fam-1", "jti-1", "jti-2"); err != nil {
+		t.Fatalf("rotate current token: %v", err)
+	}
+	if err := f.CheckAndRotate(ctx, "fam-1", "jti-2", "jti-3"); err != nil {
+		t.Fatalf("rotate again: %v", err)
+	}
+	if ttl := mr.TTL(refreshFamilyKeyPrefix + "fam-1"); ttl != time.Hour {
+		t.Errorf("family TTL = %v, want 1h", ttl)
+	}
+
+	// jti-1 was rotated away: presenting it again revokes the family...
+	if err := f.CheckAndRotate(ctx, "fam-1", "jti-1", "jti-x"); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("replay err = %v, want ErrRefreshReused", err)
+	}
+	// ...including the token that was current until then.
+	if err := f.CheckAndRotate(ctx, "fam-1", "jti-3", "jti-4"); !errors.Is(err, ErrRefreshReused) {
+		t.Errorf("current token after revocation err = %v, want ErrRefreshReused", err)
+	}
+}
+
+func TestRefreshFamilies_UntrackedFamilyIsAdopted(t *testing.T) {
+	f, mr := newTestRefreshFamilies(t)
+
+	if err := f.CheckAndRotate(context.Background(), "fam-new", "jti-1", "jti-2"); err != nil {
+		t.Fatalf("CheckAndRotate: %v", err)
+	}
+	if got, _ := mr.Get(refreshFamilyKeyPrefix + "fam-new"); got != "jti-2" {
+		t.Errorf("current JTI = %q, want jti-2", got)
+	}
+}
+
+func TestRefreshFamilies_ConcurrentRotationHasOneWinner(t *testing.T) {
+	f, _ := newTestRefreshFamilies(t)
+	ctx := context.Background()
+	if err := f.StoreRefreshJTI(ctx, "fam-1", "jti-1"); err != nil {
+		t.Fatalf("StoreRefreshJTI: %v", err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f.CheckAndRotate(ctx, "fam-1", "jti-1", "next-"+string(rune('a'+i)))
+		}(i)
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrRefreshReused):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d rotations succeeded, want exactly 1", won)
+	}
+}
+
+func TestGenerate_RefreshFamily(t *testing.T) {
+	s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+
+	first, err := s.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	claims, err := s.ValidateRefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("ValidateRefreshToken: %v", err)
+	}
+	if claims.Family == "" || claims.Family != first.RefreshFamily || claims.ID != first.RefreshJTI {
+		t.Fatalf("refresh claims fam=%q jti=%q, pair fam=%q jti=%q", claims.Family, claims.ID, first.RefreshFamily, first.RefreshJTI)
+	}
+
+	rotated, err := s.Generate(42, "uid-42", "kid1@student.student", "Kid One", "Student", 9, 0, WithRefreshFamily(claims.Family))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if rotated.RefreshFamily != first.RefreshFamily {
+		t.Errorf("rotated family = %q, want %q", rotated.RefreshFamily, first.RefreshFamily)
+	}
+	if access, _ := s.Validate(rotated.AccessToken); access != nil && access.refreshFamily != "" {
+		t.Error("refresh family leaked into the access token")
+	}
+}
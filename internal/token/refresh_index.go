@@ -0,0 +1,121 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boddle/reservoir/pkg/utctime"
+	"github.com/redis/go-redis/v9"
+)
+
+const refreshIndexKeyPrefix = "refresh:user:"
+
+// IssuedRefreshToken is one of a user's outstanding refresh tokens, as listed
+// by a RefreshTokenIndex.
+type IssuedRefreshToken struct {
+	JTI       string       `json:"jti"`
+	ExpiresAt utctime.Time `json:"expires_at"`
+}
+
+// RefreshTokenIndex lists each user's outstanding refresh tokens, so they can
+// be shown and revoked one at a time. A user's JTIs live in a sorted set
+// scored by expiry, so each entry lapses with its token; expired entries are
+// pruned on every write. The set itself expires with its newest token. A nil
+// *RefreshTokenIndex records nothing.
+type RefreshTokenIndex struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRefreshTokenIndex creates an index. ttl should be the refresh token
+// lifetime.
+func NewRefreshTokenIndex(client *redis.Client, ttl time.Duration) *RefreshTokenIndex {
+	return &RefreshTokenIndex{client: client, ttl: ttl}
+}
+
+// Add records jti, expiring at expiresAt, as one of userID's refresh tokens.
+func (x *RefreshTokenIndex) Add(ctx context.Context, userID int, jti string, expiresAt time.Time) error {
+	if x == nil {
+		return nil
+	}
+	key := refreshIndexKey(userID)
+	_, err := x.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.Expire(ctx, key, x.ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index refresh token: %w", err)
+	}
+	return nil
+}
+
+// List returns userID's unexpired refresh tokens, soonest-expiring first.
+func (x *RefreshTokenIndex) List(ctx context.Context, userID int) ([]IssuedRefreshToken, error) {
+	if x == nil {
+		return nil, nil
+	}
+	entries, err := x.client.ZRangeByScoreWithScores(ctx, refreshIndexKey(userID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refresh tokens: %w", err)
+	}
+	tokens := make([]IssuedRefreshToken, 0, len(entries))
+	for _, z := range entries {
+		jti, _ := z.Member.(string)
+		tokens = append(tokens, IssuedRefreshToken{
+			JTI:       jti,
+			ExpiresAt: utctime.New(time.Unix(int64(z.Score), 0)),
+		})
+	}
+	return tokens, nil
+}
+
+// Expiry returns when userID's refresh token jti expires; ok is false if jti
+// isn't one of theirs.
+func (x *RefreshTokenIndex) Expiry(ctx context.Context, userID int, jti string) (expiresAt time.Time, ok bool, err error) {
+	if x == nil {
+		return time.Time{}, false, nil
+	}
+	score, err := x.client.ZScore(ctx, refreshIndexKey(userID), jti).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	return time.Unix(int64(score), 0), true, nil
+}
+
+// Remove drops jti from userID's refresh tokens.
+func (x *RefreshTokenIndex) Remove(ctx context.Context, userID int, jti string) error {
+	if x == nil {
+		return nil
+	}
+	if err := x.client.ZRem(ctx, refreshIndexKey(userID), jti).Err(); err != nil {
+		return fmt.Errorf("failed to unindex refresh token: %w", err)
+	}
+	return nil
+}
+
+// Clear forgets all of userID's refresh tokens, e.g. once they have all been
+// revoked by a token_version bump.
+func (x *RefreshTokenIndex) Clear(ctx context.Context, userID int) error {
+	if x == nil {
+		return nil
+	}
+	if err := x.client.Del(ctx, refreshIndexKey(userID)).Err(); err != nil {
+		return fmt.Errorf("failed to clear refresh token index: %w", err)
+	}
+	return nil
+}
+
+func refreshIndexKey(userID int) string {
+	return refreshIndexKeyPrefix + strconv.Itoa(userID)
+}
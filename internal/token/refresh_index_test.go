@@ -0,0 +1,39 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRefreshTokenIndex_DropsExpiredTokens(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	idx := NewRefreshTokenIndex(client, time.Hour)
+	ctx := context.Background()
+
+	if err := idx.Add(ctx, 42, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := idx.Add(ctx, 42, "live", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	listed, err := idx.List(ctx, 42)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 1 || listed[0].JTI != "live" {
+		t.Errorf("listed = %+v, want only live", listed)
+	}
+	if _, ok, _ := idx.Expiry(ctx, 42, "expired"); ok {
+		t.Error("expired jti still indexed after a later write")
+	}
+	if ttl := mr.TTL("refresh:user:42"); ttl != time.Hour {
+		t.Errorf("index TTL = %v, want 1h", ttl)
+	}
+}
@@ -0,0 +1,44 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRefreshToken_PreviousSecretDuringRotation(t *testing.T) {
+	before := NewService("access-secret", "refresh-secret-old", time.Hour, time.Hour)
+	oldPair, err := before.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	// Rotated: new primary, old secret kept for verification.
+	rotated := NewService("access-secret", "refresh-secret-new", time.Hour, time.Hour,
+		WithPreviousRefreshSecrets("refresh-secret-old"))
+
+	claims, err := rotated.ValidateRefreshToken(oldPair.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh token signed with the previous secret rejected: %v", err)
+	}
+	if claims.Subject != "42" {
+		t.Errorf("sub = %q, want 42", claims.Subject)
+	}
+
+	// New tokens are signed with the new secret only.
+	newPair, err := rotated.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := before.ValidateRefreshToken(newPair.RefreshToken); err == nil {
+		t.Error("new refresh token verified with the old secret; it should be signed with the new one")
+	}
+	if _, err := rotated.ValidateRefreshToken(newPair.RefreshToken); err != nil {
+		t.Errorf("new refresh token rejected: %v", err)
+	}
+
+	// Once the overlap ends, old tokens stop working.
+	after := NewService("access-secret", "refresh-secret-new", time.Hour, time.Hour)
+	if _, err := after.ValidateRefreshToken(oldPair.RefreshToken); err == nil {
+		t.Error("old refresh token accepted after the previous secret was removed")
+	}
+}
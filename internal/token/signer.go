@@ -0,0 +1,245 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer produces JWT signatures with an asymmetric key. Implementations may
+// keep the private key outside the process (e.g. in a KMS/HSM); the token
+// service only ever sees signatures and the public key.
+type Signer interface {
+	// Sign returns the JWS signature over data (the "header.payload"
+	// signing input), already in JWS encoding: PKCS#1 v1.5 for RSA, r||s
+	// for ECDSA.
+	Sign(data []byte) ([]byte, error)
+	// Public returns the key that verifies Sign's output. It is what JWKS
+	// publishes.
+	Public() crypto.PublicKey
+}
+
+// LocalSigner signs with a private key held in process memory. It exists for
+// development and for deployments that don't have a KMS; it is also the
+// reference implementation the external signers are tested against.
+type LocalSigner struct {
+	key crypto.Signer
+}
+
+// NewLocalSigner wraps an RSA or ECDSA P-256 private key.
+func NewLocalSigner(key crypto.Signer) (*LocalSigner, error) {
+	if _, err := signingAlg(key.Public()); err != nil {
+		return nil, err
+	}
+	return &LocalSigner{key: key}, nil
+}
+
+// LoadLocalSigner reads a PEM-encoded RSA or ECDSA P-256 private key
+// (PKCS#1, SEC 1 or PKCS#8) from path.
+func LoadLocalSigner(path string) (*LocalSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return NewLocalSigner(signer)
+}
+
+// LoadPublicKey reads a PEM-encoded RSA or ECDSA P-256 public key (PKIX or
+// PKCS#1) from path, for WithPreviousSigningKeys.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", path)
+	}
+
+	var key interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if _, err := signingAlg(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Sign implements Signer.
+func (s *LocalSigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	sig, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return jwsSignature(s.key.Public(), sig)
+}
+
+// Public implements Signer.
+func (s *LocalSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// KMSClient is the slice of a KMS API that KMSSigner needs. An AWS KMS
+// adapter maps SignDigest to Sign with MessageType DIGEST and
+// SigningAlgorithm RSASSA_PKCS1_V1_5_SHA_256 / ECDSA_SHA_256, and PublicKey
+// to GetPublicKey. No adapter ships with the gateway yet.
+type KMSClient interface {
+	SignDigest(ctx context.Context, keyID string, digest []byte) ([]byte, error)
+	PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error)
+}
+
+// KMSSigner signs with a key that never leaves the KMS/HSM: only the SHA-256
+// digest of the signing input is sent out.
+type KMSSigner struct {
+	client KMSClient
+	keyID  string
+	public crypto.PublicKey
+}
+
+// NewKMSSigner fetches the public half of keyID once up front, so a missing
+// or unsupported key fails at startup rather than on the first login.
+func NewKMSSigner(ctx context.Context, client KMSClient, keyID string) (*KMSSigner, error) {
+	if client == nil {
+		return nil, errors.New("no KMS client configured")
+	}
+	public, err := client.PublicKey(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch KMS public key: %w", err)
+	}
+	if _, err := signingAlg(public); err != nil {
+		return nil, err
+	}
+	return &KMSSigner{client: client, keyID: keyID, public: public}, nil
+}
+
+// Sign implements Signer.
+func (s *KMSSigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	sig, err := s.client.SignDigest(context.Background(), s.keyID, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("KMS sign failed: %w", err)
+	}
+	return jwsSignature(s.public, sig)
+}
+
+// Public implements Signer.
+func (s *KMSSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// signingAlg picks the JWS algorithm for a public key.
+func signingAlg(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", public)
+	}
+}
+
+// CheckSigningAlgorithm checks a configured JWS algorithm (JWT_SIGNING_ALGORITHM)
+// against the key tokens will actually be signed with: HS256 without a
+// signer, otherwise RS256 or ES256 by key type. An empty alg accepts whatever
+// the key implies. Pinning it turns a swapped key file into a startup error
+// instead of a silent change of algorithm every verifier has to follow.
+func CheckSigningAlgorithm(alg string, signer Signer) error {
+	want := jwt.SigningMethodHS256.Alg()
+	if signer != nil {
+		method, err := signingAlg(signer.Public())
+		if err != nil {
+			return err
+		}
+		want = method.Alg()
+	}
+	if alg == "" || alg == want {
+		return nil
+	}
+	if signer == nil {
+		return fmt.Errorf("signing algorithm %s needs a signing key", alg)
+	}
+	return fmt.Errorf("signing algorithm %s does not match the %s signing key", alg, want)
+}
+
+// jwsSignature converts a signature as returned by crypto.Signer or a KMS
+// into JWS form. ECDSA signatures come back ASN.1 DER encoded, while JWS
+// wants the fixed-width r||s concatenation.
+func jwsSignature(public crypto.PublicKey, sig []byte) ([]byte, error) {
+	k, ok := public.(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	var parsed struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode ECDSA signature: %w", err)
+	}
+	size := (k.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	parsed.R.FillBytes(out[:size])
+	parsed.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// signerMethod adapts a Signer to jwt.SigningMethod so tokens are built with
+// the usual jwt.NewWithClaims/SignedString path. The key argument is unused:
+// the Signer holds (or fronts) the key.
+type signerMethod struct {
+	alg    jwt.SigningMethod
+	signer Signer
+}
+
+func (m *signerMethod) Alg() string { return m.alg.Alg() }
+
+func (m *signerMethod) Sign(signingString string, _ interface{}) ([]byte, error) {
+	return m.signer.Sign([]byte(signingString))
+}
+
+func (m *signerMethod) Verify(signingString string, sig []byte, _ interface{}) error {
+	return m.alg.Verify(signingString, sig, m.signer.Public())
+}
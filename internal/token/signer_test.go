@@ -0,0 +1,287 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSASigner(t *testing.T) *LocalSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	signer, err := NewLocalSigner(key)
+	if err != nil {
+		t.Fatalf("NewLocalSigner: %v", err)
+	}
+	return signer
+}
+
+func newECSigner(t *testing.T) *LocalSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	signer, err := NewLocalSigner(key)
+	if err != nil {
+		t.Fatalf("NewLocalSigner: %v", err)
+	}
+	return signer
+}
+
+// publicKeyFromJWK rebuilds a verification key from a served JWK, the way a
+// downstream service would.
+func publicKeyFromJWK(t *testing.T, k JWK) crypto.PublicKey {
+	t.Helper()
+	dec := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("decode JWK member: %v", err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch k.Kty {
+	case "RSA":
+		return &rsa.PublicKey{N: dec(k.N), E: int(dec(k.E).Int64())}
+	case "EC":
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: dec(k.X), Y: dec(k.Y)}
+	}
+	t.Fatalf("unexpected kty %q", k.Kty)
+	return nil
+}
+
+func TestSigner_TokensVerifyAgainstJWKS(t *testing.T) {
+	for name, signer := range map[string]*LocalSigner{
+		"RS256": newRSASigner(t),
+		"ES256": newECSigner(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithSigner(signer))
+
+			pair, err := s.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 1)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			claims, err := s.Validate(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.UserID != 42 {
+				t.Errorf("UserID = %d, want 42", claims.UserID)
+			}
+
+			jwks, ok := s.JWKS()
+			if !ok || len(jwks.Keys) != 1 {
+				t.Fatalf("JWKS() = %+v, %v; want one key", jwks, ok)
+			}
+			jwk := jwks.Keys[0]
+			if jwk.Alg != name {
+				t.Errorf("JWK alg = %q, want %q", jwk.Alg, name)
+			}
+
+			// An independent verifier using only the published key.
+			parsed, err := jwt.ParseWithClaims(pair.AccessToken, &Claims{}, func(tok *jwt.Token) (interface{}, error) {
+				if tok.Header["kid"] != jwk.Kid {
+					t.Errorf("kid = %v, want %q", tok.Header["kid"], jwk.Kid)
+				}
+				return publicKeyFromJWK(t, jwk), nil
+			}, jwt.WithValidMethods([]string{name}))
+			if err != nil || !parsed.Valid {
+				t.Fatalf("token does not verify against JWKS: %v", err)
+			}
+
+			// Refresh tokens are still HMAC-signed and still validate.
+			if _, err := s.ValidateRefreshToken(pair.RefreshToken); err != nil {
+				t.Errorf("ValidateRefreshToken: %v", err)
+			}
+		})
+	}
+}
+
+func TestSigner_RejectsOtherAlgorithms(t *testing.T) {
+	hmacService := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	signed := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithSigner(newRSASigner(t)))
+
+	hmacPair, err := hmacService.Generate(1, "", "", "", "Teacher", 1, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := signed.Validate(hmacPair.AccessToken); err == nil {
+		t.Error("HS256 token accepted by a service configured with a signer")
+	}
+
+	signedPair, err := signed.Generate(1, "", "", "", "Teacher", 1, 0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := hmacService.Validate(signedPair.AccessToken); err == nil {
+		t.Error("RS256 token accepted by an HMAC-only service")
+	}
+	// A different key with the same algorithm must not verify either.
+	other := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithSigner(newRSASigner(t)))
+	if _, err := other.Validate(signedPair.AccessToken); err == nil {
+		t.Error("token accepted under an unrelated RSA key")
+	}
+}
+
+func TestSigner_PreviousKeysVerifyDuringRotation(t *testing.T) {
+	oldSigner, newSigner := newRSASigner(t), newECSigner(t)
+	before := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithSigner(oldSigner))
+	after := NewService("access-secret", "refresh-secret", time.Hour, time.Hour,
+		WithSigner(newSigner), WithPreviousSigningKeys(oldSigner.Public()))
+
+	oldPair, err := before.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 1)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := after.Validate(oldPair.AccessToken); err != nil {
+		t.Errorf("token signed by the previous key rejected: %v", err)
+	}
+	newPair, err := after.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 1)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := after.Validate(newPair.AccessToken); err != nil {
+		t.Errorf("token signed by the current key rejected: %v", err)
+	}
+
+	// A key that was never configured doesn't verify, whatever its kid.
+	stranger := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithSigner(newRSASigner(t)))
+	strangerPair, err := stranger.Generate(42, "uid-42", "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 1)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := after.Validate(strangerPair.AccessToken); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Validate(unknown kid) = %v, want ErrSignatureInvalid", err)
+	}
+
+	jwks, ok := after.JWKS()
+	if !ok || len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() = %+v, %v; want current and previous key", jwks, ok)
+	}
+	if jwks.Keys[0].Alg != "ES256" || jwks.Keys[1].Alg != "RS256" || jwks.Keys[1].Kty != "RSA" {
+		t.Errorf("JWKS keys = %+v, want the current ES256 key then the previous RS256 key", jwks.Keys)
+	}
+	raw, _ := after.JWKSJSON()
+	var served JWKS
+	if err := json.Unmarshal(raw, &served); err != nil || len(served.Keys) != 2 || served.Keys[1].Kid != jwks.Keys[1].Kid {
+		t.Errorf("JWKSJSON() = %s, %v; want the same keys as JWKS()", raw, err)
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	signer := newRSASigner(t)
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "previous.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	key, err := LoadPublicKey(path)
+	if err != nil {
+		t.Fatalf("LoadPublicKey: %v", err)
+	}
+	if keyID(key) != keyID(signer.Public()) {
+		t.Error("loaded key differs from the one written")
+	}
+	if _, err := LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("LoadPublicKey(missing file) succeeded")
+	}
+}
+
+func TestJWKS_NotAvailableForHMAC(t *testing.T) {
+	if _, ok := newTestService(time.Hour).JWKS(); ok {
+		t.Error("JWKS() ok for an HMAC-only service")
+	}
+}
+
+func TestCheckSigningAlgorithm(t *testing.T) {
+	rsaSigner, ecSigner := newRSASigner(t), newECSigner(t)
+	tests := []struct {
+		name    string
+		alg     string
+		signer  Signer
+		wantErr bool
+	}{
+		{"unpinned HMAC", "", nil, false},
+		{"unpinned RSA", "", rsaSigner, false},
+		{"HS256 without key", "HS256", nil, false},
+		{"RS256 with RSA key", "RS256", rsaSigner, false},
+		{"ES256 with EC key", "ES256", ecSigner, false},
+		{"RS256 without key", "RS256", nil, true},
+		{"RS256 with EC key", "RS256", ecSigner, true},
+		{"HS256 with RSA key", "HS256", rsaSigner, true},
+		{"unknown", "none", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSigningAlgorithm(tt.alg, tt.signer)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckSigningAlgorithm(%q) = %v, wantErr %v", tt.alg, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// kmsFake answers like a KMS Sign call: it signs a digest and returns ECDSA
+// signatures DER encoded.
+type kmsFake struct{ key crypto.Signer }
+
+func (k kmsFake) SignDigest(_ context.Context, _ string, digest []byte) ([]byte, error) {
+	return k.key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+func (k kmsFake) PublicKey(context.Context, string) (crypto.PublicKey, error) {
+	return k.key.Public(), nil
+}
+
+func TestKMSSigner_ProducesJWSSignatures(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	signer, err := NewKMSSigner(context.Background(), kmsFake{key: key}, "alias/reservoir")
+	if err != nil {
+		t.Fatalf("NewKMSSigner: %v", err)
+	}
+
+	sig, err := signer.Sign([]byte("header.payload"))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(sig) != 64 {
+		t.Fatalf("signature length = %d, want 64 (r||s)", len(sig))
+	}
+	digest := sha256.Sum256([]byte("header.payload"))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+		t.Error("KMS signature does not verify")
+	}
+
+	if _, err := NewKMSSigner(context.Background(), nil, "alias/reservoir"); err == nil {
+		t.Error("NewKMSSigner accepted a nil client")
+	}
+}
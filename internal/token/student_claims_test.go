@@ -0,0 +1,46 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerate_StudentUsernameClaim(t *testing.T) {
+	tests := []struct {
+		name         string
+		omit         bool
+		metaType     string
+		email        string
+		username     string
+		wantEmail    string
+		wantUsername string
+	}{
+		{"synthetic student, omitted", true, "Student", "jdoe12@student.student", "jdoe12", "", "jdoe12"},
+		{"synthetic student, kept", false, "Student", "jdoe12@student.student", "jdoe12", "jdoe12@student.student", "jdoe12"},
+		// The claim is students.username, not the email's local part.
+		{"username differs from email", false, "Student", "old-name@student.student", "jdoe12", "old-name@student.student", "jdoe12"},
+		{"synthetic student without username keeps email", true, "Student", "jdoe12@student.student", "", "jdoe12@student.student", ""},
+		{"student with real email", true, "Student", "jdoe@school.org", "jdoe", "jdoe@school.org", "jdoe"},
+		{"teacher unaffected", true, "Teacher", "t@school.org", "tdoe", "t@school.org", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, WithStudentEmailOmitted(tt.omit))
+			pair, err := svc.Generate(1, "uid-1", tt.email, "J Doe", tt.metaType, 3, 0, WithUsername(tt.username))
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			claims, err := svc.Validate(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.Email != tt.wantEmail {
+				t.Errorf("email = %q, want %q", claims.Email, tt.wantEmail)
+			}
+			if claims.Username != tt.wantUsername {
+				t.Errorf("username = %q, want %q", claims.Username, tt.wantUsername)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGenerate_SubjectFormats(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		boddleUID string
+		wantSub   string
+	}{
+		{"default is user ID", nil, "uid-42", "42"},
+		{"user ID", []Option{WithSubjectFormat(SubjectUserID)}, "uid-42", "42"},
+		{"boddle_uid", []Option{WithSubjectFormat(SubjectBoddleUID)}, "uid-42", "uid-42"},
+		{"boddle_uid missing falls back to user ID", []Option{WithSubjectFormat(SubjectBoddleUID)}, "", "42"},
+		{"boddle_uid with minimal claims", []Option{WithSubjectFormat(SubjectBoddleUID), WithMinimalClaims(true)}, "uid-42", "uid-42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour, tt.opts...)
+			pair, err := s.Generate(42, tt.boddleUID, "teacher@school.org", "Ms. Frizzle", "Teacher", 7, 0)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			claims, err := s.Validate(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.Subject != tt.wantSub || claims.UserID != 42 {
+				t.Errorf("sub = %q, user_id = %d; want %q, 42", claims.Subject, claims.UserID, tt.wantSub)
+			}
+
+			// Refresh tokens are internal and always keyed by user ID.
+			refresh, err := s.ValidateRefreshToken(pair.RefreshToken)
+			if err != nil {
+				t.Fatalf("ValidateRefreshToken: %v", err)
+			}
+			if refresh.Subject != "42" {
+				t.Errorf("refresh sub = %q, want 42", refresh.Subject)
+			}
+		})
+	}
+}
+
+func TestValidate_UserIDFromSubject(t *testing.T) {
+	s := NewService("access-secret", "refresh-secret", time.Hour, time.Hour)
+	sign := func(claims jwt.MapClaims) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("access-secret"))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tok
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	// No user_id claim: the numeric sub stands in for it.
+	claims, err := s.Validate(sign(jwt.MapClaims{"sub": "42", "meta_type": "Teacher", "exp": exp}))
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if claims.UserID != 42 {
+		t.Errorf("UserID = %d, want 42 from sub", claims.UserID)
+	}
+
+	// A boddle_uid sub can't be turned into a user ID.
+	if _, err := s.Validate(sign(jwt.MapClaims{"sub": "uid-42", "exp": exp})); err == nil {
+		t.Error("token with neither user_id nor a numeric sub was accepted")
+	}
+}
+
+func TestParseSubjectFormat(t *testing.T) {
+	for _, in := range []string{"user_id", "boddle_uid"} {
+		if f, err := ParseSubjectFormat(in); err != nil || string(f) != in {
+			t.Errorf("ParseSubjectFormat(%q) = %q, %v", in, f, err)
+		}
+	}
+	if _, err := ParseSubjectFormat("email"); err == nil {
+		t.Error("ParseSubjectFormat accepted an unknown format")
+	}
+}
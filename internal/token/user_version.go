@@ -0,0 +1,80 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const userTokenVersionKeyPrefix = "token:min_tver:"
+
+// UserTokenVersions publishes a user's token_version to Redis when all their
+// sessions are revoked, so access tokens carrying an older tver stop
+// validating at once instead of living out their TTL. (Refresh tokens are
+// already checked against users.token_version.) An entry only needs to
+// outlive the access tokens it revokes, so it expires after the access TTL.
+// A nil *UserTokenVersions revokes nothing.
+type UserTokenVersions struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewUserTokenVersions creates a version store; accessTTL is the token
+// service's access-token lifetime.
+func NewUserTokenVersions(client *redis.Client, accessTTL time.Duration) *UserTokenVersions {
+	return &UserTokenVersions{client: client, ttl: accessTTL}
+}
+
+// Set rejects userID's access tokens minted with a tver below version.
+func (v *UserTokenVersions) Set(ctx context.Context, userID, version int) error {
+	if err := v.client.Set(ctx, userTokenVersionKey(userID), version, v.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to publish token version: %w", err)
+	}
+	return nil
+}
+
+// Stale reports whether claims' tver predates its user's published version.
+func (v *UserTokenVersions) Stale(ctx context.Context, claims *Claims) (bool, error) {
+	stale, err := v.AreStale(ctx, []*Claims{claims})
+	if err != nil {
+		return false, err
+	}
+	return stale[0], nil
+}
+
+// AreStale is Stale for many tokens in one round-trip.
+func (v *UserTokenVersions) AreStale(ctx context.Context, claims []*Claims) ([]bool, error) {
+	stale := make([]bool, len(claims))
+	if v == nil || len(claims) == 0 {
+		return stale, nil
+	}
+
+	keys := make([]string, len(claims))
+	for i, c := range claims {
+		keys[i] = userTokenVersionKey(c.UserID)
+	}
+	vals, err := v.client.MGet(ctx, keys...).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to check token versions: %w", err)
+	}
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			continue // no published version
+		}
+		floor, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("malformed token version %q for user %d", s, claims[i].UserID)
+		}
+		stale[i] = claims[i].TokenVersion < floor
+	}
+	return stale, nil
+}
+
+func userTokenVersionKey(userID int) string {
+	return userTokenVersionKeyPrefix + strconv.Itoa(userID)
+}
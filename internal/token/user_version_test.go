@@ -0,0 +1,41 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestUserTokenVersions_Stale(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	v := NewUserTokenVersions(client, time.Hour)
+	ctx := context.Background()
+
+	old, current, other := &Claims{UserID: 1, TokenVersion: 2}, &Claims{UserID: 1, TokenVersion: 3}, &Claims{UserID: 2}
+	if err := v.Set(ctx, 1, 3); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	stale, err := v.AreStale(ctx, []*Claims{old, current, other})
+	if err != nil {
+		t.Fatalf("AreStale: %v", err)
+	}
+	if !stale[0] || stale[1] || stale[2] {
+		t.Errorf("AreStale = %v, want [true false false]", stale)
+	}
+
+	// Once every access token it could revoke has expired, the entry goes.
+	mr.FastForward(time.Hour + time.Second)
+	if s, err := v.Stale(ctx, old); err != nil || s {
+		t.Errorf("Stale after TTL = %v, %v; want false", s, err)
+	}
+
+	var none *UserTokenVersions
+	if s, err := none.Stale(ctx, old); err != nil || s {
+		t.Errorf("nil store Stale = %v, %v; want false", s, err)
+	}
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// FindByIDs finds the users with the given IDs in one query, ordered by ID.
+// IDs with no user are simply absent from the result; an empty ids returns
+// an empty result without querying.
+func (r *Repository) FindByIDs(ctx context.Context, ids []int) ([]User, error) {
+	users := []User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, password_changed_at, COALESCE(locale, '') AS locale, COALESCE(status, 'active') AS status, created_at, updated_at
+			  FROM users
+			  WHERE id = ANY($1)
+			  ORDER BY id`
+
+	if err := r.reader.SelectContext(ctx, &users, query, idArray(ids)); err != nil {
+		return nil, fmt.Errorf("failed to find users by IDs: %w", err)
+	}
+	return users, nil
+}
+
+// FindTeachersByIDs is FindByIDs for teachers, to hydrate the meta of many
+// users at once.
+func (r *Repository) FindTeachersByIDs(ctx context.Context, ids []int) ([]Teacher, error) {
+	teachers := []Teacher{}
+	if len(ids) == 0 {
+		return teachers, nil
+	}
+	query := `SELECT id, first_name, last_name, google_uid, clever_uid, is_verified, created_at, updated_at
+			  FROM teachers
+			  WHERE id = ANY($1)
+			  ORDER BY id`
+
+	if err := r.reader.SelectContext(ctx, &teachers, query, idArray(ids)); err != nil {
+		return nil, fmt.Errorf("failed to find teachers by IDs: %w", err)
+	}
+	return teachers, nil
+}
+
+// FindStudentsByIDs is FindByIDs for students, to hydrate the meta of many
+// users at once.
+func (r *Repository) FindStudentsByIDs(ctx context.Context, ids []int) ([]Student, error) {
+	students := []Student{}
+	if len(ids) == 0 {
+		return students, nil
+	}
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+			  FROM students
+			  WHERE id = ANY($1)
+			  ORDER BY id`
+
+	if err := r.reader.SelectContext(ctx, &students, query, idArray(ids)); err != nil {
+		return nil, fmt.Errorf("failed to find students by IDs: %w", err)
+	}
+	return students, nil
+}
+
+// idArray wraps ids as a Postgres integer array for = ANY($1).
+func idArray(ids []int) interface{} {
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	return pq.Array(ids64)
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFindByIDs_OneQueryMissingIDsAbsent(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	columns := []string{"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+		"last_logged_on", "token_version", "password_changed_at", "locale", "status", "created_at", "updated_at"}
+	// 2 has no user, so only 1 and 3 come back.
+	mock.ExpectQuery(`FROM users\s+WHERE id = ANY\(\$1\)`).WithArgs("{1,2,3}").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "A", "a@school.edu", "", "uid-1", "Teacher", 10, nil, 0, nil, "", StatusActive, now, now).
+			AddRow(3, "C", "c@school.edu", "", "uid-3", "Student", 30, nil, 0, nil, "", StatusActive, now, now))
+
+	users, err := repo.FindByIDs(context.Background(), []int{1, 2, 3})
+	if err != nil {
+		t.Fatalf("FindByIDs: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != 1 || users[1].ID != 3 {
+		t.Errorf("users = %+v, want 1 and 3", users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFindMetaByIDs_OneQueryEach(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM teachers\s+WHERE id = ANY\(\$1\)`).WithArgs("{10,11}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "google_uid", "clever_uid", "is_verified", "created_at", "updated_at"}).
+			AddRow(10, "Valerie", "Frizzle", nil, nil, true, now, now))
+	mock.ExpectQuery(`FROM students\s+WHERE id = ANY\(\$1\)`).WithArgs("{30,31}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_character_name", "google_uid", "clever_uid", "icloud_uid", "parent_id", "created_at", "updated_at"}).
+			AddRow(30, nil, nil, nil, nil, nil, now, now).
+			AddRow(31, nil, nil, nil, nil, nil, now, now))
+
+	teachers, err := repo.FindTeachersByIDs(context.Background(), []int{10, 11})
+	if err != nil {
+		t.Fatalf("FindTeachersByIDs: %v", err)
+	}
+	if len(teachers) != 1 || teachers[0].ID != 10 {
+		t.Errorf("teachers = %+v, want only 10", teachers)
+	}
+	students, err := repo.FindStudentsByIDs(context.Background(), []int{30, 31})
+	if err != nil {
+		t.Fatalf("FindStudentsByIDs: %v", err)
+	}
+	if len(students) != 2 {
+		t.Errorf("students = %d, want 2", len(students))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFindByIDs_EmptyDoesNotQuery(t *testing.T) {
+	// No expectations: any query fails the test.
+	repo, mock := newMockRepository(t)
+	ctx := context.Background()
+
+	users, err := repo.FindByIDs(ctx, nil)
+	if err != nil || users == nil || len(users) != 0 {
+		t.Errorf("FindByIDs(nil) = %v, %v; want empty, nil", users, err)
+	}
+	if teachers, err := repo.FindTeachersByIDs(ctx, []int{}); err != nil || len(teachers) != 0 {
+		t.Errorf("FindTeachersByIDs = %v, %v; want empty, nil", teachers, err)
+	}
+	if students, err := repo.FindStudentsByIDs(ctx, []int{}); err != nil || len(students) != 0 {
+		t.Errorf("FindStudentsByIDs = %v, %v; want empty, nil", students, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
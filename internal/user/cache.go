@@ -0,0 +1,204 @@
+package user
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// CacheInvalidationPattern is the Redis pub/sub pattern every instance
+// listens on. Rails evicts a user after a profile edit with
+// PUBLISH user.invalidate:<id> "" (the payload is ignored).
+const (
+	cacheInvalidationPrefix  = "user.invalidate:"
+	CacheInvalidationPattern = cacheInvalidationPrefix + "*"
+)
+
+// Reconnect backoff for the invalidation subscriber.
+const (
+	minResubscribeBackoff = time.Second
+	maxResubscribeBackoff = 30 * time.Second
+)
+
+// DefaultCacheMaxEntries is how many users a Cache holds unless
+// SetMaxEntries says otherwise.
+const DefaultCacheMaxEntries = 10000
+
+// Cache is an in-process, TTL-bounded cache of UserWithMeta keyed by user ID.
+// Rails remains the system of record, so entries are evicted whenever an
+// invalidation is published (see RunInvalidationSubscriber) and otherwise
+// expire after ttl. Once full, the least recently used entry makes room for
+// a new one. Cached values are shared between callers and must not be
+// modified. A nil *Cache is valid and caches nothing.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	client     *redis.Client // publishes invalidations; nil for a local-only cache
+
+	mu      sync.Mutex
+	entries map[int]*list.Element // values are *cacheEntry
+	recency *list.List            // front is the most recently used
+
+	backoff time.Duration // first resubscribe delay; overridden in tests
+}
+
+type cacheEntry struct {
+	user      *UserWithMeta
+	expiresAt time.Time
+}
+
+// NewCache creates a user cache whose entries live for ttl, holding at most
+// DefaultCacheMaxEntries users. client is used to publish invalidations to
+// the other instances and may be nil.
+func NewCache(ttl time.Duration, client *redis.Client) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: DefaultCacheMaxEntries,
+		client:     client,
+		entries:    make(map[int]*list.Element),
+		recency:    list.New(),
+		backoff:    minResubscribeBackoff,
+	}
+}
+
+// SetMaxEntries caps how many users the cache holds; n <= 0 keeps the
+// default. Call before the cache is used.
+func (c *Cache) SetMaxEntries(n int) {
+	if n > 0 {
+		c.maxEntries = n
+	}
+}
+
+// Get returns the cached user, if present and not expired.
+func (c *Cache) Get(userID int) (*UserWithMeta, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.recency.MoveToFront(elem)
+	return entry.user, true
+}
+
+// Set caches u under its user ID, evicting the least recently used entry if
+// the cache is full.
+func (c *Cache) Set(u *UserWithMeta) {
+	if c == nil || u == nil {
+		return
+	}
+	entry := &cacheEntry{user: u, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[u.User.ID]; ok {
+		elem.Value = entry
+		c.recency.MoveToFront(elem)
+		return
+	}
+	c.entries[u.User.ID] = c.recency.PushFront(entry)
+	for c.recency.Len() > c.maxEntries {
+		c.remove(c.recency.Back())
+	}
+}
+
+// remove drops elem. c.mu must be held.
+func (c *Cache) remove(elem *list.Element) {
+	c.recency.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).user.User.ID)
+}
+
+// Invalidate evicts userID here and publishes the invalidation so every other
+// instance evicts it too. The local eviction happens even if publishing fails.
+func (c *Cache) Invalidate(ctx context.Context, userID int) error {
+	if c == nil {
+		return nil
+	}
+	c.evict(userID)
+	if c.client == nil {
+		return nil
+	}
+	if err := c.client.Publish(ctx, cacheInvalidationPrefix+strconv.Itoa(userID), "").Err(); err != nil {
+		return fmt.Errorf("failed to publish user cache invalidation: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) evict(userID int) {
+	c.mu.Lock()
+	if elem, ok := c.entries[userID]; ok {
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+}
+
+// purge drops every entry.
+func (c *Cache) purge() {
+	c.mu.Lock()
+	c.entries = make(map[int]*list.Element)
+	c.recency.Init()
+	c.mu.Unlock()
+}
+
+// RunInvalidationSubscriber evicts users as invalidations arrive on
+// CacheInvalidationPattern, until ctx is cancelled. If the subscription drops
+// it purges the whole cache (invalidations may have been missed while
+// disconnected) and resubscribes with backoff. Meant to run under a
+// background.Group.
+func (c *Cache) RunInvalidationSubscriber(ctx context.Context, logger *zap.Logger) {
+	if c == nil || c.client == nil {
+		return
+	}
+
+	sub := c.client.PSubscribe(ctx, CacheInvalidationPattern)
+	// ReceiveMessage blocks in a socket read that ignores cancellation;
+	// closing the subscription is what unblocks it on shutdown.
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	defer stop()
+	defer sub.Close()
+
+	backoff := c.backoff
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.purge()
+			logger.Warn("user cache invalidation subscription dropped; cache purged, resubscribing",
+				zap.Duration("backoff", backoff),
+				zap.Error(err),
+			)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxResubscribeBackoff)
+			// The next ReceiveMessage reconnects and resubscribes.
+			continue
+		}
+		backoff = c.backoff
+
+		userID, err := strconv.Atoi(strings.TrimPrefix(msg.Channel, cacheInvalidationPrefix))
+		if err != nil {
+			logger.Warn("ignoring malformed user cache invalidation", zap.String("channel", msg.Channel))
+			continue
+		}
+		c.evict(userID)
+	}
+}
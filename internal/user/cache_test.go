@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// startInvalidationSubscriber runs the subscriber against mr and waits until
+// its pattern subscription is registered.
+func startInvalidationSubscriber(t *testing.T, mr *miniredis.Miniredis, cache *Cache) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.RunInvalidationSubscriber(ctx, zap.NewNop())
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitFor(t, "subscription", func() bool { return mr.PubSubNumPat() > 0 })
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func cached(c *Cache, userID int) bool {
+	_, ok := c.Get(userID)
+	return ok
+}
+
+func TestCache_PublishedInvalidationEvictsEntry(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	cache := NewCache(time.Hour, client)
+	cache.Set(&UserWithMeta{User: User{ID: 42}})
+	cache.Set(&UserWithMeta{User: User{ID: 43}})
+	startInvalidationSubscriber(t, mr, cache)
+
+	// What Rails does after a profile edit.
+	mr.Publish("user.invalidate:42", "")
+
+	waitFor(t, "eviction of user 42", func() bool { return !cached(cache, 42) })
+	if !cached(cache, 43) {
+		t.Error("user 43 was evicted by an invalidation for user 42")
+	}
+}
+
+func TestCache_ResubscribesAfterDrop(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	cache := NewCache(time.Hour, client)
+	cache.backoff = 10 * time.Millisecond
+	startInvalidationSubscriber(t, mr, cache)
+
+	cache.Set(&UserWithMeta{User: User{ID: 1}})
+	mr.Close()
+	// Invalidations published while disconnected are lost, so the whole
+	// cache is dropped rather than trusted.
+	waitFor(t, "purge on disconnect", func() bool { return !cached(cache, 1) })
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("restart miniredis: %v", err)
+	}
+	waitFor(t, "resubscription", func() bool { return mr.PubSubNumPat() > 0 })
+
+	cache.Set(&UserWithMeta{User: User{ID: 7}})
+	mr.Publish("user.invalidate:7", "")
+	waitFor(t, "eviction after resubscribe", func() bool { return !cached(cache, 7) })
+}
+
+func TestCache_InvalidateEvictsLocallyAndPublishes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	// Two instances sharing Redis; only the peer runs a subscriber here so
+	// the publish is what evicts it.
+	local := NewCache(time.Hour, client)
+	peer := NewCache(time.Hour, client)
+	startInvalidationSubscriber(t, mr, peer)
+	local.Set(&UserWithMeta{User: User{ID: 5}})
+	peer.Set(&UserWithMeta{User: User{ID: 5}})
+
+	if err := local.Invalidate(context.Background(), 5); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	if cached(local, 5) {
+		t.Error("entry still cached locally after Invalidate")
+	}
+	waitFor(t, "peer eviction", func() bool { return !cached(peer, 5) })
+}
+
+func TestCache_ExpiresAndNilIsNoop(t *testing.T) {
+	c := NewCache(time.Millisecond, nil)
+	c.Set(&UserWithMeta{User: User{ID: 1}})
+	time.Sleep(5 * time.Millisecond)
+	if cached(c, 1) {
+		t.Error("entry served past its TTL")
+	}
+
+	var disabled *Cache
+	disabled.Set(&UserWithMeta{User: User{ID: 1}})
+	if cached(disabled, 1) {
+		t.Error("nil cache returned an entry")
+	}
+	if err := disabled.Invalidate(context.Background(), 1); err != nil {
+		t.Errorf("nil cache Invalidate: %v", err)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsedWhenFull(t *testing.T) {
+	c := NewCache(time.Hour, nil)
+	c.SetMaxEntries(2)
+	c.Set(&UserWithMeta{User: User{ID: 1}})
+	c.Set(&UserWithMeta{User: User{ID: 2}})
+
+	// Reading 1 makes 2 the least recently used, so 3 displaces it.
+	if !cached(c, 1) {
+		t.Fatal("entry 1 missing before the cache filled")
+	}
+	c.Set(&UserWithMeta{User: User{ID: 3}})
+
+	if cached(c, 2) {
+		t.Error("least recently used entry survived a full cache")
+	}
+	if !cached(c, 1) || !cached(c, 3) {
+		t.Error("recently used entries were evicted")
+	}
+	if n := len(c.entries); n != 2 {
+		t.Errorf("cache holds %d entries, want at most 2", n)
+	}
+}
@@ -0,0 +1,137 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/boddle/reservoir/pkg/utctime"
+)
+
+// ErrEmailTaken is returned by CreateUser when another user already has the
+// email.
+var ErrEmailTaken = errors.New("email is already registered")
+
+// uniqueViolation is Postgres's SQLSTATE for a unique constraint failure.
+const uniqueViolation = "23505"
+
+// NewUser is an account for CreateUser to provision. FirstName and LastName
+// go on the teachers/parents row; students have no name columns, so theirs
+// is only in users.name.
+type NewUser struct {
+	MetaType       string // "Teacher", "Student" or "Parent"
+	Email          string
+	PasswordDigest string
+	FirstName      string
+	LastName       string
+}
+
+// CreateUser inserts the account's meta row and its users row in one
+// transaction, so a failure leaves neither behind. The users row gets a new
+// boddle_uid. Returns ErrEmailTaken if the email is already in use.
+func (r *Repository) CreateUser(ctx context.Context, nu NewUser) (*UserWithMeta, error) {
+	result := &UserWithMeta{}
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		return tx.createUser(ctx, nu, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// createUser is CreateUser's inserts, filling in result.
+func (r *Repository) createUser(ctx context.Context, nu NewUser, result *UserWithMeta) error {
+	now := time.Now()
+	ts := utctime.New(now)
+	var metaID int
+	var err error
+	switch nu.MetaType {
+	case "Teacher":
+		metaID, err = r.CreateTeacher(ctx, nu.FirstName, nu.LastName, now)
+		result.Meta = &Teacher{ID: metaID, FirstName: nu.FirstName, LastName: nu.LastName, CreatedAt: ts, UpdatedAt: ts}
+	case "Student":
+		metaID, err = r.CreateStudent(ctx, now)
+		result.Meta = &Student{ID: metaID, CreatedAt: ts, UpdatedAt: ts}
+	case "Parent":
+		metaID, err = r.CreateParent(ctx, nu.FirstName, nu.LastName, now)
+		result.Meta = &Parent{ID: metaID, FirstName: nu.FirstName, LastName: nu.LastName, CreatedAt: ts, UpdatedAt: ts}
+	default:
+		return fmt.Errorf("cannot create a user of meta type %q", nu.MetaType)
+	}
+	if err != nil {
+		return err
+	}
+
+	usr := User{
+		Name:           strings.TrimSpace(nu.FirstName + " " + nu.LastName),
+		Email:          nu.Email,
+		PasswordDigest: nu.PasswordDigest,
+		BoddleUID:      sql.NullString{String: uuid.NewString(), Valid: true},
+		MetaType:       nu.MetaType,
+		MetaID:         metaID,
+		Status:         StatusActive,
+		CreatedAt:      ts,
+		UpdatedAt:      ts,
+	}
+	query := `INSERT INTO users (name, email, password_digest, boddle_uid, meta_type, meta_id, status, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+			  RETURNING id`
+	err = r.db.GetContext(ctx, &usr.ID, query, usr.Name, usr.Email, usr.PasswordDigest, usr.BoddleUID, usr.MetaType, usr.MetaID, usr.Status, now)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		// boddle_uid is unique too, but a fresh UUID won't collide.
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	result.User = usr
+	return nil
+}
+
+// CreateTeacher inserts a teachers row and returns its ID. New teachers start
+// unverified. Call it inside WithTx alongside the users row.
+func (r *Repository) CreateTeacher(ctx context.Context, firstName, lastName string, now time.Time) (int, error) {
+	var id int
+	query := `INSERT INTO teachers (first_name, last_name, is_verified, created_at, updated_at)
+			  VALUES ($1, $2, false, $3, $3)
+			  RETURNING id`
+	if err := r.db.GetContext(ctx, &id, query, firstName, lastName, now); err != nil {
+		return 0, fmt.Errorf("failed to create teacher: %w", err)
+	}
+	return id, nil
+}
+
+// CreateStudent inserts a students row and returns its ID. Call it inside
+// WithTx alongside the users row.
+func (r *Repository) CreateStudent(ctx context.Context, now time.Time) (int, error) {
+	var id int
+	query := `INSERT INTO students (created_at, updated_at)
+			  VALUES ($1, $1)
+			  RETURNING id`
+	if err := r.db.GetContext(ctx, &id, query, now); err != nil {
+		return 0, fmt.Errorf("failed to create student: %w", err)
+	}
+	return id, nil
+}
+
+// CreateParent inserts a parents row and returns its ID. Call it inside
+// WithTx alongside the users row.
+func (r *Repository) CreateParent(ctx context.Context, firstName, lastName string, now time.Time) (int, error) {
+	var id int
+	query := `INSERT INTO parents (first_name, last_name, created_at, updated_at)
+			  VALUES ($1, $2, $3, $3)
+			  RETURNING id`
+	if err := r.db.GetContext(ctx, &id, query, firstName, lastName, now); err != nil {
+		return 0, fmt.Errorf("failed to create parent: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+func newMockRepository(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	return NewRepository(sqlxDB, sqlxDB), mock
+}
+
+func TestCreateUser_InsertsMetaAndUserInOneTransaction(t *testing.T) {
+	tests := []struct {
+		metaType  string
+		metaQuery string
+	}{
+		{"Teacher", `INSERT INTO teachers`},
+		{"Student", `INSERT INTO students`},
+		{"Parent", `INSERT INTO parents`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metaType, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(tt.metaQuery).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			mock.ExpectQuery(`INSERT INTO users`).
+				WithArgs("Ada Lovelace", "ada@school.edu", "digest", sqlmock.AnyArg(), tt.metaType, 7, StatusActive, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			mock.ExpectCommit()
+
+			got, err := repo.CreateUser(context.Background(), NewUser{
+				MetaType: tt.metaType, Email: "ada@school.edu", PasswordDigest: "digest",
+				FirstName: "Ada", LastName: "Lovelace",
+			})
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if got.User.ID != 42 || got.User.MetaID != 7 || got.User.MetaType != tt.metaType {
+				t.Errorf("user = %d %s/%d, want 42 %s/7", got.User.ID, got.User.MetaType, got.User.MetaID, tt.metaType)
+			}
+			if !got.User.BoddleUID.Valid || got.User.BoddleUID.String == "" {
+				t.Error("expected a boddle_uid")
+			}
+			if name := got.GetFullName(); name != "Ada Lovelace" {
+				t.Errorf("GetFullName = %q, want Ada Lovelace", name)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCreateUser_DuplicateEmailRollsBack(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO teachers`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO users`).WillReturnError(&pq.Error{Code: "23505", Constraint: "index_users_on_email"})
+	mock.ExpectRollback()
+
+	_, err := repo.CreateUser(context.Background(), NewUser{MetaType: "Teacher", Email: "ada@school.edu", PasswordDigest: "digest"})
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("err = %v, want ErrEmailTaken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateUser_MetaFailureRollsBack(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO parents`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if _, err := repo.CreateUser(context.Background(), NewUser{MetaType: "Parent", Email: "p@home.com", PasswordDigest: "digest"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateUser_RejectsUnknownMetaType(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	if _, err := repo.CreateUser(context.Background(), NewUser{MetaType: "Admin", Email: "a@boddle.com"}); err == nil {
+		t.Fatal("expected an error for an Admin account")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/boddle/reservoir/internal/database"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
@@ -46,6 +48,15 @@ var (
 		},
 		[]string{"operation"},
 	)
+
+	// loginFailures counts failed password logins by LoginFailure* reason.
+	loginFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reservoir_login_failures_total",
+			Help: "Failed password logins, by reason. A rise in unknown_user relative to wrong_password suggests enumeration or password spraying.",
+		},
+		[]string{"reason"},
+	)
 )
 
 // RecordAuthDBWriteError increments the auth-path DB write error counter
@@ -54,6 +65,12 @@ func RecordAuthDBWriteError(operation string) {
 	authDBWriteErrors.WithLabelValues(operation).Inc()
 }
 
+// RecordLoginFailure increments the failed-login counter for reason (one of
+// the LoginFailure* constants).
+func RecordLoginFailure(reason string) {
+	loginFailures.WithLabelValues(reason).Inc()
+}
+
 const (
 	queueCapacity = 10000
 	batchSize     = 500
@@ -96,6 +113,9 @@ type LastLoginWriter struct {
 	// (cap 1) so Shutdown never blocks.
 	stop chan context.Context
 	wg   sync.WaitGroup
+	// queryTimeout bounds each batch UPDATE; see SetQueryTimeout. Atomic
+	// because the flush goroutine is already running when it is set.
+	queryTimeout atomic.Int64
 }
 
 func NewLastLoginWriter(db *sqlx.DB, logger *zap.Logger) *LastLoginWriter {
@@ -114,6 +134,13 @@ func newLastLoginWriter(db sqlExecutor, logger *zap.Logger) *LastLoginWriter {
 	return w
 }
 
+// SetQueryTimeout bounds each batch UPDATE by timeout (DB_QUERY_TIMEOUT)
+// instead of flushTimeout. A shutdown flush keeps the shutdown deadline if
+// that comes sooner. 0 restores flushTimeout.
+func (w *LastLoginWriter) SetQueryTimeout(timeout time.Duration) {
+	w.queryTimeout.Store(int64(timeout))
+}
+
 // Enqueue submits a user ID for a deferred last_logged_on update.
 // Non-blocking: if the queue is full, the ID is dropped and a metric
 // is incremented. Safe to call from any goroutine.
@@ -147,6 +174,17 @@ func (w *LastLoginWriter) Shutdown(ctx context.Context) {
 	}
 }
 
+// Run adapts the writer to a background.Group: it blocks until ctx is
+// cancelled, then drains the queue via Shutdown. The final flush is bounded
+// by flushTimeout; the group's own shutdown deadline bounds the wait on Run.
+func (w *LastLoginWriter) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	w.Shutdown(drainCtx)
+}
+
 func (w *LastLoginWriter) run() {
 	defer w.wg.Done()
 
@@ -165,13 +203,18 @@ func (w *LastLoginWriter) run() {
 		}
 		pending = make(map[int]struct{}, batchSize)
 
-		ctx, cancel := context.WithTimeout(parent, flushTimeout)
+		timeout := time.Duration(w.queryTimeout.Load())
+		if timeout <= 0 {
+			timeout = flushTimeout
+		}
+		ctx, cancel := database.WithQueryTimeout(parent, timeout)
 		defer cancel()
 
 		_, err := w.db.ExecContext(ctx,
 			`UPDATE users SET last_logged_on = NOW() WHERE id = ANY($1)`,
 			pq.Array(ids),
 		)
+		err = database.QueryError(ctx, err)
 		if err != nil {
 			lastLoginBatchErrors.Inc()
 			RecordAuthDBWriteError("last_logged_on")
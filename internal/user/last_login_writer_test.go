@@ -147,6 +147,39 @@ func TestShutdown_DrainsPendingIDs(t *testing.T) {
 	}
 }
 
+func TestRun_DrainsOnContextCancel(t *testing.T) {
+	flushed := make(chan int, 1)
+	exec := &fakeExecutor{
+		onExec: func(ids []int64) { flushed <- len(ids) },
+	}
+	w := newLastLoginWriter(exec, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	w.Enqueue(1)
+	w.Enqueue(2)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not return after context cancel")
+	}
+	select {
+	case n := <-flushed:
+		if n != 2 {
+			t.Fatalf("expected 2 IDs in cancel drain, got %d", n)
+		}
+	default:
+		t.Fatalf("expected Run to flush pending IDs before returning")
+	}
+}
+
 func TestFlush_DeduplicatesIDs(t *testing.T) {
 	flushed := make(chan int, 1)
 	exec := &fakeExecutor{
@@ -194,6 +227,30 @@ func TestFlush_ErrorIncrementsCounters(t *testing.T) {
 	}
 }
 
+func TestFlush_BoundedByQueryTimeout(t *testing.T) {
+	exec := &fakeExecutor{latency: 5 * time.Second}
+	w := newLastLoginWriter(exec, zap.NewNop())
+	w.SetQueryTimeout(50 * time.Millisecond)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		w.Shutdown(ctx)
+	})
+
+	batchErrBefore := testutil.ToFloat64(lastLoginBatchErrors)
+	for id := 1; id <= batchSize; id++ {
+		w.Enqueue(id)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(lastLoginBatchErrors)-batchErrBefore < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch UPDATE was not cut off by the query timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestShutdown_RespectsContextDeadline(t *testing.T) {
 	block := make(chan struct{})
 	exec := &fakeExecutor{
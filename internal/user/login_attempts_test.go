@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var attemptColumns = []string{"id", "email", "ip_address", "success", "attempted_at"}
+
+func TestFindLoginAttemptsByEmail_Pages(t *testing.T) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	count := `SELECT COUNT\(\*\) FROM login_attempts WHERE email = \$1 AND attempted_at >= \$2 AND attempted_at < \$3`
+	page := `FROM login_attempts\s+WHERE email = \$1 AND attempted_at >= \$2 AND attempted_at < \$3\s+ORDER BY attempted_at DESC, id DESC\s+LIMIT \$4 OFFSET \$5`
+
+	tests := []struct {
+		name          string
+		limit, offset int
+		total         int
+		rows          int // rows on the page; -1 when the page isn't queried
+	}{
+		{"first page", 2, 0, 5, 2},
+		{"last partial page", 2, 4, 5, 1},
+		{"offset at the end", 2, 5, 5, -1},
+		{"offset past the end", 2, 9, 5, -1},
+		{"no attempts", 2, 0, 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectQuery(count).WithArgs("ada@school.edu", from, to).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.total))
+			if tt.rows >= 0 {
+				rows := sqlmock.NewRows(attemptColumns)
+				for i := 0; i < tt.rows; i++ {
+					rows.AddRow(tt.total-tt.offset-i, "ada@school.edu", "203.0.113.7", false, to)
+				}
+				mock.ExpectQuery(page).WithArgs("ada@school.edu", from, to, tt.limit, tt.offset).WillReturnRows(rows)
+			}
+
+			attempts, total, err := repo.FindLoginAttemptsByEmail(context.Background(), "ada@school.edu", from, to, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("FindLoginAttemptsByEmail: %v", err)
+			}
+			if total != tt.total {
+				t.Errorf("total = %d, want %d", total, tt.total)
+			}
+			if attempts == nil {
+				t.Error("attempts = nil, want an empty slice at worst")
+			}
+			if want := max(tt.rows, 0); len(attempts) != want {
+				t.Errorf("got %d attempts, want %d", len(attempts), want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestGetRecentLoginAttempts_ScopedToIP(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	since := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_attempts WHERE email = \$1 AND ip_address = \$2 AND attempted_at >= \$3`).
+		WithArgs("ada@school.edu", "203.0.113.7", since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`WHERE email = \$1 AND ip_address = \$2 AND attempted_at >= \$3\s+ORDER BY attempted_at DESC, id DESC\s+LIMIT \$4 OFFSET \$5`).
+		WithArgs("ada@school.edu", "203.0.113.7", since, 2, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumns).AddRow(1, "ada@school.edu", "203.0.113.7", true, since))
+
+	attempts, total, err := repo.GetRecentLoginAttempts(context.Background(), "ada@school.edu", "203.0.113.7", since, 2, 2)
+	if err != nil {
+		t.Fatalf("GetRecentLoginAttempts: %v", err)
+	}
+	if total != 3 || len(attempts) != 1 || attempts[0].ID != 1 {
+		t.Errorf("got %d of %d (%+v), want the 1 remaining of 3", len(attempts), total, attempts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,22 +2,54 @@ package user
 
 import (
 	"database/sql"
-	"time"
+
+	"github.com/boddle/reservoir/pkg/utctime"
 )
 
 // User represents the users table (polymorphic base)
 type User struct {
-	ID             int            `db:"id" json:"id"`
-	Name           string         `db:"name" json:"name"`
-	Email          string         `db:"email" json:"email"`
-	PasswordDigest string         `db:"password_digest" json:"-"`
-	BoddleUID      sql.NullString `db:"boddle_uid" json:"boddle_uid,omitempty"`
-	MetaType       string         `db:"meta_type" json:"meta_type"`
-	MetaID         int            `db:"meta_id" json:"meta_id"`
-	LastLoggedOn   sql.NullTime   `db:"last_logged_on" json:"last_logged_on,omitempty"`
-	TokenVersion   int            `db:"token_version" json:"-"`
-	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt      time.Time      `db:"updated_at" json:"updated_at"`
+	ID             int              `db:"id" json:"id"`
+	Name           string           `db:"name" json:"name"`
+	Email          string           `db:"email" json:"email"`
+	PasswordDigest string           `db:"password_digest" json:"-"`
+	BoddleUID      sql.NullString   `db:"boddle_uid" json:"boddle_uid,omitempty"`
+	MetaType       string           `db:"meta_type" json:"meta_type"`
+	MetaID         int              `db:"meta_id" json:"meta_id"`
+	LastLoggedOn   utctime.NullTime `db:"last_logged_on" json:"last_logged_on"`
+	TokenVersion   int              `db:"token_version" json:"-"`
+	// PasswordChangedAt is set by Rails on every password change; NULL if
+	// the password never changed since the column was added.
+	PasswordChangedAt sql.NullTime `db:"password_changed_at" json:"-"`
+	Locale            string       `db:"locale" json:"locale,omitempty"`
+	Status            string       `db:"status" json:"status"`
+	CreatedAt         utctime.Time `db:"created_at" json:"created_at"`
+	UpdatedAt         utctime.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Account statuses stored in users.status. A NULL column (rows Rails created)
+// reads as StatusActive.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusDeleted   = "deleted"
+)
+
+// ValidStatus reports whether status is one of the account statuses.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusSuspended, StatusDeleted:
+		return true
+	}
+	return false
+}
+
+// KnownMetaType reports whether metaType is one FindWithMeta loads meta for.
+func KnownMetaType(metaType string) bool {
+	switch metaType {
+	case "Teacher", "Student", "Parent":
+		return true
+	}
+	return false
 }
 
 // Teacher represents the teachers table
@@ -28,22 +60,23 @@ type Teacher struct {
 	GoogleUID  sql.NullString `db:"google_uid" json:"google_uid,omitempty"`
 	CleverUID  sql.NullString `db:"clever_uid" json:"clever_uid,omitempty"`
 	IsVerified bool           `db:"is_verified" json:"is_verified"`
-	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt  time.Time      `db:"updated_at" json:"updated_at"`
+	CreatedAt  utctime.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt  utctime.Time   `db:"updated_at" json:"updated_at"`
 }
 
 // Student represents the students table
-// Note: students don't have first_name/last_name/username columns.
+// Note: students don't have first_name/last_name columns.
 // The display name comes from users.name via the polymorphic association.
 type Student struct {
 	ID                int            `db:"id" json:"id"`
+	Username          sql.NullString `db:"username" json:"username,omitempty"`
 	GameCharacterName sql.NullString `db:"game_character_name" json:"game_character_name,omitempty"`
 	GoogleUID         sql.NullString `db:"google_uid" json:"google_uid,omitempty"`
 	CleverUID         sql.NullString `db:"clever_uid" json:"clever_uid,omitempty"`
 	ICloudUID         sql.NullString `db:"icloud_uid" json:"icloud_uid,omitempty"`
 	ParentID          sql.NullInt64  `db:"parent_id" json:"parent_id,omitempty"`
-	CreatedAt         time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt         time.Time      `db:"updated_at" json:"updated_at"`
+	CreatedAt         utctime.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt         utctime.Time   `db:"updated_at" json:"updated_at"`
 }
 
 // Parent represents the parents table
@@ -52,32 +85,42 @@ type Parent struct {
 	FirstName string         `db:"first_name" json:"first_name"`
 	LastName  string         `db:"last_name" json:"last_name"`
 	ICloudUID sql.NullString `db:"icloud_uid" json:"icloud_uid,omitempty"`
-	CreatedAt time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt time.Time      `db:"updated_at" json:"updated_at"`
+	CreatedAt utctime.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt utctime.Time   `db:"updated_at" json:"updated_at"`
 }
 
 // LoginAttempt represents the login_attempts table for rate limiting
 type LoginAttempt struct {
-	ID          int       `db:"id" json:"id"`
-	Email       string    `db:"email" json:"email"`
-	IPAddress   string    `db:"ip_address" json:"ip_address"`
-	Success     bool      `db:"success" json:"success"`
-	AttemptedAt time.Time `db:"attempted_at" json:"attempted_at"`
+	ID          int          `db:"id" json:"id"`
+	Email       string       `db:"email" json:"email"`
+	IPAddress   string       `db:"ip_address" json:"ip_address"`
+	Success     bool         `db:"success" json:"success"`
+	AttemptedAt utctime.Time `db:"attempted_at" json:"attempted_at"`
 }
 
+// Reasons a password login failed, stored in login_attempts.reason and used
+// as the reservoir_login_failures_total label. Internal only: the client gets
+// the same error for all of them so it can't probe which emails exist.
+const (
+	LoginFailureUnknownUser   = "unknown_user"
+	LoginFailureWrongPassword = "wrong_password"
+	LoginFailureAccountStatus = "account_status"
+	LoginFailureNoPassword    = "no_password" // only with PASSWORD_LOGIN_UNAVAILABLE_ERROR
+)
+
 // LoginToken represents the login_tokens table for magic links
 type LoginToken struct {
-	ID        int       `db:"id" json:"id"`
-	UserID    int       `db:"user_id" json:"user_id"`
-	Secret    string    `db:"secret" json:"secret"`
-	Permanent bool      `db:"permanent" json:"permanent"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	ID        int          `db:"id" json:"id"`
+	UserID    int          `db:"user_id" json:"user_id"`
+	Secret    string       `db:"secret" json:"secret"`
+	Permanent bool         `db:"permanent" json:"permanent"`
+	CreatedAt utctime.Time `db:"created_at" json:"created_at"`
 }
 
 // UserWithMeta combines User with their meta type data (Teacher/Student/Parent)
 type UserWithMeta struct {
-	User   User
-	Meta   interface{} // Can be Teacher, Student, or Parent
+	User User
+	Meta interface{} // Can be Teacher, Student, or Parent
 }
 
 // GetFullName returns the full name based on meta type.
@@ -95,3 +138,38 @@ func (u *UserWithMeta) GetFullName() string {
 		return u.User.Name
 	}
 }
+
+// StudentUsername returns students.username when meta is a *Student with
+// one set, and "" for any other meta record.
+func StudentUsername(meta interface{}) string {
+	if s, ok := meta.(*Student); ok && s.Username.Valid {
+		return s.Username.String
+	}
+	return ""
+}
+
+// LinkedProviders lists the SSO providers linked to the account, derived from
+// the non-null UID columns on the meta record ("google", "clever", "icloud",
+// in that order). Returns an empty, non-nil slice when none are linked so it
+// serializes as [] rather than null.
+func (u *UserWithMeta) LinkedProviders() []string {
+	providers := []string{}
+	add := func(name string, uid sql.NullString) {
+		if uid.Valid && uid.String != "" {
+			providers = append(providers, name)
+		}
+	}
+
+	switch meta := u.Meta.(type) {
+	case *Teacher:
+		add("google", meta.GoogleUID)
+		add("clever", meta.CleverUID)
+	case *Student:
+		add("google", meta.GoogleUID)
+		add("clever", meta.CleverUID)
+		add("icloud", meta.ICloudUID)
+	case *Parent:
+		add("icloud", meta.ICloudUID)
+	}
+	return providers
+}
@@ -0,0 +1,133 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLinkedProviders(t *testing.T) {
+	linked := func(uid string) sql.NullString { return sql.NullString{String: uid, Valid: true} }
+
+	tests := []struct {
+		name string
+		meta interface{}
+		want []string
+	}{
+		{
+			name: "teacher with Google linked",
+			meta: &Teacher{GoogleUID: linked("g-1")},
+			want: []string{"google"},
+		},
+		{
+			name: "student with Google and iCloud linked",
+			meta: &Student{GoogleUID: linked("g-2"), ICloudUID: linked("apple-2")},
+			want: []string{"google", "icloud"},
+		},
+		{
+			name: "parent with iCloud linked",
+			meta: &Parent{ICloudUID: linked("apple-3")},
+			want: []string{"icloud"},
+		},
+		{
+			name: "empty UID string is not a link",
+			meta: &Teacher{CleverUID: sql.NullString{String: "", Valid: true}},
+			want: []string{},
+		},
+		{
+			name: "no meta record",
+			meta: nil,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UserWithMeta{Meta: tt.meta}
+			got := u.LinkedProviders()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LinkedProviders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLinkedProviders_SerializesAsEmptyArray guards the settings UI contract:
+// an account with nothing linked renders [] rather than null.
+func TestLinkedProviders_SerializesAsEmptyArray(t *testing.T) {
+	u := &UserWithMeta{Meta: &Teacher{}}
+	b, err := json.Marshal(u.LinkedProviders())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(b) != "[]" {
+		t.Errorf("marshaled = %s, want []", b)
+	}
+}
+
+func TestStudentUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		meta interface{}
+		want string
+	}{
+		{"student with username", &Student{Username: sql.NullString{String: "jdoe12", Valid: true}}, "jdoe12"},
+		{"student without username", &Student{}, ""},
+		{"teacher", &Teacher{}, ""},
+		{"no meta", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StudentUsername(tt.meta); got != tt.want {
+				t.Errorf("StudentUsername() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUser_TimestampsSerializeAsUTC(t *testing.T) {
+	// A row scanned on a connection whose session time zone isn't UTC.
+	var u User
+	stored := time.Date(2026, 1, 15, 8, 0, 0, 0, time.FixedZone("PST", -8*60*60))
+	if err := u.CreatedAt.Scan(stored); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if err := u.LastLoggedOn.Scan(stored); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	body, err := json.Marshal(u)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for _, field := range []string{"created_at", "last_logged_on"} {
+		if got[field] != "2026-01-15T16:00:00Z" {
+			t.Errorf("%s = %v, want %q", field, got[field], "2026-01-15T16:00:00Z")
+		}
+	}
+}
+
+func TestUser_NeverLoggedOnSerializesAsNull(t *testing.T) {
+	var u User
+	if err := u.LastLoggedOn.Scan(nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	body, err := json.Marshal(u)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if v, ok := got["last_logged_on"]; !ok || v != nil {
+		t.Errorf("last_logged_on = %v (present %v), want null", v, ok)
+	}
+}
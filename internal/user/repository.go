@@ -7,27 +7,70 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // Repository handles user data operations.
 // db is the writer (used for INSERTs, UPDATEs, DELETEs).
 // reader is the read replica (used for SELECTs). When no replica is
 // configured, reader is the same handle as db so no extra pool is opened.
+// Inside WithTx both are the transaction.
 type Repository struct {
-	db     *sqlx.DB
-	reader *sqlx.DB
+	db     dbtx
+	reader dbtx
+
+	// pool is the writer pool WithTx begins transactions on; nil on a
+	// Repository already inside one.
+	pool *sqlx.DB
+
+	// queryTimeout bounds each query; see SetQueryTimeout.
+	queryTimeout time.Duration
+}
+
+// dbtx is what queries run against: a *sqlx.DB, or a *sqlx.Tx inside WithTx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 }
 
 // NewRepository creates a new user repository. Pass the same handle for both
 // writer and reader when no read replica is configured.
 func NewRepository(writer, reader *sqlx.DB) *Repository {
-	return &Repository{db: writer, reader: reader}
+	return &Repository{db: writer, reader: reader, pool: writer}
+}
+
+// WithTx runs fn with a Repository whose reads and writes all go through one
+// transaction on the writer, committing if fn returns nil and rolling back
+// otherwise. fn's error is returned as is. Called on a Repository already in
+// a transaction, it runs fn in that one.
+func (r *Repository) WithTx(ctx context.Context, fn func(*Repository) error) error {
+	if r.pool == nil {
+		return fn(r)
+	}
+
+	tx, err := r.pool.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txRepo := &Repository{queryTimeout: r.queryTimeout}
+	txRepo.db = txRepo.withTimeout(tx)
+	txRepo.reader = txRepo.db
+	if err := fn(txRepo); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // FindByEmail finds a user by email address
 func (r *Repository) FindByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
-	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, created_at, updated_at
+	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, password_changed_at, COALESCE(locale, '') AS locale, COALESCE(status, 'active') AS status, created_at, updated_at
 			  FROM users
 			  WHERE email = $1`
 
@@ -45,7 +88,7 @@ func (r *Repository) FindByEmail(ctx context.Context, email string) (*User, erro
 // FindByID finds a user by ID
 func (r *Repository) FindByID(ctx context.Context, id int) (*User, error) {
 	var user User
-	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, created_at, updated_at
+	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, password_changed_at, COALESCE(locale, '') AS locale, COALESCE(status, 'active') AS status, created_at, updated_at
 			  FROM users
 			  WHERE id = $1`
 
@@ -63,7 +106,7 @@ func (r *Repository) FindByID(ctx context.Context, id int) (*User, error) {
 // FindByBoddleUID finds a user by Boddle UID
 func (r *Repository) FindByBoddleUID(ctx context.Context, boddleUID string) (*User, error) {
 	var user User
-	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, created_at, updated_at
+	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, password_changed_at, COALESCE(locale, '') AS locale, COALESCE(status, 'active') AS status, created_at, updated_at
 			  FROM users
 			  WHERE boddle_uid = $1`
 
@@ -135,6 +178,27 @@ func (r *Repository) FindTeacher(ctx context.Context, id int) (*Teacher, error)
 	return &teacher, nil
 }
 
+// LockTeacher reads a teacher from the writer with SELECT ... FOR UPDATE, so
+// no one else can change the row until the transaction ends. Use it inside
+// WithTx to re-check a row before writing it.
+func (r *Repository) LockTeacher(ctx context.Context, id int) (*Teacher, error) {
+	var teacher Teacher
+	query := `SELECT id, first_name, last_name, google_uid, clever_uid, is_verified, created_at, updated_at
+			  FROM teachers
+			  WHERE id = $1
+			  FOR UPDATE`
+
+	err := r.db.GetContext(ctx, &teacher, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock teacher: %w", err)
+	}
+
+	return &teacher, nil
+}
+
 // FindTeacherByGoogleUID finds a teacher by Google UID
 func (r *Repository) FindTeacherByGoogleUID(ctx context.Context, googleUID string) (*Teacher, error) {
 	var teacher Teacher
@@ -174,7 +238,7 @@ func (r *Repository) FindTeacherByCleverUID(ctx context.Context, cleverUID strin
 // FindStudent finds a student by ID
 func (r *Repository) FindStudent(ctx context.Context, id int) (*Student, error) {
 	var student Student
-	query := `SELECT id, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
 			  FROM students
 			  WHERE id = $1`
 
@@ -189,10 +253,29 @@ func (r *Repository) FindStudent(ctx context.Context, id int) (*Student, error)
 	return &student, nil
 }
 
+// LockStudent is LockTeacher for a student.
+func (r *Repository) LockStudent(ctx context.Context, id int) (*Student, error) {
+	var student Student
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+			  FROM students
+			  WHERE id = $1
+			  FOR UPDATE`
+
+	err := r.db.GetContext(ctx, &student, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock student: %w", err)
+	}
+
+	return &student, nil
+}
+
 // FindStudentByGoogleUID finds a student by Google UID
 func (r *Repository) FindStudentByGoogleUID(ctx context.Context, googleUID string) (*Student, error) {
 	var student Student
-	query := `SELECT id, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
 			  FROM students
 			  WHERE google_uid = $1`
 
@@ -229,7 +312,7 @@ func (r *Repository) FindParent(ctx context.Context, id int) (*Parent, error) {
 // This is the reverse lookup since meta tables don't have a user_id column.
 func (r *Repository) FindUserByMeta(ctx context.Context, metaType string, metaID int) (*User, error) {
 	var user User
-	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, created_at, updated_at
+	query := `SELECT id, name, email, password_digest, boddle_uid, meta_type, meta_id, last_logged_on, token_version, password_changed_at, COALESCE(locale, '') AS locale, COALESCE(status, 'active') AS status, created_at, updated_at
 			  FROM users
 			  WHERE meta_type = $1 AND meta_id = $2`
 
@@ -266,32 +349,129 @@ func (r *Repository) IncrementTokenVersion(ctx context.Context, userID int) (int
 	return newVersion, nil
 }
 
-// RecordLoginAttempt records a login attempt for rate limiting
-func (r *Repository) RecordLoginAttempt(ctx context.Context, email, ipAddress string, success bool) error {
-	query := `INSERT INTO login_attempts (email, ip_address, success, attempted_at)
-			  VALUES ($1, $2, $3, $4)`
+// PasswordChangedAt returns when userID's password last changed, or the zero
+// time if it never has (or the user doesn't exist).
+func (r *Repository) PasswordChangedAt(ctx context.Context, userID int) (time.Time, error) {
+	var changedAt sql.NullTime
+	query := `SELECT password_changed_at FROM users WHERE id = $1`
+	err := r.reader.GetContext(ctx, &changedAt, query, userID)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load password change time: %w", err)
+	}
+	return changedAt.Time, nil
+}
 
-	_, err := r.db.ExecContext(ctx, query, email, ipAddress, success, time.Now())
+// SetStatus sets a user's account status and bumps token_version in the same
+// statement, so the change also revokes every outstanding refresh token.
+// Returns the new token_version, or sql.ErrNoRows if the user doesn't exist.
+func (r *Repository) SetStatus(ctx context.Context, userID int, status string) (int, error) {
+	query := `UPDATE users SET status = $1, token_version = token_version + 1, updated_at = $2 WHERE id = $3 RETURNING token_version`
+	var version int
+	if err := r.db.GetContext(ctx, &version, query, status, time.Now(), userID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to update user status: %w", err)
+	}
+	return version, nil
+}
+
+// UpdateLocale sets the user's preferred locale (BCP 47 tag). An empty
+// locale clears the preference.
+func (r *Repository) UpdateLocale(ctx context.Context, userID int, locale string) error {
+	query := `UPDATE users SET locale = NULLIF($1, ''), updated_at = $2 WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, query, locale, time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update locale: %w", err)
+	}
+	return nil
+}
+
+// RecordLoginAttempt records a login attempt for rate limiting. reason is one
+// of the LoginFailure* constants; pass "" to leave the reason column out of
+// the INSERT, for successes and for databases without the column.
+func (r *Repository) RecordLoginAttempt(ctx context.Context, email, ipAddress string, success bool, reason string) error {
+	var err error
+	if reason == "" {
+		query := `INSERT INTO login_attempts (email, ip_address, success, attempted_at)
+				  VALUES ($1, $2, $3, $4)`
+		_, err = r.db.ExecContext(ctx, query, email, ipAddress, success, time.Now())
+	} else {
+		query := `INSERT INTO login_attempts (email, ip_address, success, attempted_at, reason)
+				  VALUES ($1, $2, $3, $4, $5)`
+		_, err = r.db.ExecContext(ctx, query, email, ipAddress, success, time.Now(), reason)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to record login attempt: %w", err)
 	}
 	return nil
 }
 
-// GetRecentLoginAttempts gets recent login attempts for rate limiting
-func (r *Repository) GetRecentLoginAttempts(ctx context.Context, email, ipAddress string, since time.Time) ([]LoginAttempt, error) {
-	var attempts []LoginAttempt
-	query := `SELECT id, email, ip_address, success, attempted_at
-			  FROM login_attempts
-			  WHERE email = $1 AND ip_address = $2 AND attempted_at >= $3
-			  ORDER BY attempted_at DESC`
+// GetRecentLoginAttempts returns one page of the login attempts for an email
+// from one IP since the given time, newest first, and how many there are in
+// all. Ties on attempted_at are broken by id, so pages don't overlap or skip.
+func (r *Repository) GetRecentLoginAttempts(ctx context.Context, email, ipAddress string, since time.Time, limit, offset int) ([]LoginAttempt, int, error) {
+	attempts, total, err := r.pageLoginAttempts(ctx,
+		`email = $1 AND ip_address = $2 AND attempted_at >= $3`,
+		limit, offset, email, ipAddress, since)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get recent login attempts: %w", err)
+	}
+	return attempts, total, nil
+}
 
-	err := r.reader.SelectContext(ctx, &attempts, query, email, ipAddress, since)
+// FindLoginAttemptsByEmail returns one page of the login attempts for an
+// email in [from, to), from any IP, newest first, and how many there are in
+// all. It is for auditing a single account.
+func (r *Repository) FindLoginAttemptsByEmail(ctx context.Context, email string, from, to time.Time, limit, offset int) ([]LoginAttempt, int, error) {
+	attempts, total, err := r.pageLoginAttempts(ctx,
+		`email = $1 AND attempted_at >= $2 AND attempted_at < $3`,
+		limit, offset, email, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get recent login attempts: %w", err)
+		return nil, 0, fmt.Errorf("failed to find login attempts: %w", err)
+	}
+	return attempts, total, nil
+}
+
+// pageLoginAttempts counts the login attempts matching where and reads the
+// requested page of them, ordered by attempted_at then id, newest first.
+// where's placeholders are numbered from $1 to match args.
+func (r *Repository) pageLoginAttempts(ctx context.Context, where string, limit, offset int, args ...interface{}) ([]LoginAttempt, int, error) {
+	var total int
+	if err := r.reader.GetContext(ctx, &total, `SELECT COUNT(*) FROM login_attempts WHERE `+where, args...); err != nil {
+		return nil, 0, err
 	}
 
-	return attempts, nil
+	attempts := []LoginAttempt{}
+	if total == 0 || offset >= total {
+		return attempts, total, nil
+	}
+	n := len(args)
+	query := fmt.Sprintf(`SELECT id, email, ip_address, success, attempted_at
+			  FROM login_attempts
+			  WHERE %s
+			  ORDER BY attempted_at DESC, id DESC
+			  LIMIT $%d OFFSET $%d`, where, n+1, n+2)
+	if err := r.reader.SelectContext(ctx, &attempts, query, append(args, limit, offset)...); err != nil {
+		return nil, 0, err
+	}
+	return attempts, total, nil
+}
+
+// ListLoginAttempts returns one page of login attempts recorded under any of
+// logins (emails, or student usernames) since the given time, from any IP,
+// newest first, and how many there are in all.
+func (r *Repository) ListLoginAttempts(ctx context.Context, logins []string, since time.Time, limit, offset int) ([]LoginAttempt, int, error) {
+	attempts, total, err := r.pageLoginAttempts(ctx,
+		`email = ANY($1) AND attempted_at >= $2`,
+		limit, offset, pq.Array(logins), since)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list login attempts: %w", err)
+	}
+	return attempts, total, nil
 }
 
 // FindLoginToken finds a login token by secret
@@ -324,6 +504,20 @@ func (r *Repository) DeleteLoginToken(ctx context.Context, id int) error {
 	return nil
 }
 
+// SetTeacherVerified sets a teacher's is_verified flag. Returns
+// sql.ErrNoRows when no teacher has the given ID.
+func (r *Repository) SetTeacherVerified(ctx context.Context, teacherID int, verified bool) error {
+	query := `UPDATE teachers SET is_verified = $1, updated_at = $2 WHERE id = $3`
+	res, err := r.db.ExecContext(ctx, query, verified, time.Now(), teacherID)
+	if err != nil {
+		return fmt.Errorf("failed to update teacher verification: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // UpdateTeacherGoogleUID updates a teacher's Google UID
 func (r *Repository) UpdateTeacherGoogleUID(ctx context.Context, teacherID int, googleUID string) error {
 	query := `UPDATE teachers SET google_uid = $1, updated_at = $2 WHERE id = $3`
@@ -367,7 +561,7 @@ func (r *Repository) UpdateStudentCleverUID(ctx context.Context, studentID int,
 // FindStudentByiCloudUID finds a student by iCloud UID
 func (r *Repository) FindStudentByiCloudUID(ctx context.Context, icloudUID string) (*Student, error) {
 	var student Student
-	query := `SELECT id, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
 			  FROM students
 			  WHERE icloud_uid = $1`
 
@@ -400,10 +594,34 @@ func (r *Repository) FindParentByiCloudUID(ctx context.Context, icloudUID string
 	return &parent, nil
 }
 
+// UpdateStudentName sets the name of studentID's user row. Students have no
+// name columns of their own. A name already on the record is left alone.
+func (r *Repository) UpdateStudentName(ctx context.Context, studentID int, name string) error {
+	query := `UPDATE users SET name = $1, updated_at = $2
+			  WHERE meta_type = 'Student' AND meta_id = $3 AND COALESCE(name, '') = ''`
+	_, err := r.db.ExecContext(ctx, query, name, time.Now(), studentID)
+	if err != nil {
+		return fmt.Errorf("failed to update student name: %w", err)
+	}
+	return nil
+}
+
+// UpdateParentName sets a parent's first and last name, provided neither is
+// already set.
+func (r *Repository) UpdateParentName(ctx context.Context, parentID int, firstName, lastName string) error {
+	query := `UPDATE parents SET first_name = $1, last_name = $2, updated_at = $3
+			  WHERE id = $4 AND first_name = '' AND last_name = ''`
+	_, err := r.db.ExecContext(ctx, query, firstName, lastName, time.Now(), parentID)
+	if err != nil {
+		return fmt.Errorf("failed to update parent name: %w", err)
+	}
+	return nil
+}
+
 // FindStudentByCleverUID finds a student by Clever UID
 func (r *Repository) FindStudentByCleverUID(ctx context.Context, cleverUID string) (*Student, error) {
 	var student Student
-	query := `SELECT id, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
+	query := `SELECT id, username, game_character_name, google_uid, clever_uid, icloud_uid, parent_id, created_at, updated_at
 			  FROM students
 			  WHERE clever_uid = $1`
 
@@ -418,6 +636,30 @@ func (r *Repository) FindStudentByCleverUID(ctx context.Context, cleverUID strin
 	return &student, nil
 }
 
+// FindStudentByUsername finds the users row of the student with the given
+// username, or nil if there is none. username must already be normalized
+// (trimmed and lowercased); the match ignores the stored username's case.
+// students.username is only unique as stored, so two students may differ
+// in case alone. Such a username is ambiguous and also returns nil, rather
+// than signing in whichever row the database returns first.
+func (r *Repository) FindStudentByUsername(ctx context.Context, username string) (*User, error) {
+	var users []User
+	query := `SELECT u.id, u.name, u.email, u.password_digest, u.boddle_uid, u.meta_type, u.meta_id, u.last_logged_on, u.token_version, u.password_changed_at, COALESCE(u.locale, '') AS locale, COALESCE(u.status, 'active') AS status, u.created_at, u.updated_at
+			  FROM students s
+			  JOIN users u ON u.meta_type = 'Student' AND u.meta_id = s.id
+			  WHERE LOWER(s.username) = $1
+			  LIMIT 2`
+
+	if err := r.reader.SelectContext(ctx, &users, query, username); err != nil {
+		return nil, fmt.Errorf("failed to find student by username: %w", err)
+	}
+	if len(users) != 1 {
+		return nil, nil
+	}
+
+	return &users[0], nil
+}
+
 // UpdateStudentiCloudUID updates a student's iCloud UID
 func (r *Repository) UpdateStudentiCloudUID(ctx context.Context, studentID int, icloudUID string) error {
 	query := `UPDATE students SET icloud_uid = $1, updated_at = $2 WHERE id = $3`
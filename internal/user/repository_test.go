@@ -0,0 +1,75 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFindStudentByUsername(t *testing.T) {
+	columns := []string{"id", "name", "email", "password_digest", "boddle_uid", "meta_type", "meta_id",
+		"last_logged_on", "token_version", "password_changed_at", "locale", "status", "created_at", "updated_at"}
+	query := `FROM students s\s+JOIN users u ON u.meta_type = 'Student' AND u.meta_id = s.id\s+WHERE LOWER\(s.username\) = \$1`
+
+	t.Run("found", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		now := time.Now()
+		mock.ExpectQuery(query).WithArgs("kido9").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(42, "Kid One", "kid1@student.student", "digest", "uid-42", "Student", 9, nil, 0, nil, "", StatusActive, now, now))
+
+		got, err := repo.FindStudentByUsername(context.Background(), "kido9")
+		if err != nil {
+			t.Fatalf("FindStudentByUsername: %v", err)
+		}
+		if got == nil || got.ID != 42 || got.MetaType != "Student" || got.MetaID != 9 {
+			t.Errorf("user = %+v, want student user 42", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectQuery(query).WithArgs("nobody1").WillReturnRows(sqlmock.NewRows(columns))
+
+		got, err := repo.FindStudentByUsername(context.Background(), "nobody1")
+		if err != nil || got != nil {
+			t.Errorf("got %+v, %v; want nil, nil", got, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("case variants are ambiguous", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		now := time.Now()
+		mock.ExpectQuery(query).WithArgs("kido9").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(42, "Kid One", "kid1@student.student", "digest", "uid-42", "Student", 9, nil, 0, nil, "", StatusActive, now, now).
+				AddRow(43, "Kid Two", "kid2@student.student", "digest", "uid-43", "Student", 10, nil, 0, nil, "", StatusActive, now, now))
+
+		got, err := repo.FindStudentByUsername(context.Background(), "kido9")
+		if err != nil || got != nil {
+			t.Errorf("got %+v, %v; want nil, nil for a username two students share", got, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectQuery(query).WithArgs("kido9").WillReturnError(sql.ErrConnDone)
+
+		if _, err := repo.FindStudentByUsername(context.Background(), "kido9"); !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("err = %v, want it to wrap sql.ErrConnDone", err)
+		}
+	})
+}
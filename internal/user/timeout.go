@@ -0,0 +1,56 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/boddle/reservoir/internal/database"
+)
+
+// timeoutDB runs each query on db under database.WithQueryTimeout. Every
+// call returns only once its result is read, so the child context can be
+// cancelled on the way out.
+type timeoutDB struct {
+	db      dbtx
+	timeout time.Duration
+}
+
+func (t timeoutDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, t.timeout)
+	defer cancel()
+	res, err := t.db.ExecContext(ctx, query, args...)
+	return res, database.QueryError(ctx, err)
+}
+
+func (t timeoutDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, t.timeout)
+	defer cancel()
+	return database.QueryError(ctx, t.db.GetContext(ctx, dest, query, args...))
+}
+
+func (t timeoutDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, t.timeout)
+	defer cancel()
+	return database.QueryError(ctx, t.db.SelectContext(ctx, dest, query, args...))
+}
+
+// SetQueryTimeout bounds each query whose context has no deadline of its own
+// by timeout (DB_QUERY_TIMEOUT), including those inside WithTx. 0 disables
+// the bound.
+func (r *Repository) SetQueryTimeout(timeout time.Duration) {
+	r.queryTimeout = timeout
+	r.db = r.withTimeout(r.db)
+	r.reader = r.withTimeout(r.reader)
+}
+
+// withTimeout wraps db in a timeoutDB when a query timeout is set.
+func (r *Repository) withTimeout(db dbtx) dbtx {
+	if t, ok := db.(timeoutDB); ok {
+		db = t.db
+	}
+	if r.queryTimeout <= 0 {
+		return db
+	}
+	return timeoutDB{db: db, timeout: r.queryTimeout}
+}
@@ -0,0 +1,59 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSetQueryTimeout_SlowQueryReturnsDeadlineError(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	repo.SetQueryTimeout(50 * time.Millisecond)
+	// Stands in for SELECT pg_sleep(...): the mock holds the query until its
+	// context is done.
+	mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).WithArgs(42).
+		WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+	start := time.Now()
+	_, err := repo.FindByID(context.Background(), 42)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("FindByID took %v, want it cut off near the 50ms timeout", elapsed)
+	}
+}
+
+func TestSetQueryTimeout_InsideTransaction(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	repo.SetQueryTimeout(50 * time.Millisecond)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO teachers`).WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectRollback()
+
+	_, err := repo.CreateUser(context.Background(), NewUser{MetaType: "Teacher", Email: "ada@school.edu", PasswordDigest: "digest"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a deadline error", err)
+	}
+}
+
+func TestSetQueryTimeout_CallerDeadlineStands(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	repo.SetQueryTimeout(10 * time.Millisecond)
+	// Slower than the default timeout, but within the caller's own deadline.
+	mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).WithArgs(42).
+		WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(42, "Ada"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usr, err := repo.FindByID(ctx, 42)
+	if err != nil || usr == nil || usr.ID != 42 {
+		t.Fatalf("FindByID = %v, %v; want user 42", usr, err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
+	"github.com/boddle/reservoir/internal/database"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -24,7 +26,8 @@ type SequenceStore interface {
 
 // Repository implements SequenceStore against PostgreSQL.
 type Repository struct {
-	db *sqlx.DB
+	db           *sqlx.DB
+	queryTimeout time.Duration
 }
 
 // NewRepository creates a new username repository.
@@ -32,6 +35,12 @@ func NewRepository(db *sqlx.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// SetQueryTimeout bounds each query by timeout (DB_QUERY_TIMEOUT) when its
+// context has no deadline. 0 disables the bound.
+func (r *Repository) SetQueryTimeout(timeout time.Duration) {
+	r.queryTimeout = timeout
+}
+
 // NextNumber atomically increments and returns the next number for the given
 // base username. Postgres row-level locking on the UPSERT ensures that
 // concurrent callers with the same base always receive distinct numbers.
@@ -44,7 +53,9 @@ func (r *Repository) NextNumber(ctx context.Context, base string) (int, error) {
 		DO UPDATE SET max_number = username_sequences.max_number + 1
 		RETURNING max_number`
 
-	err := r.db.QueryRowContext(ctx, query, base).Scan(&num)
+	ctx, cancel := database.WithQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	err := database.QueryError(ctx, r.db.QueryRowContext(ctx, query, base).Scan(&num))
 	if err != nil {
 		return 0, fmt.Errorf("failed to get next username number for base %q: %w", base, err)
 	}
@@ -57,7 +68,9 @@ func (r *Repository) CurrentNumber(ctx context.Context, base string) (int, error
 	var num int
 	query := `SELECT COALESCE(max_number, 0) FROM username_sequences WHERE base_username = $1`
 
-	err := r.db.QueryRowContext(ctx, query, base).Scan(&num)
+	ctx, cancel := database.WithQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	err := database.QueryError(ctx, r.db.QueryRowContext(ctx, query, base).Scan(&num))
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
@@ -72,7 +85,9 @@ func (r *Repository) IsUsernameTaken(ctx context.Context, username string) (bool
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM students WHERE username = $1)`
 
-	err := r.db.QueryRowContext(ctx, query, username).Scan(&exists)
+	ctx, cancel := database.WithQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	err := database.QueryError(ctx, r.db.QueryRowContext(ctx, query, username).Scan(&exists))
 	if err != nil {
 		return false, fmt.Errorf("failed to check username %q: %w", username, err)
 	}
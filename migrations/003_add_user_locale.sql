@@ -0,0 +1,7 @@
+-- Add an optional per-user locale (BCP 47 tag, e.g. "en-US", "es").
+-- Embedded in access tokens as the `locale` claim so downstream services can
+-- localize without an extra user lookup. Set via PATCH /auth/me/locale; Google
+-- sign-in falls back to the locale reported by Google when this is NULL.
+-- Nullable with no default so existing rows are unaffected.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
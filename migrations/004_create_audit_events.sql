@@ -0,0 +1,20 @@
+-- Append-only audit trail for privileged actions taken through the gateway
+-- (e.g. an admin force-verifying a teacher). Rails remains the system of
+-- record for the affected rows; this table records who changed what, when,
+-- and from where, so support actions performed here are still attributable.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id            BIGSERIAL    PRIMARY KEY,
+    actor_user_id INTEGER,
+    action        VARCHAR(64)  NOT NULL,
+    target_type   VARCHAR(32),
+    target_id     INTEGER,
+    ip_address    VARCHAR(45),
+    metadata      JSONB,
+    created_at    TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_target
+    ON audit_events (target_type, target_id, created_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor
+    ON audit_events (actor_user_id, created_at DESC);
@@ -0,0 +1,9 @@
+-- Add an account status so an account can be suspended or disabled from the
+-- gateway. Values: 'active', 'suspended', 'deleted'. Set via
+-- PUT /admin/users/:id/status; every authentication path rejects suspended
+-- (ACCOUNT_SUSPENDED) and deleted (ACCOUNT_DISABLED) accounts.
+--
+-- Nullable with no default: rows Rails creates without knowing about the
+-- column stay NULL, and Reservoir reads NULL as 'active'.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status VARCHAR(16);
@@ -0,0 +1,12 @@
+-- Record why a failed password login failed, for abuse analytics: attempts
+-- against emails with no account ('unknown_user') point at enumeration or
+-- password spraying, while 'wrong_password' against a real account points at
+-- guessing. 'account_status' marks a correct password on a suspended or
+-- deleted account. NULL for successes and for rows written before this
+-- migration or by Rails.
+--
+-- Reservoir only writes the column once LOGIN_ATTEMPT_REASONS=true, so deploy
+-- this migration first. The reason is internal: clients always receive the
+-- same INVALID_CREDENTIALS error.
+ALTER TABLE login_attempts
+    ADD COLUMN IF NOT EXISTS reason VARCHAR(32);
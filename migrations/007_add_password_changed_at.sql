@@ -0,0 +1,6 @@
+-- Record when a user's password last changed. Rails sets it on every password
+-- change or reset; Reservoir embeds it in tokens as the `pwc` claim and rejects
+-- tokens minted before it, so a change signs out every earlier session.
+-- Nullable with no default: NULL means the password hasn't changed since.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
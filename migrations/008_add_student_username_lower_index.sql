@@ -0,0 +1,8 @@
+-- Index students by lowercased username for username login, which matches
+-- case-insensitively: generated usernames are lowercase, but older ones may
+-- not be. Not UNIQUE, so legacy usernames differing only in case don't block
+-- the migration, and not partial, so the planner can use it for a plain
+-- LOWER(username) = $1 lookup. A regular CREATE INDEX keeps it valid inside
+-- a transaction block.
+CREATE INDEX IF NOT EXISTS idx_students_username_lower
+    ON students (LOWER(username));
@@ -16,16 +16,40 @@ func (e *AppError) Error() string {
 
 // Common error codes
 const (
-	ErrCodeInvalidCredentials  = "INVALID_CREDENTIALS"
-	ErrCodeInvalidToken        = "INVALID_TOKEN"
-	ErrCodeTokenExpired        = "TOKEN_EXPIRED"
-	ErrCodeTokenRevoked        = "TOKEN_REVOKED"
-	ErrCodeRateLimitExceeded   = "RATE_LIMIT_EXCEEDED"
-	ErrCodeValidationFailed    = "VALIDATION_FAILED"
-	ErrCodeInternalError       = "INTERNAL_ERROR"
-	ErrCodeUnauthorized        = "UNAUTHORIZED"
-	ErrCodeForbidden           = "FORBIDDEN"
-	ErrCodeNotFound            = "NOT_FOUND"
+	ErrCodeInvalidCredentials     = "INVALID_CREDENTIALS"
+	ErrCodeInvalidToken           = "INVALID_TOKEN"
+	ErrCodeTokenExpired           = "TOKEN_EXPIRED"
+	ErrCodeTokenRevoked           = "TOKEN_REVOKED"
+	ErrCodeTokenMismatch          = "TOKEN_MISMATCH"
+	ErrCodeRateLimitExceeded      = "RATE_LIMIT_EXCEEDED"
+	ErrCodeValidationFailed       = "VALIDATION_FAILED"
+	ErrCodeInternalError          = "INTERNAL_ERROR"
+	ErrCodeUnauthorized           = "UNAUTHORIZED"
+	ErrCodeForbidden              = "FORBIDDEN"
+	ErrCodeNotFound               = "NOT_FOUND"
+	ErrCodeServiceUnavailable     = "SERVICE_UNAVAILABLE"
+	ErrCodeTooManyLinkedProviders = "TOO_MANY_LINKED_PROVIDERS"
+	ErrCodeUnsupportedRole        = "UNSUPPORTED_ROLE"
+	ErrCodeOAuthSessionExpired    = "OAUTH_SESSION_EXPIRED"
+	ErrCodeUnsupportedAPIVersion  = "UNSUPPORTED_API_VERSION"
+	ErrCodeAccountSuspended       = "ACCOUNT_SUSPENDED"
+	ErrCodeAccountDisabled        = "ACCOUNT_DISABLED"
+	ErrCodeShuttingDown           = "SHUTTING_DOWN"
+
+	ErrCodePasswordLoginUnavailable = "PASSWORD_LOGIN_UNAVAILABLE"
+	ErrCodeCaptchaRequired          = "CAPTCHA_REQUIRED"
+	ErrCodeRedirectURINotAllowed    = "REDIRECT_URI_NOT_ALLOWED"
+	ErrCodeTooManyOAuthFlows        = "TOO_MANY_OAUTH_FLOWS"
+	ErrCodeInvalidContextHeader     = "INVALID_CONTEXT_HEADER"
+	ErrCodeUnknownApp               = "UNKNOWN_APP"
+	ErrCodeInvalidMetaType          = "INVALID_META_TYPE"
+	ErrCodeNoLinkedAccount          = "NO_LINKED_ACCOUNT"
+	ErrCodeRedirectURLNotAllowed    = "REDIRECT_URL_NOT_ALLOWED"
+	ErrCodeServerBusy               = "SERVER_BUSY"
+	ErrCodeDomainNotAllowed         = "DOMAIN_NOT_ALLOWED"
+	ErrCodeEmailTaken               = "EMAIL_TAKEN"
+	ErrCodeEmailNotVerified         = "EMAIL_NOT_VERIFIED"
+	ErrCodeForbiddenRole            = "FORBIDDEN_ROLE"
 )
 
 // NewAppError creates a new application error
@@ -39,10 +63,31 @@ func NewAppError(code, message string, status int) *AppError {
 
 // Common errors
 var (
-	ErrInvalidCredentials = NewAppError(ErrCodeInvalidCredentials, "Invalid email or password", 401)
-	ErrInvalidToken       = NewAppError(ErrCodeInvalidToken, "Invalid token", 401)
-	ErrTokenExpired       = NewAppError(ErrCodeTokenExpired, "Token expired", 401)
-	ErrTokenRevoked       = NewAppError(ErrCodeTokenRevoked, "Token revoked", 401)
-	ErrRateLimitExceeded  = NewAppError(ErrCodeRateLimitExceeded, "Too many login attempts", 429)
-	ErrUnauthorized       = NewAppError(ErrCodeUnauthorized, "Unauthorized", 401)
+	ErrInvalidCredentials     = NewAppError(ErrCodeInvalidCredentials, "Invalid email or password", 401)
+	ErrInvalidToken           = NewAppError(ErrCodeInvalidToken, "Invalid token", 401)
+	ErrTokenExpired           = NewAppError(ErrCodeTokenExpired, "Token expired", 401)
+	ErrTokenRevoked           = NewAppError(ErrCodeTokenRevoked, "Token revoked", 401)
+	ErrTokenMismatch          = NewAppError(ErrCodeTokenMismatch, "Refresh token does not belong to the access token's user", 401)
+	ErrRateLimitExceeded      = NewAppError(ErrCodeRateLimitExceeded, "Too many login attempts", 429)
+	ErrTooManyLinkedProviders = NewAppError(ErrCodeTooManyLinkedProviders, "This account has already linked the maximum number of sign-in providers", 409)
+	ErrUnauthorized           = NewAppError(ErrCodeUnauthorized, "Unauthorized", 401)
+	ErrNotFound               = NewAppError(ErrCodeNotFound, "Not found", 404)
+	ErrInternal               = NewAppError(ErrCodeInternalError, "Internal server error", 500)
+	ErrOAuthSessionExpired    = NewAppError(ErrCodeOAuthSessionExpired, "Your sign-in took too long and has expired. Please start signing in again.", 401)
+	ErrAccountSuspended       = NewAppError(ErrCodeAccountSuspended, "This account has been suspended", 403)
+	ErrAccountDisabled        = NewAppError(ErrCodeAccountDisabled, "This account has been disabled", 403)
+
+	ErrPasswordLoginUnavailable = NewAppError(ErrCodePasswordLoginUnavailable, "This account has no password; sign in with Google, Clever or Apple instead", 403)
+	ErrCaptchaRequired          = NewAppError(ErrCodeCaptchaRequired, "Please complete the CAPTCHA to continue signing in", 403)
+	ErrRedirectURINotAllowed    = NewAppError(ErrCodeRedirectURINotAllowed, "redirect_uri is not an allowed callback URL", 400)
+	ErrTooManyOAuthFlows        = NewAppError(ErrCodeTooManyOAuthFlows, "Too many sign-ins started from this network; please wait a few minutes and try again", 429)
+	ErrInvalidContextHeader     = NewAppError(ErrCodeInvalidContextHeader, "X-Boddle-Context header is unsigned, tampered with or expired", 400)
+	ErrUnknownApp               = NewAppError(ErrCodeUnknownApp, "app is not a recognised Boddle app", 400)
+	ErrInvalidMetaType          = NewAppError(ErrCodeInvalidMetaType, "Token is for an unknown kind of account", 401)
+	ErrRedirectURLNotAllowed    = NewAppError(ErrCodeRedirectURLNotAllowed, "redirect_url is not an allowed destination", 400)
+	ErrServerBusy               = NewAppError(ErrCodeServerBusy, "The server is busy; please try again in a moment", 503)
+	ErrDomainNotAllowed         = NewAppError(ErrCodeDomainNotAllowed, "Please sign in with your school Google account", 403)
+	ErrEmailTaken               = NewAppError(ErrCodeEmailTaken, "An account with this email already exists", 409)
+	ErrEmailNotVerified         = NewAppError(ErrCodeEmailNotVerified, "Your sign-in provider has not verified your email address", 403)
+	ErrForbiddenRole            = NewAppError(ErrCodeForbiddenRole, "This account's role can't sign in this way", 403)
 )
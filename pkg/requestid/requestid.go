@@ -0,0 +1,62 @@
+// Package requestid carries a per-request correlation ID, and the caller's
+// IP and User-Agent, through context.Context, so logs and audit records
+// written below the HTTP layer can be tied back to the request that caused
+// them.
+package requestid
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Header is the request and response header that carries the ID.
+const Header = "X-Request-ID"
+
+type (
+	contextKey   struct{}
+	clientIPKey  struct{}
+	userAgentKey struct{}
+)
+
+// WithID returns a copy of ctx carrying id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// WithClientIP returns a copy of ctx carrying the caller's IP address.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the caller's IP address in ctx, or "" if there is none.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// WithUserAgent returns a copy of ctx carrying the caller's User-Agent.
+func WithUserAgent(ctx context.Context, ua string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, ua)
+}
+
+// UserAgent returns the caller's User-Agent in ctx, or "" if there is none.
+func UserAgent(ctx context.Context) string {
+	ua, _ := ctx.Value(userAgentKey{}).(string)
+	return ua
+}
+
+// Logger returns base annotated with ctx's request ID, or base unchanged when
+// ctx has none.
+func Logger(ctx context.Context, base *zap.Logger) *zap.Logger {
+	if id := FromContext(ctx); id != "" {
+		return base.With(zap.String("request_id", id))
+	}
+	return base
+}
@@ -0,0 +1,38 @@
+package response
+
+import (
+	"database/sql"
+	"errors"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+)
+
+// sentinels maps errors the service layer returns to what the client sees.
+// Checked in order with errors.Is. Only standard library errors belong here:
+// internal packages wrap their own errors in an AppError instead.
+var sentinels = []struct {
+	err    error
+	appErr *apperrors.AppError
+}{
+	{sql.ErrNoRows, apperrors.ErrNotFound},
+}
+
+// FromError maps err to the AppError to send the client: an AppError
+// anywhere in err's chain is used as is, a known sentinel gets its mapped
+// AppError, and anything else is apperrors.ErrInternal (500). Returns nil
+// for a nil err.
+func FromError(err error) *apperrors.AppError {
+	if err == nil {
+		return nil
+	}
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return s.appErr
+		}
+	}
+	return apperrors.ErrInternal
+}
@@ -0,0 +1,64 @@
+package response
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apperrors "github.com/boddle/reservoir/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+// wrappingError is an internal package's sentinel that unwraps to the
+// AppError clients see for it.
+type wrappingError struct{ appErr *apperrors.AppError }
+
+func (e wrappingError) Error() string { return "sentinel" }
+func (e wrappingError) Unwrap() error { return e.appErr }
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+	}{
+		{"app error", apperrors.ErrAccountSuspended, apperrors.ErrCodeAccountSuspended, http.StatusForbidden},
+		{"wrapped app error", fmt.Errorf("refresh: %w", apperrors.ErrTokenMismatch), apperrors.ErrCodeTokenMismatch, http.StatusUnauthorized},
+		{"error wrapping an app error", fmt.Errorf("invalid token: %w", wrappingError{apperrors.ErrTokenExpired}), apperrors.ErrCodeTokenExpired, http.StatusUnauthorized},
+		{"no rows", fmt.Errorf("failed to find user: %w", sql.ErrNoRows), apperrors.ErrCodeNotFound, http.StatusNotFound},
+		{"unknown", errors.New("connection reset"), apperrors.ErrCodeInternalError, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromError(tt.err)
+			if got.Code != tt.wantCode || got.Status != tt.wantStatus {
+				t.Errorf("FromError() = %s (%d), want %s (%d)", got.Code, got.Status, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+
+	if FromError(nil) != nil {
+		t.Error("FromError(nil) should be nil")
+	}
+}
+
+func TestError_UsesMappedStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	Error(c, fmt.Errorf("validate: %w", wrappingError{apperrors.ErrTokenExpired}))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if want := `"code":"TOKEN_EXPIRED"`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("body = %s, want it to contain %s", w.Body.String(), want)
+	}
+}
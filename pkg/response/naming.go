@@ -0,0 +1,118 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Naming is the casing of JSON keys in response bodies.
+type Naming string
+
+const (
+	// SnakeCase is the default: keys as the structs' json tags spell them.
+	SnakeCase Naming = "snake_case"
+	// CamelCase rewrites every snake_case key to camelCase, e.g.
+	// access_token to accessToken.
+	CamelCase Naming = "camelCase"
+)
+
+// namingKey is the gin context key SetNaming stores the naming under.
+const namingKey = "response_naming"
+
+// ParseNaming validates a naming convention name; "" is SnakeCase.
+func ParseNaming(s string) (Naming, error) {
+	switch n := Naming(s); n {
+	case "":
+		return SnakeCase, nil
+	case SnakeCase, CamelCase:
+		return n, nil
+	}
+	return "", fmt.Errorf("unknown naming convention %q (want %s or %s)", s, SnakeCase, CamelCase)
+}
+
+// SetNaming makes the responses written for c use naming n.
+func SetNaming(c *gin.Context, n Naming) {
+	c.Set(namingKey, n)
+}
+
+// NamingFrom returns the naming set for c, SnakeCase when none was.
+func NamingFrom(c *gin.Context) Naming {
+	v, _ := c.Get(namingKey)
+	if n, ok := v.(Naming); ok {
+		return n
+	}
+	return SnakeCase
+}
+
+// render writes body as JSON in c's naming. The structs keep a single
+// snake_case json tag; camelCase is produced here by re-keying the
+// marshaled body rather than by a second set of structs.
+func render(c *gin.Context, status int, body interface{}) {
+	if NamingFrom(c) != CamelCase {
+		c.JSON(status, body)
+		return
+	}
+	renamed, err := camelCaseKeys(body)
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(status, body)
+		return
+	}
+	c.JSON(status, renamed)
+}
+
+// camelCaseKeys marshals v and returns it with every object key camelCased.
+// Numbers are kept as json.Number so large IDs survive the round trip.
+func camelCaseKeys(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return renameKeys(tree), nil
+}
+
+func renameKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[camelCase(k)] = renameKeys(child)
+		}
+		return out
+	case []interface{}:
+		for i, child := range v {
+			v[i] = renameKeys(child)
+		}
+		return v
+	}
+	return v
+}
+
+// camelCase converts a snake_case key: "refresh_token" -> "refreshToken".
+// Keys without underscores are returned unchanged.
+func camelCase(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+	parts := strings.Split(key, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, p := range parts[1:] {
+		if p == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(p[:1]))
+		b.WriteString(p[1:])
+	}
+	return b.String()
+}
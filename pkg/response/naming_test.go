@@ -0,0 +1,81 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginBody stands in for an auth response: nested structs, a slice and an
+// ID too large for a float64.
+type loginBody struct {
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+	} `json:"token"`
+	User struct {
+		ID       int64  `json:"id"`
+		MetaType string `json:"meta_type"`
+	} `json:"user"`
+	LinkedProviders []struct {
+		ProviderUID string `json:"provider_uid"`
+	} `json:"linked_providers"`
+}
+
+func TestSuccess_NamingConventions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var body loginBody
+	body.Token.AccessToken, body.Token.RefreshToken, body.Token.TokenType = "a", "r", "Bearer"
+	body.User.ID, body.User.MetaType = 9007199254740993, "Teacher"
+	body.LinkedProviders = append(body.LinkedProviders, struct {
+		ProviderUID string `json:"provider_uid"`
+	}{"g-1"})
+
+	tests := []struct {
+		naming Naming
+		want   string
+	}{
+		{SnakeCase, `{"data":{"token":{"access_token":"a","refresh_token":"r","token_type":"Bearer"},"user":{"id":9007199254740993,"meta_type":"Teacher"},"linked_providers":[{"provider_uid":"g-1"}]},"success":true}`},
+		// Re-keyed objects come out in sorted key order.
+		{CamelCase, `{"data":{"linkedProviders":[{"providerUid":"g-1"}],"token":{"accessToken":"a","refreshToken":"r","tokenType":"Bearer"},"user":{"id":9007199254740993,"metaType":"Teacher"}},"success":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.naming), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			SetNaming(c, tt.naming)
+
+			Success(c, http.StatusOK, body)
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamingFrom_DefaultsToSnakeCase(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if n := NamingFrom(c); n != SnakeCase {
+		t.Errorf("NamingFrom = %q, want %q", n, SnakeCase)
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"access_token":  "accessToken",
+		"redirect_url":  "redirectUrl",
+		"success":       "success",
+		"is_verified":   "isVerified",
+		"trailing_":     "trailing",
+		"double__under": "doubleUnder",
+	} {
+		if got := camelCase(in); got != want {
+			t.Errorf("camelCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
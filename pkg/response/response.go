@@ -1,44 +1,39 @@
 package response
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	apperrors "github.com/boddle/reservoir/pkg/errors"
 )
 
 // Success sends a successful JSON response
 func Success(c *gin.Context, status int, data interface{}) {
-	c.JSON(status, gin.H{
+	render(c, status, gin.H{
 		"success": true,
 		"data":    data,
 	})
 }
 
-// Error sends an error JSON response
+// Error sends an error JSON response, with the status and code FromError
+// maps err to
 func Error(c *gin.Context, err error) {
-	if appErr, ok := err.(*apperrors.AppError); ok {
-		c.JSON(appErr.Status, gin.H{
-			"success": false,
-			"error": gin.H{
-				"code":    appErr.Code,
-				"message": appErr.Message,
-			},
-		})
-		return
-	}
-
-	// Default internal server error
-	c.JSON(500, gin.H{
+	appErr := FromError(err)
+	render(c, appErr.Status, gin.H{
 		"success": false,
 		"error": gin.H{
-			"code":    apperrors.ErrCodeInternalError,
-			"message": "Internal server error",
+			"code":    appErr.Code,
+			"message": appErr.Message,
 		},
 	})
 }
 
 // ValidationError sends a validation error response
 func ValidationError(c *gin.Context, message string) {
-	c.JSON(400, gin.H{
+	render(c, 400, gin.H{
 		"success": false,
 		"error": gin.H{
 			"code":    apperrors.ErrCodeValidationFailed,
@@ -46,3 +41,21 @@ func ValidationError(c *gin.Context, message string) {
 		},
 	})
 }
+
+// ServiceUnavailable sends a 503 with a Retry-After hint (whole seconds,
+// rounded up, minimum 1) so clients and load balancers back off instead of
+// retrying immediately. detail is surfaced as the error message.
+func ServiceUnavailable(c *gin.Context, retryAfter time.Duration, detail string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"success": false,
+		"error": gin.H{
+			"code":    apperrors.ErrCodeServiceUnavailable,
+			"message": detail,
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServiceUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		wantHeader string
+	}{
+		{"whole seconds", 5 * time.Second, "5"},
+		{"fractional rounds up", 1500 * time.Millisecond, "2"},
+		{"zero floors to one", 0, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/ready", nil)
+
+			ServiceUnavailable(c, tt.retryAfter, "redis unavailable")
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantHeader {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantHeader)
+			}
+
+			var body struct {
+				Success bool `json:"success"`
+				Error   struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if body.Success {
+				t.Error("success = true, want false")
+			}
+			if body.Error.Code != "SERVICE_UNAVAILABLE" {
+				t.Errorf("code = %q, want SERVICE_UNAVAILABLE", body.Error.Code)
+			}
+			if body.Error.Message != "redis unavailable" {
+				t.Errorf("message = %q, want %q", body.Error.Message, "redis unavailable")
+			}
+			if !c.IsAborted() {
+				t.Error("context should be aborted so later handlers don't run")
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamItems sends the success envelope with data {"items": [...]}, encoding
+// each item straight to the response as each emits it instead of marshalling
+// a slice, so a large list is never held in memory.
+//
+// Nothing is written until the first item. If each fails before that, the
+// usual error response is sent. If it fails mid-list the status is already
+// out, so the body is left truncated — invalid JSON the client can't mistake
+// for a complete list — and the error is returned for the caller to log.
+func StreamItems(c *gin.Context, each func(emit func(item interface{}) error) error) error {
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		_, err := c.Writer.WriteString(`{"success":true,"data":{"items":[`)
+		return err
+	}
+
+	n := 0
+	err := each(func(item interface{}) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if n > 0 {
+			if _, err := c.Writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		n++
+		if NamingFrom(c) == CamelCase {
+			renamed, err := camelCaseKeys(item)
+			if err != nil {
+				return err
+			}
+			item = renamed
+		}
+		return enc.Encode(item)
+	})
+	if err != nil {
+		if !started {
+			Error(c, err)
+		}
+		return err
+	}
+
+	if !started {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	_, err = c.Writer.WriteString("]}}")
+	return err
+}
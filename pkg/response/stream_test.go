@@ -0,0 +1,95 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamItems_WritesIncrementally(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	const total = 50000
+	var sizeAtHalf int
+	err := StreamItems(c, func(emit func(interface{}) error) error {
+		// Items are generated one at a time; no slice of them ever exists.
+		for i := 0; i < total; i++ {
+			if err := emit(map[string]int{"id": i}); err != nil {
+				return err
+			}
+			if i == total/2 {
+				sizeAtHalf = w.Body.Len()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamItems: %v", err)
+	}
+
+	// Half the items were already on the wire before the rest were produced.
+	if sizeAtHalf == 0 || sizeAtHalf >= w.Body.Len() {
+		t.Errorf("body was %d bytes halfway and %d at the end; want it written as items arrive", sizeAtHalf, w.Body.Len())
+	}
+
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Items []struct{ ID int } `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if !resp.Success || len(resp.Data.Items) != total || resp.Data.Items[total-1].ID != total-1 {
+		t.Errorf("got success=%v with %d items, want %d", resp.Success, len(resp.Data.Items), total)
+	}
+}
+
+func TestStreamItems_Empty(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	if err := StreamItems(c, func(func(interface{}) error) error { return nil }); err != nil {
+		t.Fatalf("StreamItems: %v", err)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != `{"success":true,"data":{"items":[]}}` {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamItems_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	boom := errors.New("db gone")
+
+	// Before anything is written: a normal error response.
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if err := StreamItems(c, func(func(interface{}) error) error { return boom }); !errors.Is(err, boom) {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	// Mid-list: the body is left as invalid JSON rather than a short list.
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	err := StreamItems(c, func(emit func(interface{}) error) error {
+		_ = emit(1)
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+	if json.Valid(w.Body.Bytes()) {
+		t.Errorf("truncated list is valid JSON: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+// Package utctime provides the timestamp type used in API responses.
+package utctime
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// Layout is the wire format of every timestamp in a response body.
+const Layout = time.RFC3339
+
+// Time is a time.Time that always serializes as UTC RFC3339 (e.g.
+// "2026-05-19T14:03:00Z"), regardless of the zone it was created or scanned
+// in. Postgres hands back timestamps in the connection's session time zone,
+// so without this the offset clients saw depended on connection settings.
+//
+// It embeds time.Time, so comparisons and arithmetic work as usual, and it
+// scans directly from database columns.
+type Time struct {
+	time.Time
+}
+
+// New wraps t.
+func New(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Time) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.UTC().Format(Layout) + `"`), nil
+}
+
+// Scan implements sql.Scanner.
+func (t *Time) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	default:
+		return fmt.Errorf("utctime: cannot scan %T into Time", src)
+	}
+}
+
+// Value implements driver.Valuer.
+func (t Time) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+// NullTime is a nullable Time, for optional timestamp columns. It serializes
+// as null when not Valid and like Time otherwise.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// NewNull wraps t, treating the zero time as null.
+func NewNull(t time.Time) NullTime {
+	return NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t NullTime) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	return New(t.Time).MarshalJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *NullTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = NullTime{}
+		return nil
+	}
+	if err := t.Time.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	t.Valid = true
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (t *NullTime) Scan(src interface{}) error {
+	var v Time
+	if err := v.Scan(src); err != nil {
+		return err
+	}
+	t.Time, t.Valid = v.Time, src != nil
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (t NullTime) Value() (driver.Value, error) {
+	if !t.Valid {
+		return nil, nil
+	}
+	return t.Time, nil
+}
@@ -0,0 +1,73 @@
+package utctime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSON_NonUTCIsWrittenAsUTC(t *testing.T) {
+	// As returned by a connection whose session time zone is US Eastern.
+	eastern := time.FixedZone("EST", -5*60*60)
+	stored := time.Date(2026, 3, 1, 9, 30, 15, 123456789, eastern)
+
+	got, err := json.Marshal(struct {
+		CreatedAt Time `json:"created_at"`
+	}{New(stored)})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"created_at":"2026-03-01T14:30:15Z"}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalJSON_RoundTrips(t *testing.T) {
+	var v Time
+	if err := json.Unmarshal([]byte(`"2026-03-01T14:30:15Z"`), &v); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !v.Equal(time.Date(2026, 3, 1, 14, 30, 15, 0, time.UTC)) {
+		t.Errorf("got %v", v.Time)
+	}
+}
+
+func TestScan(t *testing.T) {
+	var v Time
+	now := time.Now()
+	if err := v.Scan(now); err != nil || !v.Equal(now) {
+		t.Errorf("Scan(time.Time) = %v, %v", v.Time, err)
+	}
+	if err := v.Scan(nil); err != nil || !v.IsZero() {
+		t.Errorf("Scan(nil) = %v, %v", v.Time, err)
+	}
+	if err := v.Scan("2026-03-01"); err == nil {
+		t.Error("Scan(string) should fail")
+	}
+}
+
+func TestNullTime(t *testing.T) {
+	eastern := time.FixedZone("EST", -5*60*60)
+	got, err := json.Marshal(struct {
+		Set   NullTime `json:"set"`
+		Unset NullTime `json:"unset"`
+	}{NewNull(time.Date(2026, 3, 1, 9, 30, 15, 0, eastern)), NewNull(time.Time{})})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"set":"2026-03-01T14:30:15Z","unset":null}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	var v NullTime
+	if err := v.Scan(nil); err != nil || v.Valid {
+		t.Errorf("Scan(nil) = %+v, %v", v, err)
+	}
+	now := time.Now()
+	if err := v.Scan(now); err != nil || !v.Valid || !v.Time.Equal(now) {
+		t.Errorf("Scan(time.Time) = %+v, %v", v, err)
+	}
+	if err := json.Unmarshal([]byte(`null`), &v); err != nil || v.Valid {
+		t.Errorf("Unmarshal(null) = %+v, %v", v, err)
+	}
+}